@@ -8,13 +8,32 @@ import (
 
 type (
 	Config struct {
-		App      `yaml:"app"`
-		HTTP     `yaml:"http"`
-		Log      `yaml:"logger"`
-		PG       `yaml:"postgres"`
-		Mailer   `yaml:"mailer"`
-		Redis    `yaml:"redis"`
-		TokenKey `yaml:"token_key"`
+		App           `yaml:"app"`
+		HTTP          `yaml:"http"`
+		Log           `yaml:"logger"`
+		PG            `yaml:"postgres"`
+		Mailer        `yaml:"mailer"`
+		SMS           `yaml:"sms"`
+		Redis         `yaml:"redis"`
+		TokenKey      `yaml:"token_key"`
+		Password      `yaml:"password"`
+		Reauth        `yaml:"reauth"`
+		MFA           `yaml:"mfa"`
+		LoginAlerts   `yaml:"login_alerts"`
+		LoginAbuse    `yaml:"login_abuse"`
+		Challenge     `yaml:"challenge"`
+		RBAC          `yaml:"rbac"`
+		Orgs          `yaml:"orgs"`
+		Authz         `yaml:"authz"`
+		Impersonation `yaml:"impersonation"`
+		OAuth         `yaml:"oauth"`
+		OIDC          `yaml:"oidc"`
+		Social        `yaml:"social"`
+		SAML          `yaml:"saml"`
+		LDAP          `yaml:"ldap"`
+		// TrustedIssuers are the partner systems whose JWTs are accepted as
+		// access tokens.
+		TrustedIssuers []TrustedIssuer `yaml:"trusted_issuers"`
 	}
 
 	App struct {
@@ -35,6 +54,10 @@ type (
 			ExposedHeaders     []string `env-required:"true" yaml:"exposed-headers"`
 			Debug              bool     `env-required:"true" yaml:"debug"`
 		} `yaml:"cors"`
+		// TrustedProxies are the addresses or networks of the proxies whose
+		// X-Forwarded-For is believed. Without any, the client IP is the
+		// address of the connection.
+		TrustedProxies []string `yaml:"trusted-proxies" env:"HTTP_TRUSTED_PROXIES"`
 	}
 
 	Log struct {
@@ -58,6 +81,21 @@ type (
 		SMTPPort       int    `yaml:"smtp_port" env:"SMTP_PORT"`
 	}
 
+	// SMS delivers the texted codes: "log" writes them to stdout and "file"
+	// to File, for development; "http" sends them through the gateway.
+	SMS struct {
+		Backend string     `yaml:"backend" env:"SMS_BACKEND" env-default:"log"`
+		File    string     `yaml:"file" env:"SMS_FILE"`
+		Gateway SMSGateway `yaml:"gateway"`
+	}
+
+	SMSGateway struct {
+		URL     string        `yaml:"url" env:"SMS_GATEWAY_URL"`
+		Token   string        `yaml:"token" env:"SMS_GATEWAY_TOKEN"`
+		From    string        `yaml:"from" env:"SMS_GATEWAY_FROM"`
+		Timeout time.Duration `yaml:"timeout" env:"SMS_GATEWAY_TIMEOUT" env-default:"10s"`
+	}
+
 	Redis struct {
 		Addr     string `env-required:"true" yaml:"addr" env:"REDIS_ADDRESS"`
 		Password string `env-required:"true" yaml:"password" env:"REDIS_PASSWORD"`
@@ -67,6 +105,271 @@ type (
 	TokenKey struct {
 		TokenSymmetricKey string `yaml:"token_symmetric_key" env:"TOKEN_SYMMETRIC_KEY"`
 	}
+
+	Password struct {
+		Policy       PasswordPolicy `yaml:"policy"`
+		HistoryDepth int            `yaml:"history_depth" env:"PASSWORD_HISTORY_DEPTH"`
+		Expiry       PasswordExpiry `yaml:"expiry"`
+		Pepper       PasswordPepper `yaml:"pepper"`
+		// Backends check passwords at sign in, in order: "local" is the
+		// users table, "ldap" the directory.
+		Backends []string `yaml:"backends" env:"PASSWORD_BACKENDS" env-default:"local"`
+	}
+
+	// Reauth is how recently users must have authenticated for sensitive
+	// operations such as changing the password.
+	Reauth struct {
+		MaxAge time.Duration `yaml:"max_age" env:"REAUTH_MAX_AGE" env-default:"10m"`
+	}
+
+	// MFA is the second factor, a code emailed at sign in, of the users who
+	// turned it on.
+	MFA struct {
+		CodeTTL     time.Duration `yaml:"code_ttl" env:"MFA_CODE_TTL" env-default:"5m"`
+		MaxAttempts int           `yaml:"max_attempts" env:"MFA_MAX_ATTEMPTS" env-default:"5"`
+		// RememberDays is how long a device trusted at sign in skips the
+		// code, 0 to always ask.
+		RememberDays int `yaml:"remember_days" env:"MFA_REMEMBER_DAYS" env-default:"30"`
+	}
+
+	// LoginAlerts email users about logins from devices they didn't use
+	// before.
+	LoginAlerts struct {
+		Enabled bool `yaml:"enabled" env:"LOGIN_ALERTS_ENABLED" env-default:"true"`
+		// ReportURL is the "this wasn't me" link, by default the API
+		// endpoint under oidc.issuer.
+		ReportURL string        `yaml:"report_url" env:"LOGIN_ALERTS_REPORT_URL"`
+		ReportTTL time.Duration `yaml:"report_ttl" env:"LOGIN_ALERTS_REPORT_TTL" env-default:"168h"`
+	}
+
+	// LoginAbuse blocks the sources of credential stuffing: many failed
+	// logins from an address or its network, logins trying many accounts,
+	// and waves of failures over the whole system.
+	LoginAbuse struct {
+		Enabled bool `yaml:"enabled" env:"LOGIN_ABUSE_ENABLED" env-default:"true"`
+		// Window is the period the failures are counted in.
+		Window           time.Duration `yaml:"window" env:"LOGIN_ABUSE_WINDOW" env-default:"10m"`
+		IPFailures       int64         `yaml:"ip_failures" env:"LOGIN_ABUSE_IP_FAILURES" env-default:"30"`
+		SubnetFailures   int64         `yaml:"subnet_failures" env:"LOGIN_ABUSE_SUBNET_FAILURES" env-default:"100"`
+		DistinctAccounts int64         `yaml:"distinct_accounts" env:"LOGIN_ABUSE_DISTINCT_ACCOUNTS" env-default:"10"`
+		DistinctRatio    float64       `yaml:"distinct_ratio" env:"LOGIN_ABUSE_DISTINCT_RATIO" env-default:"0.5"`
+		GlobalFailures   int64         `yaml:"global_failures" env:"LOGIN_ABUSE_GLOBAL_FAILURES" env-default:"1000"`
+		SpikeDivisor     int64         `yaml:"spike_divisor" env:"LOGIN_ABUSE_SPIKE_DIVISOR" env-default:"4"`
+		BlockFor         time.Duration `yaml:"block_for" env:"LOGIN_ABUSE_BLOCK_FOR" env-default:"1h"`
+	}
+
+	// Challenge keeps bots off registration, resending codes and password
+	// resets: clients solve a proof-of-work challenge or a CAPTCHA.
+	Challenge struct {
+		Enabled bool `yaml:"enabled" env:"CHALLENGE_ENABLED" env-default:"true"`
+		// Secret signs the challenges, by default a key derived from the
+		// token key.
+		Secret string `yaml:"secret" env:"CHALLENGE_SECRET"`
+		// Difficulty is the number of leading zero bits of the hash, each
+		// one doubling the work of clients.
+		Difficulty int              `yaml:"difficulty" env:"CHALLENGE_DIFFICULTY" env-default:"18"`
+		TTL        time.Duration    `yaml:"ttl" env:"CHALLENGE_TTL" env-default:"5m"`
+		Captcha    ChallengeCaptcha `yaml:"captcha"`
+	}
+
+	// ChallengeCaptcha is the CAPTCHA accepted instead of the proof of work:
+	// none when Provider is empty, "siteverify" for hCaptcha, Turnstile and
+	// reCAPTCHA, "stub" to accept StubResponse in development.
+	ChallengeCaptcha struct {
+		Provider     string        `yaml:"provider" env:"CAPTCHA_PROVIDER"`
+		URL          string        `yaml:"url" env:"CAPTCHA_URL"`
+		Secret       string        `yaml:"secret" env:"CAPTCHA_SECRET"`
+		Timeout      time.Duration `yaml:"timeout" env:"CAPTCHA_TIMEOUT" env-default:"10s"`
+		StubResponse string        `yaml:"stub_response" env:"CAPTCHA_STUB_RESPONSE"`
+	}
+
+	RBAC struct {
+		DefaultRole string        `yaml:"default_role" env:"RBAC_DEFAULT_ROLE" env-default:"user"`
+		CacheTTL    time.Duration `yaml:"cache_ttl" env:"RBAC_CACHE_TTL" env-default:"1m"`
+	}
+
+	Orgs struct {
+		InvitationTTL time.Duration `yaml:"invitation_ttl" env:"ORGS_INVITATION_TTL" env-default:"72h"`
+	}
+
+	Authz struct {
+		CacheTTL       time.Duration   `yaml:"cache_ttl" env:"AUTHZ_CACHE_TTL" env-default:"30s"`
+		OwnershipRules []OwnershipRule `yaml:"ownership_rules"`
+		// PoliciesFile holds the attribute-based policies. Empty disables them.
+		PoliciesFile   string        `yaml:"policies_file" env:"AUTHZ_POLICIES_FILE"`
+		ReloadInterval time.Duration `yaml:"reload_interval" env:"AUTHZ_POLICIES_RELOAD_INTERVAL" env-default:"10s"`
+	}
+
+	Impersonation struct {
+		TokenTTL time.Duration `yaml:"token_ttl" env:"IMPERSONATION_TOKEN_TTL" env-default:"15m"`
+	}
+
+	OAuth struct {
+		CodeTTL         time.Duration `yaml:"code_ttl" env:"OAUTH_CODE_TTL" env-default:"1m"`
+		AccessTokenTTL  time.Duration `yaml:"access_token_ttl" env:"OAUTH_ACCESS_TOKEN_TTL" env-default:"15m"`
+		RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" env:"OAUTH_REFRESH_TOKEN_TTL" env-default:"720h"`
+		Device          OAuthDevice   `yaml:"device"`
+	}
+
+	OAuthDevice struct {
+		CodeTTL      time.Duration `yaml:"code_ttl" env:"OAUTH_DEVICE_CODE_TTL" env-default:"10m"`
+		PollInterval time.Duration `yaml:"poll_interval" env:"OAUTH_DEVICE_POLL_INTERVAL" env-default:"5s"`
+		// VerificationURI is the page of the frontend where users enter the
+		// code shown by the device. Empty advertises the API endpoint.
+		VerificationURI string `yaml:"verification_uri" env:"OAUTH_DEVICE_VERIFICATION_URI"`
+	}
+
+	OIDC struct {
+		// Issuer is the public base URL of the service.
+		Issuer string `yaml:"issuer" env:"OIDC_ISSUER" env-default:"http://localhost:8080"`
+		// AuthorizationEndpoint is the consent page of the frontend, which
+		// calls /oauth/authorize with the token of the user. Empty advertises
+		// the API endpoint itself.
+		AuthorizationEndpoint string `yaml:"authorization_endpoint" env:"OIDC_AUTHORIZATION_ENDPOINT"`
+		// SigningKeyFile holds the PEM encoded RSA key ID tokens are signed
+		// with. Empty generates a key on every start, for development.
+		SigningKeyFile string        `yaml:"signing_key_file" env:"OIDC_SIGNING_KEY_FILE"`
+		IDTokenTTL     time.Duration `yaml:"id_token_ttl" env:"OIDC_ID_TOKEN_TTL" env-default:"1h"`
+	}
+
+	Social struct {
+		StateTTL time.Duration `yaml:"state_ttl" env:"SOCIAL_STATE_TTL" env-default:"10m"`
+		LinkTTL  time.Duration `yaml:"link_ttl" env:"SOCIAL_LINK_TTL" env-default:"15m"`
+		// AllowSignUp creates accounts for users of the providers who have
+		// none yet.
+		AllowSignUp bool             `yaml:"allow_sign_up" env:"SOCIAL_ALLOW_SIGN_UP" env-default:"true"`
+		Providers   []SocialProvider `yaml:"providers"`
+	}
+
+	// SocialProvider is an OpenID Connect provider, found through its Issuer,
+	// or a plain OAuth 2.0 provider with all the endpoints set.
+	SocialProvider struct {
+		Name     string `yaml:"name"`
+		Issuer   string `yaml:"issuer"`
+		ClientID string `yaml:"client_id"`
+		// ClientSecretEnv names the environment variable holding the secret,
+		// which is better kept there than in ClientSecret.
+		ClientSecret          string       `yaml:"client_secret"`
+		ClientSecretEnv       string       `yaml:"client_secret_env"`
+		RedirectURL           string       `yaml:"redirect_url"`
+		Scopes                []string     `yaml:"scopes"`
+		AuthorizationEndpoint string       `yaml:"authorization_endpoint"`
+		TokenEndpoint         string       `yaml:"token_endpoint"`
+		UserInfoEndpoint      string       `yaml:"userinfo_endpoint"`
+		JWKSURI               string       `yaml:"jwks_uri"`
+		Claims                SocialClaims `yaml:"claims"`
+		TrustEmail            bool         `yaml:"trust_email"`
+	}
+
+	// SocialClaims names the userinfo claims of a provider that doesn't use
+	// the standard ones.
+	SocialClaims struct {
+		Subject       string `yaml:"subject"`
+		Email         string `yaml:"email"`
+		EmailVerified string `yaml:"email_verified"`
+		GivenName     string `yaml:"given_name"`
+		FamilyName    string `yaml:"family_name"`
+		Name          string `yaml:"name"`
+	}
+
+	// SAML configures the service provider the isolated organizations sign
+	// in to through their own identity provider. Entity IDs and assertion
+	// consumer services are under the OIDC issuer.
+	SAML struct {
+		RequestTTL time.Duration `yaml:"request_ttl" env:"SAML_REQUEST_TTL" env-default:"5m"`
+		// LoginRedirectURL is the page of the frontend the browser is sent
+		// to with the access token in the fragment; empty answers with JSON.
+		LoginRedirectURL string `yaml:"login_redirect_url" env:"SAML_LOGIN_REDIRECT_URL"`
+	}
+
+	// LDAP is the corporate directory passwords are checked against when
+	// "ldap" is one of the password backends.
+	LDAP struct {
+		URL                string        `yaml:"url" env:"LDAP_URL"`
+		StartTLS           bool          `yaml:"start_tls" env:"LDAP_START_TLS"`
+		CACertFile         string        `yaml:"ca_cert_file" env:"LDAP_CA_CERT_FILE"`
+		InsecureSkipVerify bool          `yaml:"insecure_skip_verify" env:"LDAP_INSECURE_SKIP_VERIFY"`
+		BindDN             string        `yaml:"bind_dn" env:"LDAP_BIND_DN"`
+		BindPassword       string        `yaml:"bind_password" env:"LDAP_BIND_PASSWORD"`
+		BaseDN             string        `yaml:"base_dn" env:"LDAP_BASE_DN"`
+		UserFilter         string        `yaml:"user_filter" env:"LDAP_USER_FILTER" env-default:"(&(objectClass=person)(mail=%s))"`
+		EmailAttribute     string        `yaml:"email_attribute" env-default:"mail"`
+		FirstNameAttribute string        `yaml:"first_name_attribute" env-default:"givenName"`
+		LastNameAttribute  string        `yaml:"last_name_attribute" env-default:"sn"`
+		GroupAttribute     string        `yaml:"group_attribute" env-default:"memberOf"`
+		GroupBaseDN        string        `yaml:"group_base_dn"`
+		GroupFilter        string        `yaml:"group_filter"`
+		Timeout            time.Duration `yaml:"timeout" env:"LDAP_TIMEOUT" env-default:"5s"`
+		// TenantOrgID is the isolated organization whose users are in the
+		// directory, 0 for the global accounts.
+		TenantOrgID   int64 `yaml:"tenant_org_id" env:"LDAP_TENANT_ORG_ID"`
+		AutoProvision bool  `yaml:"auto_provision" env:"LDAP_AUTO_PROVISION" env-default:"true"`
+		// GroupRoles maps group DNs to roles.
+		GroupRoles map[string]string `yaml:"group_roles"`
+	}
+
+	// TrustedIssuer is an external issuer of RS256 JWTs, with its keys at
+	// JWKSURL or in KeyFiles (PEM encoded public keys).
+	TrustedIssuer struct {
+		// Name identifies the issuer in the identities of its users.
+		Name            string        `yaml:"name"`
+		Issuer          string        `yaml:"issuer"`
+		Audience        string        `yaml:"audience"`
+		JWKSURL         string        `yaml:"jwks_url"`
+		KeyFiles        []string      `yaml:"key_files"`
+		RefreshInterval time.Duration `yaml:"refresh_interval"`
+		Claims          IssuerClaims  `yaml:"claims"`
+		// MatchBy maps tokens to local users by "sub" (the default), through
+		// the linked identities, or by "email".
+		MatchBy     string `yaml:"match_by"`
+		AllowSignUp bool   `yaml:"allow_sign_up"`
+		TenantOrgID int64  `yaml:"tenant_org_id"`
+	}
+
+	// IssuerClaims names the claims of an issuer that doesn't use the
+	// standard ones.
+	IssuerClaims struct {
+		Subject    string `yaml:"subject"`
+		Email      string `yaml:"email"`
+		GivenName  string `yaml:"given_name"`
+		FamilyName string `yaml:"family_name"`
+	}
+
+	// OwnershipRule allows Actions ("*" for all) on resources of the Resource
+	// type to their owner (Match "owner") or to members of the organization
+	// they belong to (Match "org").
+	OwnershipRule struct {
+		Resource string   `yaml:"resource"`
+		Actions  []string `yaml:"actions"`
+		Match    string   `yaml:"match"`
+	}
+
+	// PasswordPepper keys are "version: secret" pairs. They are better kept in
+	// the environment or in the secrets file than in this config.
+	PasswordPepper struct {
+		CurrentVersion int               `yaml:"current_version" env:"PASSWORD_PEPPER_VERSION"`
+		Keys           map[string]string `yaml:"keys" env:"PASSWORD_PEPPERS"`
+		SecretsFile    string            `yaml:"secrets_file" env:"PASSWORD_PEPPER_SECRETS_FILE"`
+	}
+
+	PasswordExpiry struct {
+		MaxAgeDays    int           `yaml:"max_age_days" env:"PASSWORD_MAX_AGE_DAYS"`
+		WarnDays      int           `yaml:"warn_days" env:"PASSWORD_EXPIRY_WARN_DAYS"`
+		CheckInterval time.Duration `yaml:"check_interval" env:"PASSWORD_EXPIRY_CHECK_INTERVAL" env-default:"1h"`
+	}
+
+	PasswordPolicy struct {
+		MinLength      int    `yaml:"min_length" env:"PASSWORD_MIN_LENGTH" env-default:"8"`
+		RequireUpper   bool   `yaml:"require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+		RequireLower   bool   `yaml:"require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+		RequireDigit   bool   `yaml:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+		RequireSymbol  bool   `yaml:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+		MinCharClasses int    `yaml:"min_char_classes" env:"PASSWORD_MIN_CHAR_CLASSES"`
+		ForbidUserInfo bool   `yaml:"forbid_user_info" env:"PASSWORD_FORBID_USER_INFO"`
+		MinStrength    int    `yaml:"min_strength" env:"PASSWORD_MIN_STRENGTH"`
+		BreachedFile   string `yaml:"breached_file" env:"PASSWORD_BREACHED_FILE"`
+		MaxBreachCount int    `yaml:"max_breach_count" env:"PASSWORD_MAX_BREACH_COUNT"`
+	}
 )
 
 func New() (*Config, error) {
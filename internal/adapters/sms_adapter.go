@@ -0,0 +1,22 @@
+package adapters
+
+import "fullstack-simple-app/pkg/sms"
+
+type SMSAdapter struct {
+	sender sms.Sender
+}
+
+func NewSMSAdapter(sender sms.Sender) *SMSAdapter {
+	return &SMSAdapter{
+		sender: sender,
+	}
+}
+
+func (a *SMSAdapter) SendSMS(recipient string, templateFile string, data interface{}) error {
+	text, err := sms.Render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	return a.sender.Send(recipient, text)
+}
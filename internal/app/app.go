@@ -2,26 +2,41 @@ package app
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"fullstack-simple-app/config"
 	"fullstack-simple-app/internal/adapters"
+	"fullstack-simple-app/internal/models"
 	"fullstack-simple-app/internal/repositories"
 	"fullstack-simple-app/internal/services"
 	"fullstack-simple-app/internal/transport/http"
 	"fullstack-simple-app/pkg/async"
+	"fullstack-simple-app/pkg/challenge"
+	"fullstack-simple-app/pkg/directory"
 	"fullstack-simple-app/pkg/email"
+	"fullstack-simple-app/pkg/issuer"
 	"fullstack-simple-app/pkg/logger"
+	"fullstack-simple-app/pkg/oidc"
+	"fullstack-simple-app/pkg/password"
+	"fullstack-simple-app/pkg/policy"
 	"fullstack-simple-app/pkg/postgres"
 	"fullstack-simple-app/pkg/redis"
+	"fullstack-simple-app/pkg/sms"
+	"fullstack-simple-app/pkg/social"
 	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/validator"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/cors"
 	"golang.org/x/sync/errgroup"
 	"net"
 	http3 "net/http"
+	"os"
 	"os/signal"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -35,6 +50,9 @@ type App struct {
 	logger     logger.Logger
 	pg         *postgres.Postgres
 	redis      *redis.RedisClient
+	users      *services.UserService
+	policies   *policy.Engine
+	issuers    []*issuer.Issuer
 }
 
 func New(cfg *config.Config) (*App, error) {
@@ -63,22 +81,497 @@ func New(cfg *config.Config) (*App, error) {
 		return nil, fmt.Errorf("cannot create token maker: %w", err)
 	}
 
+	peppers, err := loadPeppers(cfg.Password.Pepper)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load password peppers: %w", err)
+	}
+
+	err = models.SetPeppers(peppers)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set password peppers: %w", err)
+	}
+
+	passwordPolicy, err := newPasswordPolicy(cfg.Password.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create password policy: %w", err)
+	}
+
 	userRepo := repositories.NewUserRepo(pg)
+	orgRepo := repositories.NewOrgRepo(pg)
 	emailSender := adapters.NewEmailAdapter(mailer)
-	userService := services.NewUserService(userRepo, emailSender, runner, redisClient, tokenMaker)
+	roleRepo := repositories.NewRoleRepo(pg)
+	deviceRepo := repositories.NewTrustedDeviceRepo(pg)
+	loginDeviceRepo := repositories.NewLoginDeviceRepo(pg)
+	oauthRepo := repositories.NewOAuthRepo(pg)
+	apiKeyRepo := repositories.NewAPIKeyRepo(pg)
+
+	// revoked sessions are remembered as long as the longest-lived tokens
+	sessionService := services.NewSessionService(redisClient, oauthRepo, apiKeyRepo, deviceRepo, max(24*time.Hour, cfg.OAuth.AccessTokenTTL, cfg.Impersonation.TokenTTL))
+
+	loginAbuseService := services.NewLoginAbuseService(redisClient, services.LoginAbuseConfig{
+		Enabled:          cfg.LoginAbuse.Enabled,
+		Window:           cfg.LoginAbuse.Window,
+		IPFailures:       cfg.LoginAbuse.IPFailures,
+		SubnetFailures:   cfg.LoginAbuse.SubnetFailures,
+		DistinctAccounts: cfg.LoginAbuse.DistinctAccounts,
+		DistinctRatio:    cfg.LoginAbuse.DistinctRatio,
+		GlobalFailures:   cfg.LoginAbuse.GlobalFailures,
+		SpikeDivisor:     cfg.LoginAbuse.SpikeDivisor,
+		BlockFor:         cfg.LoginAbuse.BlockFor,
+	})
+
+	smsBackend, err := newSMSSender(cfg.SMS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sms config: %w", err)
+	}
+	smsSender := adapters.NewSMSAdapter(smsBackend)
+
+	passwordVerifiers, err := newPasswordVerifiers(cfg, userRepo, roleRepo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password backends config: %w", err)
+	}
+
+	userService := services.NewUserService(userRepo, orgRepo, deviceRepo, loginDeviceRepo, sessionService, loginAbuseService, emailSender, smsSender, runner, redisClient, tokenMaker, passwordPolicy, services.PasswordConfig{
+		HistoryDepth:  cfg.Password.HistoryDepth,
+		MaxAge:        days(cfg.Password.Expiry.MaxAgeDays),
+		ExpiryWarning: days(cfg.Password.Expiry.WarnDays),
+	}, services.RoleConfig{
+		DefaultRole: cfg.RBAC.DefaultRole,
+	}, services.MFAConfig{
+		CodeTTL:     cfg.MFA.CodeTTL,
+		MaxAttempts: cfg.MFA.MaxAttempts,
+		RememberFor: days(cfg.MFA.RememberDays),
+	}, services.LoginAlertConfig{
+		Enabled:   cfg.LoginAlerts.Enabled,
+		ReportURL: loginReportURL(cfg),
+		ReportTTL: cfg.LoginAlerts.ReportTTL,
+	}, passwordVerifiers...)
 	userHandler := http.NewUserHandler(userService, l)
 
-	router := http.NewRouter(userHandler)
+	roleService := services.NewRoleService(roleRepo, cfg.RBAC.CacheTTL)
+	roleHandler := http.NewRoleHandler(roleService, l)
+
+	adminHandler := http.NewAdminHandler(userService, loginAbuseService, l)
+
+	orgService := services.NewOrgService(orgRepo, userRepo, emailSender, runner, tokenMaker, cfg.Orgs.InvitationTTL)
+	orgHandler := http.NewOrgHandler(orgService, l)
+
+	guard := http.NewGuard(roleService, l, cfg.Reauth.MaxAge)
+
+	authzConfig, err := newAuthzConfig(cfg.Authz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authz config: %w", err)
+	}
+
+	var policies services.PolicyEvaluator
+	if cfg.Authz.PoliciesFile != "" {
+		a.policies, err = policy.NewEngine(cfg.Authz.PoliciesFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load authz policies: %w", err)
+		}
+		policies = a.policies
+	}
+
+	authzService := services.NewAuthzService(roleService, policies, userRepo, redisClient, authzConfig)
+	authzHandler := http.NewAuthzHandler(authzService, l)
+	authorizer := http.NewAuthorizer(authzService, l)
+
+	auditRepo := repositories.NewAuditRepo(pg)
+	impersonationService := services.NewImpersonationService(userRepo, orgRepo, auditRepo, roleService, emailSender, runner, sessionService.Verifier(tokenMaker), tokenMaker, cfg.Impersonation.TokenTTL)
+	idTokenSigner, err := newIDTokenSigner(cfg.OIDC, l)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load id token signing key: %w", err)
+	}
+
+	oauthService := services.NewOAuthService(oauthRepo, userRepo, orgRepo, redisClient, tokenMaker, idTokenSigner, services.OAuthConfig{
+		CodeTTL:               cfg.OAuth.CodeTTL,
+		AccessTokenTTL:        cfg.OAuth.AccessTokenTTL,
+		RefreshTokenTTL:       cfg.OAuth.RefreshTokenTTL,
+		Issuer:                cfg.OIDC.Issuer,
+		AuthorizationEndpoint: cfg.OIDC.AuthorizationEndpoint,
+		IDTokenTTL:            cfg.OIDC.IDTokenTTL,
+		DeviceCodeTTL:         cfg.OAuth.Device.CodeTTL,
+		DevicePollInterval:    cfg.OAuth.Device.PollInterval,
+		DeviceVerificationURI: cfg.OAuth.Device.VerificationURI,
+	})
+	oauthHandler := http.NewOAuthHandler(impersonationService, oauthService, l)
+	oidcHandler := http.NewOIDCHandler(oauthService, l)
+
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo, userRepo, roleService, runner)
+	apiKeyHandler := http.NewAPIKeyHandler(apiKeyService, l)
+
+	socialProviders, err := newSocialProviders(cfg.Social.Providers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid social login config: %w", err)
+	}
+
+	identityRepo := repositories.NewIdentityRepo(pg)
+	socialService := services.NewSocialService(identityRepo, userRepo, userService, redisClient, tokenMaker, socialProviders, services.SocialConfig{
+		StateTTL:    cfg.Social.StateTTL,
+		LinkTTL:     cfg.Social.LinkTTL,
+		AllowSignUp: cfg.Social.AllowSignUp,
+		DefaultRole: cfg.RBAC.DefaultRole,
+	})
+	socialHandler := http.NewSocialHandler(socialService, l)
+
+	samlRepo := repositories.NewSAMLRepo(pg)
+	samlService := services.NewSAMLService(samlRepo, orgRepo, userRepo, redisClient, tokenMaker, services.SAMLConfig{
+		BaseURL:          cfg.OIDC.Issuer,
+		RequestTTL:       cfg.SAML.RequestTTL,
+		LoginRedirectURL: cfg.SAML.LoginRedirectURL,
+		DefaultRole:      cfg.RBAC.DefaultRole,
+	})
+	samlHandler := http.NewSAMLHandler(samlService, l)
+
+	externalTokenService, tokenIssuers, err := newExternalTokenService(cfg, tokenMaker, identityRepo, userRepo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted issuers config: %w", err)
+	}
+
+	challengeService, err := newChallengeService(cfg, redisClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge config: %w", err)
+	}
+	challengeHandler := http.NewChallengeHandler(challengeService, l)
+
+	verifier := http.NewCredentialVerifier(sessionService.Verifier(externalTokenService), apiKeyService)
+
+	router := http.NewRouter(userHandler, roleHandler, adminHandler, orgHandler, authzHandler, oauthHandler, oidcHandler, apiKeyHandler, socialHandler, samlHandler, challengeHandler, verifier, guard, authorizer)
+
+	// the client IP counts failed logins, so it mustn't be up to the client
+	err = router.SetTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies config: %w", err)
+	}
 
 	a.cfg = cfg
 	a.router = router
 	a.logger = l
 	a.pg = pg
 	a.redis = redisClient
+	a.users = userService
+	a.issuers = tokenIssuers
 
 	return a, nil
 }
 
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+func newPasswordPolicy(cfg config.PasswordPolicy) (*password.Policy, error) {
+	var opts []password.Option
+
+	if cfg.BreachedFile != "" {
+		checker, err := password.NewHIBPFile(cfg.BreachedFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, password.WithBreachChecker(checker))
+	}
+
+	policy := password.NewPolicy(opts...)
+	policy.MinLength = cfg.MinLength
+	policy.RequireUpper = cfg.RequireUpper
+	policy.RequireLower = cfg.RequireLower
+	policy.RequireDigit = cfg.RequireDigit
+	policy.RequireSymbol = cfg.RequireSymbol
+	policy.MinCharClasses = cfg.MinCharClasses
+	policy.ForbidUserInfo = cfg.ForbidUserInfo
+	policy.MinStrength = cfg.MinStrength
+	policy.MaxBreachCount = cfg.MaxBreachCount
+
+	return policy, nil
+}
+
+func newAuthzConfig(cfg config.Authz) (services.AuthzConfig, error) {
+	authzConfig := services.AuthzConfig{CacheTTL: cfg.CacheTTL}
+
+	for i, r := range cfg.OwnershipRules {
+		rule := models.OwnershipRule{Resource: r.Resource, Actions: r.Actions, Match: r.Match}
+
+		v := validator.New()
+		if models.ValidateOwnershipRule(v, rule); !v.Valid() {
+			return services.AuthzConfig{}, fmt.Errorf("ownership rule %d: %v", i, v.Errors)
+		}
+
+		authzConfig.OwnershipRules = append(authzConfig.OwnershipRules, rule)
+	}
+
+	return authzConfig, nil
+}
+
+// newIDTokenSigner loads the signing key of the ID tokens, or generates one
+// when none is configured.
+func newIDTokenSigner(cfg config.OIDC, l logger.Logger) (*oidc.Signer, error) {
+	if cfg.SigningKeyFile != "" {
+		return oidc.LoadSigner(cfg.SigningKeyFile)
+	}
+
+	l.Warn("oidc signing_key_file is not set, id tokens are signed with a temporary key")
+
+	return oidc.GenerateSigner()
+}
+
+// newSocialProviders creates the clients of the identity providers users
+// sign in with.
+func newSocialProviders(cfgs []config.SocialProvider) ([]services.SocialProvider, error) {
+	client := &http3.Client{Timeout: 10 * time.Second}
+
+	providers := make([]services.SocialProvider, 0, len(cfgs))
+	names := make(map[string]bool, len(cfgs))
+
+	for _, cfg := range cfgs {
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate provider %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		secret := cfg.ClientSecret
+		if cfg.ClientSecretEnv != "" {
+			secret = os.Getenv(cfg.ClientSecretEnv)
+		}
+
+		provider, err := social.NewProvider(social.Config{
+			Name:                  cfg.Name,
+			Issuer:                cfg.Issuer,
+			ClientID:              cfg.ClientID,
+			ClientSecret:          secret,
+			RedirectURL:           cfg.RedirectURL,
+			Scopes:                cfg.Scopes,
+			AuthorizationEndpoint: cfg.AuthorizationEndpoint,
+			TokenEndpoint:         cfg.TokenEndpoint,
+			UserInfoEndpoint:      cfg.UserInfoEndpoint,
+			JWKSURI:               cfg.JWKSURI,
+			Claims:                social.ClaimMapping(cfg.Claims),
+			TrustEmail:            cfg.TrustEmail,
+		}, client)
+		if err != nil {
+			return nil, err
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// newPasswordVerifiers returns the password backends in the configured
+// order.
+// loginReportURL is the "this wasn't me" link of login alerts.
+func loginReportURL(cfg *config.Config) string {
+	if cfg.LoginAlerts.ReportURL != "" {
+		return cfg.LoginAlerts.ReportURL
+	}
+	return strings.TrimSuffix(cfg.OIDC.Issuer, "/") + "/users/login-alerts/report"
+}
+
+// newChallengeService signs the challenges with the configured secret or,
+// without one, with a key derived from the token key.
+func newChallengeService(cfg *config.Config, redisClient *redis.RedisClient) (*services.ChallengeService, error) {
+	key := []byte(cfg.Challenge.Secret)
+	if len(key) == 0 {
+		mac := hmac.New(sha256.New, []byte(cfg.TokenKey.TokenSymmetricKey))
+		mac.Write([]byte("challenge"))
+		key = mac.Sum(nil)
+	}
+
+	pow, err := challenge.NewProofOfWork(key, cfg.Challenge.Difficulty, cfg.Challenge.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	var captcha challenge.Verifier
+
+	switch c := cfg.Challenge.Captcha; c.Provider {
+	case "":
+	case "siteverify":
+		captcha, err = challenge.NewSiteVerify(challenge.SiteVerifyConfig{
+			URL:     c.URL,
+			Secret:  c.Secret,
+			Timeout: c.Timeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+	case "stub":
+		captcha = challenge.NewStub(c.StubResponse)
+	default:
+		return nil, fmt.Errorf("unknown captcha provider %q", c.Provider)
+	}
+
+	return services.NewChallengeService(pow, captcha, redisClient, cfg.Challenge.Enabled), nil
+}
+
+func newSMSSender(cfg config.SMS) (sms.Sender, error) {
+	switch cfg.Backend {
+	case "log":
+		return sms.NewSink(os.Stdout), nil
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return sms.NewSink(f), nil
+	case "http":
+		return sms.NewGateway(sms.GatewayConfig{
+			URL:     cfg.Gateway.URL,
+			Token:   cfg.Gateway.Token,
+			From:    cfg.Gateway.From,
+			Timeout: cfg.Gateway.Timeout,
+		})
+	default:
+		return nil, fmt.Errorf("unknown sms backend %q", cfg.Backend)
+	}
+}
+
+func newPasswordVerifiers(cfg *config.Config, userRepo *repositories.UserModel, roleRepo *repositories.RoleModel) ([]services.PasswordVerifier, error) {
+	verifiers := make([]services.PasswordVerifier, 0, len(cfg.Password.Backends))
+	seen := make(map[string]bool, len(cfg.Password.Backends))
+
+	for _, backend := range cfg.Password.Backends {
+		backend = strings.TrimSpace(backend)
+		if seen[backend] {
+			return nil, fmt.Errorf("duplicate backend %q", backend)
+		}
+		seen[backend] = true
+
+		switch backend {
+		case "local":
+			verifiers = append(verifiers, services.NewLocalPasswordVerifier(userRepo))
+		case "ldap":
+			dir, err := directory.New(directory.Config{
+				URL:                cfg.LDAP.URL,
+				StartTLS:           cfg.LDAP.StartTLS,
+				CACertFile:         cfg.LDAP.CACertFile,
+				InsecureSkipVerify: cfg.LDAP.InsecureSkipVerify,
+				BindDN:             cfg.LDAP.BindDN,
+				BindPassword:       cfg.LDAP.BindPassword,
+				BaseDN:             cfg.LDAP.BaseDN,
+				UserFilter:         cfg.LDAP.UserFilter,
+				EmailAttribute:     cfg.LDAP.EmailAttribute,
+				FirstNameAttribute: cfg.LDAP.FirstNameAttribute,
+				LastNameAttribute:  cfg.LDAP.LastNameAttribute,
+				GroupAttribute:     cfg.LDAP.GroupAttribute,
+				GroupBaseDN:        cfg.LDAP.GroupBaseDN,
+				GroupFilter:        cfg.LDAP.GroupFilter,
+				Timeout:            cfg.LDAP.Timeout,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			verifiers = append(verifiers, services.NewLDAPPasswordVerifier(dir, userRepo, roleRepo, services.LDAPConfig{
+				TenantOrgID:   cfg.LDAP.TenantOrgID,
+				AutoProvision: cfg.LDAP.AutoProvision,
+				GroupRoles:    cfg.LDAP.GroupRoles,
+				DefaultRole:   cfg.RBAC.DefaultRole,
+			}))
+		default:
+			return nil, fmt.Errorf("unknown backend %q", backend)
+		}
+	}
+
+	if len(verifiers) == 0 {
+		return nil, errors.New("no password backend")
+	}
+
+	return verifiers, nil
+}
+
+// newExternalTokenService accepts the tokens of the trusted issuers besides
+// ours. The issuers returned refresh their keys in the background.
+func newExternalTokenService(cfg *config.Config, tokens services.TokenVerifier, identityRepo *repositories.IdentityModel, userRepo *repositories.UserModel) (*services.ExternalTokenService, []*issuer.Issuer, error) {
+	client := &http3.Client{Timeout: 10 * time.Second}
+
+	s := services.NewExternalTokenService(tokens, identityRepo, userRepo, cfg.RBAC.DefaultRole)
+	issuers := make([]*issuer.Issuer, 0, len(cfg.TrustedIssuers))
+
+	for _, ic := range cfg.TrustedIssuers {
+		keys := make([]string, 0, len(ic.KeyFiles))
+		for _, path := range ic.KeyFiles {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, string(data))
+		}
+
+		iss, err := issuer.New(issuer.Config{
+			Issuer:          ic.Issuer,
+			Audience:        ic.Audience,
+			JWKSURL:         ic.JWKSURL,
+			Keys:            keys,
+			RefreshInterval: ic.RefreshInterval,
+		}, client)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = s.AddIssuer(iss, services.ExternalIssuerConfig{
+			Name:           ic.Name,
+			SubjectClaim:   ic.Claims.Subject,
+			EmailClaim:     ic.Claims.Email,
+			FirstNameClaim: ic.Claims.GivenName,
+			LastNameClaim:  ic.Claims.FamilyName,
+			MatchBy:        ic.MatchBy,
+			AllowSignUp:    ic.AllowSignUp,
+			TenantOrgID:    ic.TenantOrgID,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		issuers = append(issuers, iss)
+	}
+
+	return s, issuers, nil
+}
+
+// loadPeppers collects the pepper keys from the config and the secrets file,
+// the latter taking precedence.
+func loadPeppers(cfg config.PasswordPepper) (models.Peppers, error) {
+	peppers := models.Peppers{Current: cfg.CurrentVersion, Keys: make(map[int][]byte)}
+
+	pairs := make(map[string]string, len(cfg.Keys))
+	for version, secret := range cfg.Keys {
+		pairs[version] = secret
+	}
+
+	if cfg.SecretsFile != "" {
+		content, err := os.ReadFile(cfg.SecretsFile)
+		if err != nil {
+			return models.Peppers{}, err
+		}
+
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			version, secret, ok := strings.Cut(line, ":")
+			if !ok {
+				return models.Peppers{}, fmt.Errorf("%s: malformed line, want <version>:<secret>", cfg.SecretsFile)
+			}
+			pairs[strings.TrimSpace(version)] = strings.TrimSpace(secret)
+		}
+	}
+
+	for version, secret := range pairs {
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return models.Peppers{}, fmt.Errorf("invalid pepper version %q: %w", version, err)
+		}
+		if len(secret) < 32 {
+			return models.Peppers{}, fmt.Errorf("pepper version %d: secret must be at least 32 characters", v)
+		}
+		peppers.Keys[v] = []byte(secret)
+	}
+
+	return peppers, nil
+}
+
 func (a *App) Run(ctx context.Context) error {
 	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -89,6 +582,31 @@ func (a *App) Run(ctx context.Context) error {
 		return a.startHTTP(ctx)
 	})
 
+	grp.Go(func() error {
+		return a.startPasswordExpiryNotifier(ctx)
+	})
+
+	if a.policies != nil {
+		grp.Go(func() error {
+			a.policies.Watch(ctx, a.cfg.Authz.ReloadInterval, func(err error) {
+				a.logger.Error("authz policies reload: %v", err)
+			})
+			return nil
+		})
+	}
+
+	for _, iss := range a.issuers {
+		grp.Go(func() error {
+			if err := iss.Refresh(ctx); err != nil {
+				a.logger.Error("trusted issuer keys: %v", err)
+			}
+			iss.Watch(ctx, func(err error) {
+				a.logger.Error("trusted issuer keys: %v", err)
+			})
+			return nil
+		})
+	}
+
 	err := grp.Wait()
 	switch {
 	case err == nil || errors.Is(err, context.Canceled):
@@ -175,6 +693,30 @@ func (a *App) startHTTP(ctx context.Context) error {
 	return err
 }
 
+// startPasswordExpiryNotifier periodically sends warnings to users whose
+// passwords are about to expire.
+func (a *App) startPasswordExpiryNotifier(ctx context.Context) error {
+	if a.cfg.Password.Expiry.MaxAgeDays <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(a.cfg.Password.Expiry.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		err := a.users.NotifyExpiringPasswords(ctx)
+		if err != nil {
+			a.logger.Error("password expiry notifier: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
 func (a *App) background(fn func()) {
 	a.wg.Add(1)
 	go func() {
@@ -3,32 +3,74 @@
 package errcode
 
 const (
-	ErrInvalidRequest     = "invalid_request"
-	ErrUnauthorized       = "unauthorized"
-	ErrForbidden          = "forbidden"
-	ErrNotFound           = "not_found"
-	ErrConflict           = "conflict"
-	ErrInternal           = "internal_error"
-	ErrEmailAlreadyExists = "email_already_exists"
-	ErrAccountCreated     = "account_created"
-	ErrOTPNotFound        = "otp_not_found"
-	ErrOTPInvalid         = "invalid_otp"
-	ErrInvalidPassword    = "invalid_password"
-	ErrLoginRedirect      = "login_redirect"
+	ErrInvalidRequest         = "invalid_request"
+	ErrUnauthorized           = "unauthorized"
+	ErrForbidden              = "forbidden"
+	ErrNotFound               = "not_found"
+	ErrConflict               = "conflict"
+	ErrInternal               = "internal_error"
+	ErrEmailAlreadyExists     = "email_already_exists"
+	ErrAccountCreated         = "account_created"
+	ErrOTPNotFound            = "otp_not_found"
+	ErrOTPInvalid             = "invalid_otp"
+	ErrInvalidPassword        = "invalid_password"
+	ErrLoginRedirect          = "login_redirect"
+	ErrPasswordReused         = "password_reused"
+	ErrPasswordChangeRequired = "password_change_required"
+	ErrAccountSuspended       = "account_suspended"
+	ErrUnsupportedGrantType   = "unsupported_grant_type"
+	ErrInvalidGrant           = "invalid_grant"
+	ErrImpersonatedToken      = "impersonated_token"
+	ErrInvalidClient          = "invalid_client"
+	ErrInvalidScope           = "invalid_scope"
+	ErrUnauthorizedClient     = "unauthorized_client"
+	ErrAuthorizationPending   = "authorization_pending"
+	ErrSlowDown               = "slow_down"
+	ErrAccessDenied           = "access_denied"
+	ErrExpiredToken           = "expired_token"
+	ErrSocialLoginFailed      = "social_login_failed"
+	ErrAccountLinkRequired    = "account_link_required"
+	ErrSAMLLoginFailed        = "saml_login_failed"
+	ErrReauthRequired         = "reauth_required"
+	ErrMFARequired            = "mfa_required"
+	ErrLoginBlocked           = "login_blocked"
+	ErrChallengeRequired      = "challenge_required"
+	ErrChallengeFailed        = "challenge_failed"
 )
 
 var errorMessages = map[string]string{
-	ErrInvalidRequest:     "The request is invalid or malformed",
-	ErrUnauthorized:       "Missing or invalid authentication credentials",
-	ErrForbidden:          "You do not have permission to access this resource",
-	ErrNotFound:           "The requested resource was not found",
-	ErrConflict:           "A resource conflict occurred (e.g., duplicate data)",
-	ErrInternal:           "An unexpected server error occurred",
-	ErrEmailAlreadyExists: "A user with this email already exists. Please try a different email.",
-	ErrAccountCreated:     "An account was created, but email with activation code was not sent. Please, contact support.",
-	ErrOTPNotFound:        "For this user code was not found. Please try again.",
-	ErrOTPInvalid:         "The code you provided is invalid",
-	ErrInvalidPassword:    "The password you provided is incorrect",
+	ErrInvalidRequest:         "The request is invalid or malformed",
+	ErrUnauthorized:           "Missing or invalid authentication credentials",
+	ErrForbidden:              "You do not have permission to access this resource",
+	ErrNotFound:               "The requested resource was not found",
+	ErrConflict:               "A resource conflict occurred (e.g., duplicate data)",
+	ErrInternal:               "An unexpected server error occurred",
+	ErrEmailAlreadyExists:     "A user with this email already exists. Please try a different email.",
+	ErrAccountCreated:         "An account was created, but email with activation code was not sent. Please, contact support.",
+	ErrOTPNotFound:            "For this user code was not found. Please try again.",
+	ErrOTPInvalid:             "The code you provided is invalid",
+	ErrInvalidPassword:        "The password you provided is incorrect",
+	ErrPasswordReused:         "This password was used recently. Please choose a different one.",
+	ErrPasswordChangeRequired: "Your password has expired. Please set a new one.",
+	ErrAccountSuspended:       "Your account is suspended. Please, contact support.",
+	ErrUnsupportedGrantType:   "The authorization grant type is not supported",
+	ErrInvalidGrant:           "The provided grant is invalid or expired",
+	ErrImpersonatedToken:      "This action is not allowed while impersonating a user",
+	ErrInvalidClient:          "Client authentication failed",
+	ErrInvalidScope:           "The requested scope is invalid or exceeds the allowed scope",
+	ErrUnauthorizedClient:     "The client is not allowed to use this grant type",
+	ErrAuthorizationPending:   "The authorization request is still pending",
+	ErrSlowDown:               "Polling too often, slow down",
+	ErrAccessDenied:           "The user denied the authorization request",
+	ErrExpiredToken:           "The device code has expired",
+	ErrSocialLoginFailed:      "Signing in with the identity provider failed",
+	ErrAccountLinkRequired:    "An account with this email already exists. Confirm with its password to link the identity provider",
+	ErrSAMLLoginFailed:        "Signing in with the SAML identity provider failed",
+	ErrReauthRequired:         "Please confirm your identity again to continue",
+	ErrMFARequired:            "Enter the code we sent to your email to finish signing in",
+	ErrLoginBlocked:           "Too many failed logins from your network. Please try again later.",
+	ErrChallengeRequired:      "Please solve the challenge and try again.",
+	ErrChallengeFailed:        "The challenge was not solved. Please try a new one.",
 }
 
 // GetErrorMessage returns a standard “user-friendly” message
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"strings"
+	"time"
+)
+
+const (
+	APIKeyPrefix = "sk_live_"
+
+	// apiKeyDisplayLength is how much of the key is kept in plain text to tell
+	// the keys apart: the prefix and the first characters of the secret.
+	apiKeyDisplayLength = len(APIKeyPrefix) + 6
+)
+
+// APIKey authenticates a machine client on behalf of a user (UserID) or of a
+// service (Service). Only the hash of the key is stored.
+type APIKey struct {
+	KeyID      int64      `json:"key_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"user_id,omitempty"`
+	Service    string     `json:"service,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedBy  int64      `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// GenerateAPIKey returns a new random key and sets its prefix and hash.
+func (k *APIKey) GenerateAPIKey() (string, error) {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := APIKeyPrefix + secret
+
+	k.Prefix = plaintext[:apiKeyDisplayLength]
+	k.Hash = verification.Hash(plaintext)
+
+	return plaintext, nil
+}
+
+// generateSecret returns size random bytes, base32 encoded in lower case.
+func generateSecret(size int) (string, error) {
+	randomBytes := make([]byte, size)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+func ValidateServiceName(v *validator.Validator, service string) {
+	v.Check(service != "", "service", "must be provided")
+	v.Check(validator.Matches(service, SlugRX), "service", "must contain only lowercase letters, digits and dashes")
+}
+
+// ValidateAPIKeyPlaintext only checks the format, so that obviously wrong keys
+// don't reach the database.
+func ValidateAPIKeyPlaintext(v *validator.Validator, key string) {
+	v.Check(strings.HasPrefix(key, APIKeyPrefix), "api_key", "must start with "+APIKeyPrefix)
+	v.Check(len(key) == len(APIKeyPrefix)+52, "api_key", "must be a valid api key")
+}
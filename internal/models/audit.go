@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	AuditImpersonation = "impersonation"
+)
+
+// AuditEvent records a security relevant action: who (the actor) did what to
+// whom (the subject).
+type AuditEvent struct {
+	EventID   int64                  `json:"event_id"`
+	Type      string                 `json:"type"`
+	ActorID   int64                  `json:"actor_user_id,omitempty"`
+	SubjectID int64                  `json:"subject_user_id,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
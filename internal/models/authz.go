@@ -0,0 +1,111 @@
+package models
+
+import (
+	"fmt"
+	"fullstack-simple-app/pkg/validator"
+	"slices"
+)
+
+const (
+	// OwnershipMatchOwner grants the action when the subject is the owner of
+	// the resource.
+	OwnershipMatchOwner = "owner"
+	// OwnershipMatchOrg grants the action when the resource belongs to the
+	// organization the subject acts in.
+	OwnershipMatchOrg = "org"
+
+	MaxAuthzBatchSize = 100
+)
+
+// AuthzSubject is who asks: the user or client of a verified access token or
+// API key.
+type AuthzSubject struct {
+	UserID int64
+	OrgID  int64
+	Roles  []string
+	// Scopes limit the actions to the listed ones. A client has no roles and
+	// may perform exactly the actions its scopes list.
+	Scopes []string
+	Client bool
+}
+
+func (s AuthzSubject) AllowsScope(action string) bool {
+	if len(s.Scopes) == 0 {
+		return !s.Client
+	}
+
+	return slices.Contains(s.Scopes, action)
+}
+
+type AuthzResource struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	OwnerID int64  `json:"owner_id"`
+	OrgID   int64  `json:"org_id"`
+	// Attributes are available to the policies as resource.<name>.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// AuthzCheck asks whether the subject may perform the action (a permission
+// name such as "users:write") on the resource.
+type AuthzCheck struct {
+	Action   string        `json:"action"`
+	Resource AuthzResource `json:"resource"`
+	// Environment is available to the policies as env.<name>, e.g. env.ip.
+	Environment map[string]interface{} `json:"environment,omitempty"`
+}
+
+type AuthzDecision struct {
+	Allowed bool `json:"allowed"`
+	// Rule names what decided: the token scopes, a policy, a role, an
+	// ownership rule or "default-deny".
+	Rule string `json:"rule,omitempty"`
+}
+
+// OwnershipRule allows the listed actions (or all of them, "*") on resources
+// of a type when the subject matches the resource as described by Match.
+type OwnershipRule struct {
+	Resource string
+	Actions  []string
+	Match    string
+}
+
+func (r OwnershipRule) String() string {
+	return fmt.Sprintf("ownership:%s:%s", r.Resource, r.Match)
+}
+
+func (r OwnershipRule) Applies(check AuthzCheck) bool {
+	if r.Resource != check.Resource.Type {
+		return false
+	}
+
+	for _, action := range r.Actions {
+		if action == "*" || action == check.Action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r OwnershipRule) Matches(subject AuthzSubject, resource AuthzResource) bool {
+	switch r.Match {
+	case OwnershipMatchOwner:
+		return subject.UserID != 0 && subject.UserID == resource.OwnerID
+	case OwnershipMatchOrg:
+		return subject.OrgID != 0 && subject.OrgID == resource.OrgID
+	default:
+		return false
+	}
+}
+
+func ValidateAuthzCheck(v *validator.Validator, check AuthzCheck) {
+	v.Check(check.Action != "", "action", "must be provided")
+	v.Check(check.Resource.Type != "", "resource.type", "must be provided")
+}
+
+func ValidateOwnershipRule(v *validator.Validator, rule OwnershipRule) {
+	v.Check(rule.Resource != "", "resource", "must be provided")
+	v.Check(len(rule.Actions) > 0, "actions", "must contain at least 1 action")
+	v.Check(validator.In(rule.Match, OwnershipMatchOwner, OwnershipMatchOrg), "match", "must be one of owner, org")
+}
@@ -0,0 +1,9 @@
+package models
+
+// ChallengeAnswer proves a request isn't made by a bot: a solved
+// proof-of-work challenge or the response of a CAPTCHA.
+type ChallengeAnswer struct {
+	Token    string
+	Solution string
+	Captcha  string
+}
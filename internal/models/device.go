@@ -0,0 +1,78 @@
+package models
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+
+	// userCodeAlphabet has no vowels, so that codes don't spell words, and
+	// no characters that are easily confused (RFC 8628, 6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// DeviceAuthorization is a pending device flow (RFC 8628): the device polls
+// with the device code while the user approves the user code elsewhere.
+type DeviceAuthorization struct {
+	ClientID     string        `json:"client_id"`
+	Scopes       []string      `json:"scopes"`
+	UserCode     string        `json:"user_code"`
+	Status       string        `json:"status"`
+	UserID       int64         `json:"user_id,omitempty"`
+	OrgID        int64         `json:"org_id,omitempty"`
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt time.Time     `json:"last_polled_at"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+// DeviceCode is the response of the device authorization endpoint.
+type DeviceCode struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+	Interval                time.Duration
+}
+
+// GenerateUserCode returns a code like "WDJB-MJHT" for the user to type.
+func GenerateUserCode() (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+
+	return b.String(), nil
+}
+
+// GenerateDeviceCode returns a new random device code.
+func GenerateDeviceCode() (string, error) {
+	return generateSecret(32)
+}
+
+// NormalizeUserCode makes the code typed by the user comparable: case and
+// separators don't matter.
+func NormalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Identity links the account of a user at an identity provider (Google,
+// GitHub, ...) to a local user. Subject is the ID of the user at the
+// provider.
+type Identity struct {
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	UserID      int64      `json:"user_id"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// IdentityLink is an identity waiting for the owner of the local account
+// with the same email to confirm the link.
+type IdentityLink struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	UserID   int64  `json:"user_id"`
+}
+
+var (
+	ErrIdentityNotFound  = errors.New("identity not found")
+	ErrDuplicateIdentity = errors.New("identity is already linked")
+)
+
+// GenerateLinkToken returns a new random token for an IdentityLink.
+func GenerateLinkToken() (string, error) {
+	return generateSecret(32)
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+const (
+	LoginSourceIP     = "ip"
+	LoginSourceSubnet = "subnet"
+)
+
+// LoginBlock is a source of failed logins refused until ExpiresAt: a single
+// address or its whole network.
+type LoginBlock struct {
+	Kind      string    `json:"kind"`
+	Source    string    `json:"source"`
+	Reason    string    `json:"reason"`
+	BlockedAt time.Time `json:"blocked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LoginAbuseStats describes the failed logins seen lately. The totals count
+// since the start of this instance, the rest is shared by all instances.
+type LoginAbuseStats struct {
+	// Failures is the number of failed logins in the current window of all
+	// sources together.
+	Failures int64 `json:"failures"`
+	// Spike is whether Failures went over the threshold of an attack on the
+	// whole system, which makes the thresholds of sources stricter.
+	Spike bool `json:"spike"`
+
+	TotalFailures int64 `json:"total_failures"`
+	TotalRejected int64 `json:"total_rejected"`
+	TotalBlocks   int64 `json:"total_blocks"`
+	TotalSpikes   int64 `json:"total_spikes"`
+}
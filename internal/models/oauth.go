@@ -0,0 +1,177 @@
+package models
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fullstack-simple-app/pkg/oauth"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const ResponseTypeCode = "code"
+
+// OAuthClient is an application registered to get tokens of users through
+// the authorization code flow, or tokens of its own through the client
+// credentials grant. Confidential clients authenticate with a secret, of
+// which only the hash is stored; public clients (SPAs, mobile apps) can't
+// keep one and rely on PKCE alone.
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	SecretHash   []byte    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthorizationRequest holds the parameters of the authorization endpoint.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is returned in the ID token, OpenID Connect only.
+	Nonce string
+}
+
+// AuthorizationCode is what an issued code stands for until it's exchanged.
+type AuthorizationCode struct {
+	ClientID      string   `json:"client_id"`
+	RedirectURI   string   `json:"redirect_uri"`
+	UserID        int64    `json:"user_id"`
+	OrgID         int64    `json:"org_id"`
+	Scopes        []string `json:"scopes"`
+	CodeChallenge string   `json:"code_challenge"`
+	Nonce         string   `json:"nonce,omitempty"`
+}
+
+type RefreshToken struct {
+	Hash      []byte
+	ClientID  string
+	UserID    int64
+	OrgID     int64
+	Scopes    []string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// TokenGrant is what the token endpoint hands out.
+type TokenGrant struct {
+	AccessToken  string
+	ExpiresIn    time.Duration
+	RefreshToken string
+	// IDToken is issued when the openid scope was granted.
+	IDToken string
+	Scopes  []string
+}
+
+var (
+	ErrClientNotFound       = errors.New("oauth client not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+)
+
+// GenerateCredentials sets a new client ID and, for a confidential client,
+// returns its secret.
+func (c *OAuthClient) GenerateCredentials() (string, error) {
+	clientID, err := generateSecret(10)
+	if err != nil {
+		return "", err
+	}
+
+	c.ClientID = clientID
+
+	if !c.Confidential {
+		return "", nil
+	}
+
+	secret, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	c.SecretHash = verification.Hash(secret)
+
+	return secret, nil
+}
+
+// Authenticate checks the secret of a confidential client. Public clients
+// have none to check.
+func (c *OAuthClient) Authenticate(secret string) bool {
+	if !c.Confidential {
+		return secret == ""
+	}
+
+	return subtle.ConstantTimeCompare(verification.Hash(secret), c.SecretHash) == 1
+}
+
+// AllowsRedirectURI only accepts one of the registered URIs, compared as
+// strings.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// GenerateAuthorizationCode returns a new random code.
+func GenerateAuthorizationCode() (string, error) {
+	return generateSecret(32)
+}
+
+// GenerateRefreshToken returns a new random token and sets its hash.
+func (t *RefreshToken) GenerateRefreshToken() (string, error) {
+	plaintext, err := generateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	t.Hash = verification.Hash(plaintext)
+
+	return plaintext, nil
+}
+
+func (t *RefreshToken) Expired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(client.Scopes) > 0, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+
+	// the authorization code flow is all a public client can use
+	if !client.Confidential {
+		v.Check(len(client.RedirectURIs) > 0, "redirect_uris", "must contain at least 1 uri for a public client")
+	}
+
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must contain absolute uris without fragment, http only for localhost")
+	}
+}
+
+func ValidateAuthorizationRequest(v *validator.Validator, req AuthorizationRequest) {
+	v.Check(req.ResponseType == ResponseTypeCode, "response_type", "must be code")
+	v.Check(req.ClientID != "", "client_id", "must be provided")
+	v.Check(req.RedirectURI != "", "redirect_uri", "must be provided")
+	v.Check(req.CodeChallengeMethod == oauth.CodeChallengeMethodS256, "code_challenge_method", "must be S256")
+	v.Check(oauth.ValidCodeChallenge(req.CodeChallenge), "code_challenge", "must be a base64url encoded SHA-256 hash")
+}
+
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+
+	if u.Scheme == "http" {
+		return validator.In(u.Hostname(), "localhost", "127.0.0.1", "::1")
+	}
+
+	return true
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+	"fullstack-simple-app/pkg/validator"
+	"regexp"
+	"time"
+)
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// Organization groups users. An isolated organization is a tenant with its own
+// user namespace: its accounts can't join other organizations, and their
+// emails may repeat accounts that exist elsewhere.
+type Organization struct {
+	OrgID     int64     `json:"org_id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Isolated  bool      `json:"isolated"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Membership struct {
+	OrgID     int64     `json:"org_id"`
+	OrgName   string    `json:"org_name,omitempty"`
+	OrgSlug   string    `json:"org_slug,omitempty"`
+	UserID    int64     `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Invitation struct {
+	InvitationID int64      `json:"invitation_id"`
+	OrgID        int64      `json:"org_id"`
+	Email        string     `json:"email"`
+	Role         string     `json:"role"`
+	TokenHash    []byte     `json:"-"`
+	InvitedBy    int64      `json:"invited_by"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	AcceptedAt   *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+var (
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrDuplicateSlug      = errors.New("duplicate organization slug")
+	ErrNotMember          = errors.New("user is not a member of the organization")
+	ErrInvitationNotFound = errors.New("invitation not found")
+
+	SlugRX = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// CanManage reports whether a member with this role may invite members.
+func (m Membership) CanManage() bool {
+	return m.Role == OrgRoleOwner || m.Role == OrgRoleAdmin
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	ValidateSlug(v, org.Slug)
+}
+
+func ValidateSlug(v *validator.Validator, slug string) {
+	v.Check(slug != "", "org", "must be provided")
+	v.Check(validator.Matches(slug, SlugRX), "org", "must contain only lowercase letters, digits and dashes")
+}
+
+func ValidateOrgRole(v *validator.Validator, role string) {
+	v.Check(validator.In(role, OrgRoleOwner, OrgRoleAdmin, OrgRoleMember), "role", "must be one of owner, admin, member")
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Peppers are server-side secrets mixed into a password with HMAC-SHA256
+// before it is hashed with bcrypt. They never reach the database, only their
+// version is stored next to each hash, so a leaked users table alone is not
+// enough to crack passwords offline. Version 0 stands for "no pepper".
+type Peppers struct {
+	Current int
+	Keys    map[int][]byte
+}
+
+var (
+	ErrUnknownPepperVersion = errors.New("unknown pepper version")
+
+	peppersMu sync.RWMutex
+	peppers   = Peppers{}
+)
+
+// SetPeppers installs the pepper keys used by Password. Several versions may
+// be active at once during a rotation: hashes are always verified with the
+// version they were created with, and new ones use the current version.
+func SetPeppers(p Peppers) error {
+	if p.Current != 0 {
+		if _, ok := p.Keys[p.Current]; !ok {
+			return fmt.Errorf("%w: current version %d has no key", ErrUnknownPepperVersion, p.Current)
+		}
+	}
+	if _, ok := p.Keys[0]; ok {
+		return errors.New("pepper version 0 is reserved for unpeppered hashes")
+	}
+
+	peppersMu.Lock()
+	defer peppersMu.Unlock()
+
+	peppers = p
+
+	return nil
+}
+
+func currentPepperVersion() int {
+	peppersMu.RLock()
+	defer peppersMu.RUnlock()
+
+	return peppers.Current
+}
+
+// pepper returns the input for bcrypt: the plaintext itself for version 0, or
+// its HMAC under the given pepper version otherwise. The MAC is base64 encoded
+// so it contains no zero bytes and fits into bcrypt's 72-byte limit.
+func pepper(plaintext string, version int) ([]byte, error) {
+	if version == 0 {
+		return []byte(plaintext), nil
+	}
+
+	peppersMu.RLock()
+	key, ok := peppers.Keys[version]
+	peppersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPepperVersion, version)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(plaintext))
+
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
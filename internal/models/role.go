@@ -0,0 +1,25 @@
+package models
+
+import (
+	"errors"
+	"fullstack-simple-app/pkg/validator"
+	"regexp"
+)
+
+type Role struct {
+	RoleID      int64    `json:"role_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+
+	RoleNameRX = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+)
+
+func ValidateRoleName(v *validator.Validator, role string) {
+	v.Check(role != "", "role", "must be provided")
+	v.Check(validator.Matches(role, RoleNameRX), "role", "must be a valid role name")
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"crypto/x509"
+	"errors"
+	"fullstack-simple-app/pkg/saml"
+	"fullstack-simple-app/pkg/validator"
+	"time"
+)
+
+// SAMLConnection is the SAML identity provider the accounts of an isolated
+// organization sign in with. The attributes name where the assertion holds
+// the fields of the account; an empty email attribute takes the NameID.
+type SAMLConnection struct {
+	OrgID              int64  `json:"org_id"`
+	IdPEntityID        string `json:"idp_entity_id"`
+	SSOURL             string `json:"sso_url"`
+	Certificate        string `json:"certificate"`
+	EmailAttribute     string `json:"email_attribute"`
+	FirstNameAttribute string `json:"first_name_attribute"`
+	LastNameAttribute  string `json:"last_name_attribute"`
+	// AllowIdPInitiated accepts logins started at the identity provider,
+	// which can't be tied to a request of ours.
+	AllowIdPInitiated bool      `json:"allow_idp_initiated"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+var ErrSAMLConnectionNotFound = errors.New("saml connection not found")
+
+// IdentityProvider returns the identity provider responses are validated
+// against.
+func (c *SAMLConnection) IdentityProvider() (saml.IdentityProvider, error) {
+	cert, err := saml.ParseCertificate(c.Certificate)
+	if err != nil {
+		return saml.IdentityProvider{}, err
+	}
+
+	return saml.IdentityProvider{
+		EntityID:     c.IdPEntityID,
+		SSOURL:       c.SSOURL,
+		Certificates: []*x509.Certificate{cert},
+	}, nil
+}
+
+// MapUser returns the account fields the assertion holds.
+func (c *SAMLConnection) MapUser(assertion *saml.Assertion) User {
+	user := User{
+		Email:       assertion.NameID,
+		FirstName:   assertion.Attribute(c.FirstNameAttribute),
+		LastName:    assertion.Attribute(c.LastNameAttribute),
+		TenantOrgID: c.OrgID,
+	}
+
+	if c.EmailAttribute != "" {
+		user.Email = assertion.Attribute(c.EmailAttribute)
+	}
+
+	return user
+}
+
+func ValidateSAMLConnection(v *validator.Validator, c *SAMLConnection) {
+	v.Check(c.IdPEntityID != "", "idp_entity_id", "must be provided")
+	v.Check(validRedirectURI(c.SSOURL), "sso_url", "must be an absolute uri, http only for localhost")
+
+	_, err := saml.ParseCertificate(c.Certificate)
+	v.Check(err == nil, "certificate", "must be a PEM or base64 encoded X.509 certificate")
+
+	for field, attribute := range map[string]string{
+		"email_attribute":      c.EmailAttribute,
+		"first_name_attribute": c.FirstNameAttribute,
+		"last_name_attribute":  c.LastNameAttribute,
+	} {
+		v.Check(len(attribute) <= 255, field, "must not be more than 255 bytes long")
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+// SignIn is the outcome of a sign in with the right credentials. Exactly one
+// of the tokens is set: the access token, or a limited token good only for
+// the step the user has to take first.
+type SignIn struct {
+	AccessToken string
+	// MFAToken completes the sign in with the code sent to the user.
+	MFAToken string
+	// PasswordChangeToken may only set a new password, the old one expired.
+	PasswordChangeToken string
+	// LinkToken links a social login to the existing account of its email
+	// once the password of the account is confirmed.
+	LinkToken string
+}
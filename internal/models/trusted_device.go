@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// TrustedDevice is a browser where the user completed the second factor and
+// asked to be remembered: signing in there needs only the password until
+// ExpiresAt. The browser keeps the secret in a cookie, we keep its hash.
+type TrustedDevice struct {
+	DeviceID   int64     `json:"device_id"`
+	UserID     int64     `json:"user_id"`
+	TokenHash  []byte    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// RememberedDevice is the remember-me cookie of a new TrustedDevice.
+type RememberedDevice struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// ClientInfo describes the client a request came from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	// DeviceID is the value of the long-lived device cookie, if any.
+	DeviceID string
+}
+
+// LoginDevice is a device the user signed in from, known by its device
+// cookie, or by its browser and network.
+type LoginDevice struct {
+	DeviceID   int64     `json:"device_id"`
+	UserID     int64     `json:"user_id"`
+	CookieHash []byte    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IPPrefix   string    `json:"ip_prefix"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+var ErrTrustedDeviceNotFound = errors.New("trusted device not found")
+
+// GenerateDeviceToken returns a new random secret for a TrustedDevice.
+func GenerateDeviceToken() (string, error) {
+	return generateSecret(32)
+}
+
+// GenerateDeviceID returns a new random value for the device cookie.
+func GenerateDeviceID() (string, error) {
+	return generateSecret(32)
+}
+
+// GenerateLoginAlertToken returns a new random token for the "this wasn't
+// me" link of a login alert.
+func GenerateLoginAlertToken() (string, error) {
+	return generateSecret(32)
+}
+
+// GenerateMFAToken returns a new random token for a pending second factor.
+func GenerateMFAToken() (string, error) {
+	return generateSecret(32)
+}
@@ -4,9 +4,16 @@ import (
 	"errors"
 	"fullstack-simple-app/pkg/validator"
 	"golang.org/x/crypto/bcrypt"
+	"regexp"
 	"time"
 )
 
+// Channels one-time codes are delivered through.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
 type User struct {
 	UserID    int64     `json:"user_id"`
 	FirstName string    `json:"first_name"`
@@ -18,36 +25,91 @@ type User struct {
 	Active    bool      `json:"active"`
 	DeletedAt time.Time `json:"deleted_at"`
 	Activated bool      `json:"activated"`
+
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	Roles             []string  `json:"roles"`
+	// TenantOrgID is the isolated organization the account belongs to,
+	// zero for global accounts.
+	TenantOrgID int64 `json:"tenant_org_id,omitempty"`
+	// MFAEnabled asks for a code after the password, except on trusted
+	// devices. MFAMethod is the channel the code is sent through.
+	MFAEnabled bool   `json:"mfa_enabled"`
+	MFAMethod  string `json:"mfa_method"`
+	// Phone is in E.164 format. Codes are only texted to verified phones,
+	// activation codes aside.
+	Phone         string `json:"phone,omitempty"`
+	PhoneVerified bool   `json:"phone_verified"`
+	// EmailVerified is whether the user proved to own the email. Accounts
+	// activated with a texted code haven't.
+	EmailVerified bool `json:"email_verified"`
 }
 type Password struct {
-	plaintext *string
-	Hash      []byte
+	plaintext     *string
+	Hash          []byte
+	PepperVersion int
 }
 
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 	ErrNotFound       = errors.New("user not found")
+	ErrInvalidCursor  = errors.New("invalid cursor")
+
+	// PhoneRX matches phone numbers in E.164 format.
+	PhoneRX = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
 )
 
-// The Set() method calculates the bcrypt hash of a plaintext password, and stores both
-// the hash and the plaintext versions in the struct
+// PasswordExpiresAt returns the moment the user's password expires when
+// passwords may live for maxAge. A zero maxAge means passwords never expire.
+func (u *User) PasswordExpiresAt(maxAge time.Duration) (time.Time, bool) {
+	if maxAge <= 0 {
+		return time.Time{}, false
+	}
+	return u.PasswordChangedAt.Add(maxAge), true
+}
+
+// The Set() method calculates the bcrypt hash of a plaintext password peppered with
+// the current pepper version, and stores both the hash and the plaintext versions in the struct
 func (p *Password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	version := currentPepperVersion()
+
+	peppered, err := pepper(plaintextPassword, version)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(peppered, 12)
 	if err != nil {
 		return err
 	}
 
 	p.plaintext = &plaintextPassword
 	p.Hash = hash
+	p.PepperVersion = version
 
 	return nil
 }
 
+// SetRandom sets a password nobody knows, for accounts created through an
+// identity provider. The user can set one later with a password reset.
+func (p *Password) SetRandom() error {
+	secret, err := generateSecret(32)
+	if err != nil {
+		return err
+	}
+
+	return p.Set(secret)
+}
+
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
 // otherwise.
 func (p *Password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.Hash, []byte(plaintextPassword))
+	peppered, err := pepper(plaintextPassword, p.PepperVersion)
+	if err != nil {
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword(p.Hash, peppered)
 	if err != nil {
 		switch {
 		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
@@ -60,11 +122,26 @@ func (p *Password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// NeedsRehash reports whether the hash was made with a pepper version other
+// than the current one and should be recomputed on the next successful login.
+func (p *Password) NeedsRehash() bool {
+	return p.PepperVersion != currentPepperVersion()
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
 
+func ValidatePhone(v *validator.Validator, phone string) {
+	v.Check(phone != "", "phone", "must be provided")
+	v.Check(validator.Matches(phone, PhoneRX), "phone", "must be in international format, e.g. +992901234567")
+}
+
+func ValidateChannel(v *validator.Validator, channel string) {
+	v.Check(validator.In(channel, ChannelEmail, ChannelSMS), "channel", "must be email or sms")
+}
+
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
@@ -80,6 +157,10 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	ValidateEmail(v, user.Email)
 
+	if user.Phone != "" {
+		ValidatePhone(v, user.Phone)
+	}
+
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fullstack-simple-app/pkg/validator"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultUsersPageSize = 50
+	MaxUsersPageSize     = 200
+)
+
+// UserSortSafelist holds the values accepted by UserFilter.Sort; a leading
+// "-" means descending order.
+var UserSortSafelist = []string{
+	"user_id", "email", "last_name", "created_at",
+	"-user_id", "-email", "-last_name", "-created_at",
+}
+
+// UserFilter describes a search over users for the admin API. Zero values
+// mean "don't filter by this field".
+type UserFilter struct {
+	EmailPrefix string
+	Name        string
+	Activated   *bool
+	Active      *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Role        string
+	// OrgID limits the search to members of the organization.
+	OrgID int64
+
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SortColumn returns the column to sort by and whether the order is descending.
+func (f UserFilter) SortColumn() (string, bool) {
+	if strings.HasPrefix(f.Sort, "-") {
+		return strings.TrimPrefix(f.Sort, "-"), true
+	}
+	return f.Sort, false
+}
+
+func ValidateUserFilter(v *validator.Validator, f UserFilter) {
+	v.Check(validator.In(f.Sort, UserSortSafelist...), "sort", "invalid sort value")
+	v.Check(f.Limit > 0, "limit", "must be greater than zero")
+	v.Check(f.Limit <= MaxUsersPageSize, "limit", "must be a maximum of 200")
+	v.Check(len(f.EmailPrefix) <= 254, "email_prefix", "must not be more than 254 bytes long")
+	v.Check(len(f.Name) <= 50, "name", "must not be more than 50 bytes long")
+
+	if f.Role != "" {
+		ValidateRoleName(v, f.Role)
+	}
+
+	if f.CreatedFrom != nil && f.CreatedTo != nil {
+		v.Check(f.CreatedFrom.Before(*f.CreatedTo), "created_to", "must be after created_from")
+	}
+}
@@ -0,0 +1,176 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type APIKeyModel struct {
+	pg *postgres.Postgres
+}
+
+func NewAPIKeyRepo(db *postgres.Postgres) *APIKeyModel {
+	return &APIKeyModel{pg: db}
+}
+
+const apiKeyColumns = `key_id, name, prefix, key_hash, COALESCE(user_id, 0), COALESCE(service, ''),
+		scopes, expires_at, last_used_at, COALESCE(created_by, 0), created_at`
+
+func (a *APIKeyModel) CreateAPIKey(key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (name, prefix, key_hash, user_id, service, scopes, expires_at, created_by)
+		VALUES ($1, $2, $3, NULLIF($4::integer, 0), NULLIF($5, ''), $6, $7, NULLIF($8::integer, 0))
+		RETURNING key_id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return a.pg.Pool.QueryRow(
+		ctx,
+		query,
+		key.Name, key.Prefix, key.Hash, key.UserID, key.Service, key.Scopes, key.ExpiresAt, key.CreatedBy,
+	).Scan(&key.KeyID, &key.CreatedAt)
+}
+
+// GetAPIKeyByHash returns the key with the given hash unless it was revoked.
+// Expiry is left to the caller.
+func (a *APIKeyModel) GetAPIKeyByHash(hash []byte) (models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanAPIKey(a.pg.Pool.QueryRow(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.APIKey{}, models.ErrAPIKeyNotFound
+		}
+		return models.APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// ListUserAPIKeys returns the keys of the user that were not revoked.
+func (a *APIKeyModel) ListUserAPIKeys(userID int64) ([]models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY key_id`
+
+	return a.listAPIKeys(query, userID)
+}
+
+// ListServiceAPIKeys returns the keys of all services that were not revoked.
+func (a *APIKeyModel) ListServiceAPIKeys() ([]models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE service IS NOT NULL AND revoked_at IS NULL
+		ORDER BY service, key_id`
+
+	return a.listAPIKeys(query)
+}
+
+func (a *APIKeyModel) listAPIKeys(query string, args ...interface{}) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.pg.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeUserAPIKey revokes a key of the user.
+func (a *APIKeyModel) RevokeUserAPIKey(userID, keyID int64) error {
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE key_id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	return a.revokeAPIKey(query, keyID, userID)
+}
+
+// RevokeUserAPIKeys revokes all keys of the user.
+func (a *APIKeyModel) RevokeUserAPIKeys(userID int64) error {
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := a.pg.Pool.Exec(ctx, query, userID)
+
+	return err
+}
+
+// RevokeServiceAPIKey revokes a key of any service.
+func (a *APIKeyModel) RevokeServiceAPIKey(keyID int64) error {
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE key_id = $1 AND service IS NOT NULL AND revoked_at IS NULL`
+
+	return a.revokeAPIKey(query, keyID)
+}
+
+func (a *APIKeyModel) revokeAPIKey(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := a.pg.Pool.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIKey records the use of the key. The timestamp is kept with a minute
+// precision so that busy clients don't write on every request.
+func (a *APIKeyModel) TouchAPIKey(keyID int64) error {
+	query := `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE key_id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := a.pg.Pool.Exec(ctx, query, keyID)
+
+	return err
+}
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+	var key models.APIKey
+
+	err := row.Scan(
+		&key.KeyID, &key.Name, &key.Prefix, &key.Hash, &key.UserID, &key.Service,
+		&key.Scopes, &key.ExpiresAt, &key.LastUsedAt, &key.CreatedBy, &key.CreatedAt,
+	)
+
+	return key, err
+}
@@ -0,0 +1,37 @@
+package repositories
+
+import (
+	"context"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"time"
+)
+
+type AuditModel struct {
+	pg *postgres.Postgres
+}
+
+func NewAuditRepo(db *postgres.Postgres) *AuditModel {
+	return &AuditModel{pg: db}
+}
+
+func (a *AuditModel) RecordEvent(event *models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (event_type, actor_user_id, subject_user_id, ip, details)
+		VALUES ($1, NULLIF($2::integer, 0), NULLIF($3::integer, 0), $4, $5)
+		RETURNING event_id, created_at`
+
+	details := event.Details
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return a.pg.Pool.QueryRow(
+		ctx,
+		query,
+		event.Type, event.ActorID, event.SubjectID, event.IP, details,
+	).Scan(&event.EventID, &event.CreatedAt)
+}
@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+type IdentityModel struct {
+	pg *postgres.Postgres
+}
+
+func NewIdentityRepo(db *postgres.Postgres) *IdentityModel {
+	return &IdentityModel{pg: db}
+}
+
+const identityColumns = `provider, subject, user_id, COALESCE(email, ''), created_at, last_login_at`
+
+// CreateIdentity links the identity to its user. A user has at most one
+// identity per provider.
+func (i *IdentityModel) CreateIdentity(identity *models.Identity) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NOW())
+		RETURNING created_at, last_login_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := i.pg.Pool.QueryRow(
+		ctx,
+		query,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email,
+	).Scan(&identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return models.ErrDuplicateIdentity
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (i *IdentityModel) GetIdentity(provider, subject string) (models.Identity, error) {
+	query := `
+		SELECT ` + identityColumns + ` FROM user_identities
+		WHERE provider = $1 AND subject = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	identity, err := scanIdentity(i.pg.Pool.QueryRow(ctx, query, provider, subject))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Identity{}, models.ErrIdentityNotFound
+		}
+		return models.Identity{}, err
+	}
+
+	return identity, nil
+}
+
+func (i *IdentityModel) ListUserIdentities(userID int64) ([]models.Identity, error) {
+	query := `
+		SELECT ` + identityColumns + ` FROM user_identities
+		WHERE user_id = $1
+		ORDER BY provider`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := i.pg.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.Identity{}
+
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+func (i *IdentityModel) DeleteIdentity(userID int64, provider string) error {
+	query := `
+		DELETE FROM user_identities
+		WHERE user_id = $1 AND provider = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := i.pg.Pool.Exec(ctx, query, userID, provider)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return models.ErrIdentityNotFound
+	}
+
+	return nil
+}
+
+// TouchIdentity records a login with the identity and the email the provider
+// knows the user by now.
+func (i *IdentityModel) TouchIdentity(identity *models.Identity) error {
+	query := `
+		UPDATE user_identities SET last_login_at = NOW(), email = NULLIF($3, '')
+		WHERE provider = $1 AND subject = $2
+		RETURNING last_login_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return i.pg.Pool.QueryRow(ctx, query, identity.Provider, identity.Subject, identity.Email).Scan(&identity.LastLoginAt)
+}
+
+func scanIdentity(row pgx.Row) (models.Identity, error) {
+	var identity models.Identity
+
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+
+	return identity, err
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type LoginDeviceModel struct {
+	pg *postgres.Postgres
+}
+
+func NewLoginDeviceRepo(db *postgres.Postgres) *LoginDeviceModel {
+	return &LoginDeviceModel{pg: db}
+}
+
+// RecordLoginDevice remembers the device of a login and reports whether it
+// was known: by its cookie, or by its user agent and network. The first
+// device of a user counts as known.
+func (l *LoginDeviceModel) RecordLoginDevice(device *models.LoginDevice) (bool, error) {
+	update := `
+		UPDATE login_devices SET last_seen_at = NOW(), cookie_hash = COALESCE($2, cookie_hash), user_agent = $3, ip_prefix = $4
+		WHERE device_id = (
+			SELECT device_id FROM login_devices
+			WHERE user_id = $1 AND (cookie_hash = $2 OR (user_agent = $3 AND ip_prefix = $4))
+			ORDER BY cookie_hash = $2 DESC NULLS LAST, last_seen_at DESC
+			LIMIT 1
+		)
+		RETURNING device_id, created_at, last_seen_at`
+
+	// the subquery doesn't see the inserted row yet
+	insert := `
+		INSERT INTO login_devices (user_id, cookie_hash, user_agent, ip_prefix)
+		VALUES ($1, $2, $3, $4)
+		RETURNING device_id, created_at, last_seen_at,
+			NOT EXISTS (SELECT 1 FROM login_devices WHERE user_id = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{device.UserID, device.CookieHash, device.UserAgent, device.IPPrefix}
+
+	err := l.pg.Pool.QueryRow(ctx, update, args...).Scan(&device.DeviceID, &device.CreatedAt, &device.LastSeenAt)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, err
+	}
+
+	var first bool
+
+	err = l.pg.Pool.QueryRow(ctx, insert, args...).Scan(&device.DeviceID, &device.CreatedAt, &device.LastSeenAt, &first)
+	if err != nil {
+		return false, err
+	}
+
+	return first, nil
+}
+
+// DeleteLoginDevices forgets the devices of the user, so that every next
+// login is reported.
+func (l *LoginDeviceModel) DeleteLoginDevices(userID int64) error {
+	query := `DELETE FROM login_devices WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := l.pg.Pool.Exec(ctx, query, userID)
+
+	return err
+}
@@ -0,0 +1,146 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type OAuthModel struct {
+	pg *postgres.Postgres
+}
+
+func NewOAuthRepo(db *postgres.Postgres) *OAuthModel {
+	return &OAuthModel{pg: db}
+}
+
+func (o *OAuthModel) CreateClient(client *models.OAuthClient) error {
+	query := `
+		INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris, scopes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return o.pg.Pool.QueryRow(
+		ctx,
+		query,
+		client.ClientID, client.Name, client.SecretHash, client.RedirectURIs, client.Scopes,
+	).Scan(&client.CreatedAt)
+}
+
+func (o *OAuthModel) GetClient(clientID string) (models.OAuthClient, error) {
+	query := `
+		SELECT client_id, name, secret_hash, redirect_uris, scopes, created_at FROM oauth_clients
+		WHERE client_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	client, err := scanClient(o.pg.Pool.QueryRow(ctx, query, clientID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.OAuthClient{}, models.ErrClientNotFound
+		}
+		return models.OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+func (o *OAuthModel) ListClients() ([]models.OAuthClient, error) {
+	query := `
+		SELECT client_id, name, secret_hash, redirect_uris, scopes, created_at FROM oauth_clients
+		ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := o.pg.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.OAuthClient{}
+
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+func (o *OAuthModel) CreateRefreshToken(token *models.RefreshToken) error {
+	query := `
+		INSERT INTO oauth_refresh_tokens (token_hash, client_id, user_id, org_id, scopes, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4::integer, 0), $5, $6)
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return o.pg.Pool.QueryRow(
+		ctx,
+		query,
+		token.Hash, token.ClientID, token.UserID, token.OrgID, token.Scopes, token.ExpiresAt,
+	).Scan(&token.CreatedAt)
+}
+
+// ConsumeRefreshToken revokes the refresh token and returns it, so that each
+// one can be used once. Revoked tokens are not found.
+func (o *OAuthModel) ConsumeRefreshToken(hash []byte) (models.RefreshToken, error) {
+	query := `
+		UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		RETURNING token_hash, client_id, user_id, COALESCE(org_id, 0), scopes, expires_at, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token models.RefreshToken
+
+	err := o.pg.Pool.QueryRow(ctx, query, hash).Scan(
+		&token.Hash, &token.ClientID, &token.UserID, &token.OrgID, &token.Scopes, &token.ExpiresAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.RefreshToken{}, models.ErrRefreshTokenNotFound
+		}
+		return models.RefreshToken{}, err
+	}
+
+	return token, nil
+}
+
+// RevokeUserRefreshTokens revokes all refresh tokens issued to the user.
+func (o *OAuthModel) RevokeUserRefreshTokens(userID int64) error {
+	query := `
+		UPDATE oauth_refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := o.pg.Pool.Exec(ctx, query, userID)
+
+	return err
+}
+
+func scanClient(row pgx.Row) (models.OAuthClient, error) {
+	var client models.OAuthClient
+
+	err := row.Scan(&client.ClientID, &client.Name, &client.SecretHash, &client.RedirectURIs, &client.Scopes, &client.CreatedAt)
+	client.Confidential = client.SecretHash != nil
+
+	return client, err
+}
@@ -0,0 +1,270 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+type OrgModel struct {
+	pg *postgres.Postgres
+}
+
+func NewOrgRepo(db *postgres.Postgres) *OrgModel {
+	return &OrgModel{pg: db}
+}
+
+// CreateOrganization stores the organization and, if ownerID is not zero,
+// makes that user its owner.
+func (o *OrgModel) CreateOrganization(org *models.Organization, ownerID int64) error {
+	query := `
+		INSERT INTO organizations (name, slug, isolated)
+		VALUES ($1, $2, $3)
+		RETURNING org_id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := o.pg.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, org.Name, org.Slug, org.Isolated).Scan(&org.OrgID, &org.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return models.ErrDuplicateSlug
+		}
+		return err
+	}
+
+	if ownerID != 0 {
+		err = addMember(ctx, tx, org.OrgID, ownerID, models.OrgRoleOwner)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (o *OrgModel) GetOrganizationBySlug(slug string) (models.Organization, error) {
+	query := `
+		SELECT org_id, name, slug, isolated, created_at FROM organizations
+		WHERE slug = $1`
+
+	return o.getOrganization(query, slug)
+}
+
+func (o *OrgModel) GetOrganizationByID(orgID int64) (models.Organization, error) {
+	query := `
+		SELECT org_id, name, slug, isolated, created_at FROM organizations
+		WHERE org_id = $1`
+
+	return o.getOrganization(query, orgID)
+}
+
+func (o *OrgModel) getOrganization(query string, arg interface{}) (models.Organization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var org models.Organization
+
+	err := o.pg.Pool.QueryRow(ctx, query, arg).Scan(&org.OrgID, &org.Name, &org.Slug, &org.Isolated, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Organization{}, models.ErrOrgNotFound
+		}
+		return models.Organization{}, err
+	}
+
+	return org, nil
+}
+
+func (o *OrgModel) GetMembership(orgID, userID int64) (models.Membership, error) {
+	query := `
+		SELECT m.org_id, o.name, o.slug, m.user_id, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN organizations o ON o.org_id = m.org_id
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.org_id = $1 AND m.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	membership, err := scanMembership(o.pg.Pool.QueryRow(ctx, query, orgID, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Membership{}, models.ErrNotMember
+		}
+		return models.Membership{}, err
+	}
+
+	return membership, nil
+}
+
+func (o *OrgModel) GetUserMemberships(userID int64) ([]models.Membership, error) {
+	query := `
+		SELECT m.org_id, o.name, o.slug, m.user_id, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN organizations o ON o.org_id = m.org_id
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.user_id = $1
+		ORDER BY o.name`
+
+	return o.listMemberships(query, userID)
+}
+
+func (o *OrgModel) GetOrgMembers(orgID int64) ([]models.Membership, error) {
+	query := `
+		SELECT m.org_id, o.name, o.slug, m.user_id, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN organizations o ON o.org_id = m.org_id
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY u.email`
+
+	return o.listMemberships(query, orgID)
+}
+
+func (o *OrgModel) listMemberships(query string, arg int64) ([]models.Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := o.pg.Pool.Query(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []models.Membership{}
+
+	for rows.Next() {
+		membership, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, membership)
+	}
+
+	return memberships, rows.Err()
+}
+
+func (o *OrgModel) CreateInvitation(invitation *models.Invitation) error {
+	query := `
+		INSERT INTO org_invitations (org_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING invitation_id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return o.pg.Pool.QueryRow(
+		ctx,
+		query,
+		invitation.OrgID, invitation.Email, invitation.Role,
+		invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt,
+	).Scan(&invitation.InvitationID, &invitation.CreatedAt)
+}
+
+// AcceptInvitation marks the pending, unexpired invitation with the given token
+// hash as accepted and adds the user to the organization.
+func (o *OrgModel) AcceptInvitation(tokenHash []byte, userID int64, email string) (models.Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := o.pg.Pool.Begin(ctx)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var invitation models.Invitation
+
+	err = tx.QueryRow(ctx, `
+		UPDATE org_invitations SET accepted_at = NOW()
+		WHERE token_hash = $1 AND email = $2 AND accepted_at IS NULL AND expires_at > NOW()
+		RETURNING invitation_id, org_id, email, role, expires_at, accepted_at, created_at`,
+		tokenHash, email,
+	).Scan(
+		&invitation.InvitationID, &invitation.OrgID, &invitation.Email, &invitation.Role,
+		&invitation.ExpiresAt, &invitation.AcceptedAt, &invitation.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Invitation{}, models.ErrInvitationNotFound
+		}
+		return models.Invitation{}, err
+	}
+
+	err = addMember(ctx, tx, invitation.OrgID, userID, invitation.Role)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	return invitation, tx.Commit(ctx)
+}
+
+// GetInvitation returns the pending, unexpired invitation with the given
+// token hash.
+func (o *OrgModel) GetInvitation(tokenHash []byte) (models.Invitation, error) {
+	query := `
+		SELECT invitation_id, org_id, email, role, expires_at, created_at FROM org_invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var invitation models.Invitation
+
+	err := o.pg.Pool.QueryRow(ctx, query, tokenHash).Scan(
+		&invitation.InvitationID, &invitation.OrgID, &invitation.Email, &invitation.Role,
+		&invitation.ExpiresAt, &invitation.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Invitation{}, models.ErrInvitationNotFound
+		}
+		return models.Invitation{}, err
+	}
+
+	return invitation, nil
+}
+
+func scanMembership(row pgx.Row) (models.Membership, error) {
+	var membership models.Membership
+
+	err := row.Scan(
+		&membership.OrgID, &membership.OrgName, &membership.OrgSlug,
+		&membership.UserID, &membership.Email, &membership.Role, &membership.CreatedAt,
+	)
+
+	return membership, err
+}
+
+// addMember adds the user to the organization. Existing members keep their
+// current role.
+func addMember(ctx context.Context, q querier, orgID, userID int64, role string) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO org_memberships (org_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, user_id) DO NOTHING`,
+		orgID, userID, role,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return models.ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
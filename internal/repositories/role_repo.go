@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+// querier is implemented by both the connection pool and a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type RoleModel struct {
+	pg *postgres.Postgres
+}
+
+func NewRoleRepo(db *postgres.Postgres) *RoleModel {
+	return &RoleModel{pg: db}
+}
+
+func (r *RoleModel) GetRoles() ([]models.Role, error) {
+	query := `
+		SELECT r.role_id, r.name, r.description,
+		       COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.role_id
+		LEFT JOIN permissions p ON p.permission_id = rp.permission_id
+		GROUP BY r.role_id
+		ORDER BY r.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.pg.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+
+	for rows.Next() {
+		var role models.Role
+
+		err = rows.Scan(&role.RoleID, &role.Name, &role.Description, &role.Permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *RoleModel) GetUserRoles(userID int64) ([]string, error) {
+	query := `
+		SELECT ` + userRolesColumn + ` FROM users
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var roles []string
+
+	err := r.pg.Pool.QueryRow(ctx, query, userID).Scan(&roles)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrNotFound
+		}
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (r *RoleModel) AssignRole(userID int64, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return assignRole(ctx, r.pg.Pool, userID, role)
+}
+
+func (r *RoleModel) RevokeRole(userID int64, role string) error {
+	query := `
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = (SELECT role_id FROM roles WHERE name = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := r.pg.Pool.Exec(ctx, query, userID, role)
+
+	return err
+}
+
+func assignRole(ctx context.Context, q querier, userID int64, role string) error {
+	var roleID int64
+
+	err := q.QueryRow(ctx, `SELECT role_id FROM roles WHERE name = $1`, role).Scan(&roleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrRoleNotFound
+		}
+		return err
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO user_roles (user_id, role_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`,
+		userID, roleID,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return models.ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type SAMLModel struct {
+	pg *postgres.Postgres
+}
+
+func NewSAMLRepo(db *postgres.Postgres) *SAMLModel {
+	return &SAMLModel{pg: db}
+}
+
+// PutSAMLConnection creates the connection of the organization or replaces
+// it.
+func (s *SAMLModel) PutSAMLConnection(c *models.SAMLConnection) error {
+	query := `
+		INSERT INTO saml_connections (org_id, idp_entity_id, sso_url, certificate, email_attribute,
+			first_name_attribute, last_name_attribute, allow_idp_initiated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (org_id) DO UPDATE SET
+			idp_entity_id = EXCLUDED.idp_entity_id,
+			sso_url = EXCLUDED.sso_url,
+			certificate = EXCLUDED.certificate,
+			email_attribute = EXCLUDED.email_attribute,
+			first_name_attribute = EXCLUDED.first_name_attribute,
+			last_name_attribute = EXCLUDED.last_name_attribute,
+			allow_idp_initiated = EXCLUDED.allow_idp_initiated,
+			updated_at = NOW()
+		RETURNING created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return s.pg.Pool.QueryRow(
+		ctx,
+		query,
+		c.OrgID, c.IdPEntityID, c.SSOURL, c.Certificate, c.EmailAttribute,
+		c.FirstNameAttribute, c.LastNameAttribute, c.AllowIdPInitiated,
+	).Scan(&c.CreatedAt, &c.UpdatedAt)
+}
+
+func (s *SAMLModel) GetSAMLConnection(orgID int64) (models.SAMLConnection, error) {
+	query := `
+		SELECT org_id, idp_entity_id, sso_url, certificate, email_attribute, first_name_attribute,
+			last_name_attribute, allow_idp_initiated, created_at, updated_at
+		FROM saml_connections
+		WHERE org_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c models.SAMLConnection
+
+	err := s.pg.Pool.QueryRow(ctx, query, orgID).Scan(
+		&c.OrgID, &c.IdPEntityID, &c.SSOURL, &c.Certificate, &c.EmailAttribute, &c.FirstNameAttribute,
+		&c.LastNameAttribute, &c.AllowIdPInitiated, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.SAMLConnection{}, models.ErrSAMLConnectionNotFound
+		}
+		return models.SAMLConnection{}, err
+	}
+
+	return c, nil
+}
+
+func (s *SAMLModel) DeleteSAMLConnection(orgID int64) error {
+	query := `
+		DELETE FROM saml_connections
+		WHERE org_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := s.pg.Pool.Exec(ctx, query, orgID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return models.ErrSAMLConnectionNotFound
+	}
+
+	return nil
+}
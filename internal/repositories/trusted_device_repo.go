@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type TrustedDeviceModel struct {
+	pg *postgres.Postgres
+}
+
+func NewTrustedDeviceRepo(db *postgres.Postgres) *TrustedDeviceModel {
+	return &TrustedDeviceModel{pg: db}
+}
+
+const trustedDeviceColumns = `device_id, user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at`
+
+func (d *TrustedDeviceModel) CreateTrustedDevice(device *models.TrustedDevice) error {
+	query := `
+		INSERT INTO trusted_devices (user_id, token_hash, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING device_id, created_at, last_used_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return d.pg.Pool.QueryRow(
+		ctx,
+		query,
+		device.UserID, device.TokenHash, device.UserAgent, device.IP, device.ExpiresAt,
+	).Scan(&device.DeviceID, &device.CreatedAt, &device.LastUsedAt)
+}
+
+// UseTrustedDevice returns the unexpired device with the token hash and
+// records its use.
+func (d *TrustedDeviceModel) UseTrustedDevice(tokenHash []byte) (models.TrustedDevice, error) {
+	query := `
+		UPDATE trusted_devices SET last_used_at = NOW()
+		WHERE token_hash = $1 AND expires_at > NOW()
+		RETURNING ` + trustedDeviceColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	device, err := scanTrustedDevice(d.pg.Pool.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.TrustedDevice{}, models.ErrTrustedDeviceNotFound
+		}
+		return models.TrustedDevice{}, err
+	}
+
+	return device, nil
+}
+
+func (d *TrustedDeviceModel) ListTrustedDevices(userID int64) ([]models.TrustedDevice, error) {
+	query := `
+		SELECT ` + trustedDeviceColumns + ` FROM trusted_devices
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := d.pg.Pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []models.TrustedDevice{}
+
+	for rows.Next() {
+		device, err := scanTrustedDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+func (d *TrustedDeviceModel) DeleteTrustedDevice(userID, deviceID int64) error {
+	query := `
+		DELETE FROM trusted_devices
+		WHERE user_id = $1 AND device_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := d.pg.Pool.Exec(ctx, query, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return models.ErrTrustedDeviceNotFound
+	}
+
+	return nil
+}
+
+// DeleteUserTrustedDevices forgets all devices of the user, expired ones
+// included.
+func (d *TrustedDeviceModel) DeleteUserTrustedDevices(userID int64) error {
+	query := `DELETE FROM trusted_devices WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.pg.Pool.Exec(ctx, query, userID)
+
+	return err
+}
+
+func scanTrustedDevice(row pgx.Row) (models.TrustedDevice, error) {
+	var device models.TrustedDevice
+
+	err := row.Scan(
+		&device.DeviceID, &device.UserID, &device.TokenHash, &device.UserAgent, &device.IP,
+		&device.CreatedAt, &device.LastUsedAt, &device.ExpiresAt,
+	)
+
+	return device, err
+}
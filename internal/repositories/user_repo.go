@@ -7,11 +7,34 @@ import (
 	"fullstack-simple-app/pkg/postgres"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
 	"time"
 )
 
 const ErrUserEmailDuplicate = `ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)"`
 
+// userRolesColumn selects the names of the roles of the user in the current
+// row of the users table.
+const userRolesColumn = `
+		COALESCE((
+			SELECT array_agg(r.name ORDER BY r.name) FROM user_roles ur
+			JOIN roles r ON r.role_id = ur.role_id
+			WHERE ur.user_id = users.user_id
+		), '{}') AS roles`
+
+// tenantCondition restricts a query by email to the user namespace of the
+// tenant passed as $2, where 0 stands for the global namespace.
+const tenantCondition = `tenant_org_id IS NOT DISTINCT FROM NULLIF($2::integer, 0)`
+
+// userColumns are the columns read by scanUser.
+var userColumns = []string{
+	"user_id", "first_name", "last_name", "email", "password_hash", "password_pepper_version",
+	"created_at", "updated_at", "active", "activated", "password_changed_at",
+	"COALESCE(tenant_org_id, 0)", "mfa_enabled", "mfa_method", "COALESCE(phone, '')", "phone_verified",
+	"email_verified",
+	userRolesColumn,
+}
+
 type UserModel struct {
 	pg *postgres.Postgres
 }
@@ -22,20 +45,26 @@ func NewUserRepo(db *postgres.Postgres) *UserModel {
 
 func (u *UserModel) CreateUser(user *models.User) error {
 	query := `
-		INSERT INTO users (first_name, last_name, email, password_hash)
-		VALUES ($1, $2, $3, $4)
-		RETURNING user_id, created_at`
+		INSERT INTO users (first_name, last_name, email, password_hash, password_pepper_version, tenant_org_id, phone)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, ''))
+		RETURNING user_id, created_at, password_changed_at, mfa_method`
 
-	args := []interface{}{user.FirstName, user.LastName, user.Email, user.Password.Hash}
+	args := []interface{}{user.FirstName, user.LastName, user.Email, user.Password.Hash, user.Password.PepperVersion, user.TenantOrgID, user.Phone}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := u.pg.Pool.QueryRow(
+	tx, err := u.pg.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(
 		ctx,
 		query,
 		args...,
-	).Scan(&user.UserID, &user.CreatedAt)
+	).Scan(&user.UserID, &user.CreatedAt, &user.PasswordChangedAt, &user.MFAMethod)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
@@ -45,36 +74,52 @@ func (u *UserModel) CreateUser(user *models.User) error {
 
 	}
 
-	return nil
+	for _, role := range user.Roles {
+		err = assignRole(ctx, tx, user.UserID, role)
+		if err != nil {
+			return err
+		}
+	}
+
+	// accounts of an isolated tenant are always members of it
+	if user.TenantOrgID != 0 {
+		err = addMember(ctx, tx, user.TenantOrgID, user.UserID, models.OrgRoleMember)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
-func (u *UserModel) ActivateUser(email string) (models.User, error) {
+func (u *UserModel) ActivateUser(tenantID int64, email string) (models.User, error) {
 	query := `
 		UPDATE users SET activated=true
-		WHERE email=$1
-		RETURNING user_id, first_name, last_name, created_at, activated`
+		WHERE email=$1 AND ` + tenantCondition + `
+		RETURNING ` + strings.Join(userColumns, ", ")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var user models.User
-
-	err := u.pg.Pool.QueryRow(
+	user, err := scanUser(u.pg.Pool.QueryRow(
 		ctx,
 		query,
-		email,
-	).Scan(&user.UserID, &user.FirstName, &user.LastName, &user.CreatedAt, &user.Activated)
+		email, tenantID,
+	))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, models.ErrNotFound
+		}
 		return models.User{}, err
 	}
 
 	return user, nil
 }
 
-func (u *UserModel) GetUserIDByEmail(email string) (int64, error) {
+func (u *UserModel) GetUserIDByEmail(tenantID int64, email string) (int64, error) {
 	query := `
 		SELECT user_id FROM users
-		WHERE email=$1`
+		WHERE email=$1 AND ` + tenantCondition
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -84,7 +129,7 @@ func (u *UserModel) GetUserIDByEmail(email string) (int64, error) {
 	err := u.pg.Pool.QueryRow(
 		ctx,
 		query,
-		email,
+		email, tenantID,
 	).Scan(&user.UserID)
 
 	if err != nil {
@@ -97,26 +142,19 @@ func (u *UserModel) GetUserIDByEmail(email string) (int64, error) {
 	return user.UserID, nil
 }
 
-func (u *UserModel) GetUserByEmail(email string) (models.User, error) {
+func (u *UserModel) GetUserByEmail(tenantID int64, email string) (models.User, error) {
 	query := `
-		SELECT user_id, first_name, last_name, email, password_hash, active, activated FROM users
-		WHERE email = $1`
+		SELECT ` + strings.Join(userColumns, ", ") + ` FROM users
+		WHERE email = $1 AND ` + tenantCondition
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var user models.User
-
-	err := u.pg.Pool.QueryRow(
+	user, err := scanUser(u.pg.Pool.QueryRow(
 		ctx,
 		query,
-		email,
-	).Scan(
-		&user.UserID, &user.FirstName,
-		&user.LastName, &user.Email,
-		&user.Password.Hash, &user.Active,
-		&user.Activated,
-	)
+		email, tenantID,
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return models.User{}, models.ErrNotFound
@@ -126,3 +164,168 @@ func (u *UserModel) GetUserByEmail(email string) (models.User, error) {
 
 	return user, nil
 }
+
+func (u *UserModel) GetPasswordHistory(userID int64, limit int) ([]models.Password, error) {
+	query := `
+		SELECT password_hash, password_pepper_version FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := u.pg.Pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.Password
+
+	for rows.Next() {
+		var password models.Password
+
+		err = rows.Scan(&password.Hash, &password.PepperVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, password)
+	}
+
+	return history, rows.Err()
+}
+
+// UpdatePassword stores the new password hash of the user and moves the
+// previous one into the password history, keeping at most historyDepth
+// entries there.
+func (u *UserModel) UpdatePassword(user *models.User, historyDepth int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.pg.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO password_history (user_id, password_hash, password_pepper_version)
+		SELECT user_id, password_hash, password_pepper_version FROM users
+		WHERE user_id = $1`,
+		user.UserID,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE users SET password_hash = $2, password_pepper_version = $3, updated_at = NOW(), password_changed_at = NOW()
+		WHERE user_id = $1
+		RETURNING updated_at, password_changed_at`,
+		user.UserID, user.Password.Hash, user.Password.PepperVersion,
+	).Scan(&user.UpdatedAt, &user.PasswordChangedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ErrNotFound
+		}
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		)`,
+		user.UserID, historyDepth,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RehashPassword replaces the stored hash of the same password, e.g. after the
+// pepper was rotated. Unlike UpdatePassword it doesn't touch the history or
+// the password age. It returns ErrNotFound when the stored hash is no longer
+// oldHash, i.e. the password was changed meanwhile.
+func (u *UserModel) RehashPassword(user *models.User, oldHash []byte) error {
+	query := `
+		UPDATE users SET password_hash = $2, password_pepper_version = $3
+		WHERE user_id = $1 AND password_hash = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tag, err := u.pg.Pool.Exec(ctx, query, user.UserID, user.Password.Hash, user.Password.PepperVersion, oldHash)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// GetUsersWithPasswordChangedBetween returns active users whose password was
+// last changed within [from, to).
+func (u *UserModel) GetUsersWithPasswordChangedBetween(from, to time.Time) ([]models.User, error) {
+	query := `
+		SELECT user_id, first_name, last_name, email, password_changed_at FROM users
+		WHERE active AND password_changed_at >= $1 AND password_changed_at < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := u.pg.Pool.Query(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+
+	for rows.Next() {
+		var user models.User
+
+		err = rows.Scan(&user.UserID, &user.FirstName, &user.LastName, &user.Email, &user.PasswordChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func scanUser(row pgx.Row) (models.User, error) {
+	var (
+		user      models.User
+		updatedAt *time.Time
+	)
+
+	err := row.Scan(
+		&user.UserID, &user.FirstName, &user.LastName, &user.Email,
+		&user.Password.Hash, &user.Password.PepperVersion,
+		&user.CreatedAt, &updatedAt, &user.Active, &user.Activated,
+		&user.PasswordChangedAt, &user.TenantOrgID, &user.MFAEnabled, &user.MFAMethod,
+		&user.Phone, &user.PhoneVerified, &user.EmailVerified, &user.Roles,
+	)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if updatedAt != nil {
+		user.UpdatedAt = *updatedAt
+	}
+
+	return user, nil
+}
@@ -0,0 +1,315 @@
+package repositories
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/models"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cursor points right after the last row of a page: the value of the sort
+// column and the user_id that breaks ties between equal values.
+type cursor struct {
+	Column string `json:"c"`
+	Value  string `json:"v"`
+	UserID int64  `json:"id"`
+}
+
+// ListUsers returns one page of users matching the filter, ordered by the sort
+// column with user_id as a tie breaker, and the cursor of the next page.
+func (u *UserModel) ListUsers(filter models.UserFilter) ([]models.User, string, error) {
+	column, desc := filter.SortColumn()
+
+	q := u.pg.Builder.
+		Select(userColumns...).
+		From("users")
+
+	if filter.EmailPrefix != "" {
+		q = q.Where(squirrel.Like{"email": escapeLike(filter.EmailPrefix) + "%"})
+	}
+
+	if filter.Name != "" {
+		pattern := "%" + escapeLike(filter.Name) + "%"
+		q = q.Where(squirrel.Or{
+			squirrel.ILike{"first_name": pattern},
+			squirrel.ILike{"last_name": pattern},
+		})
+	}
+
+	if filter.Activated != nil {
+		q = q.Where(squirrel.Eq{"activated": *filter.Activated})
+	}
+
+	if filter.Active != nil {
+		q = q.Where(squirrel.Eq{"active": *filter.Active})
+	}
+
+	if filter.CreatedFrom != nil {
+		q = q.Where(squirrel.GtOrEq{"created_at": *filter.CreatedFrom})
+	}
+
+	if filter.CreatedTo != nil {
+		q = q.Where(squirrel.Lt{"created_at": *filter.CreatedTo})
+	}
+
+	if filter.Role != "" {
+		q = q.Where(`EXISTS (
+			SELECT 1 FROM user_roles ur
+			JOIN roles r ON r.role_id = ur.role_id
+			WHERE ur.user_id = users.user_id AND r.name = ?)`, filter.Role)
+	}
+
+	if filter.OrgID != 0 {
+		q = q.Where(`EXISTS (
+			SELECT 1 FROM org_memberships m
+			WHERE m.user_id = users.user_id AND m.org_id = ?)`, filter.OrgID)
+	}
+
+	if filter.Cursor != "" {
+		after, afterID, err := decodeCursor(filter.Cursor, column)
+		if err != nil {
+			return nil, "", err
+		}
+
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		q = q.Where(fmt.Sprintf("(%s, user_id) %s (?, ?)", column, op), after, afterID)
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	q = q.OrderBy(column+" "+direction, "user_id "+direction).
+		Limit(uint64(filter.Limit) + 1)
+
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := u.pg.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var users []models.User
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, "", err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+		next = encodeCursor(users[len(users)-1], column)
+	}
+
+	return users, next, nil
+}
+
+func (u *UserModel) GetUserByID(userID int64) (models.User, error) {
+	query, args, err := u.pg.Builder.
+		Select(userColumns...).
+		From("users").
+		Where(squirrel.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user, err := scanUser(u.pg.Pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, models.ErrNotFound
+		}
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// SetUserActive suspends (active = false) or restores the user.
+func (u *UserModel) SetUserActive(userID int64, active bool) (models.User, error) {
+	query, args, err := u.pg.Builder.
+		Update("users").
+		Set("active", active).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(squirrel.Eq{"user_id": userID}).
+		Suffix("RETURNING " + strings.Join(userColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user, err := scanUser(u.pg.Pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, models.ErrNotFound
+		}
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// SetMFA turns the second factor of the user on or off and sets the channel
+// the codes go through.
+func (u *UserModel) SetMFA(userID int64, enabled bool, method string) (models.User, error) {
+	return u.updateUser(squirrel.Eq{"user_id": userID}, map[string]interface{}{
+		"mfa_enabled": enabled,
+		"mfa_method":  method,
+	})
+}
+
+// SetPhone sets a new, unverified phone of the user. Codes of the second
+// factor go by email again until the phone is verified.
+func (u *UserModel) SetPhone(userID int64, phone string) (models.User, error) {
+	return u.updateUser(squirrel.Eq{"user_id": userID}, map[string]interface{}{
+		"phone":          phone,
+		"phone_verified": false,
+		"mfa_method":     squirrel.Expr("CASE WHEN mfa_method = ? THEN ? ELSE mfa_method END", models.ChannelSMS, models.ChannelEmail),
+	})
+}
+
+// VerifyPhone marks the phone of the user verified, provided it is still
+// the given one.
+func (u *UserModel) VerifyPhone(userID int64, phone string) (models.User, error) {
+	return u.updateUser(squirrel.Eq{"user_id": userID, "phone": phone}, map[string]interface{}{
+		"phone_verified": true,
+	})
+}
+
+// VerifyEmail marks the email of the user verified, which activates the
+// account as well.
+func (u *UserModel) VerifyEmail(userID int64) (models.User, error) {
+	return u.updateUser(squirrel.Eq{"user_id": userID}, map[string]interface{}{
+		"activated":      true,
+		"email_verified": true,
+	})
+}
+
+// updateUser sets the columns of the user matching where and returns it.
+func (u *UserModel) updateUser(where squirrel.Eq, set map[string]interface{}) (models.User, error) {
+	query, args, err := u.pg.Builder.
+		Update("users").
+		SetMap(set).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(where).
+		Suffix("RETURNING " + strings.Join(userColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user, err := scanUser(u.pg.Pool.QueryRow(ctx, query, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, models.ErrNotFound
+		}
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+func encodeCursor(last models.User, column string) string {
+	c := cursor{Column: column, UserID: last.UserID}
+
+	switch column {
+	case "email":
+		c.Value = last.Email
+	case "last_name":
+		c.Value = last.LastName
+	case "created_at":
+		c.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "user_id":
+		c.Value = strconv.FormatInt(last.UserID, 10)
+	}
+
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the sort column value stored in the cursor, converted
+// to the column type, and the user_id of the last row.
+func decodeCursor(encoded string, column string) (interface{}, int64, error) {
+	c, err := parseCursor(encoded)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// a cursor is only valid for the sort order it was issued for
+	if c.Column != column {
+		return nil, 0, models.ErrInvalidCursor
+	}
+
+	switch column {
+	case "created_at":
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, 0, models.ErrInvalidCursor
+		}
+		return t, c.UserID, nil
+	case "user_id":
+		id, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, 0, models.ErrInvalidCursor
+		}
+		return id, c.UserID, nil
+	default:
+		return c.Value, c.UserID, nil
+	}
+}
+
+func parseCursor(encoded string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor{}, models.ErrInvalidCursor
+	}
+
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return cursor{}, models.ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// escapeLike escapes the LIKE wildcards in user input.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/oidc"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"log"
+	"slices"
+	"time"
+)
+
+// apiKeyPayloadTTL bounds the payload of a request authenticated with a key
+// that doesn't expire. The payload is never handed out.
+const apiKeyPayloadTTL = time.Hour
+
+// selfServiceScopes are granted by any user to their own keys: they only
+// read what the user may read of themselves, e.g. at /userinfo, so no
+// permission stands behind them.
+var selfServiceScopes = []string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail}
+
+// APIKeyService manages the API keys of machine clients. A user key acts as
+// its user, limited to its scopes; a service key may do exactly what its
+// scopes list.
+type APIKeyService struct {
+	apiKeyRepository APIKeyRepo
+	userRepository   UserRepo
+	permissions      PermissionSource
+	asyncRunner      AsyncRunner
+}
+
+type APIKeyRepo interface {
+	CreateAPIKey(key *models.APIKey) error
+	GetAPIKeyByHash(hash []byte) (models.APIKey, error)
+	ListUserAPIKeys(userID int64) ([]models.APIKey, error)
+	ListServiceAPIKeys() ([]models.APIKey, error)
+	RevokeUserAPIKey(userID, keyID int64) error
+	RevokeServiceAPIKey(keyID int64) error
+	TouchAPIKey(keyID int64) error
+}
+
+func NewAPIKeyService(apiKeyRepo APIKeyRepo, userRepo UserRepo, permissions PermissionSource, async AsyncRunner) *APIKeyService {
+	return &APIKeyService{
+		apiKeyRepository: apiKeyRepo,
+		userRepository:   userRepo,
+		permissions:      permissions,
+		asyncRunner:      async,
+	}
+}
+
+// CreateUserAPIKey creates a key acting as the user. Its scopes must be
+// permissions the user holds or self-service scopes. The plaintext key is
+// returned only here.
+func (s *APIKeyService) CreateUserAPIKey(userID int64, key *models.APIKey) (string, error) {
+	const op = "CreateUserAPIKey"
+
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return "", app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	key.UserID = user.UserID
+	key.Service = ""
+	key.CreatedBy = user.UserID
+
+	return s.createAPIKey(op, user.Roles, key)
+}
+
+// CreateServiceAPIKey creates a key of a service on behalf of an
+// administrator, who must hold every permission the key is scoped to.
+func (s *APIKeyService) CreateServiceAPIKey(creatorID int64, creatorRoles []string, key *models.APIKey) (string, error) {
+	const op = "CreateServiceAPIKey"
+
+	v := validator.New()
+
+	if models.ValidateServiceName(v, key.Service); !v.Valid() {
+		return "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	key.UserID = 0
+	key.CreatedBy = creatorID
+
+	return s.createAPIKey(op, creatorRoles, key)
+}
+
+func (s *APIKeyService) createAPIKey(op string, roles []string, key *models.APIKey) (string, error) {
+	v := validator.New()
+
+	if models.ValidateAPIKey(v, key); !v.Valid() {
+		return "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	// nobody can hand out more than they hold
+	for _, scope := range key.Scopes {
+		if key.UserID != 0 && slices.Contains(selfServiceScopes, scope) {
+			continue
+		}
+
+		allowed, err := s.permissions.HasPermission(roles, scope)
+		if err != nil {
+			return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+		}
+
+		if !allowed {
+			v.AddError("scopes", fmt.Sprintf("permission %s is not granted to you", scope))
+		}
+	}
+
+	if !v.Valid() {
+		return "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	plaintext, err := key.GenerateAPIKey()
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: key.GenerateAPIKey: %w", op, err))
+	}
+
+	err = s.apiKeyRepository.CreateAPIKey(key)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.apiKeyRepository.CreateAPIKey: %w", op, err))
+	}
+
+	return plaintext, nil
+}
+
+func (s *APIKeyService) ListUserAPIKeys(userID int64) ([]models.APIKey, error) {
+	keys, err := s.apiKeyRepository.ListUserAPIKeys(userID)
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return keys, nil
+}
+
+func (s *APIKeyService) ListServiceAPIKeys() ([]models.APIKey, error) {
+	keys, err := s.apiKeyRepository.ListServiceAPIKeys()
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return keys, nil
+}
+
+func (s *APIKeyService) RevokeUserAPIKey(userID, keyID int64) error {
+	return apiKeyError(s.apiKeyRepository.RevokeUserAPIKey(userID, keyID))
+}
+
+func (s *APIKeyService) RevokeServiceAPIKey(keyID int64) error {
+	return apiKeyError(s.apiKeyRepository.RevokeServiceAPIKey(keyID))
+}
+
+// VerifyAPIKey authenticates a request made with the key and returns the
+// payload it acts with, as if it came from an access token.
+func (s *APIKeyService) VerifyAPIKey(plaintext string) (*authentication.Payload, error) {
+	const op = "VerifyAPIKey"
+
+	v := validator.New()
+
+	if models.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		return nil, app_errors.NewValidationError(errcode.ErrUnauthorized, v.Errors)
+	}
+
+	key, err := s.apiKeyRepository.GetAPIKeyByHash(verification.Hash(plaintext))
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyNotFound) {
+			return nil, app_errors.NewAppError(errcode.ErrUnauthorized, err)
+		}
+		return nil, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if key.Expired() {
+		return nil, app_errors.NewAppError(errcode.ErrUnauthorized, errors.New("api key has expired"))
+	}
+
+	ttl := apiKeyPayloadTTL
+	if key.ExpiresAt != nil && time.Until(*key.ExpiresAt) < ttl {
+		ttl = time.Until(*key.ExpiresAt)
+	}
+
+	var payload *authentication.Payload
+
+	if key.Service != "" {
+		payload, err = authentication.NewPayload(
+			"service:"+key.Service, ttl,
+			authentication.WithClient(key.Service),
+			authentication.WithScopes(key.Scopes...),
+			authentication.WithAPIKey(key.KeyID),
+		)
+	} else {
+		// the user is read on every request so that suspension and role
+		// changes apply to the key right away
+		var user models.User
+
+		user, err = s.userRepository.GetUserByID(key.UserID)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				return nil, app_errors.NewAppError(errcode.ErrUnauthorized, err)
+			}
+			return nil, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+		}
+
+		if !user.Active || !user.Activated {
+			return nil, app_errors.NewAppError(errcode.ErrUnauthorized, errors.New("owner of the api key is not active"))
+		}
+
+		opts := append(accessTokenOptions(user, user.TenantOrgID),
+			authentication.WithScopes(key.Scopes...),
+			authentication.WithAPIKey(key.KeyID),
+		)
+
+		payload, err = authentication.NewPayload(user.Email, ttl, opts...)
+	}
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: authentication.NewPayload: %w", op, err))
+	}
+
+	s.asyncRunner.RunAsync(func() {
+		err := s.apiKeyRepository.TouchAPIKey(key.KeyID)
+		if err != nil {
+			log.Printf("Failed to record api key use: %v\n", err)
+		}
+	})
+
+	return payload, nil
+}
+
+func apiKeyError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, models.ErrAPIKeyNotFound):
+		return app_errors.NewAppError(errcode.ErrNotFound, err)
+	default:
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type stubUserRepo struct {
+	UserRepo
+	user models.User
+}
+
+func (r *stubUserRepo) GetUserByID(userID int64) (models.User, error) {
+	if userID != r.user.UserID {
+		return models.User{}, models.ErrNotFound
+	}
+	return r.user, nil
+}
+
+type stubAPIKeyRepo struct {
+	APIKeyRepo
+	keys []models.APIKey
+}
+
+func (r *stubAPIKeyRepo) CreateAPIKey(key *models.APIKey) error {
+	key.KeyID = int64(len(r.keys) + 1)
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+// stubPermissions grants the admin role every permission and others none,
+// like the seeded roles.
+type stubPermissions struct{}
+
+func (stubPermissions) HasPermission(roles []string, permission string) (bool, error) {
+	return slices.Contains(roles, "admin"), nil
+}
+
+func newTestAPIKeyService(user models.User) (*APIKeyService, *stubAPIKeyRepo) {
+	keys := &stubAPIKeyRepo{}
+	return NewAPIKeyService(keys, &stubUserRepo{user: user}, stubPermissions{}, nil), keys
+}
+
+func TestCreateUserAPIKeySelfService(t *testing.T) {
+	user := models.User{UserID: util.RandomInt(1, 1000), Roles: []string{"user"}, Active: true, Activated: true}
+
+	s, keys := newTestAPIKeyService(user)
+
+	plaintext, err := s.CreateUserAPIKey(user.UserID, &models.APIKey{Name: "ci", Scopes: []string{"openid", "email"}})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(plaintext, models.APIKeyPrefix))
+
+	require.Len(t, keys.keys, 1)
+	require.Equal(t, user.UserID, keys.keys[0].UserID)
+	require.Equal(t, []string{"openid", "email"}, keys.keys[0].Scopes)
+}
+
+func TestCreateUserAPIKeyBeyondPermissions(t *testing.T) {
+	user := models.User{UserID: util.RandomInt(1, 1000), Roles: []string{"user"}, Active: true, Activated: true}
+
+	s, keys := newTestAPIKeyService(user)
+
+	_, err := s.CreateUserAPIKey(user.UserID, &models.APIKey{Name: "ci", Scopes: []string{"openid", "users:write"}})
+	require.Error(t, err)
+
+	var appErr *app_errors.AppError
+	require.ErrorAs(t, err, &appErr)
+	require.Equal(t, errcode.ErrInvalidRequest, appErr.Code)
+	require.Empty(t, keys.keys)
+}
+
+func TestCreateServiceAPIKeySelfServiceScope(t *testing.T) {
+	admin := models.User{UserID: util.RandomInt(1, 1000), Roles: []string{"admin"}}
+
+	s, _ := newTestAPIKeyService(admin)
+
+	_, err := s.CreateServiceAPIKey(admin.UserID, []string{"user"}, &models.APIKey{Name: "sync", Service: "billing", Scopes: []string{"openid"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/policy"
+	"fullstack-simple-app/pkg/validator"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ruleDefaultDeny = "default-deny"
+	ruleScope       = "scope"
+)
+
+// AuthzService answers whether a user may perform an action on a resource.
+// Actions outside the scopes of the token are denied. The policies decide
+// next; when none applies, the action is allowed if one
+// of the user's roles grants the permission of the same name, or if an
+// ownership rule matches the resource.
+type AuthzService struct {
+	permissions    PermissionSource
+	policies       PolicyEvaluator
+	userRepository UserRepo
+	redisClient    RedisClient
+	config         AuthzConfig
+}
+
+type PermissionSource interface {
+	HasPermission(roles []string, permission string) (bool, error)
+}
+
+type PolicyEvaluator interface {
+	Evaluate(req policy.Request) policy.Decision
+}
+
+type AuthzConfig struct {
+	// CacheTTL is how long a decision is cached. Zero disables the cache.
+	CacheTTL       time.Duration
+	OwnershipRules []models.OwnershipRule
+}
+
+// NewAuthzService creates an AuthzService. policies may be nil.
+func NewAuthzService(permissions PermissionSource, policies PolicyEvaluator, userRepo UserRepo, redis RedisClient, cfg AuthzConfig) *AuthzService {
+	return &AuthzService{
+		permissions:    permissions,
+		policies:       policies,
+		userRepository: userRepo,
+		redisClient:    redis,
+		config:         cfg,
+	}
+}
+
+func (s *AuthzService) Check(subject models.AuthzSubject, check models.AuthzCheck) (models.AuthzDecision, error) {
+	v := validator.New()
+
+	if models.ValidateAuthzCheck(v, check); !v.Valid() {
+		return models.AuthzDecision{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	decision, err := s.decide(subject, check, s.subjectAttributes(subject))
+	if err != nil {
+		return models.AuthzDecision{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return decision, nil
+}
+
+// CheckBatch answers every check, in order.
+func (s *AuthzService) CheckBatch(subject models.AuthzSubject, checks []models.AuthzCheck) ([]models.AuthzDecision, error) {
+	v := validator.New()
+
+	v.Check(len(checks) > 0, "checks", "must contain at least 1 check")
+	v.Check(len(checks) <= models.MaxAuthzBatchSize, "checks", fmt.Sprintf("must not contain more than %d checks", models.MaxAuthzBatchSize))
+
+	for i, check := range checks {
+		cv := validator.New()
+		models.ValidateAuthzCheck(cv, check)
+
+		for key, message := range cv.Errors {
+			v.AddError(fmt.Sprintf("checks[%d].%s", i, key), message)
+		}
+	}
+
+	if !v.Valid() {
+		return nil, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	decisions := make([]models.AuthzDecision, 0, len(checks))
+	attributes := s.subjectAttributes(subject)
+
+	for _, check := range checks {
+		decision, err := s.decide(subject, check, attributes)
+		if err != nil {
+			return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+// decide returns the cached decision or evaluates the rules. The cache is best
+// effort: when Redis is unavailable the decision is evaluated every time.
+func (s *AuthzService) decide(subject models.AuthzSubject, check models.AuthzCheck, attributes func() (policy.Attributes, error)) (models.AuthzDecision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	key := decisionKey(subject, check)
+
+	if s.config.CacheTTL > 0 {
+		cached, err := s.redisClient.Get(ctx, key)
+		if err == nil {
+			var decision models.AuthzDecision
+			if json.Unmarshal([]byte(cached), &decision) == nil {
+				return decision, nil
+			}
+		}
+	}
+
+	decision, err := s.evaluate(subject, check, attributes)
+	if err != nil {
+		return models.AuthzDecision{}, err
+	}
+
+	if s.config.CacheTTL > 0 {
+		data, _ := json.Marshal(decision)
+
+		err = s.redisClient.Set(ctx, key, string(data), s.config.CacheTTL)
+		if err != nil {
+			log.Printf("Failed to cache authorization decision: %v\n", err)
+		}
+	}
+
+	return decision, nil
+}
+
+func (s *AuthzService) evaluate(subject models.AuthzSubject, check models.AuthzCheck, attributes func() (policy.Attributes, error)) (models.AuthzDecision, error) {
+	if !subject.AllowsScope(check.Action) {
+		return models.AuthzDecision{Allowed: false, Rule: ruleScope}, nil
+	}
+
+	if s.policies != nil {
+		subjectAttrs, err := attributes()
+		if err != nil {
+			return models.AuthzDecision{}, err
+		}
+
+		decision := s.policies.Evaluate(policy.Request{
+			Action:     check.Action,
+			Resource:   check.Resource.Type,
+			Attributes: requestAttributes(subjectAttrs, check),
+		})
+
+		switch decision.Effect {
+		case policy.EffectDeny:
+			return models.AuthzDecision{Allowed: false, Rule: "policy:" + decision.Rule}, nil
+		case policy.EffectAllow:
+			return models.AuthzDecision{Allowed: true, Rule: "policy:" + decision.Rule}, nil
+		}
+	}
+
+	// a client may do what its scopes list
+	if subject.Client {
+		return models.AuthzDecision{Allowed: true, Rule: ruleScope}, nil
+	}
+
+	for _, role := range subject.Roles {
+		ok, err := s.permissions.HasPermission([]string{role}, check.Action)
+		if err != nil {
+			return models.AuthzDecision{}, err
+		}
+		if ok {
+			return models.AuthzDecision{Allowed: true, Rule: "role:" + role}, nil
+		}
+	}
+
+	for _, rule := range s.config.OwnershipRules {
+		if rule.Applies(check) && rule.Matches(subject, check.Resource) {
+			return models.AuthzDecision{Allowed: true, Rule: rule.String()}, nil
+		}
+	}
+
+	return models.AuthzDecision{Allowed: false, Rule: ruleDefaultDeny}, nil
+}
+
+// subjectAttributes returns a function loading the attributes of the subject
+// for the policies: the token claims and the user record. The user is read at
+// most once, and only if a decision isn't cached.
+func (s *AuthzService) subjectAttributes(subject models.AuthzSubject) func() (policy.Attributes, error) {
+	var attrs policy.Attributes
+
+	return func() (policy.Attributes, error) {
+		if attrs != nil {
+			return attrs, nil
+		}
+
+		loaded := policy.Attributes{
+			"subject.user_id": subject.UserID,
+			"subject.org_id":  subject.OrgID,
+			"subject.roles":   subject.Roles,
+			"subject.scopes":  subject.Scopes,
+			"subject.client":  subject.Client,
+		}
+
+		if subject.UserID != 0 {
+			user, err := s.userRepository.GetUserByID(subject.UserID)
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				return nil, err
+			}
+			if err == nil {
+				loaded["subject.email"] = user.Email
+				loaded["subject.activated"] = user.Activated
+				loaded["subject.active"] = user.Active
+				loaded["subject.tenant_org_id"] = user.TenantOrgID
+			}
+		}
+
+		attrs = loaded
+
+		return attrs, nil
+	}
+}
+
+func requestAttributes(subject policy.Attributes, check models.AuthzCheck) policy.Attributes {
+	attrs := make(policy.Attributes, len(subject)+len(check.Resource.Attributes)+len(check.Environment)+4)
+
+	for k, v := range subject {
+		attrs[k] = v
+	}
+
+	for k, v := range check.Resource.Attributes {
+		attrs["resource."+k] = v
+	}
+	attrs["resource.type"] = check.Resource.Type
+	attrs["resource.id"] = check.Resource.ID
+	attrs["resource.owner_id"] = check.Resource.OwnerID
+	attrs["resource.org_id"] = check.Resource.OrgID
+
+	for k, v := range check.Environment {
+		attrs["env."+k] = v
+	}
+
+	return attrs
+}
+
+// decisionKey identifies everything a decision depends on but time and the
+// user record, which may thus be stale for up to the cache TTL. The roles come
+// from the token, so a role change takes effect with the next token.
+func decisionKey(subject models.AuthzSubject, check models.AuthzCheck) string {
+	roles := slices.Clone(subject.Roles)
+	slices.Sort(roles)
+
+	scopes := slices.Clone(subject.Scopes)
+	slices.Sort(scopes)
+
+	// maps are marshalled with sorted keys, so equal checks give equal keys
+	data, _ := json.Marshal(check)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%d\x00%s\x00%s\x00%t\x00", subject.UserID, subject.OrgID, strings.Join(roles, ","), strings.Join(scopes, ","), subject.Client)
+	h.Write(data)
+
+	return "authz:" + hex.EncodeToString(h.Sum(nil))
+}
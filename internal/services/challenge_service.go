@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/challenge"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"time"
+)
+
+type ProofOfWork interface {
+	Issue() (challenge.Challenge, error)
+	Verify(token string, solution string) (challenge.Challenge, error)
+}
+
+type ChallengeStore interface {
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+}
+
+// ChallengeService keeps bots off registration and the other endpoints that
+// send emails to any address. Clients solve a proof-of-work challenge or,
+// when a provider is configured, a CAPTCHA.
+type ChallengeService struct {
+	pow     ProofOfWork
+	captcha challenge.Verifier
+	store   ChallengeStore
+	enabled bool
+}
+
+// NewChallengeService returns the service; captcha is nil when no provider is
+// configured. A disabled service lets every request through.
+func NewChallengeService(pow ProofOfWork, captcha challenge.Verifier, store ChallengeStore, enabled bool) *ChallengeService {
+	return &ChallengeService{
+		pow:     pow,
+		captcha: captcha,
+		store:   store,
+		enabled: enabled,
+	}
+}
+
+// Issue returns a new proof-of-work challenge.
+func (s *ChallengeService) Issue() (challenge.Challenge, error) {
+	c, err := s.pow.Issue()
+	if err != nil {
+		return challenge.Challenge{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("Issue: s.pow.Issue: %w", err))
+	}
+
+	return c, nil
+}
+
+// Verify checks the answer of the client at ip. The CAPTCHA response is
+// preferred when both are given. Challenges are checked without state, but a
+// solved one is remembered until it expires so that it works once.
+func (s *ChallengeService) Verify(answer models.ChallengeAnswer, ip string) error {
+	const op = "Verify"
+
+	if !s.enabled {
+		return nil
+	}
+
+	if answer.Captcha != "" && s.captcha != nil {
+		err := s.captcha.Verify(answer.Captcha, ip)
+		if err != nil {
+			if errors.Is(err, challenge.ErrCaptchaFailed) {
+				return app_errors.NewAppError(errcode.ErrChallengeFailed, err)
+			}
+			return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.captcha.Verify: %w", op, err))
+		}
+		return nil
+	}
+
+	if answer.Token == "" {
+		return app_errors.NewAppError(errcode.ErrChallengeRequired, errors.New("challenge answer is missing"))
+	}
+
+	c, err := s.pow.Verify(answer.Token, answer.Solution)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrChallengeFailed, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	fresh, err := s.store.SetNX(ctx, challengeSpentKey(c.Token), "1", time.Until(c.ExpiresAt)+time.Second)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.store.SetNX: %w", op, err))
+	}
+
+	if !fresh {
+		return app_errors.NewAppError(errcode.ErrChallengeFailed, errors.New("challenge was already used"))
+	}
+
+	return nil
+}
+
+func challengeSpentKey(token string) string {
+	return "challenge:spent:" + hex.EncodeToString(verification.Hash(token))
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/issuer"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/validator"
+	"strings"
+	"time"
+)
+
+const (
+	// MatchBySubject maps the subject of external tokens to the local user
+	// through the identities, MatchByEmail through the email of the account.
+	MatchBySubject = "sub"
+	MatchByEmail   = "email"
+
+	externalTokenTimeout = 5 * time.Second
+)
+
+// ExternalTokenService accepts, besides our own tokens, the JWTs of trusted
+// external issuers, mapping them to local users.
+type ExternalTokenService struct {
+	tokenVerifier      TokenVerifier
+	identityRepository IdentityRepo
+	userRepository     UserRepo
+	issuers            map[string]externalIssuer
+	defaultRole        string
+}
+
+type TokenVerifier interface {
+	VerifyToken(token string) (*authentication.Payload, error)
+}
+
+// TrustedIssuer verifies the tokens of an external issuer.
+type TrustedIssuer interface {
+	Name() string
+	Verify(ctx context.Context, token string) (issuer.Claims, error)
+}
+
+type ExternalIssuerConfig struct {
+	// Name identifies the issuer in the identities of its users.
+	Name           string
+	SubjectClaim   string
+	EmailClaim     string
+	FirstNameClaim string
+	LastNameClaim  string
+	// MatchBy is MatchBySubject, the default, or MatchByEmail.
+	MatchBy string
+	// AllowSignUp creates the users the issuer vouches for on their first
+	// request.
+	AllowSignUp bool
+	// TenantOrgID is the isolated organization the users belong to, 0 for
+	// the global accounts.
+	TenantOrgID int64
+}
+
+type externalIssuer struct {
+	verifier TrustedIssuer
+	config   ExternalIssuerConfig
+}
+
+func NewExternalTokenService(tokens TokenVerifier, identityRepo IdentityRepo, userRepo UserRepo, defaultRole string) *ExternalTokenService {
+	return &ExternalTokenService{
+		tokenVerifier:      tokens,
+		identityRepository: identityRepo,
+		userRepository:     userRepo,
+		issuers:            make(map[string]externalIssuer),
+		defaultRole:        defaultRole,
+	}
+}
+
+// AddIssuer trusts the tokens of the issuer.
+func (s *ExternalTokenService) AddIssuer(verifier TrustedIssuer, cfg ExternalIssuerConfig) error {
+	if _, ok := s.issuers[verifier.Name()]; ok {
+		return fmt.Errorf("duplicate issuer %q", verifier.Name())
+	}
+
+	setDefault(&cfg.MatchBy, MatchBySubject)
+
+	if cfg.MatchBy != MatchBySubject && cfg.MatchBy != MatchByEmail {
+		return fmt.Errorf("issuer %q: match_by must be %q or %q", cfg.Name, MatchBySubject, MatchByEmail)
+	}
+
+	if cfg.Name == "" || len(externalProvider(cfg.Name)) > 50 {
+		return fmt.Errorf("issuer %q: name must be 1 to 46 characters", cfg.Name)
+	}
+
+	setDefault(&cfg.SubjectClaim, "sub")
+	setDefault(&cfg.EmailClaim, "email")
+	setDefault(&cfg.FirstNameClaim, "given_name")
+	setDefault(&cfg.LastNameClaim, "family_name")
+
+	s.issuers[verifier.Name()] = externalIssuer{verifier: verifier, config: cfg}
+
+	return nil
+}
+
+// VerifyToken verifies our own tokens and, failing that, the tokens of the
+// trusted issuers. The user is read on every request so that suspension and
+// role changes apply right away.
+func (s *ExternalTokenService) VerifyToken(token string) (*authentication.Payload, error) {
+	payload, err := s.tokenVerifier.VerifyToken(token)
+	if err == nil || len(s.issuers) == 0 {
+		return payload, err
+	}
+
+	iss, peekErr := issuer.PeekIssuer(token)
+	if peekErr != nil {
+		return nil, err
+	}
+
+	ext, ok := s.issuers[iss]
+	if !ok {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), externalTokenTimeout)
+	defer cancel()
+
+	claims, err := ext.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrUnauthorized, err)
+	}
+
+	user, err := s.externalUser(ext.config, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Active {
+		return nil, app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	expiresAt, _ := claims.Time("exp")
+
+	opts := append(accessTokenOptions(user, user.TenantOrgID), authentication.WithIssuer(ext.config.Name))
+
+	payload, err = authentication.NewPayload(user.Email, time.Until(expiresAt), opts...)
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return payload, nil
+}
+
+// externalUser returns the local user the claims are about, creating it when
+// allowed. Matched by subject, a user found by email is not linked on its
+// own: that is for the user to do, see SocialService.
+func (s *ExternalTokenService) externalUser(cfg ExternalIssuerConfig, claims issuer.Claims) (models.User, error) {
+	const op = "externalUser"
+
+	subject := claims.String(cfg.SubjectClaim)
+	email := strings.TrimSpace(claims.String(cfg.EmailClaim))
+
+	if cfg.MatchBy == MatchBySubject {
+		if subject == "" {
+			return models.User{}, app_errors.NewAppError(errcode.ErrUnauthorized, errors.New("token has no subject"))
+		}
+
+		identity, err := s.identityRepository.GetIdentity(externalProvider(cfg.Name), subject)
+		switch {
+		case err == nil:
+			user, err := s.userRepository.GetUserByID(identity.UserID)
+			if err != nil {
+				return models.User{}, userError(err)
+			}
+			return user, nil
+		case !errors.Is(err, models.ErrIdentityNotFound):
+			return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.identityRepository.GetIdentity: %w", op, err))
+		}
+	}
+
+	v := validator.New()
+	if models.ValidateEmail(v, email); !v.Valid() {
+		return models.User{}, app_errors.NewAppError(errcode.ErrUnauthorized, errors.New("token has no valid email"))
+	}
+
+	user, err := s.userRepository.GetUserByEmail(cfg.TenantOrgID, email)
+	switch {
+	case err == nil:
+		if cfg.MatchBy == MatchBySubject {
+			return models.User{}, app_errors.NewAppError(errcode.ErrUnauthorized, errors.New("the account with the email is not linked to the issuer"))
+		}
+		return user, nil
+	case !errors.Is(err, models.ErrNotFound):
+		return models.User{}, userError(err)
+	case !cfg.AllowSignUp:
+		return models.User{}, app_errors.NewAppError(errcode.ErrUnauthorized, err)
+	}
+
+	return s.signUp(cfg, subject, email, claims)
+}
+
+// signUp creates the active account of a user the issuer vouches for.
+func (s *ExternalTokenService) signUp(cfg ExternalIssuerConfig, subject, email string, claims issuer.Claims) (models.User, error) {
+	const op = "signUp"
+
+	user := models.User{Email: email, TenantOrgID: cfg.TenantOrgID}
+	user.FirstName, user.LastName = accountNames(claims.String(cfg.FirstNameClaim), claims.String(cfg.LastNameClaim), claims.String("name"), email)
+
+	err := user.Password.SetRandom()
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: user.Password.SetRandom: %w", op, err))
+	}
+
+	v := validator.New()
+	if models.ValidateUser(v, &user); !v.Valid() {
+		return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	if s.defaultRole != "" {
+		user.Roles = []string{s.defaultRole}
+	}
+
+	err = s.userRepository.CreateUser(&user)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	user, err = s.userRepository.ActivateUser(cfg.TenantOrgID, email)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	if cfg.MatchBy == MatchBySubject {
+		err = s.identityRepository.CreateIdentity(&models.Identity{
+			Provider: externalProvider(cfg.Name),
+			Subject:  subject,
+			UserID:   user.UserID,
+			Email:    email,
+		})
+		if err != nil {
+			return models.User{}, identityError(err)
+		}
+	}
+
+	return user, nil
+}
+
+// externalProvider is the provider of the identities of an issuer's users.
+func externalProvider(name string) string {
+	return "jwt:" + name
+}
+
+func setDefault(value *string, def string) {
+	if *value == "" {
+		*value = def
+	}
+}
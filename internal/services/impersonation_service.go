@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"log"
+	"time"
+)
+
+const permissionImpersonate = "users:impersonate"
+
+// ImpersonationService lets support staff act as another user with a
+// short-lived token carrying the actor claim.
+type ImpersonationService struct {
+	userRepository  UserRepo
+	orgRepository   OrgRepo
+	auditRepository AuditRepo
+	permissions     PermissionSource
+	userAdapter     EmailSender
+	asyncRunner     AsyncRunner
+	tokens          TokenVerifier
+	tokenMaker      TokenMaker
+	tokenTTL        time.Duration
+}
+
+type AuditRepo interface {
+	RecordEvent(event *models.AuditEvent) error
+}
+
+// NewImpersonationService returns the service. tokens verifies the tokens of
+// the actors and must reject those of revoked sessions.
+func NewImpersonationService(userRepo UserRepo, orgRepo OrgRepo, auditRepo AuditRepo, permissions PermissionSource, emailSender EmailSender, async AsyncRunner, tokens TokenVerifier, maker TokenMaker, tokenTTL time.Duration) *ImpersonationService {
+	return &ImpersonationService{
+		userRepository:  userRepo,
+		orgRepository:   orgRepo,
+		auditRepository: auditRepo,
+		permissions:     permissions,
+		userAdapter:     emailSender,
+		asyncRunner:     async,
+		tokens:          tokens,
+		tokenMaker:      maker,
+		tokenTTL:        tokenTTL,
+	}
+}
+
+// Impersonate exchanges the actor's access token for a token of the target
+// user. The exchange is audited and the target user is notified by email.
+// Impersonation tokens can't be exchanged again.
+func (s *ImpersonationService) Impersonate(actorToken string, targetUserID int64, reason string, ip string) (string, time.Duration, error) {
+	const op = "Impersonate"
+
+	actor, err := s.tokens.VerifyToken(actorToken)
+	if err != nil {
+		return "", 0, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+	}
+
+	if actor.Impersonated() || actor.Delegated() || actor.Scope != "" {
+		return "", 0, app_errors.NewAppError(errcode.ErrInvalidGrant, errors.New("only a full access token can be exchanged"))
+	}
+
+	// the token may be older than a suspension or a change of roles
+	actorUser, err := s.userRepository.GetUserByID(actor.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return "", 0, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+		}
+		return "", 0, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if !actorUser.Active {
+		return "", 0, app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	allowed, err := s.permissions.HasPermission(actorUser.Roles, permissionImpersonate)
+	if err != nil {
+		return "", 0, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if !allowed {
+		return "", 0, app_errors.NewAppError(errcode.ErrForbidden, fmt.Errorf("permission %s is required", permissionImpersonate))
+	}
+
+	if targetUserID == actor.UserID {
+		return "", 0, app_errors.NewAppError(errcode.ErrInvalidRequest, errors.New("can't impersonate yourself"))
+	}
+
+	target, err := s.userRepository.GetUserByID(targetUserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return "", 0, app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return "", 0, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if !target.Active {
+		return "", 0, app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("target account is suspended"))
+	}
+
+	// nobody may act as someone who could impersonate others in turn
+	privileged, err := s.permissions.HasPermission(target.Roles, permissionImpersonate)
+	if err != nil {
+		return "", 0, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if privileged {
+		return "", 0, app_errors.NewAppError(errcode.ErrForbidden, errors.New("privileged users can't be impersonated"))
+	}
+
+	// acting in an organization limits the targets to its members
+	orgID := target.TenantOrgID
+	if actor.OrgID != 0 {
+		_, err = s.orgRepository.GetMembership(actor.OrgID, target.UserID)
+		if err != nil {
+			return "", 0, orgError(err)
+		}
+		orgID = actor.OrgID
+	}
+
+	opts := append(accessTokenOptions(target, orgID), authentication.WithActor(actor.UserID, actor.Username))
+
+	token, err := s.tokenMaker.CreateToken(target.Email, s.tokenTTL, opts...)
+	if err != nil {
+		return "", 0, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	// a token that wasn't audited must not be handed out
+	err = s.auditRepository.RecordEvent(&models.AuditEvent{
+		Type:      models.AuditImpersonation,
+		ActorID:   actor.UserID,
+		SubjectID: target.UserID,
+		IP:        ip,
+		Details: map[string]interface{}{
+			"actor":      actor.Username,
+			"reason":     reason,
+			"org_id":     orgID,
+			"expires_in": s.tokenTTL.Seconds(),
+		},
+	})
+	if err != nil {
+		return "", 0, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.auditRepository.RecordEvent: %w", op, err))
+	}
+
+	s.asyncRunner.RunAsync(func() {
+		data := map[string]interface{}{
+			"firstName": target.FirstName,
+			"startedAt": time.Now().Format("02.01.2006 15:04 MST"),
+			"reason":    reason,
+		}
+		err := s.userAdapter.SendMail(target.Email, "impersonation_notice.tmpl", data)
+		if err != nil {
+			log.Printf("Failed to send impersonation notice email: %v\n", err)
+		}
+	})
+
+	return token, s.tokenTTL, nil
+}
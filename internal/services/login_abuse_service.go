@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/fingerprint"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginAbuseMetrics counts the failed logins seen by this instance. They are
+// published by expvar as "login_abuse".
+var loginAbuseMetrics = expvar.NewMap("login_abuse")
+
+type LoginAbuseStore interface {
+	RedisClient
+	CountDistinct(ctx context.Context, key string, member string, ttl time.Duration) (int64, error)
+	Keys(ctx context.Context, pattern string) ([]string, error)
+}
+
+// LoginAbuseConfig holds the thresholds of credential stuffing. Failures are
+// counted per Window; a zero threshold turns its check off.
+type LoginAbuseConfig struct {
+	Enabled bool
+	Window  time.Duration
+	// IPFailures and SubnetFailures are how many failures an address and
+	// its network may have.
+	IPFailures     int64
+	SubnetFailures int64
+	// DistinctAccounts is how many accounts an address may fail to sign in
+	// to, when they make at least DistinctRatio of its failures: one
+	// password tried on many accounts rather than a user mistyping theirs.
+	DistinctAccounts int64
+	DistinctRatio    float64
+	// GlobalFailures is how many failures of all sources make a spike.
+	// During a spike the other thresholds are divided by SpikeDivisor.
+	GlobalFailures int64
+	SpikeDivisor   int64
+	// BlockFor is how long offending sources are refused.
+	BlockFor time.Duration
+}
+
+// LoginAbuseService detects credential stuffing across accounts: the lockout
+// of an account doesn't stop one address trying a password on thousands of
+// them. Failed logins are counted in Redis, so all instances see them.
+type LoginAbuseService struct {
+	store  LoginAbuseStore
+	config LoginAbuseConfig
+}
+
+func NewLoginAbuseService(store LoginAbuseStore, config LoginAbuseConfig) *LoginAbuseService {
+	return &LoginAbuseService{
+		store:  store,
+		config: config,
+	}
+}
+
+// CheckLogin refuses the logins from blocked addresses and networks. The
+// logins go on when Redis fails: it isn't worth locking everybody out.
+func (s *LoginAbuseService) CheckLogin(ip string) error {
+	if !s.config.Enabled || ip == "" {
+		return nil
+	}
+
+	sources := []struct{ kind, source string }{
+		{models.LoginSourceIP, ip},
+		{models.LoginSourceSubnet, fingerprint.IPPrefix(ip)},
+	}
+
+	for _, src := range sources {
+		block, err := s.getBlock(src.kind, src.source)
+		if err != nil {
+			continue
+		}
+
+		loginAbuseMetrics.Add("rejected", 1)
+		return app_errors.NewAppError(errcode.ErrLoginBlocked, fmt.Errorf("%s %s is blocked until %s: %s", block.Kind, block.Source, block.ExpiresAt.Format(time.RFC3339), block.Reason))
+	}
+
+	return nil
+}
+
+// RecordFailure counts a failed login to the account from the address and
+// blocks the address or its network when they went over the thresholds.
+func (s *LoginAbuseService) RecordFailure(ip string, email string) {
+	const op = "RecordFailure"
+
+	if !s.config.Enabled || ip == "" {
+		return
+	}
+
+	loginAbuseMetrics.Add("failures", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	global, err := s.store.Incr(ctx, loginFailuresKey("global", "all"), s.config.Window)
+	if err != nil {
+		log.Printf("%s: s.store.Incr: %v\n", op, err)
+		return
+	}
+
+	divisor := int64(1)
+	if s.config.GlobalFailures > 0 && global >= s.config.GlobalFailures {
+		divisor = max(s.config.SpikeDivisor, 1)
+		if global == s.config.GlobalFailures {
+			loginAbuseMetrics.Add("spikes", 1)
+			log.Printf("%s: %d failed logins within %s, the thresholds are divided by %d\n", op, global, s.config.Window, divisor)
+		}
+	}
+
+	ipFailures, err := s.store.Incr(ctx, loginFailuresKey(models.LoginSourceIP, ip), s.config.Window)
+	if err != nil {
+		log.Printf("%s: s.store.Incr: %v\n", op, err)
+		return
+	}
+
+	accounts, err := s.store.CountDistinct(ctx, loginAccountsKey(ip), strings.ToLower(email), s.config.Window)
+	if err != nil {
+		log.Printf("%s: s.store.CountDistinct: %v\n", op, err)
+		return
+	}
+
+	subnet := fingerprint.IPPrefix(ip)
+
+	subnetFailures, err := s.store.Incr(ctx, loginFailuresKey(models.LoginSourceSubnet, subnet), s.config.Window)
+	if err != nil {
+		log.Printf("%s: s.store.Incr: %v\n", op, err)
+		return
+	}
+
+	switch {
+	case overThreshold(ipFailures, s.config.IPFailures, divisor):
+		s.blockSource(models.LoginSourceIP, ip, fmt.Sprintf("%d failed logins within %s", ipFailures, s.config.Window))
+	case overThreshold(accounts, s.config.DistinctAccounts, divisor) && float64(accounts) >= s.config.DistinctRatio*float64(ipFailures):
+		s.blockSource(models.LoginSourceIP, ip, fmt.Sprintf("failed logins to %d accounts within %s", accounts, s.config.Window))
+	}
+
+	if overThreshold(subnetFailures, s.config.SubnetFailures, divisor) {
+		s.blockSource(models.LoginSourceSubnet, subnet, fmt.Sprintf("%d failed logins within %s", subnetFailures, s.config.Window))
+	}
+}
+
+// ListBlocks returns the sources refused at the moment, the latest first.
+func (s *LoginAbuseService) ListBlocks() ([]models.LoginBlock, error) {
+	const op = "ListBlocks"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	keys, err := s.store.Keys(ctx, "login_abuse:block:*")
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.store.Keys: %w", op, err))
+	}
+
+	blocks := make([]models.LoginBlock, 0, len(keys))
+	for _, key := range keys {
+		block, err := s.readBlock(ctx, key)
+		if err != nil {
+			// expired since the scan
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].BlockedAt.After(blocks[j].BlockedAt)
+	})
+
+	return blocks, nil
+}
+
+// Unblock lets the source sign in again and forgets its failures.
+func (s *LoginAbuseService) Unblock(kind string, source string) error {
+	const op = "Unblock"
+
+	_, err := s.getBlock(kind, source)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrNotFound, errors.New("source is not blocked"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	keys := []string{loginBlockKey(kind, source), loginFailuresKey(kind, source)}
+	if kind == models.LoginSourceIP {
+		keys = append(keys, loginAccountsKey(source))
+	}
+
+	for _, key := range keys {
+		err = s.store.Del(ctx, key)
+		if err != nil {
+			return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.store.Del: %w", op, err))
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the failed logins of the current window and the totals of
+// this instance.
+func (s *LoginAbuseService) Stats() models.LoginAbuseStats {
+	stats := models.LoginAbuseStats{
+		TotalFailures: loginAbuseMetric("failures"),
+		TotalRejected: loginAbuseMetric("rejected"),
+		TotalBlocks:   loginAbuseMetric("blocks"),
+		TotalSpikes:   loginAbuseMetric("spikes"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.store.Get(ctx, loginFailuresKey("global", "all"))
+	if err == nil {
+		stats.Failures, _ = strconv.ParseInt(val, 10, 64)
+	}
+	stats.Spike = s.config.GlobalFailures > 0 && stats.Failures >= s.config.GlobalFailures
+
+	return stats
+}
+
+func (s *LoginAbuseService) blockSource(kind string, source string, reason string) {
+	const op = "blockSource"
+
+	// failures of a blocked network still come from its other addresses
+	if _, err := s.getBlock(kind, source); err == nil {
+		return
+	}
+
+	now := time.Now()
+	block := models.LoginBlock{
+		Kind:      kind,
+		Source:    source,
+		Reason:    reason,
+		BlockedAt: now,
+		ExpiresAt: now.Add(s.config.BlockFor),
+	}
+
+	data, err := json.Marshal(block)
+	if err != nil {
+		log.Printf("%s: json.Marshal: %v\n", op, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.store.Set(ctx, loginBlockKey(kind, source), string(data), s.config.BlockFor)
+	if err != nil {
+		log.Printf("%s: s.store.Set: %v\n", op, err)
+		return
+	}
+
+	loginAbuseMetrics.Add("blocks", 1)
+	log.Printf("%s: blocked %s %s for %s: %s\n", op, kind, source, s.config.BlockFor, reason)
+}
+
+func (s *LoginAbuseService) getBlock(kind string, source string) (models.LoginBlock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return s.readBlock(ctx, loginBlockKey(kind, source))
+}
+
+func (s *LoginAbuseService) readBlock(ctx context.Context, key string) (models.LoginBlock, error) {
+	val, err := s.store.Get(ctx, key)
+	if err != nil {
+		return models.LoginBlock{}, err
+	}
+
+	var block models.LoginBlock
+	err = json.Unmarshal([]byte(val), &block)
+	if err != nil {
+		return models.LoginBlock{}, err
+	}
+
+	return block, nil
+}
+
+// overThreshold reports whether the count reached the threshold divided by
+// the divisor; a zero threshold is never reached.
+func overThreshold(count int64, threshold int64, divisor int64) bool {
+	return threshold > 0 && count >= max(threshold/divisor, 1)
+}
+
+// isLoginFailure reports whether the sign in failed on the credentials, not
+// on a backend.
+func isLoginFailure(err error) bool {
+	var appErr *app_errors.AppError
+	if !errors.As(err, &appErr) {
+		return false
+	}
+
+	return appErr.Code == errcode.ErrInvalidPassword || appErr.Code == errcode.ErrNotFound
+}
+
+func loginAbuseMetric(name string) int64 {
+	if v, ok := loginAbuseMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func loginFailuresKey(kind string, source string) string {
+	return "login_abuse:failures:" + kind + ":" + source
+}
+
+func loginAccountsKey(ip string) string {
+	return "login_abuse:accounts:" + ip
+}
+
+func loginBlockKey(kind string, source string) string {
+	return "login_abuse:block:" + kind + ":" + source
+}
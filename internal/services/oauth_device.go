@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/oauth"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"strings"
+	"time"
+)
+
+// slowDownStep is added to the polling interval of a device that polls too
+// often (RFC 8628, 3.5).
+const slowDownStep = 5 * time.Second
+
+// StartDeviceAuthorization starts the device flow for the client. The device
+// shows the user code and polls the token endpoint with the device code.
+func (s *OAuthService) StartDeviceAuthorization(clientID, clientSecret, scope string) (models.DeviceCode, error) {
+	const op = "StartDeviceAuthorization"
+
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return models.DeviceCode{}, err
+	}
+
+	scopes := oauth.ParseScope(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !oauth.Subset(scopes, client.Scopes) {
+		return models.DeviceCode{}, app_errors.NewAppError(errcode.ErrInvalidScope, fmt.Errorf("client may only request %s", oauth.FormatScope(client.Scopes)))
+	}
+
+	deviceCode, err := models.GenerateDeviceCode()
+	if err != nil {
+		return models.DeviceCode{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: models.GenerateDeviceCode: %w", op, err))
+	}
+
+	userCode, err := models.GenerateUserCode()
+	if err != nil {
+		return models.DeviceCode{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: models.GenerateUserCode: %w", op, err))
+	}
+
+	authorization := models.DeviceAuthorization{
+		ClientID:  client.ClientID,
+		Scopes:    scopes,
+		UserCode:  userCode,
+		Status:    models.DeviceStatusPending,
+		Interval:  s.config.DevicePollInterval,
+		ExpiresAt: time.Now().Add(s.config.DeviceCodeTTL),
+	}
+
+	deviceKey := deviceCodeKey(deviceCode)
+
+	err = s.saveDeviceAuthorization(deviceKey, authorization)
+	if err != nil {
+		return models.DeviceCode{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, userCodeKey(userCode), deviceKey, s.config.DeviceCodeTTL)
+	if err != nil {
+		return models.DeviceCode{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Set: %w", op, err))
+	}
+
+	verificationURI := s.deviceVerificationURI()
+
+	verificationURIComplete, err := redirectURI(verificationURI, map[string]string{"user_code": userCode})
+	if err != nil {
+		return models.DeviceCode{}, err
+	}
+
+	return models.DeviceCode{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURIComplete,
+		ExpiresIn:               s.config.DeviceCodeTTL,
+		Interval:                s.config.DevicePollInterval,
+	}, nil
+}
+
+// GetDeviceAuthorization returns what the user is asked to approve: the
+// client and the scopes of a pending user code.
+func (s *OAuthService) GetDeviceAuthorization(userCode string) (models.OAuthClient, []string, error) {
+	_, authorization, err := s.pendingDeviceAuthorization(userCode)
+	if err != nil {
+		return models.OAuthClient{}, nil, err
+	}
+
+	client, err := s.oauthRepository.GetClient(authorization.ClientID)
+	if err != nil {
+		return models.OAuthClient{}, nil, clientError(err)
+	}
+
+	return client, authorization.Scopes, nil
+}
+
+// DecideDeviceAuthorization records the answer of the signed in user for the
+// user code. A user code can be answered once. The answer is kept apart from
+// the authorization, which the polls of the device don't overwrite then.
+func (s *OAuthService) DecideDeviceAuthorization(userID int64, orgID int64, userCode string, approve bool) error {
+	const op = "DecideDeviceAuthorization"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// of concurrent answers only one gets the user code
+	deviceKey, err := s.redisClient.GetDel(ctx, userCodeKey(userCode))
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrNotFound, errors.New("user code is invalid or expired"))
+	}
+
+	authorization, err := s.loadDeviceAuthorization(deviceKey)
+	if err != nil || authorization.Status != models.DeviceStatusPending {
+		return app_errors.NewAppError(errcode.ErrNotFound, errors.New("user code is invalid or expired"))
+	}
+
+	authorization.Status = models.DeviceStatusDenied
+	if approve {
+		authorization.Status = models.DeviceStatusApproved
+		authorization.UserID = userID
+		authorization.OrgID = orgID
+	}
+
+	err = s.saveDeviceAuthorization(deviceDecisionKey(deviceKey), authorization)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	return nil
+}
+
+// PollDeviceAuthorization answers the polling device: pending, slow down,
+// denied, expired or the tokens once the user approved.
+func (s *OAuthService) PollDeviceAuthorization(clientID, clientSecret, deviceCode string) (models.TokenGrant, error) {
+	const op = "PollDeviceAuthorization"
+
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return models.TokenGrant{}, err
+	}
+
+	deviceKey := deviceCodeKey(deviceCode)
+
+	authorization, err := s.loadDeviceAuthorization(deviceKey)
+	if err != nil {
+		// the code is gone once it expires
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrExpiredToken, err)
+	}
+
+	if authorization.ClientID != client.ClientID {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, errors.New("device code was issued to another client"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the answer is taken at once, so that the tokens are issued once
+	val, err := s.redisClient.GetDel(ctx, deviceDecisionKey(deviceKey))
+	if err == nil {
+		var decision models.DeviceAuthorization
+		if err = json.Unmarshal([]byte(val), &decision); err != nil {
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: json.Unmarshal: %w", op, err))
+		}
+
+		_ = s.redisClient.Del(ctx, deviceKey)
+		_ = s.redisClient.Del(ctx, devicePollKey(deviceKey))
+
+		if decision.Status != models.DeviceStatusApproved {
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrAccessDenied, errors.New("user denied the device"))
+		}
+		return s.issueTokens(client, decision.UserID, decision.OrgID, decision.Scopes, "")
+	}
+
+	// the polls are tracked under their own key
+	poll, err := s.loadDeviceAuthorization(devicePollKey(deviceKey))
+	if err != nil {
+		poll = authorization
+	}
+
+	code := errcode.ErrAuthorizationPending
+	if time.Since(poll.LastPolledAt) < poll.Interval {
+		code = errcode.ErrSlowDown
+		poll.Interval += slowDownStep
+	}
+	poll.LastPolledAt = time.Now()
+
+	err = s.saveDeviceAuthorization(devicePollKey(deviceKey), poll)
+	if err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	return models.TokenGrant{}, app_errors.NewAppError(code, errors.New("device authorization is pending"))
+}
+
+func (s *OAuthService) pendingDeviceAuthorization(userCode string) (string, models.DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	deviceKey, err := s.redisClient.Get(ctx, userCodeKey(userCode))
+	if err != nil {
+		return "", models.DeviceAuthorization{}, app_errors.NewAppError(errcode.ErrNotFound, errors.New("user code is invalid or expired"))
+	}
+
+	authorization, err := s.loadDeviceAuthorization(deviceKey)
+	if err != nil || authorization.Status != models.DeviceStatusPending {
+		return "", models.DeviceAuthorization{}, app_errors.NewAppError(errcode.ErrNotFound, errors.New("user code is invalid or expired"))
+	}
+
+	return deviceKey, authorization, nil
+}
+
+func (s *OAuthService) loadDeviceAuthorization(deviceKey string) (models.DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, deviceKey)
+	if err != nil {
+		return models.DeviceAuthorization{}, err
+	}
+
+	var authorization models.DeviceAuthorization
+	err = json.Unmarshal([]byte(val), &authorization)
+
+	return authorization, err
+}
+
+// saveDeviceAuthorization stores the authorization until it expires.
+func (s *OAuthService) saveDeviceAuthorization(deviceKey string, authorization models.DeviceAuthorization) error {
+	ttl := time.Until(authorization.ExpiresAt)
+	if ttl <= 0 {
+		return errors.New("device authorization has expired")
+	}
+
+	data, err := json.Marshal(authorization)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return s.redisClient.Set(ctx, deviceKey, string(data), ttl)
+}
+
+func (s *OAuthService) deviceVerificationURI() string {
+	if s.config.DeviceVerificationURI != "" {
+		return s.config.DeviceVerificationURI
+	}
+	return strings.TrimSuffix(s.config.Issuer, "/") + "/oauth/device"
+}
+
+func deviceCodeKey(deviceCode string) string {
+	return "oauth:device:" + hex.EncodeToString(verification.Hash(deviceCode))
+}
+
+func deviceDecisionKey(deviceKey string) string {
+	return deviceKey + ":decision"
+}
+
+func devicePollKey(deviceKey string) string {
+	return deviceKey + ":poll"
+}
+
+func userCodeKey(userCode string) string {
+	return "oauth:user_code:" + models.NormalizeUserCode(userCode)
+}
@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/jwks"
+	"fullstack-simple-app/pkg/oauth"
+	"fullstack-simple-app/pkg/oidc"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// OAuthService is the OAuth 2.0 authorization server: the client registry,
+// the authorization code flow with PKCE, refresh token rotation and the
+// client credentials and device grants, with OpenID Connect on top. Tokens of the
+// authorization code flow act as the user, limited to the granted scopes;
+// client credentials tokens act as the client itself.
+type OAuthService struct {
+	oauthRepository OAuthRepo
+	userRepository  UserRepo
+	orgRepository   OrgRepo
+	redisClient     RedisClient
+	tokenMaker      TokenMaker
+	idTokenSigner   IDTokenSigner
+	config          OAuthConfig
+}
+
+type OAuthRepo interface {
+	CreateClient(client *models.OAuthClient) error
+	GetClient(clientID string) (models.OAuthClient, error)
+	ListClients() ([]models.OAuthClient, error)
+	CreateRefreshToken(token *models.RefreshToken) error
+	ConsumeRefreshToken(hash []byte) (models.RefreshToken, error)
+}
+
+type IDTokenSigner interface {
+	Sign(claims oidc.Claims) (string, error)
+	KeySet() jwks.Set
+}
+
+type OAuthConfig struct {
+	CodeTTL         time.Duration
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Issuer is the public base URL of the service, the iss of ID tokens.
+	Issuer string
+	// AuthorizationEndpoint is the consent page advertised to OpenID Connect
+	// clients. Empty means the API endpoint.
+	AuthorizationEndpoint string
+	IDTokenTTL            time.Duration
+
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
+	// DeviceVerificationURI is the page where users enter the user code of
+	// a device. Empty means the API endpoint.
+	DeviceVerificationURI string
+}
+
+func NewOAuthService(oauthRepo OAuthRepo, userRepo UserRepo, orgRepo OrgRepo, redis RedisClient, maker TokenMaker, idTokenSigner IDTokenSigner, cfg OAuthConfig) *OAuthService {
+	return &OAuthService{
+		oauthRepository: oauthRepo,
+		userRepository:  userRepo,
+		orgRepository:   orgRepo,
+		redisClient:     redis,
+		tokenMaker:      maker,
+		idTokenSigner:   idTokenSigner,
+		config:          cfg,
+	}
+}
+
+// CreateClient registers the client and returns its secret, which is shown
+// only once. Public clients get none.
+func (s *OAuthService) CreateClient(client *models.OAuthClient) (string, error) {
+	const op = "CreateClient"
+
+	v := validator.New()
+
+	if models.ValidateOAuthClient(v, client); !v.Valid() {
+		return "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	secret, err := client.GenerateCredentials()
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: client.GenerateCredentials: %w", op, err))
+	}
+
+	err = s.oauthRepository.CreateClient(client)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.oauthRepository.CreateClient: %w", op, err))
+	}
+
+	return secret, nil
+}
+
+func (s *OAuthService) ListClients() ([]models.OAuthClient, error) {
+	clients, err := s.oauthRepository.ListClients()
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return clients, nil
+}
+
+// PrepareAuthorization validates the authorization request and returns what
+// the user is asked to consent to: the client and the scopes. No scope means
+// all the scopes the client is allowed.
+func (s *OAuthService) PrepareAuthorization(req models.AuthorizationRequest) (models.OAuthClient, []string, error) {
+	v := validator.New()
+
+	if models.ValidateAuthorizationRequest(v, req); !v.Valid() {
+		return models.OAuthClient{}, nil, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	client, err := s.oauthRepository.GetClient(req.ClientID)
+	if err != nil {
+		return models.OAuthClient{}, nil, clientError(err)
+	}
+
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return models.OAuthClient{}, nil, app_errors.NewAppError(errcode.ErrInvalidRequest, errors.New("redirect_uri is not registered for the client"))
+	}
+
+	scopes := oauth.ParseScope(req.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !oauth.Subset(scopes, client.Scopes) {
+		return models.OAuthClient{}, nil, app_errors.NewAppError(errcode.ErrInvalidScope, fmt.Errorf("client may only request %s", oauth.FormatScope(client.Scopes)))
+	}
+
+	return client, scopes, nil
+}
+
+// Authorize records the consent of the user and returns the redirect URI
+// carrying the authorization code.
+func (s *OAuthService) Authorize(userID int64, orgID int64, req models.AuthorizationRequest) (string, error) {
+	const op = "Authorize"
+
+	client, scopes, err := s.PrepareAuthorization(req)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := models.GenerateAuthorizationCode()
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: models.GenerateAuthorizationCode: %w", op, err))
+	}
+
+	data, err := json.Marshal(models.AuthorizationCode{
+		ClientID:      client.ClientID,
+		RedirectURI:   req.RedirectURI,
+		UserID:        userID,
+		OrgID:         orgID,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+	})
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: json.Marshal: %w", op, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, authorizationCodeKey(code), string(data), s.config.CodeTTL)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Set: %w", op, err))
+	}
+
+	return redirectURI(req.RedirectURI, map[string]string{"code": code, "state": req.State})
+}
+
+// Deny returns the redirect URI telling the client the user didn't consent.
+func (s *OAuthService) Deny(req models.AuthorizationRequest) (string, error) {
+	_, _, err := s.PrepareAuthorization(req)
+	if err != nil {
+		return "", err
+	}
+
+	return redirectURI(req.RedirectURI, map[string]string{"error": "access_denied", "state": req.State})
+}
+
+// ExchangeCode issues tokens for an authorization code. A code can be used
+// once, by the client it was issued to, with the PKCE verifier matching the
+// challenge of the authorization request.
+func (s *OAuthService) ExchangeCode(clientID, clientSecret, code, redirectURI, codeVerifier string) (models.TokenGrant, error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return models.TokenGrant{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	key := authorizationCodeKey(code)
+
+	// used or not, a code doesn't survive an exchange attempt, and of
+	// concurrent exchanges only one gets it
+	val, err := s.redisClient.GetDel(ctx, key)
+	if err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+	}
+
+	var authorization models.AuthorizationCode
+	if err = json.Unmarshal([]byte(val), &authorization); err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+	}
+
+	if authorization.ClientID != client.ClientID || authorization.RedirectURI != redirectURI {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, errors.New("code was issued to another client or redirect_uri"))
+	}
+
+	if err = oauth.VerifyS256(codeVerifier, authorization.CodeChallenge); err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+	}
+
+	return s.issueTokens(client, authorization.UserID, authorization.OrgID, authorization.Scopes, authorization.Nonce)
+}
+
+// Refresh exchanges a refresh token for new tokens. The refresh token is
+// rotated; scope may narrow the scopes of the new tokens.
+func (s *OAuthService) Refresh(clientID, clientSecret, refreshToken, scope string) (models.TokenGrant, error) {
+	const op = "Refresh"
+
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return models.TokenGrant{}, err
+	}
+
+	token, err := s.oauthRepository.ConsumeRefreshToken(verification.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenNotFound) {
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+		}
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if token.ClientID != client.ClientID || token.Expired() {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, errors.New("refresh token is expired or was issued to another client"))
+	}
+
+	scopes := token.Scopes
+	if requested := oauth.ParseScope(scope); len(requested) > 0 {
+		if !oauth.Subset(requested, token.Scopes) {
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidScope, errors.New("scope exceeds the original grant"))
+		}
+		scopes = requested
+	}
+
+	return s.issueTokens(client, token.UserID, token.OrgID, scopes, "")
+}
+
+// ClientCredentials issues a token whose subject is the client, for service
+// to service calls. Only confidential clients may use the grant; the token
+// may do what its scopes list and can't be refreshed.
+func (s *OAuthService) ClientCredentials(clientID, clientSecret, scope string) (models.TokenGrant, error) {
+	const op = "ClientCredentials"
+
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return models.TokenGrant{}, err
+	}
+
+	if !client.Confidential {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrUnauthorizedClient, errors.New("public clients can't use client credentials"))
+	}
+
+	scopes := oauth.ParseScope(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !oauth.Subset(scopes, client.Scopes) {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidScope, fmt.Errorf("client may only request %s", oauth.FormatScope(client.Scopes)))
+	}
+
+	accessToken, err := s.tokenMaker.CreateToken(client.ClientID, s.config.AccessTokenTTL,
+		authentication.WithClient(client.ClientID),
+		authentication.WithScopes(scopes...),
+	)
+	if err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.tokenMaker.CreateToken: %w", op, err))
+	}
+
+	return models.TokenGrant{
+		AccessToken: accessToken,
+		ExpiresIn:   s.config.AccessTokenTTL,
+		Scopes:      scopes,
+	}, nil
+}
+
+func (s *OAuthService) authenticateClient(clientID, clientSecret string) (models.OAuthClient, error) {
+	client, err := s.oauthRepository.GetClient(clientID)
+	if err != nil {
+		if errors.Is(err, models.ErrClientNotFound) {
+			return models.OAuthClient{}, app_errors.NewAppError(errcode.ErrInvalidClient, err)
+		}
+		return models.OAuthClient{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if !client.Authenticate(clientSecret) {
+		return models.OAuthClient{}, app_errors.NewAppError(errcode.ErrInvalidClient, errors.New("invalid client credentials"))
+	}
+
+	return client, nil
+}
+
+// issueTokens creates an access token acting as the user within the scopes,
+// a refresh token for the same grant and, with the openid scope, an ID token.
+// The user must still be active and a member of the organization.
+func (s *OAuthService) issueTokens(client models.OAuthClient, userID, orgID int64, scopes []string, nonce string) (models.TokenGrant, error) {
+	const op = "issueTokens"
+
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+		}
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	if !user.Active || !user.Activated {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, errors.New("user is not active"))
+	}
+
+	if orgID != 0 && orgID != user.TenantOrgID {
+		_, err = s.orgRepository.GetMembership(orgID, user.UserID)
+		if err != nil {
+			if errors.Is(err, models.ErrNotMember) {
+				return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInvalidGrant, err)
+			}
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+		}
+	}
+
+	opts := append(accessTokenOptions(user, orgID),
+		authentication.WithScopes(scopes...),
+		authentication.WithClient(client.ClientID),
+	)
+
+	accessToken, err := s.tokenMaker.CreateToken(user.Email, s.config.AccessTokenTTL, opts...)
+	if err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.tokenMaker.CreateToken: %w", op, err))
+	}
+
+	refreshToken := &models.RefreshToken{
+		ClientID:  client.ClientID,
+		UserID:    user.UserID,
+		OrgID:     orgID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(s.config.RefreshTokenTTL),
+	}
+
+	plaintext, err := refreshToken.GenerateRefreshToken()
+	if err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: refreshToken.GenerateRefreshToken: %w", op, err))
+	}
+
+	err = s.oauthRepository.CreateRefreshToken(refreshToken)
+	if err != nil {
+		return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.oauthRepository.CreateRefreshToken: %w", op, err))
+	}
+
+	var idToken string
+	if slices.Contains(scopes, oidc.ScopeOpenID) {
+		idToken, err = s.idToken(client, user, scopes, nonce)
+		if err != nil {
+			return models.TokenGrant{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.idToken: %w", op, err))
+		}
+	}
+
+	return models.TokenGrant{
+		AccessToken:  accessToken,
+		ExpiresIn:    s.config.AccessTokenTTL,
+		RefreshToken: plaintext,
+		IDToken:      idToken,
+		Scopes:       scopes,
+	}, nil
+}
+
+func clientError(err error) error {
+	if errors.Is(err, models.ErrClientNotFound) {
+		return app_errors.NewAppError(errcode.ErrInvalidRequest, err)
+	}
+	return app_errors.NewAppError(errcode.ErrInternal, err)
+}
+
+// authorizationCodeKey keeps only the hash of a code in Redis.
+func authorizationCodeKey(code string) string {
+	return "oauth:code:" + hex.EncodeToString(verification.Hash(code))
+}
+
+// redirectURI adds the non-empty parameters to the query of the registered
+// redirect URI.
+func redirectURI(base string, params map[string]string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/jwks"
+	"fullstack-simple-app/pkg/oauth"
+	"fullstack-simple-app/pkg/oidc"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Discovery describes the provider to OpenID Connect clients.
+func (s *OAuthService) Discovery() oidc.ProviderMetadata {
+	issuer := strings.TrimSuffix(s.config.Issuer, "/")
+
+	authorizationEndpoint := s.config.AuthorizationEndpoint
+	if authorizationEndpoint == "" {
+		authorizationEndpoint = issuer + "/oauth/authorize"
+	}
+
+	return oidc.ProviderMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             authorizationEndpoint,
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail},
+		ResponseTypesSupported:            []string{models.ResponseTypeCode},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", oauth.GrantTypeDeviceCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwks.AlgRS256},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{oauth.CodeChallengeMethodS256},
+		ClaimsSupported:                   []string{"sub", "email", "email_verified", "given_name", "family_name", "name", "nonce"},
+	}
+}
+
+// KeySet returns the keys ID tokens are signed with.
+func (s *OAuthService) KeySet() jwks.Set {
+	return s.idTokenSigner.KeySet()
+}
+
+// UserInfo returns the claims about the user of an access token granted the
+// openid scope.
+func (s *OAuthService) UserInfo(payload *authentication.Payload) (oidc.UserInfo, error) {
+	const op = "UserInfo"
+
+	if payload.UserID == 0 || !slices.Contains(payload.Scopes, oidc.ScopeOpenID) {
+		return oidc.UserInfo{}, app_errors.NewAppError(errcode.ErrForbidden, errors.New("token was not granted the openid scope"))
+	}
+
+	user, err := s.userRepository.GetUserByID(payload.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return oidc.UserInfo{}, app_errors.NewAppError(errcode.ErrUnauthorized, err)
+		}
+		return oidc.UserInfo{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	return userInfo(user, payload.Scopes), nil
+}
+
+func (s *OAuthService) idToken(client models.OAuthClient, user models.User, scopes []string, nonce string) (string, error) {
+	now := time.Now()
+
+	return s.idTokenSigner.Sign(oidc.Claims{
+		Issuer:    strings.TrimSuffix(s.config.Issuer, "/"),
+		Audience:  oidc.Audience{client.ClientID},
+		ExpiresAt: now.Add(s.config.IDTokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		Nonce:     nonce,
+		UserInfo:  userInfo(user, scopes),
+	})
+}
+
+// userInfo returns the claims the scopes grant access to.
+func userInfo(user models.User, scopes []string) oidc.UserInfo {
+	info := oidc.UserInfo{Subject: strconv.FormatInt(user.UserID, 10)}
+
+	if slices.Contains(scopes, oidc.ScopeEmail) {
+		verified := user.EmailVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+
+	if slices.Contains(scopes, oidc.ScopeProfile) {
+		info.GivenName = user.FirstName
+		info.FamilyName = user.LastName
+		info.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	return info
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"log"
+	"time"
+)
+
+type OrgService struct {
+	orgRepository  OrgRepo
+	userRepository UserRepo
+	userAdapter    EmailSender
+	asyncRunner    AsyncRunner
+	tokenMaker     TokenMaker
+	invitationTTL  time.Duration
+}
+
+type OrgRepo interface {
+	CreateOrganization(org *models.Organization, ownerID int64) error
+	GetOrganizationBySlug(slug string) (models.Organization, error)
+	GetOrganizationByID(orgID int64) (models.Organization, error)
+	GetMembership(orgID, userID int64) (models.Membership, error)
+	GetUserMemberships(userID int64) ([]models.Membership, error)
+	GetOrgMembers(orgID int64) ([]models.Membership, error)
+	CreateInvitation(invitation *models.Invitation) error
+	GetInvitation(tokenHash []byte) (models.Invitation, error)
+	AcceptInvitation(tokenHash []byte, userID int64, email string) (models.Invitation, error)
+}
+
+func NewOrgService(orgRepo OrgRepo, userRepo UserRepo, emailSender EmailSender, async AsyncRunner, maker TokenMaker, invitationTTL time.Duration) *OrgService {
+	return &OrgService{
+		orgRepository:  orgRepo,
+		userRepository: userRepo,
+		userAdapter:    emailSender,
+		asyncRunner:    async,
+		tokenMaker:     maker,
+		invitationTTL:  invitationTTL,
+	}
+}
+
+// CreateOrganization creates a shared organization owned by the user.
+func (s *OrgService) CreateOrganization(userID int64, org *models.Organization) error {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if user.TenantOrgID != 0 {
+		return app_errors.NewAppError(errcode.ErrForbidden, errors.New("tenant accounts can't create organizations"))
+	}
+
+	org.Isolated = false
+
+	return s.createOrganization(org, user.UserID)
+}
+
+// CreateTenant creates an isolated organization. It has no members until users
+// register into it.
+func (s *OrgService) CreateTenant(org *models.Organization) error {
+	org.Isolated = true
+
+	return s.createOrganization(org, 0)
+}
+
+func (s *OrgService) createOrganization(org *models.Organization, ownerID int64) error {
+	v := validator.New()
+
+	if models.ValidateOrganization(v, org); !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	err := s.orgRepository.CreateOrganization(org, ownerID)
+	if err != nil {
+		return orgError(err)
+	}
+
+	return nil
+}
+
+func (s *OrgService) ListUserOrganizations(userID int64) ([]models.Membership, error) {
+	memberships, err := s.orgRepository.GetUserMemberships(userID)
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return memberships, nil
+}
+
+// ListMembers returns the members of the organization. Only its members may
+// see them.
+func (s *OrgService) ListMembers(userID int64, orgID int64) ([]models.Membership, error) {
+	_, err := s.orgRepository.GetMembership(orgID, userID)
+	if err != nil {
+		return nil, orgError(err)
+	}
+
+	members, err := s.orgRepository.GetOrgMembers(orgID)
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return members, nil
+}
+
+// InviteMember emails an invitation into the organization. Only its owners
+// and admins may invite.
+func (s *OrgService) InviteMember(inviterID int64, orgID int64, email string, role string) (models.Invitation, error) {
+	membership, err := s.orgRepository.GetMembership(orgID, inviterID)
+	if err != nil {
+		return models.Invitation{}, orgError(err)
+	}
+
+	if !membership.CanManage() {
+		return models.Invitation{}, app_errors.NewAppError(errcode.ErrForbidden, errors.New("only owners and admins may invite members"))
+	}
+
+	// admins can't hand out more than they have
+	if role == models.OrgRoleOwner && membership.Role != models.OrgRoleOwner {
+		return models.Invitation{}, app_errors.NewAppError(errcode.ErrForbidden, errors.New("only owners may invite owners"))
+	}
+
+	return s.invite(inviterID, orgID, email, role)
+}
+
+// AdminInviteMember invites into any organization on behalf of a service
+// administrator, e.g. the first owner of a new tenant.
+func (s *OrgService) AdminInviteMember(adminID int64, orgID int64, email string, role string) (models.Invitation, error) {
+	return s.invite(adminID, orgID, email, role)
+}
+
+func (s *OrgService) invite(inviterID int64, orgID int64, email string, role string) (models.Invitation, error) {
+	const op = "invite"
+
+	v := validator.New()
+
+	models.ValidateEmail(v, email)
+	models.ValidateOrgRole(v, role)
+
+	if !v.Valid() {
+		return models.Invitation{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	org, err := s.orgRepository.GetOrganizationByID(orgID)
+	if err != nil {
+		return models.Invitation{}, orgError(err)
+	}
+
+	token, err := verification.GenerateToken(inviterID, s.invitationTTL, verification.ScopeInvitation)
+	if err != nil {
+		return models.Invitation{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	invitation := models.Invitation{
+		OrgID:     org.OrgID,
+		Email:     email,
+		Role:      role,
+		TokenHash: token.Hash,
+		InvitedBy: inviterID,
+		ExpiresAt: token.Expiry,
+	}
+
+	err = s.orgRepository.CreateInvitation(&invitation)
+	if err != nil {
+		return models.Invitation{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	s.asyncRunner.RunAsync(func() {
+		data := map[string]interface{}{
+			"orgName":         org.Name,
+			"invitationToken": token.Plaintext,
+			"expiresAt":       token.Expiry.Format("02.01.2006 15:04 MST"),
+		}
+		err := s.userAdapter.SendMail(email, "org_invitation.tmpl", data)
+		if err != nil {
+			log.Printf("Failed to send invitation email: %v\n", err)
+		}
+	})
+
+	return invitation, nil
+}
+
+// AcceptInvitation adds the user to the organization the invitation was sent
+// for. The invitation must have been sent to the user's email, and accounts of
+// an isolated tenant can only join that tenant.
+func (s *OrgService) AcceptInvitation(userID int64, token string) (models.Membership, error) {
+	v := validator.New()
+
+	if verification.ValidationTokenPlaintext(v, token); !v.Valid() {
+		return models.Membership{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	user, err := s.getUser(userID)
+	if err != nil {
+		return models.Membership{}, err
+	}
+
+	hash := verification.Hash(token)
+
+	invitation, err := s.orgRepository.GetInvitation(hash)
+	if err != nil {
+		return models.Membership{}, orgError(err)
+	}
+
+	org, err := s.orgRepository.GetOrganizationByID(invitation.OrgID)
+	if err != nil {
+		return models.Membership{}, orgError(err)
+	}
+
+	if (org.Isolated || user.TenantOrgID != 0) && user.TenantOrgID != org.OrgID {
+		return models.Membership{}, app_errors.NewAppError(errcode.ErrForbidden, errors.New("account belongs to another tenant"))
+	}
+
+	invitation, err = s.orgRepository.AcceptInvitation(hash, user.UserID, user.Email)
+	if err != nil {
+		return models.Membership{}, orgError(err)
+	}
+
+	membership, err := s.orgRepository.GetMembership(invitation.OrgID, user.UserID)
+	if err != nil {
+		return models.Membership{}, orgError(err)
+	}
+
+	return membership, nil
+}
+
+// SwitchOrganization returns a new access token acting in the organization.
+// Zero switches global accounts back to acting outside of any organization.
+// The token keeps when and how the user authenticated.
+func (s *OrgService) SwitchOrganization(userID int64, orgID int64, authTime time.Time, amr []string) (string, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return "", err
+	}
+
+	if user.TenantOrgID != 0 && orgID != user.TenantOrgID {
+		return "", app_errors.NewAppError(errcode.ErrForbidden, errors.New("account belongs to another tenant"))
+	}
+
+	if orgID != 0 {
+		_, err = s.orgRepository.GetMembership(orgID, user.UserID)
+		if err != nil {
+			return "", orgError(err)
+		}
+	}
+
+	opts := append(accessTokenOptions(user, orgID), authentication.WithAuthentication(authTime, amr...))
+
+	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour, opts...)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return token, nil
+}
+
+func (s *OrgService) getUser(userID int64) (models.User, error) {
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return user, nil
+}
+
+// orgError maps the organization repository errors to application errors.
+func orgError(err error) error {
+	switch {
+	case errors.Is(err, models.ErrOrgNotFound), errors.Is(err, models.ErrInvitationNotFound):
+		return app_errors.NewAppError(errcode.ErrNotFound, err)
+	case errors.Is(err, models.ErrNotMember):
+		return app_errors.NewAppError(errcode.ErrForbidden, err)
+	case errors.Is(err, models.ErrDuplicateSlug):
+		return app_errors.NewAppError(errcode.ErrConflict, err)
+	default:
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+}
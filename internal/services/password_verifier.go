@@ -0,0 +1,257 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/directory"
+	"fullstack-simple-app/pkg/validator"
+)
+
+// PasswordVerifier checks the password of a user of the tenant and returns
+// the local account of the user. A verifier that doesn't know the user fails
+// with errcode.ErrNotFound, so the next one of the chain is asked.
+type PasswordVerifier interface {
+	VerifyPassword(tenantID int64, email, password string) (models.User, error)
+}
+
+// LocalPasswordVerifier checks the password hashes of the users table.
+type LocalPasswordVerifier struct {
+	userRepository UserRepo
+}
+
+func NewLocalPasswordVerifier(userRepo UserRepo) *LocalPasswordVerifier {
+	return &LocalPasswordVerifier{userRepository: userRepo}
+}
+
+func (v *LocalPasswordVerifier) VerifyPassword(tenantID int64, email, password string) (models.User, error) {
+	user, err := v.userRepository.GetUserByEmail(tenantID, email)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if !match {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInvalidPassword, errors.New("invalid password"))
+	}
+
+	return user, nil
+}
+
+type Directory interface {
+	Authenticate(username, password string) (directory.Entry, error)
+}
+
+// LDAPPasswordVerifier checks passwords against a corporate directory.
+type LDAPPasswordVerifier struct {
+	directory      Directory
+	userRepository UserRepo
+	roleRepository RoleRepo
+	config         LDAPConfig
+}
+
+type LDAPConfig struct {
+	// TenantOrgID is the isolated organization whose users are in the
+	// directory, 0 for the global accounts.
+	TenantOrgID int64
+	// AutoProvision creates the local account on the first login.
+	AutoProvision bool
+	// GroupRoles maps the DNs of directory groups to roles. The roles are
+	// granted and revoked on every login to follow the groups; other roles
+	// are left alone.
+	GroupRoles  map[string]string
+	DefaultRole string
+}
+
+func NewLDAPPasswordVerifier(dir Directory, userRepo UserRepo, roleRepo RoleRepo, cfg LDAPConfig) *LDAPPasswordVerifier {
+	return &LDAPPasswordVerifier{
+		directory:      dir,
+		userRepository: userRepo,
+		roleRepository: roleRepo,
+		config:         cfg,
+	}
+}
+
+func (v *LDAPPasswordVerifier) VerifyPassword(tenantID int64, email, password string) (models.User, error) {
+	const op = "LDAPPasswordVerifier.VerifyPassword"
+
+	if tenantID != v.config.TenantOrgID {
+		return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, models.ErrNotFound)
+	}
+
+	entry, err := v.directory.Authenticate(email, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, directory.ErrUserNotFound):
+			return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, models.ErrNotFound)
+		case errors.Is(err, directory.ErrInvalidCredentials):
+			return models.User{}, app_errors.NewAppError(errcode.ErrInvalidPassword, errors.New("invalid password"))
+		default:
+			return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: v.directory.Authenticate: %w", op, err))
+		}
+	}
+
+	user, err := v.userRepository.GetUserByEmail(tenantID, email)
+	switch {
+	case err == nil && !user.EmailVerified:
+		// anybody may have registered the email before its owner signed
+		// in: an account nobody verified isn't handed to the directory user
+		return models.User{}, app_errors.NewAppError(errcode.ErrForbidden, errors.New("account of the email isn't verified"))
+	case err == nil:
+	case errors.Is(err, models.ErrNotFound) && v.config.AutoProvision:
+		user, err = v.provisionUser(tenantID, email, entry)
+		if err != nil {
+			return models.User{}, err
+		}
+	default:
+		return models.User{}, userError(err)
+	}
+
+	err = v.syncRoles(&user, entry.Groups)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// provisionUser creates the active local account of a directory user, with
+// the email verified by the directory. The password of the account is
+// random: the directory keeps the real one.
+func (v *LDAPPasswordVerifier) provisionUser(tenantID int64, email string, entry directory.Entry) (models.User, error) {
+	const op = "provisionUser"
+
+	user := models.User{Email: email, TenantOrgID: tenantID}
+	user.FirstName, user.LastName = accountNames(entry.FirstName, entry.LastName, "", email)
+
+	err := user.Password.SetRandom()
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: user.Password.SetRandom: %w", op, err))
+	}
+
+	val := validator.New()
+	if models.ValidateUser(val, &user); !val.Valid() {
+		return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, val.Errors)
+	}
+
+	if v.config.DefaultRole != "" {
+		user.Roles = []string{v.config.DefaultRole}
+	}
+
+	err = v.userRepository.CreateUser(&user)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	user, err = v.userRepository.VerifyEmail(user.UserID)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	return user, nil
+}
+
+// syncRoles grants the roles mapped from the groups of the user and revokes
+// the mapped roles of groups the user has left.
+func (v *LDAPPasswordVerifier) syncRoles(user *models.User, groups []string) error {
+	if len(v.config.GroupRoles) == 0 {
+		return nil
+	}
+
+	want := make(map[string]bool)
+	for groupDN, role := range v.config.GroupRoles {
+		if _, ok := want[role]; !ok {
+			want[role] = false
+		}
+		for _, group := range groups {
+			if directory.SameDN(group, groupDN) {
+				want[role] = true
+			}
+		}
+	}
+
+	has := make(map[string]bool, len(user.Roles))
+	for _, role := range user.Roles {
+		has[role] = true
+	}
+
+	changed := false
+	for role, member := range want {
+		var err error
+		switch {
+		case member && !has[role]:
+			err = v.roleRepository.AssignRole(user.UserID, role)
+		case !member && has[role]:
+			err = v.roleRepository.RevokeRole(user.UserID, role)
+		default:
+			continue
+		}
+		if err != nil {
+			return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("sync role %q: %w", role, err))
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	roles, err := v.roleRepository.GetUserRoles(user.UserID)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+	user.Roles = roles
+
+	return nil
+}
+
+// verifyPassword asks the verifiers in turn until one accepts the password.
+// When all fail, the most telling error is returned: a failure of a backend
+// over a wrong password, and a wrong password over an unknown user. The
+// second value reports whether the local password hash was checked.
+func (s *UserService) verifyPassword(tenantID int64, email, password string) (models.User, bool, error) {
+	var (
+		lastErr error
+		rank    int
+	)
+
+	for _, verifier := range s.passwordVerifiers {
+		user, err := verifier.VerifyPassword(tenantID, email, password)
+		if err == nil {
+			_, local := verifier.(*LocalPasswordVerifier)
+			return user, local, nil
+		}
+
+		if r := verifierErrorRank(err); r > rank || lastErr == nil {
+			lastErr, rank = err, r
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = app_errors.NewAppError(errcode.ErrNotFound, models.ErrNotFound)
+	}
+
+	return models.User{}, false, lastErr
+}
+
+func verifierErrorRank(err error) int {
+	var appErr *app_errors.AppError
+	if !errors.As(err, &appErr) {
+		return 2
+	}
+
+	switch appErr.Code {
+	case errcode.ErrNotFound:
+		return 0
+	case errcode.ErrInvalidPassword:
+		return 1
+	default:
+		return 2
+	}
+}
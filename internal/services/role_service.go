@@ -0,0 +1,163 @@
+package services
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/validator"
+	"sync"
+	"time"
+)
+
+type RoleService struct {
+	roleRepository RoleRepo
+	cacheTTL       time.Duration
+
+	mu          sync.RWMutex
+	permissions map[string]map[string]bool
+	loadedAt    time.Time
+}
+
+type RoleRepo interface {
+	GetRoles() ([]models.Role, error)
+	GetUserRoles(userID int64) ([]string, error)
+	AssignRole(userID int64, role string) error
+	RevokeRole(userID int64, role string) error
+}
+
+// NewRoleService creates a RoleService. The role to permission mapping is
+// cached in memory for cacheTTL, so permission checks don't hit the database
+// on every request.
+func NewRoleService(roleRepo RoleRepo, cacheTTL time.Duration) *RoleService {
+	return &RoleService{
+		roleRepository: roleRepo,
+		cacheTTL:       cacheTTL,
+	}
+}
+
+// HasPermission reports whether any of the roles grants the permission.
+func (s *RoleService) HasPermission(roles []string, permission string) (bool, error) {
+	permissions, err := s.rolePermissions()
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if permissions[role][permission] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Permissions returns the union of the permissions granted by the roles.
+func (s *RoleService) Permissions(roles []string) ([]string, error) {
+	permissions, err := s.rolePermissions()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+
+	for _, role := range roles {
+		for permission := range permissions[role] {
+			if !seen[permission] {
+				seen[permission] = true
+				result = append(result, permission)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (s *RoleService) rolePermissions() (map[string]map[string]bool, error) {
+	s.mu.RLock()
+	permissions, loadedAt := s.permissions, s.loadedAt
+	s.mu.RUnlock()
+
+	if permissions != nil && time.Since(loadedAt) < s.cacheTTL {
+		return permissions, nil
+	}
+
+	roles, err := s.roleRepository.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	permissions = make(map[string]map[string]bool, len(roles))
+	for _, role := range roles {
+		permissions[role.Name] = make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			permissions[role.Name][permission] = true
+		}
+	}
+
+	s.mu.Lock()
+	s.permissions = permissions
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	return permissions, nil
+}
+
+func (s *RoleService) ListRoles() ([]models.Role, error) {
+	roles, err := s.roleRepository.GetRoles()
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return roles, nil
+}
+
+func (s *RoleService) GetUserRoles(userID int64) ([]string, error) {
+	roles, err := s.roleRepository.GetUserRoles(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return roles, nil
+}
+
+// AssignRole grants the role to the user. The change shows up in the user's
+// tokens the next time they are issued.
+func (s *RoleService) AssignRole(userID int64, role string) error {
+	v := validator.New()
+
+	if models.ValidateRoleName(v, role); !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	err := s.roleRepository.AssignRole(userID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotFound), errors.Is(err, models.ErrRoleNotFound):
+			return app_errors.NewAppError(errcode.ErrNotFound, err)
+		default:
+			return app_errors.NewAppError(errcode.ErrInternal, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *RoleService) RevokeRole(userID int64, role string) error {
+	v := validator.New()
+
+	if models.ValidateRoleName(v, role); !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	err := s.roleRepository.RevokeRole(userID, role)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/saml"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SAMLService struct {
+	samlRepository SAMLRepo
+	orgRepository  OrgRepo
+	userRepository UserRepo
+	redisClient    SAMLStore
+	tokenMaker     TokenMaker
+	config         SAMLConfig
+}
+
+type SAMLStore interface {
+	RedisClient
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+}
+
+type SAMLRepo interface {
+	PutSAMLConnection(c *models.SAMLConnection) error
+	GetSAMLConnection(orgID int64) (models.SAMLConnection, error)
+	DeleteSAMLConnection(orgID int64) error
+}
+
+type SAMLConfig struct {
+	// BaseURL is the public URL of the service, the prefix of the entity ID
+	// and the assertion consumer service of each tenant.
+	BaseURL string
+	// RequestTTL is how long the user may take to sign in at the identity
+	// provider.
+	RequestTTL time.Duration
+	// LoginRedirectURL is the page of the frontend the browser is sent to
+	// with the access token in the fragment. Empty answers with JSON.
+	LoginRedirectURL string
+	DefaultRole      string
+}
+
+func NewSAMLService(samlRepo SAMLRepo, orgRepo OrgRepo, userRepo UserRepo, redis SAMLStore, maker TokenMaker, cfg SAMLConfig) *SAMLService {
+	return &SAMLService{
+		samlRepository: samlRepo,
+		orgRepository:  orgRepo,
+		userRepository: userRepo,
+		redisClient:    redis,
+		tokenMaker:     maker,
+		config:         cfg,
+	}
+}
+
+// Metadata describes the service provider of the tenant to its identity
+// provider.
+func (s *SAMLService) Metadata(slug string) ([]byte, error) {
+	org, err := s.tenant(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.serviceProvider(org).Metadata()
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return data, nil
+}
+
+// StartLogin returns the URL of the identity provider of the tenant carrying
+// a new authentication request (SP-initiated login).
+func (s *SAMLService) StartLogin(slug string) (string, error) {
+	const op = "StartLogin"
+
+	org, connection, idp, err := s.connection(slug)
+	if err != nil {
+		return "", err
+	}
+
+	requestID, redirectTo, err := s.serviceProvider(org).AuthnRequestURL(idp, "", time.Now())
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: AuthnRequestURL: %w", op, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, samlRequestKey(requestID), strconv.FormatInt(connection.OrgID, 10), s.config.RequestTTL)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Set: %w", op, err))
+	}
+
+	return redirectTo, nil
+}
+
+// ConsumeResponse validates the response the identity provider of the tenant
+// posted to its assertion consumer service, and signs the user in. Accounts
+// are created on their first login. The second value is where the browser
+// is sent with the token, when a frontend page is configured.
+func (s *SAMLService) ConsumeResponse(slug, samlResponse string) (string, string, error) {
+	const op = "ConsumeResponse"
+
+	org, connection, idp, err := s.connection(slug)
+	if err != nil {
+		return "", "", err
+	}
+
+	assertion, err := s.serviceProvider(org).ParseResponse(samlResponse, idp, time.Now())
+	if err != nil {
+		return "", "", app_errors.NewAppError(errcode.ErrSAMLLoginFailed, fmt.Errorf("%s: ParseResponse: %w", op, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if assertion.InResponseTo != "" {
+		// a request is answered once
+		orgID, err := s.redisClient.GetDel(ctx, samlRequestKey(assertion.InResponseTo))
+		if err != nil || orgID != strconv.FormatInt(org.OrgID, 10) {
+			return "", "", app_errors.NewAppError(errcode.ErrSAMLLoginFailed, errors.New("response to an unknown or expired request"))
+		}
+	} else if !connection.AllowIdPInitiated {
+		return "", "", app_errors.NewAppError(errcode.ErrSAMLLoginFailed, errors.New("logins initiated at the identity provider are not allowed"))
+	}
+
+	// an assertion signs the user in once
+	fresh, err := s.redisClient.SetNX(ctx, samlAssertionKey(org.OrgID, assertion.ID), "1", time.Until(assertion.ExpiresAt))
+	if err != nil {
+		return "", "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.SetNX: %w", op, err))
+	}
+
+	if !fresh {
+		return "", "", app_errors.NewAppError(errcode.ErrSAMLLoginFailed, errors.New("assertion was already used"))
+	}
+
+	user, err := s.provisionUser(connection.MapUser(assertion))
+	if err != nil {
+		return "", "", err
+	}
+
+	if !user.Active {
+		return "", "", app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour, signInOptions(user, org.OrgID)...)
+	if err != nil {
+		return "", "", app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if s.config.LoginRedirectURL == "" {
+		return token, "", nil
+	}
+
+	fragment := url.Values{"access_token": {token}}
+
+	return token, s.config.LoginRedirectURL + "#" + fragment.Encode(), nil
+}
+
+func (s *SAMLService) PutConnection(connection *models.SAMLConnection) error {
+	org, err := s.orgRepository.GetOrganizationByID(connection.OrgID)
+	if err != nil {
+		return orgError(err)
+	}
+
+	v := validator.New()
+
+	v.Check(org.Isolated, "org_id", "must be an isolated organization")
+	models.ValidateSAMLConnection(v, connection)
+
+	if !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	err = s.samlRepository.PutSAMLConnection(connection)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return nil
+}
+
+func (s *SAMLService) GetConnection(orgID int64) (models.SAMLConnection, error) {
+	connection, err := s.samlRepository.GetSAMLConnection(orgID)
+	if err != nil {
+		return models.SAMLConnection{}, samlError(err)
+	}
+
+	return connection, nil
+}
+
+func (s *SAMLService) DeleteConnection(orgID int64) error {
+	return samlError(s.samlRepository.DeleteSAMLConnection(orgID))
+}
+
+// provisionUser returns the account of the tenant with the email of the
+// assertion, creating it when there is none. The identity provider vouches
+// for the email, so new accounts are active right away.
+func (s *SAMLService) provisionUser(fields models.User) (models.User, error) {
+	const op = "provisionUser"
+
+	fields.Email = strings.TrimSpace(fields.Email)
+
+	v := validator.New()
+	if models.ValidateEmail(v, fields.Email); !v.Valid() {
+		return models.User{}, app_errors.NewAppError(errcode.ErrSAMLLoginFailed, fmt.Errorf("%s: assertion holds no valid email", op))
+	}
+
+	user, err := s.userRepository.GetUserByEmail(fields.TenantOrgID, fields.Email)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, models.ErrNotFound) {
+		return models.User{}, userError(err)
+	}
+
+	user = models.User{Email: fields.Email, TenantOrgID: fields.TenantOrgID}
+	user.FirstName, user.LastName = accountNames(fields.FirstName, fields.LastName, "", fields.Email)
+
+	err = user.Password.SetRandom()
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: user.Password.SetRandom: %w", op, err))
+	}
+
+	if models.ValidateUser(v, &user); !v.Valid() {
+		return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	if s.config.DefaultRole != "" {
+		user.Roles = []string{s.config.DefaultRole}
+	}
+
+	err = s.userRepository.CreateUser(&user)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	user, err = s.userRepository.ActivateUser(user.TenantOrgID, user.Email)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	return user, nil
+}
+
+// tenant returns the isolated organization with the slug: only tenants have
+// their own identity provider.
+func (s *SAMLService) tenant(slug string) (models.Organization, error) {
+	org, err := s.orgRepository.GetOrganizationBySlug(slug)
+	if err != nil {
+		return models.Organization{}, orgError(err)
+	}
+
+	if !org.Isolated {
+		return models.Organization{}, app_errors.NewAppError(errcode.ErrNotFound, models.ErrSAMLConnectionNotFound)
+	}
+
+	return org, nil
+}
+
+func (s *SAMLService) connection(slug string) (models.Organization, models.SAMLConnection, saml.IdentityProvider, error) {
+	org, err := s.tenant(slug)
+	if err != nil {
+		return models.Organization{}, models.SAMLConnection{}, saml.IdentityProvider{}, err
+	}
+
+	connection, err := s.samlRepository.GetSAMLConnection(org.OrgID)
+	if err != nil {
+		return models.Organization{}, models.SAMLConnection{}, saml.IdentityProvider{}, samlError(err)
+	}
+
+	idp, err := connection.IdentityProvider()
+	if err != nil {
+		return models.Organization{}, models.SAMLConnection{}, saml.IdentityProvider{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return org, connection, idp, nil
+}
+
+// serviceProvider is the service provider as known to the identity provider
+// of the tenant.
+func (s *SAMLService) serviceProvider(org models.Organization) saml.ServiceProvider {
+	base := strings.TrimSuffix(s.config.BaseURL, "/") + "/saml/" + org.Slug
+
+	return saml.ServiceProvider{
+		EntityID: base + "/metadata",
+		ACSURL:   base + "/acs",
+	}
+}
+
+func samlError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, models.ErrSAMLConnectionNotFound):
+		return app_errors.NewAppError(errcode.ErrNotFound, err)
+	default:
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+}
+
+func samlRequestKey(requestID string) string {
+	return "saml:request:" + hex.EncodeToString(verification.Hash(requestID))
+}
+
+func samlAssertionKey(orgID int64, assertionID string) string {
+	return fmt.Sprintf("saml:assertion:%d:%s", orgID, hex.EncodeToString(verification.Hash(assertionID)))
+}
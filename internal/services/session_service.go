@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"strconv"
+	"time"
+)
+
+// SessionService signs users out everywhere. Access tokens are stateless, so
+// the moment of the revocation is kept for as long as tokens live, and the
+// tokens issued before it are rejected.
+type SessionService struct {
+	redisClient            RedisClient
+	refreshTokenRepository RefreshTokenRevoker
+	apiKeyRepository       APIKeyRevoker
+	deviceRepository       TrustedDeviceRepo
+	tokenTTL               time.Duration
+}
+
+type RefreshTokenRevoker interface {
+	RevokeUserRefreshTokens(userID int64) error
+}
+
+type APIKeyRevoker interface {
+	RevokeUserAPIKeys(userID int64) error
+}
+
+// NewSessionService returns the service; tokenTTL is the longest lifetime of
+// an access token.
+func NewSessionService(redis RedisClient, refreshTokens RefreshTokenRevoker, apiKeys APIKeyRevoker, deviceRepo TrustedDeviceRepo, tokenTTL time.Duration) *SessionService {
+	return &SessionService{
+		redisClient:            redis,
+		refreshTokenRepository: refreshTokens,
+		apiKeyRepository:       apiKeys,
+		deviceRepository:       deviceRepo,
+		tokenTTL:               tokenTTL,
+	}
+}
+
+// RevokeSessions invalidates the access and refresh tokens and the api keys of
+// the user issued so far, and forgets the devices trusted to skip the second
+// factor.
+func (s *SessionService) RevokeSessions(userID int64) error {
+	const op = "RevokeSessions"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := s.redisClient.Set(ctx, sessionsRevokedKey(userID), strconv.FormatInt(time.Now().UnixNano(), 10), s.tokenTTL)
+	if err != nil {
+		return fmt.Errorf("%s: s.redisClient.Set: %w", op, err)
+	}
+
+	err = s.refreshTokenRepository.RevokeUserRefreshTokens(userID)
+	if err != nil {
+		return fmt.Errorf("%s: s.refreshTokenRepository.RevokeUserRefreshTokens: %w", op, err)
+	}
+
+	// keys are created without a step-up, whoever had the session may have one
+	err = s.apiKeyRepository.RevokeUserAPIKeys(userID)
+	if err != nil {
+		return fmt.Errorf("%s: s.apiKeyRepository.RevokeUserAPIKeys: %w", op, err)
+	}
+
+	err = s.deviceRepository.DeleteUserTrustedDevices(userID)
+	if err != nil {
+		return fmt.Errorf("%s: s.deviceRepository.DeleteUserTrustedDevices: %w", op, err)
+	}
+
+	return nil
+}
+
+// Verifier wraps the token verifier to reject the tokens of revoked
+// sessions.
+func (s *SessionService) Verifier(tokens TokenVerifier) TokenVerifier {
+	return sessionVerifier{tokens: tokens, sessions: s}
+}
+
+// revokedAt returns when the sessions of the user were last revoked, zero if
+// not within the lifetime of tokens.
+func (s *SessionService) revokedAt(userID int64) time.Time {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, sessionsRevokedKey(userID))
+	if err != nil {
+		return time.Time{}
+	}
+
+	nanos, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
+}
+
+type sessionVerifier struct {
+	tokens   TokenVerifier
+	sessions *SessionService
+}
+
+func (v sessionVerifier) VerifyToken(token string) (*authentication.Payload, error) {
+	payload, err := v.tokens.VerifyToken(token)
+	if err != nil || payload.UserID == 0 {
+		return payload, err
+	}
+
+	if payload.IssuedAt.Before(v.sessions.revokedAt(payload.UserID)) {
+		return nil, app_errors.NewAppError(errcode.ErrUnauthorized, errors.New("session was revoked"))
+	}
+
+	return payload, nil
+}
+
+func sessionsRevokedKey(userID int64) string {
+	return "sessions:revoked:" + strconv.FormatInt(userID, 10)
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/social"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// exchangeTimeout bounds the calls to the identity provider at the
+	// callback.
+	exchangeTimeout = 10 * time.Second
+	// linkAttempts is how many passwords a link token allows
+	linkAttempts = 5
+)
+
+type SocialService struct {
+	identityRepository IdentityRepo
+	userRepository     UserRepo
+	accounts           AccountSignIn
+	redisClient        RedisClient
+	tokenMaker         TokenMaker
+	providers          map[string]SocialProvider
+	config             SocialConfig
+}
+
+// AccountSignIn signs in the existing accounts identities are linked to.
+type AccountSignIn interface {
+	SignInAccount(userID int64, password string, client models.ClientInfo) (models.SignIn, error)
+	SignInExternal(userID int64) (models.SignIn, error)
+}
+
+type IdentityRepo interface {
+	CreateIdentity(identity *models.Identity) error
+	GetIdentity(provider, subject string) (models.Identity, error)
+	ListUserIdentities(userID int64) ([]models.Identity, error)
+	DeleteIdentity(userID int64, provider string) error
+	TouchIdentity(identity *models.Identity) error
+}
+
+// SocialProvider is an external identity provider users sign in with.
+type SocialProvider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, req social.AuthRequest) (string, error)
+	Exchange(ctx context.Context, code string, req social.AuthRequest) (social.Identity, error)
+}
+
+type SocialConfig struct {
+	// StateTTL is how long the user may take to sign in at the provider.
+	StateTTL time.Duration
+	// LinkTTL is how long the user may take to confirm the link of an
+	// identity to their existing account.
+	LinkTTL time.Duration
+	// AllowSignUp creates accounts for identities with an unknown email.
+	AllowSignUp bool
+	DefaultRole string
+}
+
+// socialState is what the state parameter of a login stands for.
+type socialState struct {
+	Provider string             `json:"provider"`
+	Request  social.AuthRequest `json:"request"`
+}
+
+func NewSocialService(identityRepo IdentityRepo, userRepo UserRepo, accounts AccountSignIn, redis RedisClient, maker TokenMaker, providers []SocialProvider, cfg SocialConfig) *SocialService {
+	byName := make(map[string]SocialProvider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+
+	return &SocialService{
+		identityRepository: identityRepo,
+		userRepository:     userRepo,
+		accounts:           accounts,
+		redisClient:        redis,
+		tokenMaker:         maker,
+		providers:          byName,
+		config:             cfg,
+	}
+}
+
+// Providers returns the names of the configured providers.
+func (s *SocialService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// StartLogin returns the URL of the provider the user is sent to. The
+// provider sends them back to the callback with a code and the state.
+func (s *SocialService) StartLogin(providerName string) (string, error) {
+	const op = "StartLogin"
+
+	provider, err := s.provider(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := social.NewAuthRequest()
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: social.NewAuthRequest: %w", op, err))
+	}
+
+	data, err := json.Marshal(socialState{Provider: providerName, Request: req})
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: json.Marshal: %w", op, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exchangeTimeout)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, socialStateKey(req.State), string(data), s.config.StateTTL)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Set: %w", op, err))
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, req)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrSocialLoginFailed, fmt.Errorf("%s: provider.AuthCodeURL: %w", op, err))
+	}
+
+	return authURL, nil
+}
+
+// CompleteLogin handles the return of the user from the provider and signs
+// them in:
+//   - with the account the identity is linked to;
+//   - with a new account when no account has the email of the identity;
+//   - with the account that has the email, once its owner confirms the link
+//     with their password. Only an email verified by the provider is trusted
+//     for this: the link token to confirm it with is returned instead.
+func (s *SocialService) CompleteLogin(providerName, code, state string) (models.SignIn, error) {
+	const op = "CompleteLogin"
+
+	provider, err := s.provider(providerName)
+	if err != nil {
+		return models.SignIn{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exchangeTimeout)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, socialStateKey(state))
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrSocialLoginFailed, errors.New("login state is invalid or expired"))
+	}
+
+	// a state is good for one attempt
+	_ = s.redisClient.Del(ctx, socialStateKey(state))
+
+	var saved socialState
+	if err = json.Unmarshal([]byte(val), &saved); err != nil || saved.Provider != providerName {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrSocialLoginFailed, errors.New("login state is invalid or expired"))
+	}
+
+	external, err := provider.Exchange(ctx, code, saved.Request)
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrSocialLoginFailed, fmt.Errorf("%s: provider.Exchange: %w", op, err))
+	}
+
+	identity, err := s.identityRepository.GetIdentity(providerName, external.Subject)
+	switch {
+	case err == nil:
+		identity.Email = external.Email
+		if err = s.identityRepository.TouchIdentity(&identity); err != nil {
+			return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.identityRepository.TouchIdentity: %w", op, err))
+		}
+
+		return s.accounts.SignInExternal(identity.UserID)
+	case !errors.Is(err, models.ErrIdentityNotFound):
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.identityRepository.GetIdentity: %w", op, err))
+	}
+
+	if external.Email == "" {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrSocialLoginFailed, errors.New("the provider shared no email"))
+	}
+
+	// only global accounts sign in through providers
+	user, err := s.userRepository.GetUserByEmail(0, external.Email)
+	switch {
+	case err == nil:
+		linkToken, err := s.requestLink(user, external)
+		if err != nil {
+			return models.SignIn{}, err
+		}
+		return models.SignIn{LinkToken: linkToken}, nil
+	case !errors.Is(err, models.ErrNotFound):
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.userRepository.GetUserByEmail: %w", op, err))
+	}
+
+	if !s.config.AllowSignUp {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrSocialLoginFailed, errors.New("no account with this email"))
+	}
+
+	// an unverified email may belong to someone else, who would find their
+	// future account already linked to this identity
+	if !external.EmailVerified {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrSocialLoginFailed, errors.New("the provider hasn't verified the email"))
+	}
+
+	token, err := s.signUp(external)
+	if err != nil {
+		return models.SignIn{}, err
+	}
+
+	return models.SignIn{AccessToken: token}, nil
+}
+
+// ConfirmLink links the identity of the link token to the existing account,
+// once the password of the account proves that the user owns it, and signs
+// them in like a sign in with the password: users with the second factor on
+// still need the code.
+func (s *SocialService) ConfirmLink(linkToken, password string, client models.ClientInfo) (models.SignIn, error) {
+	const op = "ConfirmLink"
+
+	key := identityLinkKey(linkToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, key)
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrNotFound, errors.New("link token is invalid or expired"))
+	}
+
+	var link models.IdentityLink
+	if err = json.Unmarshal([]byte(val), &link); err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: json.Unmarshal: %w", op, err))
+	}
+
+	// every try is counted before the password is checked, so that
+	// parallel guesses don't get past the limit
+	attempts, err := s.redisClient.Incr(ctx, identityLinkAttemptsKey(linkToken), s.config.LinkTTL)
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Incr: %w", op, err))
+	}
+
+	if attempts > linkAttempts {
+		err = s.redisClient.Del(ctx, key)
+		if err != nil {
+			return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Del: %w", op, err))
+		}
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrNotFound, errors.New("too many attempts, sign in with the provider again"))
+	}
+
+	result, err := s.accounts.SignInAccount(link.UserID, password, client)
+	if err != nil {
+		return models.SignIn{}, err
+	}
+
+	// a link token links once: of concurrent requests only one gets it
+	if _, err = s.redisClient.GetDel(ctx, key); err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrNotFound, errors.New("link token was already used"))
+	}
+
+	err = s.identityRepository.CreateIdentity(&models.Identity{
+		Provider: link.Provider,
+		Subject:  link.Subject,
+		UserID:   link.UserID,
+		Email:    link.Email,
+	})
+	if err != nil {
+		return models.SignIn{}, identityError(err)
+	}
+
+	return result, nil
+}
+
+// ListIdentities returns the providers the user signs in with.
+func (s *SocialService) ListIdentities(userID int64) ([]models.Identity, error) {
+	identities, err := s.identityRepository.ListUserIdentities(userID)
+	if err != nil {
+		return nil, identityError(err)
+	}
+
+	return identities, nil
+}
+
+// Unlink removes the identity of the provider from the user. The password,
+// or a password reset, still signs them in.
+func (s *SocialService) Unlink(userID int64, providerName string) error {
+	return identityError(s.identityRepository.DeleteIdentity(userID, providerName))
+}
+
+func (s *SocialService) provider(name string) (SocialProvider, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, app_errors.NewAppError(errcode.ErrNotFound, fmt.Errorf("unknown identity provider %q", name))
+	}
+
+	return provider, nil
+}
+
+// requestLink keeps the identity until the owner of the account confirms the
+// link, and returns the token to confirm it with.
+func (s *SocialService) requestLink(user models.User, external social.Identity) (string, error) {
+	const op = "requestLink"
+
+	// anyone can claim an unverified email at some providers
+	if !external.EmailVerified {
+		return "", app_errors.NewAppError(errcode.ErrEmailAlreadyExists, errors.New("the provider has not verified the email of the existing account"))
+	}
+
+	linkToken, err := models.GenerateLinkToken()
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: models.GenerateLinkToken: %w", op, err))
+	}
+
+	data, err := json.Marshal(models.IdentityLink{
+		Provider: external.Provider,
+		Subject:  external.Subject,
+		Email:    external.Email,
+		UserID:   user.UserID,
+	})
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: json.Marshal: %w", op, err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, identityLinkKey(linkToken), string(data), s.config.LinkTTL)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Set: %w", op, err))
+	}
+
+	return linkToken, nil
+}
+
+// signUp creates the account of the identity, activated since the provider
+// has verified the email. It has a random password until the user resets it.
+func (s *SocialService) signUp(external social.Identity) (string, error) {
+	const op = "signUp"
+
+	firstName, lastName := identityNames(external)
+
+	user := models.User{
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     external.Email,
+	}
+
+	err := user.Password.SetRandom()
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: user.Password.SetRandom: %w", op, err))
+	}
+
+	v := validator.New()
+	if models.ValidateUser(v, &user); !v.Valid() {
+		return "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	if s.config.DefaultRole != "" {
+		user.Roles = []string{s.config.DefaultRole}
+	}
+
+	err = s.userRepository.CreateUser(&user)
+	if err != nil {
+		return "", userError(err)
+	}
+
+	user, err = s.userRepository.VerifyEmail(user.UserID)
+	if err != nil {
+		return "", userError(err)
+	}
+
+	err = s.identityRepository.CreateIdentity(&models.Identity{
+		Provider: external.Provider,
+		Subject:  external.Subject,
+		UserID:   user.UserID,
+		Email:    external.Email,
+	})
+	if err != nil {
+		return "", identityError(err)
+	}
+
+	return s.issueToken(user)
+}
+
+func (s *SocialService) issueToken(user models.User) (string, error) {
+	if !user.Active {
+		return "", app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	if !user.Activated {
+		return "", app_errors.NewAppError(errcode.ErrForbidden, errors.New("account is not activated"))
+	}
+
+	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour, signInOptions(user, user.TenantOrgID)...)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return token, nil
+}
+
+// identityNames splits the name the provider knows the user by into the
+// first and last names an account requires.
+func identityNames(external social.Identity) (string, string) {
+	return accountNames(external.GivenName, external.FamilyName, external.Name, external.Email)
+}
+
+// accountNames returns the first and last names an account requires from
+// what an identity provider shares: the separate names, the full name or at
+// least the email.
+func accountNames(first, last, fullName, email string) (string, string) {
+	if first == "" && last == "" {
+		first, last, _ = strings.Cut(strings.TrimSpace(fullName), " ")
+	}
+
+	if first == "" {
+		first, _, _ = strings.Cut(email, "@")
+	}
+
+	if last == "" {
+		last = first
+	}
+
+	return truncate(strings.TrimSpace(first), 50), truncate(strings.TrimSpace(last), 50)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+func userError(err error) error {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return app_errors.NewAppError(errcode.ErrNotFound, err)
+	case errors.Is(err, models.ErrDuplicateEmail):
+		return app_errors.NewAppError(errcode.ErrEmailAlreadyExists, err)
+	default:
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+}
+
+func identityError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, models.ErrIdentityNotFound):
+		return app_errors.NewAppError(errcode.ErrNotFound, err)
+	case errors.Is(err, models.ErrDuplicateIdentity):
+		return app_errors.NewAppError(errcode.ErrConflict, err)
+	default:
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+}
+
+func socialStateKey(state string) string {
+	return "social:state:" + hex.EncodeToString(verification.Hash(state))
+}
+
+func identityLinkKey(linkToken string) string {
+	return "social:link:" + hex.EncodeToString(verification.Hash(linkToken))
+}
+
+func identityLinkAttemptsKey(linkToken string) string {
+	return "social:link_attempts:" + hex.EncodeToString(verification.Hash(linkToken))
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/validator"
+)
+
+// ListUsers searches the users. A non-zero filter.OrgID limits the search to
+// the members of that organization.
+func (s *UserService) ListUsers(filter models.UserFilter) (models.UserPage, error) {
+	if filter.Sort == "" {
+		filter.Sort = "-created_at"
+	}
+	if filter.Limit == 0 {
+		filter.Limit = models.DefaultUsersPageSize
+	}
+
+	v := validator.New()
+
+	if models.ValidateUserFilter(v, filter); !v.Valid() {
+		return models.UserPage{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	users, next, err := s.userRepository.ListUsers(filter)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			return models.UserPage{}, app_errors.NewAppError(errcode.ErrInvalidRequest, err)
+		}
+		return models.UserPage{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if users == nil {
+		users = []models.User{}
+	}
+
+	return models.UserPage{Users: users, NextCursor: next}, nil
+}
+
+// GetUserByID returns the user. When orgID is not zero, only members of that
+// organization are visible.
+func (s *UserService) GetUserByID(orgID int64, userID int64) (models.User, error) {
+	if orgID != 0 {
+		_, err := s.orgRepository.GetMembership(orgID, userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNotMember) {
+				return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, models.ErrNotFound)
+			}
+			return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+		}
+	}
+
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return user, nil
+}
+
+// ForceActivateUser activates the account without the emailed code.
+func (s *UserService) ForceActivateUser(orgID int64, userID int64) (models.User, error) {
+	user, err := s.GetUserByID(orgID, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err = s.userRepository.ActivateUser(user.TenantOrgID, user.Email)
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return s.GetUserByID(orgID, user.UserID)
+}
+
+// SuspendUser blocks the user from signing in until UnsuspendUser is called
+// and signs them out of the sessions they have.
+func (s *UserService) SuspendUser(orgID int64, userID int64) (models.User, error) {
+	const op = "SuspendUser"
+
+	user, err := s.setUserActive(orgID, userID, false)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	err = s.sessions.RevokeSessions(user.UserID)
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	return user, nil
+}
+
+func (s *UserService) UnsuspendUser(orgID int64, userID int64) (models.User, error) {
+	return s.setUserActive(orgID, userID, true)
+}
+
+func (s *UserService) setUserActive(orgID int64, userID int64, active bool) (models.User, error) {
+	_, err := s.GetUserByID(orgID, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err := s.userRepository.SetUserActive(userID, active)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return user, nil
+}
+
+// TriggerPasswordReset emails the user a password reset code on behalf of an
+// admin.
+func (s *UserService) TriggerPasswordReset(orgID int64, userID int64) error {
+	const op = "TriggerPasswordReset"
+
+	user, err := s.GetUserByID(orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.sendPasswordReset(user.TenantOrgID, user.Email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
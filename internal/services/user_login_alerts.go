@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/fingerprint"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type LoginDeviceRepo interface {
+	RecordLoginDevice(device *models.LoginDevice) (bool, error)
+	DeleteLoginDevices(userID int64) error
+}
+
+type SessionRevoker interface {
+	RevokeSessions(userID int64) error
+}
+
+// LoginAlertConfig holds the settings of the emails about logins from new
+// devices.
+type LoginAlertConfig struct {
+	Enabled bool
+	// ReportURL is the "this wasn't me" link, the token is added as the
+	// token query parameter.
+	ReportURL string
+	// ReportTTL is how long the link works.
+	ReportTTL time.Duration
+}
+
+// alertNewDevice tells the user about a login from a device not seen before.
+// It runs in the background: the login doesn't wait for it.
+func (s *UserService) alertNewDevice(user models.User, client models.ClientInfo) {
+	if !s.loginAlertConfig.Enabled {
+		return
+	}
+
+	loginAt := time.Now()
+
+	s.asyncRunner.RunAsync(func() {
+		const op = "alertNewDevice"
+
+		device := models.LoginDevice{
+			UserID:    user.UserID,
+			UserAgent: client.UserAgent,
+			IPPrefix:  fingerprint.IPPrefix(client.IP),
+		}
+		if client.DeviceID != "" {
+			device.CookieHash = verification.Hash(client.DeviceID)
+		}
+
+		known, err := s.loginDeviceRepository.RecordLoginDevice(&device)
+		if err != nil {
+			log.Printf("%s: s.loginDeviceRepository.RecordLoginDevice: %v\n", op, err)
+			return
+		}
+
+		if known {
+			return
+		}
+
+		reportURL, err := s.loginReportURL(user.UserID)
+		if err != nil {
+			log.Printf("%s: %v\n", op, err)
+			return
+		}
+
+		data := map[string]interface{}{
+			"loginAt":   loginAt.Format("02.01.2006 15:04 MST"),
+			"ip":        client.IP,
+			"browser":   fingerprint.Browser(client.UserAgent),
+			"reportURL": reportURL,
+		}
+		err = s.userAdapter.SendMail(user.Email, "new_device_login.tmpl", data)
+		if err != nil {
+			log.Printf("Failed to send new device login email: %v\n", err)
+		}
+	})
+}
+
+// loginReportURL returns a new "this wasn't me" link for the user.
+func (s *UserService) loginReportURL(userID int64) (string, error) {
+	token, err := models.GenerateLoginAlertToken()
+	if err != nil {
+		return "", fmt.Errorf("models.GenerateLoginAlertToken: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, loginAlertKey(token), strconv.FormatInt(userID, 10), s.loginAlertConfig.ReportTTL)
+	if err != nil {
+		return "", fmt.Errorf("s.redisClient.Set: %w", err)
+	}
+
+	reportURL, err := url.Parse(s.loginAlertConfig.ReportURL)
+	if err != nil {
+		return "", fmt.Errorf("url.Parse: %w", err)
+	}
+
+	query := reportURL.Query()
+	query.Set("token", token)
+	reportURL.RawQuery = query.Encode()
+
+	return reportURL.String(), nil
+}
+
+// ReportLogin handles the "this wasn't me" link of a login alert: the user
+// is signed out everywhere and gets a password reset code, and every next
+// login is treated as one from a new device.
+func (s *UserService) ReportLogin(token string) error {
+	const op = "ReportLogin"
+
+	key := loginAlertKey(token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, key)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrNotFound, errors.New("login alert is unknown or expired"))
+	}
+
+	userID, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: strconv.ParseInt: %w", op, err))
+	}
+
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		return userError(err)
+	}
+
+	err = s.sessions.RevokeSessions(user.UserID)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	err = s.loginDeviceRepository.DeleteLoginDevices(user.UserID)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.loginDeviceRepository.DeleteLoginDevices: %w", op, err))
+	}
+
+	err = s.sendPasswordReset(user.TenantOrgID, user.Email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the link works once; a failure above leaves it usable for a retry
+	err = s.redisClient.Del(ctx, key)
+	if err != nil {
+		log.Printf("%s: s.redisClient.Del: %v\n", op, err)
+	}
+
+	return nil
+}
+
+func loginAlertKey(token string) string {
+	return "login_alert:" + hex.EncodeToString(verification.Hash(token))
+}
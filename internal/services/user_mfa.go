@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"log"
+	"time"
+)
+
+type TrustedDeviceRepo interface {
+	CreateTrustedDevice(device *models.TrustedDevice) error
+	UseTrustedDevice(tokenHash []byte) (models.TrustedDevice, error)
+	ListTrustedDevices(userID int64) ([]models.TrustedDevice, error)
+	DeleteTrustedDevice(userID, deviceID int64) error
+	DeleteUserTrustedDevices(userID int64) error
+}
+
+// MFAConfig holds the second factor settings of the service.
+type MFAConfig struct {
+	// CodeTTL is how long the emailed code is valid.
+	CodeTTL time.Duration
+	// MaxAttempts is how many tries a code allows.
+	MaxAttempts int
+	// RememberFor is how long a trusted device skips the second factor. Zero
+	// disables remembering devices.
+	RememberFor time.Duration
+}
+
+// mfaChallenge is a sign in waiting for the second factor. Methods are how
+// the user authenticated before the code.
+type mfaChallenge struct {
+	UserID    int64     `json:"user_id"`
+	OrgID     int64     `json:"org_id"`
+	Local     bool      `json:"local"`
+	Methods   []string  `json:"methods"`
+	CodeHash  []byte    `json:"code_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// startMFA sends a code to the user who authenticated with the methods, by
+// text when the user chose so and has a verified phone, by email otherwise.
+// It returns the token to complete the sign in with.
+func (s *UserService) startMFA(user models.User, orgID int64, local bool, methods ...string) (models.SignIn, error) {
+	const op = "startMFA"
+
+	code, err := verification.GenerateOTP()
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: verification.GenerateOTP: %w", op, err))
+	}
+
+	token, err := models.GenerateMFAToken()
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: models.GenerateMFAToken: %w", op, err))
+	}
+
+	challenge := mfaChallenge{
+		UserID:    user.UserID,
+		OrgID:     orgID,
+		Local:     local,
+		Methods:   methods,
+		CodeHash:  verification.Hash(code),
+		ExpiresAt: time.Now().Add(s.mfaConfig.CodeTTL),
+	}
+
+	err = s.saveMFAChallenge(mfaChallengeKey(token), challenge)
+	if err != nil {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+	}
+
+	s.asyncRunner.RunAsync(func() {
+		data := map[string]interface{}{
+			"code":      code,
+			"userID":    user.UserID,
+			"expiresIn": int(s.mfaConfig.CodeTTL.Minutes()),
+		}
+
+		var err error
+		if user.MFAMethod == models.ChannelSMS && user.PhoneVerified {
+			err = s.smsAdapter.SendSMS(user.Phone, "mfa_code.tmpl", data)
+		} else {
+			err = s.userAdapter.SendMail(user.Email, "mfa_code.tmpl", data)
+		}
+		if err != nil {
+			log.Printf("Failed to send sign in code: %v\n", err)
+		}
+	})
+
+	return models.SignIn{MFAToken: token}, nil
+}
+
+// CompleteMFA finishes the sign in started by UserSignIn with the code. With
+// remember set, the device of the client is trusted and the returned cookie
+// lets it skip the code for MFAConfig.RememberFor.
+func (s *UserService) CompleteMFA(mfaToken string, code string, remember bool, client models.ClientInfo) (models.SignIn, models.RememberedDevice, error) {
+	const op = "CompleteMFA"
+
+	key := mfaChallengeKey(mfaToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, key)
+	if err != nil {
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrOTPNotFound, err)
+	}
+
+	var challenge mfaChallenge
+	err = json.Unmarshal([]byte(val), &challenge)
+	if err != nil {
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: json.Unmarshal: %w", op, err))
+	}
+
+	// every try is counted before the comparison, atomically, so that
+	// parallel guesses don't get past the limit
+	attempts, err := s.redisClient.Incr(ctx, mfaAttemptsKey(mfaToken), time.Until(challenge.ExpiresAt)+time.Second)
+	if err != nil {
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Incr: %w", op, err))
+	}
+
+	if attempts > int64(s.mfaConfig.MaxAttempts) {
+		err = s.redisClient.Del(ctx, key)
+		if err != nil {
+			log.Printf("%s: s.redisClient.Del: %v\n", op, err)
+		}
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("too many attempts, sign in again"))
+	}
+
+	if subtle.ConstantTimeCompare(verification.Hash(code), challenge.CodeHash) != 1 {
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrOTPInvalid, errors.New("invalid sign in code"))
+	}
+
+	// a code signs in once: of concurrent requests only one gets it
+	_, err = s.redisClient.GetDel(ctx, key)
+	if err != nil {
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrOTPNotFound, fmt.Errorf("%s: s.redisClient.GetDel: %w", op, err))
+	}
+
+	user, err := s.userRepository.GetUserByID(challenge.UserID)
+	if err != nil {
+		return models.SignIn{}, models.RememberedDevice{}, userError(err)
+	}
+
+	if !user.Active {
+		return models.SignIn{}, models.RememberedDevice{}, app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	var organization models.Organization
+	if challenge.OrgID != 0 {
+		organization, err = s.orgRepository.GetOrganizationByID(challenge.OrgID)
+		if err != nil {
+			return models.SignIn{}, models.RememberedDevice{}, orgError(err)
+		}
+	}
+
+	result, err := s.signIn(user, organization, challenge.Local, append(challenge.Methods, authentication.AMROTP)...)
+	if err != nil || result.AccessToken == "" {
+		return result, models.RememberedDevice{}, err
+	}
+
+	s.alertNewDevice(user, client)
+
+	if !remember || s.mfaConfig.RememberFor <= 0 {
+		return result, models.RememberedDevice{}, nil
+	}
+
+	device, err := s.rememberDevice(user, client)
+	if err != nil {
+		// the user is signed in all the same and gets the code next time
+		log.Printf("%s: failed to remember the device: %v\n", op, err)
+	}
+
+	return result, device, nil
+}
+
+// isTrustedDevice reports whether the remember-me token was issued to the
+// user for this browser and is still trusted.
+func (s *UserService) isTrustedDevice(user models.User, client models.ClientInfo, deviceToken string) bool {
+	if deviceToken == "" || s.mfaConfig.RememberFor <= 0 {
+		return false
+	}
+
+	payload, err := s.tokenMaker.VerifyToken(deviceToken)
+	if err != nil || payload.Scope != authentication.ScopeTrustedDevice || payload.UserID != user.UserID {
+		return false
+	}
+
+	device, err := s.deviceRepository.UseTrustedDevice(verification.Hash(deviceToken))
+	if err != nil {
+		if !errors.Is(err, models.ErrTrustedDeviceNotFound) {
+			log.Printf("isTrustedDevice: s.deviceRepository.UseTrustedDevice: %v\n", err)
+		}
+		return false
+	}
+
+	// a cookie copied to another browser doesn't carry the trust along
+	return device.UserID == user.UserID && device.UserAgent == client.UserAgent
+}
+
+func (s *UserService) rememberDevice(user models.User, client models.ClientInfo) (models.RememberedDevice, error) {
+	token, err := s.tokenMaker.CreateToken(
+		user.Email, s.mfaConfig.RememberFor,
+		authentication.WithScope(authentication.ScopeTrustedDevice), authentication.WithUserID(user.UserID),
+	)
+	if err != nil {
+		return models.RememberedDevice{}, err
+	}
+
+	device := models.TrustedDevice{
+		UserID:    user.UserID,
+		TokenHash: verification.Hash(token),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		ExpiresAt: time.Now().Add(s.mfaConfig.RememberFor),
+	}
+
+	err = s.deviceRepository.CreateTrustedDevice(&device)
+	if err != nil {
+		return models.RememberedDevice{}, err
+	}
+
+	return models.RememberedDevice{Token: token, ExpiresAt: device.ExpiresAt}, nil
+}
+
+// SetMFA turns the second factor of the user on or off and picks the channel
+// of the codes, the email by default. Texted codes need a verified phone.
+// Turning the second factor off forgets the trusted devices.
+func (s *UserService) SetMFA(userID int64, enabled bool, method string) (models.User, error) {
+	if method == "" {
+		method = models.ChannelEmail
+	}
+
+	v := validator.New()
+
+	if models.ValidateChannel(v, method); !v.Valid() {
+		return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	if method == models.ChannelSMS {
+		user, err := s.userRepository.GetUserByID(userID)
+		if err != nil {
+			return models.User{}, userError(err)
+		}
+
+		if v.Check(user.PhoneVerified, "method", "needs a verified phone"); !v.Valid() {
+			return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+		}
+	}
+
+	user, err := s.userRepository.SetMFA(userID, enabled, method)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	if !enabled {
+		err = s.deviceRepository.DeleteUserTrustedDevices(userID)
+		if err != nil {
+			return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+		}
+	}
+
+	return user, nil
+}
+
+func (s *UserService) ListTrustedDevices(userID int64) ([]models.TrustedDevice, error) {
+	devices, err := s.deviceRepository.ListTrustedDevices(userID)
+	if err != nil {
+		return nil, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return devices, nil
+}
+
+func (s *UserService) RevokeTrustedDevice(userID int64, deviceID int64) error {
+	err := s.deviceRepository.DeleteTrustedDevice(userID, deviceID)
+	if err != nil {
+		if errors.Is(err, models.ErrTrustedDeviceNotFound) {
+			return app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return nil
+}
+
+func (s *UserService) RevokeTrustedDevices(userID int64) error {
+	err := s.deviceRepository.DeleteUserTrustedDevices(userID)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return nil
+}
+
+// saveMFAChallenge stores the challenge until it expires.
+func (s *UserService) saveMFAChallenge(key string, challenge mfaChallenge) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ttl := time.Until(challenge.ExpiresAt)
+	if ttl <= 0 {
+		return s.redisClient.Del(ctx, key)
+	}
+
+	return s.redisClient.Set(ctx, key, string(data), ttl)
+}
+
+func mfaChallengeKey(token string) string {
+	return "mfa:challenge:" + hex.EncodeToString(verification.Hash(token))
+}
+
+func mfaAttemptsKey(token string) string {
+	return "mfa:attempts:" + hex.EncodeToString(verification.Hash(token))
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"log"
+	"time"
+)
+
+const (
+	passwordResetTTL       = 15 * time.Minute
+	passwordChangeTokenTTL = 15 * time.Minute
+	// passwordResetAttempts is how many tries an emailed code allows
+	passwordResetAttempts = 5
+)
+
+func (s *UserService) ChangePassword(userID int64, currentPassword string, newPassword string) error {
+	const op = "ChangePassword"
+
+	v := validator.New()
+
+	if models.ValidatePasswordPlaintext(v, currentPassword); !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	match, err := user.Password.Matches(currentPassword)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if !match {
+		return app_errors.NewAppError(errcode.ErrInvalidPassword, errors.New("invalid password"))
+	}
+
+	err = s.setPassword(&user, newPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *UserService) RequestPasswordReset(org string, email string) error {
+	v := validator.New()
+
+	if models.ValidateEmail(v, email); !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	tenantID, err := s.tenantID(org)
+	if err != nil {
+		return err
+	}
+
+	return s.sendPasswordReset(tenantID, email)
+}
+
+func (s *UserService) sendPasswordReset(tenantID int64, email string) error {
+	userID, err := s.userRepository.GetUserIDByEmail(tenantID, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	otp, err := verification.GenerateOTP()
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, passwordResetKey(tenantID, email), otp, passwordResetTTL)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	// the new code gets its own attempts
+	err = s.redisClient.Del(ctx, passwordResetAttemptsKey(tenantID, email))
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	s.asyncRunner.RunAsync(func() {
+		data := map[string]interface{}{
+			"resetToken": otp,
+			"userID":     userID,
+		}
+		err := s.userAdapter.SendMail(email, "password_reset.tmpl", data)
+		if err != nil {
+			log.Printf("Failed to send password reset email: %v\n", err)
+		}
+	})
+
+	return nil
+}
+
+func (s *UserService) ResetPassword(org string, email string, otp string, newPassword string) error {
+	const op = "ResetPassword"
+
+	v := validator.New()
+
+	if models.ValidateEmail(v, email); !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	tenantID, err := s.tenantID(org)
+	if err != nil {
+		return err
+	}
+
+	key := passwordResetKey(tenantID, email)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, key)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrOTPNotFound, err)
+	}
+
+	// every try is counted before the comparison, atomically, so that
+	// parallel guesses don't get past the limit
+	attempts, err := s.redisClient.Incr(ctx, passwordResetAttemptsKey(tenantID, email), passwordResetTTL)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Incr: %w", op, err))
+	}
+
+	if attempts > passwordResetAttempts {
+		err = s.redisClient.Del(ctx, key)
+		if err != nil {
+			return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Del: %w", op, err))
+		}
+		return app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("too many attempts, request a new code"))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(val), []byte(otp)) != 1 {
+		return app_errors.NewAppError(errcode.ErrOTPInvalid, errors.New("Invalid otp provided"))
+	}
+
+	user, err := s.userRepository.GetUserByEmail(tenantID, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	// a password the policy refuses leaves the code usable for another try
+	err = s.validateNewPassword(&user, newPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the code resets once: of concurrent requests only one gets it
+	val, err = s.redisClient.GetDel(ctx, key)
+	if err != nil || subtle.ConstantTimeCompare([]byte(val), []byte(otp)) != 1 {
+		return app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("code was already used"))
+	}
+
+	err = s.setPassword(&user, newPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !user.EmailVerified {
+		err = s.claimAccount(user)
+		if err != nil {
+			return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: %w", op, err))
+		}
+	}
+
+	return nil
+}
+
+// claimAccount verifies the email of the user who reset the password with the
+// emailed code. The account may have been registered and activated by text
+// by someone else, so the phone they gave is dropped.
+func (s *UserService) claimAccount(user models.User) error {
+	_, err := s.userRepository.VerifyEmail(user.UserID)
+	if err != nil {
+		return fmt.Errorf("s.userRepository.VerifyEmail: %w", err)
+	}
+
+	if user.Phone != "" {
+		_, err = s.userRepository.SetPhone(user.UserID, "")
+		if err != nil {
+			return fmt.Errorf("s.userRepository.SetPhone: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// setPassword validates the new password against the policy and the user's
+// password history, and stores it.
+func (s *UserService) setPassword(user *models.User, newPassword string) error {
+	err := s.validateNewPassword(user, newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(newPassword)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	err = s.userRepository.UpdatePassword(user, max(s.passwordConfig.HistoryDepth-1, 0))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return app_errors.NewAppError(errcode.ErrNotFound, err)
+		}
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	// whoever knew the old password may have trusted their own browser
+	err = s.deviceRepository.DeleteUserTrustedDevices(user.UserID)
+	if err != nil {
+		log.Printf("Failed to forget the trusted devices of user %d: %v\n", user.UserID, err)
+	}
+
+	return nil
+}
+
+// validateNewPassword checks the new password against the policy and the
+// user's password history.
+func (s *UserService) validateNewPassword(user *models.User, newPassword string) error {
+	v := validator.New()
+
+	models.ValidatePasswordPlaintext(v, newPassword)
+
+	err := s.passwordPolicy.Validate(v, newPassword, user.FirstName, user.LastName, user.Email)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	reused, err := s.isRecentPassword(user, newPassword)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	if reused {
+		return app_errors.NewAppError(errcode.ErrPasswordReused, errors.New("password was used recently"))
+	}
+
+	return nil
+}
+
+// isRecentPassword reports whether the plaintext matches the current password
+// or one of the previous ones kept in the history. Every stored hash is checked
+// through models.Password, so the check works for any supported hash format.
+func (s *UserService) isRecentPassword(user *models.User, plaintext string) (bool, error) {
+	depth := s.passwordConfig.HistoryDepth
+	if depth <= 0 {
+		return false, nil
+	}
+
+	recent := []models.Password{user.Password}
+
+	if depth > 1 {
+		history, err := s.userRepository.GetPasswordHistory(user.UserID, depth-1)
+		if err != nil {
+			return false, err
+		}
+		recent = append(recent, history...)
+	}
+
+	for _, password := range recent {
+		match, err := password.Matches(plaintext)
+		// a hash under a retired pepper can't be checked any more
+		if errors.Is(err, models.ErrUnknownPepperVersion) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// NotifyExpiringPasswords emails every user whose password expires within the
+// configured warning window. Each user is warned once per window.
+func (s *UserService) NotifyExpiringPasswords(ctx context.Context) error {
+	const op = "NotifyExpiringPasswords"
+
+	if s.passwordConfig.MaxAge <= 0 || s.passwordConfig.ExpiryWarning <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	from := now.Add(-s.passwordConfig.MaxAge)
+	to := from.Add(s.passwordConfig.ExpiryWarning)
+
+	users, err := s.userRepository.GetUsersWithPasswordChangedBetween(from, to)
+	if err != nil {
+		return fmt.Errorf("%s: s.userRepository.GetUsersWithPasswordChangedBetween: %w", op, err)
+	}
+
+	for _, user := range users {
+		expiresAt, _ := user.PasswordExpiresAt(s.passwordConfig.MaxAge)
+		key := fmt.Sprintf("password_expiry_warning:%d:%d", user.UserID, user.PasswordChangedAt.Unix())
+
+		_, err = s.redisClient.Get(ctx, key)
+		if err == nil {
+			continue
+		}
+
+		err = s.redisClient.Set(ctx, key, "1", s.passwordConfig.ExpiryWarning)
+		if err != nil {
+			return fmt.Errorf("%s: s.redisClient.Set: %w", op, err)
+		}
+
+		s.asyncRunner.RunAsync(func() {
+			data := map[string]interface{}{
+				"firstName": user.FirstName,
+				"expiresAt": expiresAt.Format("02.01.2006 15:04 MST"),
+				"daysLeft":  int(time.Until(expiresAt).Hours()/24) + 1,
+			}
+			err := s.userAdapter.SendMail(user.Email, "password_expiry_warning.tmpl", data)
+			if err != nil {
+				log.Printf("Failed to send password expiry warning email: %v\n", err)
+			}
+		})
+	}
+
+	return nil
+}
+
+// repepperPassword rehashes the password with the current pepper version.
+// It is called after a successful login, the only time the plaintext is known.
+func (s *UserService) repepperPassword(user models.User, plaintext string) {
+	oldHash := user.Password.Hash
+
+	err := user.Password.Set(plaintext)
+	if err != nil {
+		log.Printf("Failed to repepper password of user %d: %v\n", user.UserID, err)
+		return
+	}
+
+	err = s.userRepository.RehashPassword(&user, oldHash)
+	// the password was changed in the meantime
+	if errors.Is(err, models.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store repeppered password of user %d: %v\n", user.UserID, err)
+	}
+}
+
+func passwordResetKey(tenantID int64, email string) string {
+	return "password_reset:" + accountKey(tenantID, email)
+}
+
+func passwordResetAttemptsKey(tenantID int64, email string) string {
+	return "password_reset_attempts:" + accountKey(tenantID, email)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/tokens/verification"
+	"fullstack-simple-app/pkg/validator"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	phoneVerificationTTL = 15 * time.Minute
+	// phoneVerificationAttempts is how many tries a texted code allows
+	phoneVerificationAttempts = 5
+)
+
+// SetPhone sets a new phone of the user and texts it a code to verify it
+// with. Until then no other codes are texted there.
+func (s *UserService) SetPhone(userID int64, phone string) (models.User, error) {
+	v := validator.New()
+
+	if models.ValidatePhone(v, phone); !v.Valid() {
+		return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	user, err := s.userRepository.SetPhone(userID, phone)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	otp, err := verification.GenerateOTP()
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = s.redisClient.Set(ctx, phoneVerificationKey(userID), hex.EncodeToString(verification.Hash(otp)), phoneVerificationTTL)
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	// a new code gets the full number of tries
+	err = s.redisClient.Del(ctx, phoneVerificationAttemptsKey(userID))
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	s.asyncRunner.RunAsync(func() {
+		data := map[string]interface{}{
+			"code":   otp,
+			"userID": user.UserID,
+		}
+		err := s.smsAdapter.SendSMS(phone, "phone_verification.tmpl", data)
+		if err != nil {
+			log.Printf("Failed to send phone verification code: %v\n", err)
+		}
+	})
+
+	return user, nil
+}
+
+// VerifyPhone marks the phone of the user verified with the code texted to
+// it by SetPhone.
+func (s *UserService) VerifyPhone(userID int64, otp string) (models.User, error) {
+	const op = "VerifyPhone"
+
+	key := phoneVerificationKey(userID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	val, err := s.redisClient.Get(ctx, key)
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrOTPNotFound, err)
+	}
+
+	// every try is counted before the comparison, atomically, so that
+	// parallel guesses don't get past the limit
+	attempts, err := s.redisClient.Incr(ctx, phoneVerificationAttemptsKey(userID), phoneVerificationTTL)
+	if err != nil {
+		return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Incr: %w", op, err))
+	}
+
+	if attempts > phoneVerificationAttempts {
+		err = s.redisClient.Del(ctx, key)
+		if err != nil {
+			return models.User{}, app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Del: %w", op, err))
+		}
+		return models.User{}, app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("too many attempts, request a new code"))
+	}
+
+	hash := hex.EncodeToString(verification.Hash(otp))
+
+	if subtle.ConstantTimeCompare([]byte(val), []byte(hash)) != 1 {
+		return models.User{}, app_errors.NewAppError(errcode.ErrOTPInvalid, errors.New("Invalid otp provided"))
+	}
+
+	// the code verifies once: of concurrent requests only one gets it
+	val, err = s.redisClient.GetDel(ctx, key)
+	if err != nil || subtle.ConstantTimeCompare([]byte(val), []byte(hash)) != 1 {
+		return models.User{}, app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("code was already used"))
+	}
+
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	user, err = s.userRepository.VerifyPhone(userID, user.Phone)
+	if err != nil {
+		return models.User{}, userError(err)
+	}
+
+	return user, nil
+}
+
+func phoneVerificationKey(userID int64) string {
+	return "phone_verification:" + strconv.FormatInt(userID, 10)
+}
+
+func phoneVerificationAttemptsKey(userID int64) string {
+	return "phone_verification:attempts:" + strconv.FormatInt(userID, 10)
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"fullstack-simple-app/internal/errcode"
@@ -11,15 +13,44 @@ import (
 	"fullstack-simple-app/pkg/tokens/verification"
 	"fullstack-simple-app/pkg/validator"
 	"log"
+	"slices"
 	"time"
 )
 
+const (
+	activationCodeTTL = 15 * time.Minute
+	// activationAttempts is how many tries the activation codes allow
+	activationAttempts = 5
+)
+
 type UserService struct {
 	userRepository UserRepo
+	orgRepository  OrgRepo
 	userAdapter    EmailSender
+	smsAdapter     SMSSender
 	asyncRunner    AsyncRunner
 	redisClient    RedisClient
 	tokenMaker     TokenMaker
+	passwordPolicy PasswordPolicy
+	passwordConfig PasswordConfig
+	roleConfig     RoleConfig
+	mfaConfig      MFAConfig
+	// passwordVerifiers are asked in turn to check passwords at sign in
+	passwordVerifiers []PasswordVerifier
+	// deviceRepository keeps the devices that skip the second factor
+	deviceRepository TrustedDeviceRepo
+	// loginDeviceRepository keeps the devices users signed in from, to
+	// alert them about new ones
+	loginDeviceRepository LoginDeviceRepo
+	sessions              SessionRevoker
+	loginAlertConfig      LoginAlertConfig
+	// loginAbuse refuses the sources of credential stuffing
+	loginAbuse LoginAbuseGuard
+}
+
+type LoginAbuseGuard interface {
+	CheckLogin(ip string) error
+	RecordFailure(ip string, email string)
 }
 
 type AsyncRunner interface {
@@ -28,48 +59,124 @@ type AsyncRunner interface {
 
 type UserRepo interface {
 	CreateUser(user *models.User) error
-	ActivateUser(email string) (models.User, error)
-	GetUserIDByEmail(email string) (int64, error)
-	GetUserByEmail(email string) (models.User, error)
+	ActivateUser(tenantID int64, email string) (models.User, error)
+	GetUserIDByEmail(tenantID int64, email string) (int64, error)
+	GetUserByEmail(tenantID int64, email string) (models.User, error)
+	GetPasswordHistory(userID int64, limit int) ([]models.Password, error)
+	UpdatePassword(user *models.User, historyDepth int) error
+	RehashPassword(user *models.User, oldHash []byte) error
+	GetUsersWithPasswordChangedBetween(from, to time.Time) ([]models.User, error)
+	ListUsers(filter models.UserFilter) ([]models.User, string, error)
+	GetUserByID(userID int64) (models.User, error)
+	SetUserActive(userID int64, active bool) (models.User, error)
+	SetMFA(userID int64, enabled bool, method string) (models.User, error)
+	SetPhone(userID int64, phone string) (models.User, error)
+	VerifyPhone(userID int64, phone string) (models.User, error)
+	VerifyEmail(userID int64) (models.User, error)
 }
 
 type EmailSender interface {
 	SendMail(recipient string, templateFile string, data interface{}) error
 }
 
+type SMSSender interface {
+	SendSMS(recipient string, templateFile string, data interface{}) error
+}
+
 type RedisClient interface {
 	Set(ctx context.Context, key, value string, ttl time.Duration) error
 	Get(ctx context.Context, key string) (string, error)
+	GetDel(ctx context.Context, key string) (string, error)
 	Del(ctx context.Context, key string) error
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
 }
 
 type TokenMaker interface {
-	CreateToken(username string, duration time.Duration) (string, error)
+	CreateToken(username string, duration time.Duration, opts ...authentication.PayloadOption) (string, error)
 	VerifyToken(token string) (*authentication.Payload, error)
 }
 
-func NewUserService(userRepo UserRepo, EmailSender EmailSender, async AsyncRunner, redis RedisClient, maker TokenMaker) *UserService {
+type PasswordPolicy interface {
+	Validate(v *validator.Validator, password string, userInputs ...string) error
+}
+
+// PasswordConfig holds the password lifecycle settings of the service.
+type PasswordConfig struct {
+	// HistoryDepth is how many of the latest passwords, the current one
+	// included, a user is not allowed to reuse. Zero disables the check.
+	HistoryDepth int
+	// MaxAge is how long a password stays valid. Zero means it never expires.
+	MaxAge time.Duration
+	// ExpiryWarning is how long before the expiry the user gets a warning email.
+	ExpiryWarning time.Duration
+}
+
+// RoleConfig holds the authorization settings of the service.
+type RoleConfig struct {
+	// DefaultRole is assigned to every newly registered user.
+	DefaultRole string
+}
+
+func NewUserService(userRepo UserRepo, orgRepo OrgRepo, deviceRepo TrustedDeviceRepo, loginDeviceRepo LoginDeviceRepo, sessions SessionRevoker, loginAbuse LoginAbuseGuard, EmailSender EmailSender, smsSender SMSSender, async AsyncRunner, redis RedisClient, maker TokenMaker, policy PasswordPolicy, passwordCfg PasswordConfig, roleCfg RoleConfig, mfaCfg MFAConfig, alertCfg LoginAlertConfig, verifiers ...PasswordVerifier) *UserService {
+	if len(verifiers) == 0 {
+		verifiers = []PasswordVerifier{NewLocalPasswordVerifier(userRepo)}
+	}
+
 	return &UserService{
 		userRepository: userRepo,
+		orgRepository:  orgRepo,
 		userAdapter:    EmailSender,
+		smsAdapter:     smsSender,
 		asyncRunner:    async,
 		redisClient:    redis,
 		tokenMaker:     maker,
+		passwordPolicy: policy,
+		passwordConfig: passwordCfg,
+		roleConfig:     roleCfg,
+		mfaConfig:      mfaCfg,
+
+		deviceRepository:  deviceRepo,
+		passwordVerifiers: verifiers,
+
+		loginDeviceRepository: loginDeviceRepo,
+		sessions:              sessions,
+		loginAlertConfig:      alertCfg,
+		loginAbuse:            loginAbuse,
 	}
 }
 
-func (s *UserService) RegisterUser(user *models.User, password string) error {
+// RegisterUser creates the account. When org is the slug of an isolated
+// tenant, the account belongs to that tenant; otherwise it is global. The
+// activation code is texted when the user gave a phone, emailed otherwise.
+func (s *UserService) RegisterUser(org string, user *models.User, password string) error {
 	const op = "RegisterUser"
 
-	err := user.Password.Set(password)
+	tenantID, err := s.tenantID(org)
+	if err != nil {
+		return err
+	}
+	user.TenantOrgID = tenantID
+
+	err = user.Password.Set(password)
 	if err != nil {
 		return fmt.Errorf("%s: user.Password.Set: %w", op, err)
 	}
 
 	v := validator.New()
 
-	if models.ValidateUser(v, user); !v.Valid() {
-		return app_errors.NewAppError(errcode.ErrInvalidRequest, fmt.Errorf("%v", v.Errors))
+	models.ValidateUser(v, user)
+
+	err = s.passwordPolicy.Validate(v, password, user.FirstName, user.LastName, user.Email)
+	if err != nil {
+		return app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.passwordPolicy.Validate: %w", op, err))
+	}
+
+	if !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	if s.roleConfig.DefaultRole != "" {
+		user.Roles = []string{s.roleConfig.DefaultRole}
 	}
 
 	err = s.userRepository.CreateUser(user)
@@ -82,62 +189,116 @@ func (s *UserService) RegisterUser(user *models.User, password string) error {
 		}
 	}
 
-	otp, err := verification.GenerateOTP()
-	if err != nil {
-		return app_errors.NewAppError(errcode.ErrAccountCreated, err)
+	channel := models.ChannelEmail
+	if user.Phone != "" {
+		channel = models.ChannelSMS
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	err = s.redisClient.Set(ctx, "activation:"+user.Email, otp, 15*time.Minute)
+	err = s.sendActivationCode(*user, channel)
 	if err != nil {
 		return app_errors.NewAppError(errcode.ErrAccountCreated, err)
 	}
 
-	s.asyncRunner.RunAsync(func() {
-		data := map[string]interface{}{
-			"activationToken": otp,
-			"userID":          user.UserID,
-		}
-		err := s.userAdapter.SendMail(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			log.Printf("Failed to send confirmation email: %v\n", err)
-		}
-	})
-
 	return nil
 }
 
-func (s *UserService) VerifyUser(email string, otp string) (models.User, string, error) {
+// VerifyUser activates the account with the code sent at registration or by
+// ResendCode and returns a token of the new user. The code only activates:
+// the token doesn't count as a recent authentication, and activated accounts
+// can't be verified again.
+func (s *UserService) VerifyUser(org string, email string, otp string) (models.User, string, error) {
+	const op = "VerifyUser"
+
 	v := validator.New()
 
 	if models.ValidateEmail(v, email); !v.Valid() {
-		return models.User{}, "", app_errors.NewAppError(errcode.ErrInvalidRequest, fmt.Errorf("%v", v.Errors))
+		return models.User{}, "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
 	}
 
-	val, err := s.redisClient.Get(context.Background(), "activation:"+email)
+	tenantID, err := s.tenantID(org)
 	if err != nil {
-		return models.User{}, "", app_errors.NewAppError(errcode.ErrOTPNotFound, err)
+		return models.User{}, "", err
 	}
 
-	if val != otp {
-		return models.User{}, "", app_errors.NewAppError(errcode.ErrOTPInvalid, errors.New("Invalid otp provided"))
+	current, err := s.userRepository.GetUserByEmail(tenantID, email)
+	if err != nil {
+		return models.User{}, "", userError(err)
 	}
 
-	s.asyncRunner.RunAsync(func() {
-		err := s.redisClient.Del(context.Background(), "activation:"+email)
-		if err != nil {
-			log.Printf("Failed to send confirmation email: %v\n", err)
+	err = canActivate(current)
+	if err != nil {
+		return models.User{}, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the code may have been sent through either channel
+	codes := make(map[string]string, 2)
+	for _, c := range []string{models.ChannelEmail, models.ChannelSMS} {
+		val, err := s.redisClient.Get(ctx, activationKey(c, tenantID, email))
+		if err == nil {
+			codes[c] = val
 		}
-	})
+	}
+
+	if len(codes) == 0 {
+		return models.User{}, "", app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("no activation code was sent"))
+	}
 
-	user, err := s.userRepository.ActivateUser(email)
+	// every try is counted before the comparison, atomically, so that
+	// parallel guesses don't get past the limit
+	attempts, err := s.redisClient.Incr(ctx, activationAttemptsKey(tenantID, email), activationCodeTTL)
 	if err != nil {
-		return models.User{}, "", app_errors.NewAppError(errcode.ErrInternal, err)
+		return models.User{}, "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Incr: %w", op, err))
 	}
 
-	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour)
+	if attempts > activationAttempts {
+		for c := range codes {
+			err = s.redisClient.Del(ctx, activationKey(c, tenantID, email))
+			if err != nil {
+				return models.User{}, "", app_errors.NewAppError(errcode.ErrInternal, fmt.Errorf("%s: s.redisClient.Del: %w", op, err))
+			}
+		}
+		return models.User{}, "", app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("too many attempts, request a new code"))
+	}
+
+	hash := activationCodeHash(otp)
+
+	var channel string
+	for c, val := range codes {
+		if subtle.ConstantTimeCompare([]byte(val), []byte(hash)) == 1 {
+			channel = c
+		}
+	}
+
+	if channel == "" {
+		return models.User{}, "", app_errors.NewAppError(errcode.ErrOTPInvalid, errors.New("Invalid otp provided"))
+	}
+
+	// the code activates once: of concurrent requests only one gets it
+	val, err := s.redisClient.GetDel(ctx, activationKey(channel, tenantID, email))
+	if err != nil || subtle.ConstantTimeCompare([]byte(val), []byte(hash)) != 1 {
+		return models.User{}, "", app_errors.NewAppError(errcode.ErrOTPNotFound, errors.New("code was already used"))
+	}
+
+	user, err := s.userRepository.ActivateUser(tenantID, email)
+	if err != nil {
+		return models.User{}, "", userError(err)
+	}
+
+	// a texted code proves the phone, not the email
+	switch {
+	case channel == models.ChannelEmail:
+		user, err = s.userRepository.VerifyEmail(user.UserID)
+	case user.Phone != "":
+		user, err = s.userRepository.VerifyPhone(user.UserID, user.Phone)
+	}
+	if err != nil {
+		return models.User{}, "", userError(err)
+	}
+
+	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour, accessTokenOptions(user, tenantID)...)
 	if err != nil {
 		return models.User{}, "", app_errors.NewAppError(errcode.ErrLoginRedirect, err)
 	}
@@ -145,88 +306,321 @@ func (s *UserService) VerifyUser(email string, otp string) (models.User, string,
 	return user, token, nil
 }
 
-func (s *UserService) ResendCode(email string) error {
+// ResendCode sends a new activation code through the channel, the email by
+// default. Codes are texted to the phone the user registered with.
+func (s *UserService) ResendCode(org string, email string, channel string) error {
+	if channel == "" {
+		channel = models.ChannelEmail
+	}
+
 	v := validator.New()
 
-	if models.ValidateEmail(v, email); !v.Valid() {
-		return app_errors.NewAppError(errcode.ErrInvalidRequest, fmt.Errorf("%v", v.Errors))
+	models.ValidateEmail(v, email)
+	models.ValidateChannel(v, channel)
+
+	if !v.Valid() {
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
 	}
 
-	otp, err := verification.GenerateOTP()
+	tenantID, err := s.tenantID(org)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepository.GetUserByEmail(tenantID, email)
+	if err != nil {
+		return userError(err)
+	}
+
+	err = canActivate(user)
+	if err != nil {
+		return err
+	}
+
+	if channel == models.ChannelSMS && user.Phone == "" {
+		v.AddError("channel", "the account has no phone")
+		return app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	err = s.sendActivationCode(user, channel)
 	if err != nil {
 		return app_errors.NewAppError(errcode.ErrAccountCreated, err)
 	}
 
+	return nil
+}
+
+// sendActivationCode stores a new activation code of the user and sends it
+// through the channel.
+func (s *UserService) sendActivationCode(user models.User, channel string) error {
+	otp, err := verification.GenerateOTP()
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	err = s.redisClient.Set(ctx, "activation:"+email, otp, 15*time.Minute)
+	err = s.redisClient.Set(ctx, activationKey(channel, user.TenantOrgID, user.Email), activationCodeHash(otp), activationCodeTTL)
 	if err != nil {
-		return app_errors.NewAppError(errcode.ErrAccountCreated, err)
+		return err
 	}
 
-	userID, err := s.userRepository.GetUserIDByEmail(email)
+	// a new code gets the full number of tries
+	err = s.redisClient.Del(ctx, activationAttemptsKey(user.TenantOrgID, user.Email))
 	if err != nil {
-		if errors.Is(err, models.ErrNotFound) {
-			return app_errors.NewAppError(errcode.ErrNotFound, err)
-		}
-		return app_errors.NewAppError(errcode.ErrInternal, err)
+		return err
 	}
 
 	s.asyncRunner.RunAsync(func() {
 		data := map[string]interface{}{
 			"activationToken": otp,
-			"userID":          userID,
+			"userID":          user.UserID,
+		}
+
+		var err error
+		if channel == models.ChannelSMS {
+			err = s.smsAdapter.SendSMS(user.Phone, "user_welcome.tmpl", data)
+		} else {
+			err = s.userAdapter.SendMail(user.Email, "user_welcome.tmpl", data)
 		}
-		err := s.userAdapter.SendMail(email, "user_welcome.tmpl", data)
 		if err != nil {
-			log.Printf("Failed to send confirmation email: %v\n", err)
+			log.Printf("Failed to send activation code by %s: %v\n", channel, err)
 		}
 	})
 
 	return nil
 }
 
-func (s *UserService) UserSignIn(email string, password string) (string, error) {
+// UserSignIn returns an access token acting in the organization with the
+// given slug, or in the user's tenant when org is empty. Users with the
+// second factor on get a code by email instead, unless deviceToken is the
+// remember-me token of the client's browser: the returned MFA token
+// completes the sign in with CompleteMFA.
+func (s *UserService) UserSignIn(org string, email string, password string, client models.ClientInfo, deviceToken string) (models.SignIn, error) {
 	v := validator.New()
 
 	models.ValidateEmail(v, email)
 	models.ValidatePasswordPlaintext(v, password)
 
 	if !v.Valid() {
-		return "", app_errors.NewAppError(errcode.ErrInvalidRequest, fmt.Errorf("%v", v.Errors))
+		return models.SignIn{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
 	}
 
-	user, err := s.userRepository.GetUserByEmail(email)
+	organization, err := s.organization(org)
 	if err != nil {
-		if errors.Is(err, models.ErrNotFound) {
-			return "", app_errors.NewAppError(errcode.ErrNotFound, err)
+		return models.SignIn{}, err
+	}
+
+	user, local, err := s.checkPassword(tenantOf(organization), email, password, client.IP)
+	if err != nil {
+		return models.SignIn{}, err
+	}
+
+	return s.passwordSignIn(user, organization, local, client, deviceToken)
+}
+
+// SignInAccount signs in the account with the password like UserSignIn, for
+// the sign ins that found the account otherwise, e.g. the confirmation of a
+// social login link. The account acts in its tenant.
+func (s *UserService) SignInAccount(userID int64, password string, client models.ClientInfo) (models.SignIn, error) {
+	v := validator.New()
+
+	if models.ValidatePasswordPlaintext(v, password); !v.Valid() {
+		return models.SignIn{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
+	}
+
+	current, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		return models.SignIn{}, userError(err)
+	}
+
+	user, local, err := s.checkPassword(current.TenantOrgID, current.Email, password, client.IP)
+	if err != nil {
+		return models.SignIn{}, err
+	}
+
+	if user.UserID != current.UserID {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInvalidPassword, errors.New("invalid password"))
+	}
+
+	return s.passwordSignIn(user, models.Organization{}, local, client, "")
+}
+
+// SignInExternal signs in the user who authenticated at an identity
+// provider. The provider doesn't vouch for the second factor: users who
+// turned it on get a code first.
+func (s *UserService) SignInExternal(userID int64) (models.SignIn, error) {
+	user, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		return models.SignIn{}, userError(err)
+	}
+
+	if !user.Active {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	if !user.Activated {
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrForbidden, errors.New("account is not activated"))
+	}
+
+	if user.MFAEnabled {
+		return s.startMFA(user, 0, false)
+	}
+
+	return s.signIn(user, models.Organization{}, false)
+}
+
+// checkPassword verifies the password of a sign in from ip. Blocked sources
+// are refused and the failures are counted against the source.
+func (s *UserService) checkPassword(tenantID int64, email string, password string, ip string) (models.User, bool, error) {
+	err := s.loginAbuse.CheckLogin(ip)
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	user, local, err := s.verifyPassword(tenantID, email, password)
+	if err != nil {
+		if isLoginFailure(err) {
+			s.loginAbuse.RecordFailure(ip, email)
 		}
-		return "", app_errors.NewAppError(errcode.ErrInternal, err)
+		return models.User{}, false, err
+	}
+
+	if !user.Active {
+		return models.User{}, false, app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
 	}
 
-	match, err := user.Password.Matches(password)
+	if local && user.Password.NeedsRehash() {
+		s.asyncRunner.RunAsync(func() {
+			s.repepperPassword(user, password)
+		})
+	}
+
+	return user, local, nil
+}
+
+// passwordSignIn finishes the sign in of the user whose password was right:
+// it asks for the second factor unless deviceToken trusts the browser.
+func (s *UserService) passwordSignIn(user models.User, organization models.Organization, local bool, client models.ClientInfo, deviceToken string) (models.SignIn, error) {
+	if user.MFAEnabled && !s.isTrustedDevice(user, client, deviceToken) {
+		return s.startMFA(user, organization.OrgID, local, authentication.AMRPassword)
+	}
+
+	result, err := s.signIn(user, organization, local, authentication.AMRPassword)
+	if err == nil && result.AccessToken != "" {
+		s.alertNewDevice(user, client)
+	}
+
+	return result, err
+}
+
+// signIn returns the access token of the user who authenticated with the
+// methods, acting in the organization.
+func (s *UserService) signIn(user models.User, organization models.Organization, local bool, methods ...string) (models.SignIn, error) {
+	// An expired password still proves the identity, but the only thing the
+	// user may do with it is to set a new one: a limited token is returned
+	// instead of the access token. Passwords kept elsewhere expire there.
+	if expiresAt, ok := user.PasswordExpiresAt(s.passwordConfig.MaxAge); local && ok && time.Now().After(expiresAt) {
+		token, err := s.tokenMaker.CreateToken(
+			user.Email, passwordChangeTokenTTL,
+			authentication.WithScope(authentication.ScopePasswordChange), authentication.WithUserID(user.UserID),
+			authentication.WithAuthentication(time.Now(), methods...),
+		)
+		if err != nil {
+			return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, err)
+		}
+		return models.SignIn{PasswordChangeToken: token}, nil
+	}
+
+	orgID := user.TenantOrgID
+	if organization.OrgID != 0 && !organization.Isolated {
+		_, err := s.orgRepository.GetMembership(organization.OrgID, user.UserID)
+		if err != nil {
+			return models.SignIn{}, orgError(err)
+		}
+		orgID = organization.OrgID
+	}
+
+	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour, signInOptions(user, orgID, methods...)...)
 	if err != nil {
-		return "", app_errors.NewAppError(errcode.ErrInternal, err)
+		return models.SignIn{}, app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return models.SignIn{AccessToken: token}, nil
+}
+
+// Reauthenticate checks the password of the signed in user again and returns
+// a token for the same session with a fresh authentication time, as needed
+// by sensitive operations. amr lists the methods the session already used.
+// Wrong passwords count against the address ip like those of UserSignIn.
+func (s *UserService) Reauthenticate(userID int64, orgID int64, password string, amr []string, ip string) (string, error) {
+	v := validator.New()
+
+	if models.ValidatePasswordPlaintext(v, password); !v.Valid() {
+		return "", app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
 	}
 
-	if !match {
-		return "", app_errors.NewAppError(errcode.ErrInvalidPassword, errors.New("invalid password"))
+	current, err := s.userRepository.GetUserByID(userID)
+	if err != nil {
+		return "", userError(err)
+	}
+
+	err = s.loginAbuse.CheckLogin(ip)
+	if err != nil {
+		return "", err
 	}
 
-	return s.tokenMaker.CreateToken(user.Email, 24*time.Hour)
+	user, _, err := s.verifyPassword(current.TenantOrgID, current.Email, password)
+	if err == nil && user.UserID != current.UserID {
+		err = app_errors.NewAppError(errcode.ErrInvalidPassword, errors.New("invalid password"))
+	}
+	if err != nil {
+		if isLoginFailure(err) {
+			s.loginAbuse.RecordFailure(ip, current.Email)
+		}
+		return "", err
+	}
+
+	if !user.Active {
+		return "", app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	if orgID != 0 && orgID != user.TenantOrgID {
+		_, err = s.orgRepository.GetMembership(orgID, user.UserID)
+		if err != nil {
+			return "", orgError(err)
+		}
+	}
+
+	methods := amr
+	if !slices.Contains(methods, authentication.AMRPassword) {
+		methods = append(slices.Clone(amr), authentication.AMRPassword)
+	}
+
+	token, err := s.tokenMaker.CreateToken(user.Email, 24*time.Hour, signInOptions(user, orgID, methods...)...)
+	if err != nil {
+		return "", app_errors.NewAppError(errcode.ErrInternal, err)
+	}
+
+	return token, nil
 }
 
-func (s *UserService) GetUser(email string) (models.User, error) {
+func (s *UserService) GetUser(org string, email string) (models.User, error) {
 	v := validator.New()
 
 	models.ValidateEmail(v, email)
 
 	if !v.Valid() {
-		return models.User{}, app_errors.NewAppError(errcode.ErrInvalidRequest, fmt.Errorf("%v", v.Errors))
+		return models.User{}, app_errors.NewValidationError(errcode.ErrInvalidRequest, v.Errors)
 	}
 
-	user, err := s.userRepository.GetUserByEmail(email)
+	tenantID, err := s.tenantID(org)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err := s.userRepository.GetUserByEmail(tenantID, email)
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
 			return models.User{}, app_errors.NewAppError(errcode.ErrNotFound, err)
@@ -236,3 +630,93 @@ func (s *UserService) GetUser(email string) (models.User, error) {
 
 	return user, nil
 }
+
+// organization returns the organization with the given slug, or the zero
+// organization when the slug is empty.
+func (s *UserService) organization(slug string) (models.Organization, error) {
+	if slug == "" {
+		return models.Organization{}, nil
+	}
+
+	org, err := s.orgRepository.GetOrganizationBySlug(slug)
+	if err != nil {
+		return models.Organization{}, orgError(err)
+	}
+
+	return org, nil
+}
+
+// tenantID returns the ID of the isolated tenant with the given slug. Shared
+// organizations and an empty slug select the global accounts (zero).
+func (s *UserService) tenantID(slug string) (int64, error) {
+	org, err := s.organization(slug)
+	if err != nil {
+		return 0, err
+	}
+
+	return tenantOf(org), nil
+}
+
+func tenantOf(org models.Organization) int64 {
+	if org.Isolated {
+		return org.OrgID
+	}
+	return 0
+}
+
+// activationKey is where the activation code sent through the channel is
+// kept. Emailed codes keep the key they always had.
+func activationKey(channel string, tenantID int64, email string) string {
+	if channel == models.ChannelSMS {
+		return "activation:sms:" + accountKey(tenantID, email)
+	}
+	return "activation:" + accountKey(tenantID, email)
+}
+
+func activationAttemptsKey(tenantID int64, email string) string {
+	return "activation:attempts:" + accountKey(tenantID, email)
+}
+
+// activationCodeHash is what is kept of an activation code.
+func activationCodeHash(otp string) string {
+	return hex.EncodeToString(verification.Hash(otp))
+}
+
+// canActivate refuses the codes of accounts that were activated already,
+// which would let a code sign in their owner, and of suspended ones.
+func canActivate(user models.User) error {
+	if user.Activated {
+		return app_errors.NewAppError(errcode.ErrConflict, errors.New("account is already activated"))
+	}
+
+	if !user.Active {
+		return app_errors.NewAppError(errcode.ErrAccountSuspended, errors.New("account is suspended"))
+	}
+
+	return nil
+}
+
+// accountKey identifies the account in cache keys: the same email may belong
+// to several tenants.
+func accountKey(tenantID int64, email string) string {
+	if tenantID == 0 {
+		return email
+	}
+	return fmt.Sprintf("%d:%s", tenantID, email)
+}
+
+// accessTokenOptions returns the claims of a full access token acting in the
+// given organization.
+func accessTokenOptions(user models.User, orgID int64) []authentication.PayloadOption {
+	return []authentication.PayloadOption{
+		authentication.WithUserID(user.UserID),
+		authentication.WithRoles(user.Roles...),
+		authentication.WithOrg(orgID),
+	}
+}
+
+// signInOptions are the options of the token issued when the user has just
+// authenticated with the methods.
+func signInOptions(user models.User, orgID int64, methods ...string) []authentication.PayloadOption {
+	return append(accessTokenOptions(user, orgID), authentication.WithAuthentication(time.Now(), methods...))
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type AdminHandler struct {
+	userService AdminUserService
+	loginAbuse  LoginAbuseService
+	logger      logger.Logger
+}
+
+type AdminUserService interface {
+	ListUsers(filter models.UserFilter) (models.UserPage, error)
+	GetUserByID(orgID int64, userID int64) (models.User, error)
+	ForceActivateUser(orgID int64, userID int64) (models.User, error)
+	SuspendUser(orgID int64, userID int64) (models.User, error)
+	UnsuspendUser(orgID int64, userID int64) (models.User, error)
+	TriggerPasswordReset(orgID int64, userID int64) error
+}
+
+func NewAdminHandler(userService AdminUserService, loginAbuse LoginAbuseService, logger logger.Logger) *AdminHandler {
+	return &AdminHandler{
+		userService: userService,
+		loginAbuse:  loginAbuse,
+		logger:      logger,
+	}
+}
+
+type listUsersRequest struct {
+	EmailPrefix string     `form:"email_prefix"`
+	Name        string     `form:"name"`
+	Activated   *bool      `form:"activated"`
+	Active      *bool      `form:"active"`
+	CreatedFrom *time.Time `form:"created_from" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedTo   *time.Time `form:"created_to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Role        string     `form:"role"`
+	Sort        string     `form:"sort"`
+	Limit       int        `form:"limit"`
+	Cursor      string     `form:"cursor"`
+}
+
+func (h *AdminHandler) ListUsersHandler(ctx *gin.Context) {
+	const op = "ListUsersHandler"
+
+	var req listUsersRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	page, err := h.userService.ListUsers(models.UserFilter{
+		EmailPrefix: req.EmailPrefix,
+		Name:        req.Name,
+		Activated:   req.Activated,
+		Active:      req.Active,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+		Role:        req.Role,
+		Sort:        req.Sort,
+		Limit:       req.Limit,
+		Cursor:      req.Cursor,
+		// an admin acting in an organization only sees its members
+		OrgID: authPayload(ctx).OrgID,
+	})
+	if err != nil {
+		h.logger.Error("%s: h.userService.ListUsers: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
+
+type adminUserRequest struct {
+	UserID int64 `uri:"user_id" binding:"required,min=1"`
+}
+
+func (h *AdminHandler) GetUserHandler(ctx *gin.Context) {
+	h.handleUserAction(ctx, "GetUserHandler", h.userService.GetUserByID)
+}
+
+func (h *AdminHandler) ActivateUserHandler(ctx *gin.Context) {
+	h.handleUserAction(ctx, "ActivateUserHandler", h.userService.ForceActivateUser)
+}
+
+func (h *AdminHandler) SuspendUserHandler(ctx *gin.Context) {
+	h.handleUserAction(ctx, "SuspendUserHandler", h.userService.SuspendUser)
+}
+
+func (h *AdminHandler) UnsuspendUserHandler(ctx *gin.Context) {
+	h.handleUserAction(ctx, "UnsuspendUserHandler", h.userService.UnsuspendUser)
+}
+
+// handleUserAction runs an action on the user from the URI, within the
+// organization of the admin's token, and responds with the resulting user.
+func (h *AdminHandler) handleUserAction(ctx *gin.Context, op string, action func(orgID int64, userID int64) (models.User, error)) {
+	var req adminUserRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	user, err := action(authPayload(ctx).OrgID, req.UserID)
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+func (h *AdminHandler) TriggerPasswordResetHandler(ctx *gin.Context) {
+	const op = "TriggerPasswordResetHandler"
+
+	var req adminUserRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.userService.TriggerPasswordReset(authPayload(ctx).OrgID, req.UserID)
+	if err != nil {
+		h.logger.Error("%s: h.userService.TriggerPasswordReset: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "password reset code was sent"})
+}
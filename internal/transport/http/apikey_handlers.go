@@ -0,0 +1,154 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type APIKeyHandler struct {
+	apiKeyService APIKeyService
+	logger        logger.Logger
+}
+
+type APIKeyService interface {
+	CreateUserAPIKey(userID int64, key *models.APIKey) (string, error)
+	CreateServiceAPIKey(creatorID int64, creatorRoles []string, key *models.APIKey) (string, error)
+	ListUserAPIKeys(userID int64) ([]models.APIKey, error)
+	ListServiceAPIKeys() ([]models.APIKey, error)
+	RevokeUserAPIKey(userID, keyID int64) error
+	RevokeServiceAPIKey(keyID int64) error
+}
+
+func NewAPIKeyHandler(apiKeyService APIKeyService, logger logger.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+		logger:        logger,
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Service   string     `json:"service"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiKeyRequest struct {
+	KeyID int64 `uri:"key_id" binding:"required,min=1"`
+}
+
+func (h *APIKeyHandler) CreateUserAPIKeyHandler(ctx *gin.Context) {
+	h.createAPIKey(ctx, "CreateUserAPIKeyHandler", func(key *models.APIKey) (string, error) {
+		return h.apiKeyService.CreateUserAPIKey(authPayload(ctx).UserID, key)
+	})
+}
+
+func (h *APIKeyHandler) CreateServiceAPIKeyHandler(ctx *gin.Context) {
+	h.createAPIKey(ctx, "CreateServiceAPIKeyHandler", func(key *models.APIKey) (string, error) {
+		payload := authPayload(ctx)
+		return h.apiKeyService.CreateServiceAPIKey(payload.UserID, payload.Roles, key)
+	})
+}
+
+// createAPIKey responds with the plaintext key, which can't be retrieved
+// later.
+func (h *APIKeyHandler) createAPIKey(ctx *gin.Context, op string, create func(key *models.APIKey) (string, error)) {
+	var req createAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	key := &models.APIKey{
+		Name:      req.Name,
+		Service:   req.Service,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	plaintext, err := create(key)
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"api_key": key, "key": plaintext})
+}
+
+func (h *APIKeyHandler) ListUserAPIKeysHandler(ctx *gin.Context) {
+	h.listAPIKeys(ctx, "ListUserAPIKeysHandler", func() ([]models.APIKey, error) {
+		return h.apiKeyService.ListUserAPIKeys(authPayload(ctx).UserID)
+	})
+}
+
+func (h *APIKeyHandler) ListServiceAPIKeysHandler(ctx *gin.Context) {
+	h.listAPIKeys(ctx, "ListServiceAPIKeysHandler", h.apiKeyService.ListServiceAPIKeys)
+}
+
+func (h *APIKeyHandler) listAPIKeys(ctx *gin.Context, op string, list func() ([]models.APIKey, error)) {
+	keys, err := list()
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (h *APIKeyHandler) RevokeUserAPIKeyHandler(ctx *gin.Context) {
+	h.revokeAPIKey(ctx, "RevokeUserAPIKeyHandler", func(keyID int64) error {
+		return h.apiKeyService.RevokeUserAPIKey(authPayload(ctx).UserID, keyID)
+	})
+}
+
+func (h *APIKeyHandler) RevokeServiceAPIKeyHandler(ctx *gin.Context) {
+	h.revokeAPIKey(ctx, "RevokeServiceAPIKeyHandler", h.apiKeyService.RevokeServiceAPIKey)
+}
+
+func (h *APIKeyHandler) revokeAPIKey(ctx *gin.Context, op string, revoke func(keyID int64) error) {
+	var req apiKeyRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := revoke(req.KeyID)
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "api key was revoked"})
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type AuthzHandler struct {
+	authzService AuthzService
+	logger       logger.Logger
+}
+
+type AuthzService interface {
+	Check(subject models.AuthzSubject, check models.AuthzCheck) (models.AuthzDecision, error)
+	CheckBatch(subject models.AuthzSubject, checks []models.AuthzCheck) ([]models.AuthzDecision, error)
+}
+
+func NewAuthzHandler(authzService AuthzService, logger logger.Logger) *AuthzHandler {
+	return &AuthzHandler{
+		authzService: authzService,
+		logger:       logger,
+	}
+}
+
+type authzCheckRequest struct {
+	models.AuthzCheck
+	// Explain adds the rule that decided to the response.
+	Explain bool `json:"explain"`
+}
+
+func (h *AuthzHandler) CheckHandler(ctx *gin.Context) {
+	const op = "CheckHandler"
+
+	var req authzCheckRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	subject := authzSubject(ctx)
+
+	decision, err := h.authzService.Check(subject, req.AuthzCheck)
+	if err != nil {
+		h.logger.Error("%s: h.authzService.Check: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	h.logDecision(subject, req.AuthzCheck, decision)
+
+	if !req.Explain {
+		decision.Rule = ""
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"decision": decision})
+}
+
+type authzBatchRequest struct {
+	Checks  []models.AuthzCheck `json:"checks" binding:"required"`
+	Explain bool                `json:"explain"`
+}
+
+func (h *AuthzHandler) CheckBatchHandler(ctx *gin.Context) {
+	const op = "CheckBatchHandler"
+
+	var req authzBatchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	subject := authzSubject(ctx)
+
+	decisions, err := h.authzService.CheckBatch(subject, req.Checks)
+	if err != nil {
+		h.logger.Error("%s: h.authzService.CheckBatch: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	for i := range decisions {
+		h.logDecision(subject, req.Checks[i], decisions[i])
+
+		if !req.Explain {
+			decisions[i].Rule = ""
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"decisions": decisions})
+}
+
+func (h *AuthzHandler) logDecision(subject models.AuthzSubject, check models.AuthzCheck, decision models.AuthzDecision) {
+	h.logger.Info("authz decision: user=%d org=%d action=%s resource=%s/%s allowed=%t rule=%s",
+		subject.UserID, subject.OrgID, check.Action, check.Resource.Type, check.Resource.ID, decision.Allowed, decision.Rule)
+}
+
+// authzSubject is the user of the verified token.
+func authzSubject(ctx *gin.Context) models.AuthzSubject {
+	payload := authPayload(ctx)
+
+	return models.AuthzSubject{
+		UserID: payload.UserID,
+		OrgID:  payload.OrgID,
+		Roles:  payload.Roles,
+		Scopes: payload.Scopes,
+		Client: payload.IsClient(),
+	}
+}
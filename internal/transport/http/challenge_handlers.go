@@ -0,0 +1,101 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/challenge"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// The answer to a challenge goes in headers, so that it is checked before
+// the handler reads the body.
+const (
+	challengeTokenHeader    = "X-Challenge-Token"
+	challengeSolutionHeader = "X-Challenge-Solution"
+	captchaResponseHeader   = "X-Captcha-Response"
+)
+
+type ChallengeHandler struct {
+	challengeService ChallengeService
+	logger           logger.Logger
+}
+
+type ChallengeService interface {
+	Issue() (challenge.Challenge, error)
+	Verify(answer models.ChallengeAnswer, ip string) error
+}
+
+func NewChallengeHandler(challengeService ChallengeService, logger logger.Logger) *ChallengeHandler {
+	return &ChallengeHandler{
+		challengeService: challengeService,
+		logger:           logger,
+	}
+}
+
+// IssueChallengeHandler returns a new proof-of-work challenge to solve
+// before registering.
+func (h *ChallengeHandler) IssueChallengeHandler(ctx *gin.Context) {
+	const op = "IssueChallengeHandler"
+
+	c, err := h.challengeService.Issue()
+	if err != nil {
+		h.logger.Error("%s: h.challengeService.Issue: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c)
+}
+
+// RequireChallenge lets the request through only with a solved challenge or
+// CAPTCHA. Otherwise the client gets a new challenge to solve and retry with.
+func (h *ChallengeHandler) RequireChallenge(ctx *gin.Context) {
+	const op = "RequireChallenge"
+
+	answer := models.ChallengeAnswer{
+		Token:    ctx.GetHeader(challengeTokenHeader),
+		Solution: ctx.GetHeader(challengeSolutionHeader),
+		Captcha:  ctx.GetHeader(captchaResponseHeader),
+	}
+
+	err := h.challengeService.Verify(answer, ctx.ClientIP())
+	if err == nil {
+		ctx.Next()
+		return
+	}
+
+	h.logger.Error("%s: h.challengeService.Verify: %v", op, err)
+
+	var appErr *app_errors.AppError
+	if !errors.As(err, &appErr) || appErr.Code == errcode.ErrInternal {
+		respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", nil)
+		ctx.Abort()
+		return
+	}
+
+	c, err := h.challengeService.Issue()
+	if err != nil {
+		h.logger.Error("%s: h.challengeService.Issue: %v", op, err)
+		respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", nil)
+		ctx.Abort()
+		return
+	}
+
+	ctx.AbortWithStatusJSON(statusFromCode(appErr.Code), gin.H{
+		"error":     appErr.Code,
+		"message":   errcode.GetErrorMessage(appErr.Code),
+		"details":   appErr.Error(),
+		"challenge": c,
+	})
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type LoginAbuseService interface {
+	ListBlocks() ([]models.LoginBlock, error)
+	Unblock(kind string, source string) error
+	Stats() models.LoginAbuseStats
+}
+
+// ListLoginBlocksHandler shows the sources of failed logins refused at the
+// moment, together with the counts of failures.
+func (h *AdminHandler) ListLoginBlocksHandler(ctx *gin.Context) {
+	const op = "ListLoginBlocksHandler"
+
+	blocks, err := h.loginAbuse.ListBlocks()
+	if err != nil {
+		h.logger.Error("%s: h.loginAbuse.ListBlocks: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"blocks": blocks, "stats": h.loginAbuse.Stats()})
+}
+
+type unblockLoginRequest struct {
+	Kind   string `form:"kind" binding:"required,oneof=ip subnet"`
+	Source string `form:"source" binding:"required"`
+}
+
+// UnblockLoginHandler lifts the block of a source before it expires. The
+// source goes in the query: networks contain slashes.
+func (h *AdminHandler) UnblockLoginHandler(ctx *gin.Context) {
+	const op = "UnblockLoginHandler"
+
+	var req unblockLoginRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.loginAbuse.Unblock(req.Kind, req.Source)
+	if err != nil {
+		h.logger.Error("%s: h.loginAbuse.Unblock: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "source was unblocked"})
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/pkg/app_errors"
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"net/http"
+)
+
+type reportLoginRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// reportLoginPage confirms the report. Mail scanners open the links of
+// emails, so following the link doesn't sign anybody out by itself.
+var reportLoginPage = template.Must(template.New("report").Parse(`<!doctype html>
+<html>
+
+<head>
+    <meta name="viewport" content="width=device-width" />
+    <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+</head>
+
+<body>
+    <p>Если в учётную запись вошли не вы, мы завершим все сеансы, отзовём API-ключи и пришлём код для смены пароля.</p>
+    <form method="post">
+        <input type="hidden" name="token" value="{{.}}" />
+        <button type="submit">Это был не я</button>
+    </form>
+</body>
+
+</html>
+`))
+
+// ConfirmReportLoginHandler is the "this wasn't me" link of a login alert
+// email: it only shows the button posting the report.
+func (h *UserHandler) ConfirmReportLoginHandler(ctx *gin.Context) {
+	const op = "ConfirmReportLoginHandler"
+
+	var req reportLoginRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	page := new(bytes.Buffer)
+	if err := reportLoginPage.Execute(page, req.Token); err != nil {
+		h.logger.Error("%s: reportLoginPage.Execute: %v", op, err)
+		respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", nil)
+		return
+	}
+
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// ReportLoginHandler signs the user out everywhere on the report posted by
+// the confirmation page or a frontend.
+func (h *UserHandler) ReportLoginHandler(ctx *gin.Context) {
+	const op = "ReportLoginHandler"
+
+	var req reportLoginRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		h.logger.Error("%s: ShouldBind: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.userService.ReportLogin(req.Token)
+	if err != nil {
+		h.logger.Error("%s: h.userService.ReportLogin: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "all sessions were signed out and a password reset code was sent"})
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// trustedDeviceCookie holds the remember-me token of a browser that may skip
+// the second factor, deviceCookie a random ID telling the browser apart in
+// login alerts. Only the login endpoints need to see them.
+const (
+	trustedDeviceCookie = "trusted_device"
+	deviceCookie        = "device_id"
+	deviceCookieMaxAge  = 400 * 24 * 60 * 60
+	loginCookiePath     = "/users/login"
+)
+
+type completeMFARequest struct {
+	MFAToken       string `json:"mfa_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+	RememberDevice bool   `json:"remember_device"`
+}
+
+// CompleteMFAHandler finishes a sign in with the code emailed to the user.
+// Asked to remember the device, it sets the remember-me cookie.
+func (h *UserHandler) CompleteMFAHandler(ctx *gin.Context) {
+	const op = "CompleteMFAHandler"
+
+	var req completeMFARequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	result, device, err := h.userService.CompleteMFA(req.MFAToken, req.Code, req.RememberDevice, loginClientInfo(ctx))
+	if err != nil {
+		h.logger.Error("%s: h.userService.CompleteMFA: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	if respondWithNextStep(ctx, result) {
+		return
+	}
+
+	if device.Token != "" {
+		ctx.SetSameSite(http.SameSiteStrictMode)
+		ctx.SetCookie(trustedDeviceCookie, device.Token, int(time.Until(device.ExpiresAt).Seconds()), loginCookiePath, "", true, true)
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"access-token": result.AccessToken})
+}
+
+type setMFARequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+	// Method is "email", the default, or "sms".
+	Method string `json:"method"`
+}
+
+func (h *UserHandler) SetMFAHandler(ctx *gin.Context) {
+	const op = "SetMFAHandler"
+
+	var req setMFARequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	user, err := h.userService.SetMFA(authPayload(ctx).UserID, *req.Enabled, req.Method)
+	if err != nil {
+		h.logger.Error("%s: h.userService.SetMFA: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+func (h *UserHandler) ListTrustedDevicesHandler(ctx *gin.Context) {
+	const op = "ListTrustedDevicesHandler"
+
+	devices, err := h.userService.ListTrustedDevices(authPayload(ctx).UserID)
+	if err != nil {
+		h.logger.Error("%s: h.userService.ListTrustedDevices: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+type trustedDeviceRequest struct {
+	DeviceID int64 `uri:"device_id" binding:"required,min=1"`
+}
+
+func (h *UserHandler) RevokeTrustedDeviceHandler(ctx *gin.Context) {
+	const op = "RevokeTrustedDeviceHandler"
+
+	var req trustedDeviceRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.userService.RevokeTrustedDevice(authPayload(ctx).UserID, req.DeviceID)
+	if err != nil {
+		h.logger.Error("%s: h.userService.RevokeTrustedDevice: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "device is no longer trusted"})
+}
+
+func (h *UserHandler) RevokeTrustedDevicesHandler(ctx *gin.Context) {
+	const op = "RevokeTrustedDevicesHandler"
+
+	err := h.userService.RevokeTrustedDevices(authPayload(ctx).UserID)
+	if err != nil {
+		h.logger.Error("%s: h.userService.RevokeTrustedDevices: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "devices are no longer trusted"})
+}
+
+func clientInfo(ctx *gin.Context) models.ClientInfo {
+	return models.ClientInfo{
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+}
+
+// loginClientInfo is the client of a login request, its device cookie
+// included. Browsers without one get a new one.
+func loginClientInfo(ctx *gin.Context) models.ClientInfo {
+	client := clientInfo(ctx)
+
+	client.DeviceID, _ = ctx.Cookie(deviceCookie)
+	if client.DeviceID == "" {
+		id, err := models.GenerateDeviceID()
+		if err != nil {
+			return client
+		}
+		client.DeviceID = id
+	}
+
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(deviceCookie, client.DeviceID, deviceCookieMaxAge, loginCookiePath, "", true, true)
+
+	return client
+}
@@ -0,0 +1,257 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/logger"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"fullstack-simple-app/pkg/validator"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	authorizationHeaderKey  = "authorization"
+	authorizationTypeBearer = "bearer"
+	authorizationTypeAPIKey = "apikey"
+	authorizationPayloadKey = "authorization_payload"
+)
+
+type TokenVerifier interface {
+	VerifyToken(token string) (*authentication.Payload, error)
+}
+
+type APIKeyVerifier interface {
+	VerifyAPIKey(key string) (*authentication.Payload, error)
+}
+
+// CredentialVerifier verifies both kinds of credentials a request may carry.
+type CredentialVerifier interface {
+	TokenVerifier
+	APIKeyVerifier
+}
+
+type credentialVerifier struct {
+	TokenVerifier
+	APIKeyVerifier
+}
+
+func NewCredentialVerifier(tokens TokenVerifier, apiKeys APIKeyVerifier) CredentialVerifier {
+	return credentialVerifier{TokenVerifier: tokens, APIKeyVerifier: apiKeys}
+}
+
+// authMiddleware verifies the bearer token or the API key of the request and
+// stores its payload in the context under authorizationPayloadKey. Limited
+// tokens are rejected unless their scope is listed in allowedScopes.
+func authMiddleware(verifier CredentialVerifier, allowedScopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		authorizationHeader := ctx.GetHeader(authorizationHeaderKey)
+		if len(authorizationHeader) == 0 {
+			err := errors.New("authorization header is not provided")
+			respondWithError(ctx, http.StatusUnauthorized, errcode.ErrUnauthorized, "", err)
+			ctx.Abort()
+			return
+		}
+
+		fields := strings.Fields(authorizationHeader)
+		if len(fields) < 2 {
+			err := errors.New("invalid authorization header format")
+			respondWithError(ctx, http.StatusUnauthorized, errcode.ErrUnauthorized, "", err)
+			ctx.Abort()
+			return
+		}
+
+		var (
+			payload *authentication.Payload
+			err     error
+		)
+
+		switch authorizationType := strings.ToLower(fields[0]); authorizationType {
+		case authorizationTypeBearer:
+			payload, err = verifier.VerifyToken(fields[1])
+		case authorizationTypeAPIKey:
+			payload, err = verifier.VerifyAPIKey(fields[1])
+		default:
+			err = fmt.Errorf("unsupported authorization type %s", authorizationType)
+		}
+		if err != nil {
+			respondWithError(ctx, http.StatusUnauthorized, errcode.ErrUnauthorized, "", err)
+			ctx.Abort()
+			return
+		}
+
+		if payload.Scope != "" && !validator.In(payload.Scope, allowedScopes...) {
+			err = fmt.Errorf("token with scope %s can't be used here", payload.Scope)
+			respondWithError(ctx, http.StatusForbidden, errcode.ErrForbidden, "", err)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set(authorizationPayloadKey, payload)
+		ctx.Next()
+	}
+}
+
+func authPayload(ctx *gin.Context) *authentication.Payload {
+	return ctx.MustGet(authorizationPayloadKey).(*authentication.Payload)
+}
+
+// rejectImpersonation keeps impersonation tokens away from sensitive routes.
+// It must run after authMiddleware.
+func rejectImpersonation(ctx *gin.Context) {
+	if authPayload(ctx).Impersonated() {
+		err := errors.New("impersonation tokens can't be used here")
+		respondWithError(ctx, http.StatusForbidden, errcode.ErrImpersonatedToken, "", err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.Next()
+}
+
+// rejectDelegated keeps tokens issued to clients and API keys away from routes
+// that manage credentials or issue tokens, which would let them outgrow their
+// scopes. It must run after authMiddleware.
+func rejectDelegated(ctx *gin.Context) {
+	if authPayload(ctx).Delegated() {
+		err := errors.New("tokens of clients and api keys can't be used here")
+		respondWithError(ctx, http.StatusForbidden, errcode.ErrForbidden, "", err)
+		ctx.Abort()
+		return
+	}
+
+	ctx.Next()
+}
+
+type PermissionChecker interface {
+	HasPermission(roles []string, permission string) (bool, error)
+}
+
+// Guard protects routes with permissions granted to the roles carried in the
+// access token, within the scopes of the token, and sensitive routes with
+// recent authentication.
+type Guard struct {
+	permissions  PermissionChecker
+	logger       logger.Logger
+	reauthMaxAge time.Duration
+}
+
+func NewGuard(permissions PermissionChecker, logger logger.Logger, reauthMaxAge time.Duration) *Guard {
+	return &Guard{
+		permissions:  permissions,
+		logger:       logger,
+		reauthMaxAge: reauthMaxAge,
+	}
+}
+
+// RequireRecentAuth lets the request through only if the user authenticated
+// within the re-authentication window. Otherwise the client is told to
+// re-authenticate (POST /users/reauth) and retry. It must run after
+// authMiddleware.
+func (g *Guard) RequireRecentAuth(ctx *gin.Context) {
+	if !authPayload(ctx).AuthenticatedWithin(g.reauthMaxAge) {
+		ctx.AbortWithStatusJSON(statusFromCode(errcode.ErrReauthRequired), gin.H{
+			"error":   errcode.ErrReauthRequired,
+			"message": errcode.GetErrorMessage(errcode.ErrReauthRequired),
+			"max_age": int(g.reauthMaxAge.Seconds()),
+		})
+		return
+	}
+
+	ctx.Next()
+}
+
+// RequirePermission lets the request through only if one of the roles of the
+// authenticated user grants the permission, or the scopes of a client list
+// it. It must run after authMiddleware.
+func (g *Guard) RequirePermission(permission string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		const op = "RequirePermission"
+
+		payload := authPayload(ctx)
+
+		allowed := payload.AllowsScope(permission)
+
+		var err error
+		if allowed && !payload.IsClient() {
+			allowed, err = g.permissions.HasPermission(payload.Roles, permission)
+		}
+		if err != nil {
+			g.logger.Error("%s: g.permissions.HasPermission: %v", op, err)
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", nil)
+			ctx.Abort()
+			return
+		}
+
+		if !allowed {
+			err = fmt.Errorf("permission %s is required", permission)
+			respondWithError(ctx, http.StatusForbidden, errcode.ErrForbidden, "", err)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// Authorizer protects routes with the full authorization decision: policies,
+// role permissions and ownership rules.
+type Authorizer struct {
+	authz  AuthzService
+	logger logger.Logger
+}
+
+func NewAuthorizer(authz AuthzService, logger logger.Logger) *Authorizer {
+	return &Authorizer{
+		authz:  authz,
+		logger: logger,
+	}
+}
+
+// Authorize lets the request through only if the action is allowed on the
+// resource described by the request. It must run after authMiddleware.
+func (a *Authorizer) Authorize(action string, resource func(ctx *gin.Context) models.AuthzResource) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		const op = "Authorize"
+
+		check := models.AuthzCheck{
+			Action:      action,
+			Resource:    resource(ctx),
+			Environment: map[string]interface{}{"ip": ctx.ClientIP()},
+		}
+
+		decision, err := a.authz.Check(authzSubject(ctx), check)
+		if err != nil {
+			a.logger.Error("%s: a.authz.Check: %v", op, err)
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", nil)
+			ctx.Abort()
+			return
+		}
+
+		if !decision.Allowed {
+			err = fmt.Errorf("%s on %s is not allowed", action, check.Resource.Type)
+			respondWithError(ctx, http.StatusForbidden, errcode.ErrForbidden, "", err)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// userResource describes the user addressed by the user_id URI parameter, or
+// users in general on routes without one.
+func userResource(ctx *gin.Context) models.AuthzResource {
+	resource := models.AuthzResource{Type: "user", ID: ctx.Param("user_id")}
+
+	if id, err := strconv.ParseInt(resource.ID, 10, 64); err == nil {
+		resource.OwnerID = id
+	}
+
+	return resource
+}
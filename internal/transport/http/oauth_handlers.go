@@ -0,0 +1,514 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"fullstack-simple-app/pkg/oauth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+type OAuthHandler struct {
+	tokenExchanger      TokenExchanger
+	authorizationServer AuthorizationServer
+	logger              logger.Logger
+}
+
+type TokenExchanger interface {
+	Impersonate(actorToken string, targetUserID int64, reason string, ip string) (string, time.Duration, error)
+}
+
+type AuthorizationServer interface {
+	CreateClient(client *models.OAuthClient) (string, error)
+	ListClients() ([]models.OAuthClient, error)
+	PrepareAuthorization(req models.AuthorizationRequest) (models.OAuthClient, []string, error)
+	Authorize(userID int64, orgID int64, req models.AuthorizationRequest) (string, error)
+	Deny(req models.AuthorizationRequest) (string, error)
+	ExchangeCode(clientID, clientSecret, code, redirectURI, codeVerifier string) (models.TokenGrant, error)
+	Refresh(clientID, clientSecret, refreshToken, scope string) (models.TokenGrant, error)
+	ClientCredentials(clientID, clientSecret, scope string) (models.TokenGrant, error)
+	StartDeviceAuthorization(clientID, clientSecret, scope string) (models.DeviceCode, error)
+	GetDeviceAuthorization(userCode string) (models.OAuthClient, []string, error)
+	DecideDeviceAuthorization(userID int64, orgID int64, userCode string, approve bool) error
+	PollDeviceAuthorization(clientID, clientSecret, deviceCode string) (models.TokenGrant, error)
+}
+
+func NewOAuthHandler(tokenExchanger TokenExchanger, authorizationServer AuthorizationServer, logger logger.Logger) *OAuthHandler {
+	return &OAuthHandler{
+		tokenExchanger:      tokenExchanger,
+		authorizationServer: authorizationServer,
+		logger:              logger,
+	}
+}
+
+type createClientRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes" binding:"required"`
+	Confidential bool     `json:"confidential"`
+}
+
+// CreateClientHandler registers a client. The response holds the client
+// secret, which can't be retrieved later.
+func (h *OAuthHandler) CreateClientHandler(ctx *gin.Context) {
+	const op = "CreateClientHandler"
+
+	var req createClientRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	client := &models.OAuthClient{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		Confidential: req.Confidential,
+	}
+
+	secret, err := h.authorizationServer.CreateClient(client)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.CreateClient: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	response := gin.H{"client": client}
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func (h *OAuthHandler) ListClientsHandler(ctx *gin.Context) {
+	const op = "ListClientsHandler"
+
+	clients, err := h.authorizationServer.ListClients()
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.ListClients: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// authorizeRequest holds the parameters of the authorization endpoint, from
+// the query or the form.
+type authorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+	// Approve is the answer of the user on the consent step.
+	Approve bool `form:"approve"`
+}
+
+func (r authorizeRequest) authorizationRequest() models.AuthorizationRequest {
+	return models.AuthorizationRequest{
+		ResponseType:        r.ResponseType,
+		ClientID:            r.ClientID,
+		RedirectURI:         r.RedirectURI,
+		Scope:               r.Scope,
+		State:               r.State,
+		CodeChallenge:       r.CodeChallenge,
+		CodeChallengeMethod: r.CodeChallengeMethod,
+		Nonce:               r.Nonce,
+	}
+}
+
+// AuthorizeHandler validates an authorization request for the signed in user
+// and tells what to ask their consent for.
+func (h *OAuthHandler) AuthorizeHandler(ctx *gin.Context) {
+	const op = "AuthorizeHandler"
+
+	var req authorizeRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithOAuthError(ctx, app_errors.NewAppError(errcode.ErrInvalidRequest, err))
+		return
+	}
+
+	client, scopes, err := h.authorizationServer.PrepareAuthorization(req.authorizationRequest())
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.PrepareAuthorization: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"client_id":   client.ClientID,
+		"client_name": client.Name,
+		"scopes":      scopes,
+	})
+}
+
+// ConsentHandler takes the answer of the user and responds with where to
+// redirect them: back to the client with a code or with access_denied.
+func (h *OAuthHandler) ConsentHandler(ctx *gin.Context) {
+	const op = "ConsentHandler"
+
+	var req authorizeRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		h.logger.Error("%s: ShouldBind: %v", op, err)
+		respondWithOAuthError(ctx, app_errors.NewAppError(errcode.ErrInvalidRequest, err))
+		return
+	}
+
+	var (
+		redirectTo string
+		err        error
+	)
+
+	if req.Approve {
+		payload := authPayload(ctx)
+		redirectTo, err = h.authorizationServer.Authorize(payload.UserID, payload.OrgID, req.authorizationRequest())
+	} else {
+		redirectTo, err = h.authorizationServer.Deny(req.authorizationRequest())
+	}
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"redirect_to": redirectTo})
+}
+
+// tokenRequest holds the parameters of every supported grant type.
+type tokenRequest struct {
+	GrantType string `form:"grant_type" binding:"required"`
+
+	// RFC 8693 token exchange
+	SubjectToken       string `form:"subject_token"`
+	SubjectTokenType   string `form:"subject_token_type"`
+	RequestedSubject   string `form:"requested_subject"`
+	RequestedTokenType string `form:"requested_token_type"`
+	Reason             string `form:"reason"`
+
+	// authorization code, refresh token and client credentials grants
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+
+	// device authorization grant
+	DeviceCode string `form:"device_code"`
+}
+
+// TokenHandler is the OAuth 2.0 token endpoint. It takes form encoded
+// parameters and responds in the RFC 6749 format.
+func (h *OAuthHandler) TokenHandler(ctx *gin.Context) {
+	const op = "TokenHandler"
+
+	ctx.Header("Cache-Control", "no-store")
+
+	var req tokenRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		h.logger.Error("%s: ShouldBind: %v", op, err)
+		respondWithOAuthError(ctx, app_errors.NewAppError(errcode.ErrInvalidRequest, err))
+		return
+	}
+
+	req.ClientID, req.ClientSecret = clientCredentials(ctx, req.ClientID, req.ClientSecret)
+
+	switch req.GrantType {
+	case grantTypeAuthorizationCode:
+		h.exchangeCode(ctx, req)
+	case grantTypeRefreshToken:
+		h.refreshToken(ctx, req)
+	case grantTypeClientCredentials:
+		h.clientCredentials(ctx, req)
+	case oauth.GrantTypeDeviceCode:
+		h.pollDeviceAuthorization(ctx, req)
+	case grantTypeTokenExchange:
+		h.exchangeToken(ctx, req)
+	default:
+		err := fmt.Errorf("grant type %q is not supported", req.GrantType)
+		h.logger.Error("%s: %v", op, err)
+		respondWithOAuthError(ctx, app_errors.NewAppError(errcode.ErrUnsupportedGrantType, err))
+	}
+}
+
+// exchangeToken issues a token for the requested subject to the holder of the
+// subject token, i.e. impersonation.
+func (h *OAuthHandler) exchangeToken(ctx *gin.Context, req tokenRequest) {
+	const op = "exchangeToken"
+
+	targetUserID, err := strconv.ParseInt(req.RequestedSubject, 10, 64)
+	if err != nil || req.SubjectToken == "" ||
+		(req.SubjectTokenType != "" && req.SubjectTokenType != tokenTypeAccessToken) ||
+		(req.RequestedTokenType != "" && req.RequestedTokenType != tokenTypeAccessToken) {
+		err = errors.New("subject_token and a numeric requested_subject are required, only access tokens are supported")
+		h.logger.Error("%s: %v", op, err)
+		respondWithOAuthError(ctx, app_errors.NewAppError(errcode.ErrInvalidRequest, err))
+		return
+	}
+
+	accessToken, expiresIn, err := h.tokenExchanger.Impersonate(req.SubjectToken, targetUserID, req.Reason, ctx.ClientIP())
+	if err != nil {
+		h.logger.Error("%s: h.tokenExchanger.Impersonate: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"access_token":      accessToken,
+		"issued_token_type": tokenTypeAccessToken,
+		"token_type":        "Bearer",
+		"expires_in":        int(expiresIn.Seconds()),
+	})
+}
+
+func (h *OAuthHandler) exchangeCode(ctx *gin.Context, req tokenRequest) {
+	const op = "exchangeCode"
+
+	grant, err := h.authorizationServer.ExchangeCode(req.ClientID, req.ClientSecret, req.Code, req.RedirectURI, req.CodeVerifier)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.ExchangeCode: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	respondWithTokenGrant(ctx, grant)
+}
+
+func (h *OAuthHandler) refreshToken(ctx *gin.Context, req tokenRequest) {
+	const op = "refreshToken"
+
+	grant, err := h.authorizationServer.Refresh(req.ClientID, req.ClientSecret, req.RefreshToken, req.Scope)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.Refresh: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	respondWithTokenGrant(ctx, grant)
+}
+
+func (h *OAuthHandler) clientCredentials(ctx *gin.Context, req tokenRequest) {
+	const op = "clientCredentials"
+
+	grant, err := h.authorizationServer.ClientCredentials(req.ClientID, req.ClientSecret, req.Scope)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.ClientCredentials: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	respondWithTokenGrant(ctx, grant)
+}
+
+func (h *OAuthHandler) pollDeviceAuthorization(ctx *gin.Context, req tokenRequest) {
+	const op = "pollDeviceAuthorization"
+
+	grant, err := h.authorizationServer.PollDeviceAuthorization(req.ClientID, req.ClientSecret, req.DeviceCode)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.PollDeviceAuthorization: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	respondWithTokenGrant(ctx, grant)
+}
+
+type deviceAuthorizationRequest struct {
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Scope        string `form:"scope"`
+}
+
+// DeviceAuthorizationHandler is the device authorization endpoint of
+// RFC 8628: it hands out the device and user codes.
+func (h *OAuthHandler) DeviceAuthorizationHandler(ctx *gin.Context) {
+	const op = "DeviceAuthorizationHandler"
+
+	ctx.Header("Cache-Control", "no-store")
+
+	var req deviceAuthorizationRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		h.logger.Error("%s: ShouldBind: %v", op, err)
+		respondWithOAuthError(ctx, app_errors.NewAppError(errcode.ErrInvalidRequest, err))
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(ctx, req.ClientID, req.ClientSecret)
+
+	code, err := h.authorizationServer.StartDeviceAuthorization(clientID, clientSecret, req.Scope)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.StartDeviceAuthorization: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"device_code":               code.DeviceCode,
+		"user_code":                 code.UserCode,
+		"verification_uri":          code.VerificationURI,
+		"verification_uri_complete": code.VerificationURIComplete,
+		"expires_in":                int(code.ExpiresIn.Seconds()),
+		"interval":                  int(code.Interval.Seconds()),
+	})
+}
+
+type deviceVerificationRequest struct {
+	UserCode string `form:"user_code" json:"user_code" binding:"required"`
+	Approve  bool   `form:"approve" json:"approve"`
+}
+
+// DeviceVerificationHandler tells the signed in user which client the user
+// code belongs to and what it asks for.
+func (h *OAuthHandler) DeviceVerificationHandler(ctx *gin.Context) {
+	const op = "DeviceVerificationHandler"
+
+	var req deviceVerificationRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	client, scopes, err := h.authorizationServer.GetDeviceAuthorization(req.UserCode)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.GetDeviceAuthorization: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"client_id":   client.ClientID,
+		"client_name": client.Name,
+		"scopes":      scopes,
+	})
+}
+
+// DeviceDecisionHandler takes the answer of the signed in user for the user
+// code shown by the device.
+func (h *OAuthHandler) DeviceDecisionHandler(ctx *gin.Context) {
+	const op = "DeviceDecisionHandler"
+
+	var req deviceVerificationRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		h.logger.Error("%s: ShouldBind: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	payload := authPayload(ctx)
+
+	err := h.authorizationServer.DecideDeviceAuthorization(payload.UserID, payload.OrgID, req.UserCode, req.Approve)
+	if err != nil {
+		h.logger.Error("%s: h.authorizationServer.DecideDeviceAuthorization: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	message := "device was denied"
+	if req.Approve {
+		message = "device was approved"
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// clientCredentials prefers HTTP Basic over the form parameters, as clients
+// may authenticate with either.
+func clientCredentials(ctx *gin.Context, clientID, clientSecret string) (string, string) {
+	if id, secret, ok := ctx.Request.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+		clientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	return clientID, clientSecret
+}
+
+// respondWithTokenGrant responds in the format of RFC 6749 (5.1).
+func respondWithTokenGrant(ctx *gin.Context, grant models.TokenGrant) {
+	response := gin.H{
+		"access_token": grant.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(grant.ExpiresIn.Seconds()),
+		"scope":        oauth.FormatScope(grant.Scopes),
+	}
+
+	if grant.RefreshToken != "" {
+		response["refresh_token"] = grant.RefreshToken
+	}
+
+	if grant.IDToken != "" {
+		response["id_token"] = grant.IDToken
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// respondWithOAuthError responds with the error format of RFC 6749 (5.2).
+func respondWithOAuthError(ctx *gin.Context, err error) {
+	code := errcode.ErrInternal
+
+	var appErr *app_errors.AppError
+	if errors.As(err, &appErr) {
+		code = appErr.Code
+	}
+
+	if code == errcode.ErrInvalidClient {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	ctx.JSON(statusFromCode(code), gin.H{
+		"error":             code,
+		"error_description": errcode.GetErrorMessage(code),
+	})
+}
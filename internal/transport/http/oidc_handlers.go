@@ -0,0 +1,49 @@
+package http
+
+import (
+	"fullstack-simple-app/pkg/jwks"
+	"fullstack-simple-app/pkg/logger"
+	"fullstack-simple-app/pkg/oidc"
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type OIDCHandler struct {
+	provider OIDCProvider
+	logger   logger.Logger
+}
+
+type OIDCProvider interface {
+	Discovery() oidc.ProviderMetadata
+	KeySet() jwks.Set
+	UserInfo(payload *authentication.Payload) (oidc.UserInfo, error)
+}
+
+func NewOIDCHandler(provider OIDCProvider, logger logger.Logger) *OIDCHandler {
+	return &OIDCHandler{
+		provider: provider,
+		logger:   logger,
+	}
+}
+
+func (h *OIDCHandler) DiscoveryHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.provider.Discovery())
+}
+
+func (h *OIDCHandler) KeySetHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.provider.KeySet())
+}
+
+func (h *OIDCHandler) UserInfoHandler(ctx *gin.Context) {
+	const op = "UserInfoHandler"
+
+	info, err := h.provider.UserInfo(authPayload(ctx))
+	if err != nil {
+		h.logger.Error("%s: h.provider.UserInfo: %v", op, err)
+		respondWithOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, info)
+}
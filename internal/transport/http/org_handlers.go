@@ -0,0 +1,241 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type OrgHandler struct {
+	orgService OrgService
+	logger     logger.Logger
+}
+
+type OrgService interface {
+	CreateOrganization(userID int64, org *models.Organization) error
+	CreateTenant(org *models.Organization) error
+	ListUserOrganizations(userID int64) ([]models.Membership, error)
+	ListMembers(userID int64, orgID int64) ([]models.Membership, error)
+	InviteMember(inviterID int64, orgID int64, email string, role string) (models.Invitation, error)
+	AdminInviteMember(adminID int64, orgID int64, email string, role string) (models.Invitation, error)
+	AcceptInvitation(userID int64, token string) (models.Membership, error)
+	SwitchOrganization(userID int64, orgID int64, authTime time.Time, amr []string) (string, error)
+}
+
+func NewOrgHandler(orgService OrgService, logger logger.Logger) *OrgHandler {
+	return &OrgHandler{
+		orgService: orgService,
+		logger:     logger,
+	}
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name" binding:"required"`
+	Slug string `json:"slug" binding:"required"`
+}
+
+func (h *OrgHandler) CreateOrganizationHandler(ctx *gin.Context) {
+	h.createOrganization(ctx, "CreateOrganizationHandler", func(org *models.Organization) error {
+		return h.orgService.CreateOrganization(authPayload(ctx).UserID, org)
+	})
+}
+
+func (h *OrgHandler) CreateTenantHandler(ctx *gin.Context) {
+	h.createOrganization(ctx, "CreateTenantHandler", h.orgService.CreateTenant)
+}
+
+func (h *OrgHandler) createOrganization(ctx *gin.Context, op string, create func(org *models.Organization) error) {
+	var req createOrganizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	org := &models.Organization{
+		Name: req.Name,
+		Slug: req.Slug,
+	}
+
+	err := create(org)
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"organization": org})
+}
+
+func (h *OrgHandler) ListOrganizationsHandler(ctx *gin.Context) {
+	const op = "ListOrganizationsHandler"
+
+	memberships, err := h.orgService.ListUserOrganizations(authPayload(ctx).UserID)
+	if err != nil {
+		h.logger.Error("%s: h.orgService.ListUserOrganizations: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"organizations": memberships})
+}
+
+type orgRequest struct {
+	OrgID int64 `uri:"org_id" binding:"required,min=1"`
+}
+
+func (h *OrgHandler) ListMembersHandler(ctx *gin.Context) {
+	const op = "ListMembersHandler"
+
+	var req orgRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	members, err := h.orgService.ListMembers(authPayload(ctx).UserID, req.OrgID)
+	if err != nil {
+		h.logger.Error("%s: h.orgService.ListMembers: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+type inviteMemberRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required"`
+}
+
+func (h *OrgHandler) InviteMemberHandler(ctx *gin.Context) {
+	h.inviteMember(ctx, "InviteMemberHandler", h.orgService.InviteMember)
+}
+
+func (h *OrgHandler) AdminInviteMemberHandler(ctx *gin.Context) {
+	h.inviteMember(ctx, "AdminInviteMemberHandler", h.orgService.AdminInviteMember)
+}
+
+func (h *OrgHandler) inviteMember(ctx *gin.Context, op string, invite func(inviterID int64, orgID int64, email string, role string) (models.Invitation, error)) {
+	var uri orgRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	var req inviteMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	invitation, err := invite(authPayload(ctx).UserID, uri.OrgID, req.Email, req.Role)
+	if err != nil {
+		h.logger.Error("%s: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"invitation": invitation})
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func (h *OrgHandler) AcceptInvitationHandler(ctx *gin.Context) {
+	const op = "AcceptInvitationHandler"
+
+	var req acceptInvitationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	membership, err := h.orgService.AcceptInvitation(authPayload(ctx).UserID, req.Token)
+	if err != nil {
+		h.logger.Error("%s: h.orgService.AcceptInvitation: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"membership": membership})
+}
+
+type switchOrganizationRequest struct {
+	// OrgID zero switches back to acting outside of any organization.
+	OrgID int64 `json:"org_id" binding:"min=0"`
+}
+
+func (h *OrgHandler) SwitchOrganizationHandler(ctx *gin.Context) {
+	const op = "SwitchOrganizationHandler"
+
+	var req switchOrganizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	payload := authPayload(ctx)
+
+	accessToken, err := h.orgService.SwitchOrganization(payload.UserID, req.OrgID, payload.AuthTime, payload.AMR)
+	if err != nil {
+		h.logger.Error("%s: h.orgService.SwitchOrganization: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"access-token": accessToken})
+}
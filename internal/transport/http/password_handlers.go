@@ -0,0 +1,109 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/pkg/app_errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+func (h *UserHandler) ChangePasswordHandler(ctx *gin.Context) {
+	const op = "ChangePasswordHandler"
+
+	var req changePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	payload := authPayload(ctx)
+
+	err := h.userService.ChangePassword(payload.UserID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		h.logger.Error("%s: h.userService.ChangePassword: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "password was changed"})
+}
+
+type requestPasswordResetRequest struct {
+	Email string `json:"email" binding:"required"`
+	Org   string `json:"org"`
+}
+
+func (h *UserHandler) RequestPasswordResetHandler(ctx *gin.Context) {
+	const op = "RequestPasswordResetHandler"
+
+	var req requestPasswordResetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.userService.RequestPasswordReset(req.Org, req.Email)
+	if err != nil {
+		h.logger.Error("%s: h.userService.RequestPasswordReset: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "password reset code was sent"})
+}
+
+type resetPasswordRequest struct {
+	Email       string `json:"email" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+	Org         string `json:"org"`
+}
+
+func (h *UserHandler) ResetPasswordHandler(ctx *gin.Context) {
+	const op = "ResetPasswordHandler"
+
+	var req resetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.userService.ResetPassword(req.Org, req.Email, req.Code, req.NewPassword)
+	if err != nil {
+		h.logger.Error("%s: h.userService.ResetPassword: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "password was reset"})
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/pkg/app_errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type setPhoneRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+func (h *UserHandler) SetPhoneHandler(ctx *gin.Context) {
+	const op = "SetPhoneHandler"
+
+	var req setPhoneRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	user, err := h.userService.SetPhone(authPayload(ctx).UserID, req.Phone)
+	if err != nil {
+		h.logger.Error("%s: h.userService.SetPhone: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"user": user, "message": "verification code was sent"})
+}
+
+type verifyPhoneRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func (h *UserHandler) VerifyPhoneHandler(ctx *gin.Context) {
+	const op = "VerifyPhoneHandler"
+
+	var req verifyPhoneRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	user, err := h.userService.VerifyPhone(authPayload(ctx).UserID, req.Code)
+	if err != nil {
+		h.logger.Error("%s: h.userService.VerifyPhone: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"user": user})
+}
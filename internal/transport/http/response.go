@@ -1,24 +1,47 @@
 package http
 
 import (
+	"errors"
 	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/pkg/app_errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
 var codeToHTTPStatus = map[string]int{
-	errcode.ErrInvalidRequest:     http.StatusBadRequest,          // 400
-	errcode.ErrUnauthorized:       http.StatusUnauthorized,        // 401
-	errcode.ErrForbidden:          http.StatusForbidden,           // 403
-	errcode.ErrNotFound:           http.StatusNotFound,            // 404
-	errcode.ErrConflict:           http.StatusConflict,            // 409
-	errcode.ErrInternal:           http.StatusInternalServerError, // 500
-	errcode.ErrEmailAlreadyExists: http.StatusConflict,            // 409
-	errcode.ErrAccountCreated:     http.StatusCreated,             // 201
-	errcode.ErrOTPNotFound:        http.StatusNotFound,            // 404
-	errcode.ErrOTPInvalid:         http.StatusBadRequest,          // 400
-	errcode.ErrInvalidPassword:    http.StatusUnauthorized,        // 401
-	errcode.ErrLoginRedirect:      http.StatusFound,               // 302
+	errcode.ErrInvalidRequest:         http.StatusBadRequest,          // 400
+	errcode.ErrUnauthorized:           http.StatusUnauthorized,        // 401
+	errcode.ErrForbidden:              http.StatusForbidden,           // 403
+	errcode.ErrNotFound:               http.StatusNotFound,            // 404
+	errcode.ErrConflict:               http.StatusConflict,            // 409
+	errcode.ErrInternal:               http.StatusInternalServerError, // 500
+	errcode.ErrEmailAlreadyExists:     http.StatusConflict,            // 409
+	errcode.ErrAccountCreated:         http.StatusCreated,             // 201
+	errcode.ErrOTPNotFound:            http.StatusNotFound,            // 404
+	errcode.ErrOTPInvalid:             http.StatusBadRequest,          // 400
+	errcode.ErrInvalidPassword:        http.StatusUnauthorized,        // 401
+	errcode.ErrLoginRedirect:          http.StatusFound,               // 302
+	errcode.ErrPasswordReused:         http.StatusBadRequest,          // 400
+	errcode.ErrPasswordChangeRequired: http.StatusForbidden,           // 403
+	errcode.ErrAccountSuspended:       http.StatusForbidden,           // 403
+	errcode.ErrUnsupportedGrantType:   http.StatusBadRequest,          // 400
+	errcode.ErrInvalidGrant:           http.StatusBadRequest,          // 400
+	errcode.ErrImpersonatedToken:      http.StatusForbidden,           // 403
+	errcode.ErrInvalidClient:          http.StatusUnauthorized,        // 401
+	errcode.ErrInvalidScope:           http.StatusBadRequest,          // 400
+	errcode.ErrUnauthorizedClient:     http.StatusBadRequest,          // 400
+	errcode.ErrAuthorizationPending:   http.StatusBadRequest,          // 400
+	errcode.ErrSlowDown:               http.StatusBadRequest,          // 400
+	errcode.ErrAccessDenied:           http.StatusBadRequest,          // 400
+	errcode.ErrExpiredToken:           http.StatusBadRequest,          // 400
+	errcode.ErrSocialLoginFailed:      http.StatusUnauthorized,        // 401
+	errcode.ErrAccountLinkRequired:    http.StatusConflict,            // 409
+	errcode.ErrSAMLLoginFailed:        http.StatusUnauthorized,        // 401
+	errcode.ErrReauthRequired:         http.StatusUnauthorized,        // 401
+	errcode.ErrMFARequired:            http.StatusUnauthorized,        // 401
+	errcode.ErrLoginBlocked:           http.StatusTooManyRequests,     // 429
+	errcode.ErrChallengeRequired:      http.StatusForbidden,           // 403
+	errcode.ErrChallengeFailed:        http.StatusForbidden,           // 403
 }
 
 func statusFromCode(code string) int {
@@ -42,6 +65,11 @@ func respondWithError(ctx *gin.Context, statusCode int, errorCode string, overri
 
 	if err != nil {
 		payload["details"] = err.Error()
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) && len(appErr.Fields) > 0 {
+			payload["fields"] = appErr.Fields
+		}
 	}
 
 	if reqID := ctx.GetString("request_id"); reqID != "" {
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type RoleHandler struct {
+	roleService RoleService
+	logger      logger.Logger
+}
+
+type RoleService interface {
+	ListRoles() ([]models.Role, error)
+	GetUserRoles(userID int64) ([]string, error)
+	AssignRole(userID int64, role string) error
+	RevokeRole(userID int64, role string) error
+}
+
+func NewRoleHandler(roleService RoleService, logger logger.Logger) *RoleHandler {
+	return &RoleHandler{
+		roleService: roleService,
+		logger:      logger,
+	}
+}
+
+func (h *RoleHandler) ListRolesHandler(ctx *gin.Context) {
+	const op = "ListRolesHandler"
+
+	roles, err := h.roleService.ListRoles()
+	if err != nil {
+		h.logger.Error("%s: h.roleService.ListRoles: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+type userRolesRequest struct {
+	UserID int64 `uri:"user_id" binding:"required,min=1"`
+}
+
+func (h *RoleHandler) GetUserRolesHandler(ctx *gin.Context) {
+	const op = "GetUserRolesHandler"
+
+	var req userRolesRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	roles, err := h.roleService.GetUserRoles(req.UserID)
+	if err != nil {
+		h.logger.Error("%s: h.roleService.GetUserRoles: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+type userRoleRequest struct {
+	UserID int64  `uri:"user_id" binding:"required,min=1"`
+	Role   string `uri:"role" binding:"required"`
+}
+
+func (h *RoleHandler) AssignRoleHandler(ctx *gin.Context) {
+	const op = "AssignRoleHandler"
+
+	var req userRoleRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.roleService.AssignRole(req.UserID, req.Role)
+	if err != nil {
+		h.logger.Error("%s: h.roleService.AssignRole: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "role was assigned"})
+}
+
+func (h *RoleHandler) RevokeRoleHandler(ctx *gin.Context) {
+	const op = "RevokeRoleHandler"
+
+	var req userRoleRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.roleService.RevokeRole(req.UserID, req.Role)
+	if err != nil {
+		h.logger.Error("%s: h.roleService.RevokeRole: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "role was revoked"})
+}
@@ -1,19 +1,186 @@
 package http
 
-import "github.com/gin-gonic/gin"
+import (
+	"fullstack-simple-app/pkg/tokens/authentication"
+	"github.com/gin-gonic/gin"
+)
 
-func NewRouter(userHandler *UserHandler) *gin.Engine {
+func NewRouter(userHandler *UserHandler, roleHandler *RoleHandler, adminHandler *AdminHandler, orgHandler *OrgHandler, authzHandler *AuthzHandler, oauthHandler *OAuthHandler, oidcHandler *OIDCHandler, apiKeyHandler *APIKeyHandler, socialHandler *SocialHandler, samlHandler *SAMLHandler, challengeHandler *ChallengeHandler, verifier CredentialVerifier, guard *Guard, authorizer *Authorizer) *gin.Engine {
 	r := gin.Default()
 
-	registerUserRoutes(r, userHandler)
+	registerUserRoutes(r, userHandler, challengeHandler, verifier, guard)
+	registerRoleRoutes(r, roleHandler, verifier, guard)
+	registerAdminRoutes(r, adminHandler, verifier, guard, authorizer)
+	registerOrgRoutes(r, orgHandler, verifier, guard)
+	registerAuthzRoutes(r, authzHandler, verifier)
+	registerOAuthRoutes(r, oauthHandler, verifier, guard)
+	registerOIDCRoutes(r, oidcHandler, verifier)
+	registerAPIKeyRoutes(r, apiKeyHandler, verifier, guard)
+	registerSocialRoutes(r, socialHandler, verifier, guard)
+	registerSAMLRoutes(r, samlHandler, verifier, guard)
 
 	return r
 }
 
-func registerUserRoutes(r *gin.Engine, h *UserHandler) {
-	r.POST("/users", h.RegisterUserHandler)
+func registerUserRoutes(r *gin.Engine, h *UserHandler, challenge *ChallengeHandler, verifier CredentialVerifier, guard *Guard) {
+	// the endpoints sending emails to any address need a solved challenge
+	r.GET("/users/challenge", challenge.IssueChallengeHandler)
+	r.POST("/users", challenge.RequireChallenge, h.RegisterUserHandler)
 	r.PATCH("/users/activate", h.VerifyUserHandler)
-	r.PATCH("/users/resend-code", h.ResendCodeHandler)
+	r.PATCH("/users/resend-code", challenge.RequireChallenge, h.ResendCodeHandler)
 	r.POST("/users/login", h.LoginHandler)
+	r.POST("/users/login/mfa", h.CompleteMFAHandler)
+	r.GET("/users/login-alerts/report", h.ConfirmReportLoginHandler)
+	r.POST("/users/login-alerts/report", h.ReportLoginHandler)
+	r.POST("/users/password-reset", challenge.RequireChallenge, h.RequestPasswordResetHandler)
+	r.PATCH("/users/password-reset", h.ResetPasswordHandler)
 	r.GET("/users/:email", h.GetUserHandler)
+
+	r.POST("/users/reauth", authMiddleware(verifier), rejectImpersonation, rejectDelegated, h.ReauthenticateHandler)
+
+	// an expired password can be changed with the limited token issued at login
+	r.PATCH("/users/password", authMiddleware(verifier, authentication.ScopePasswordChange), rejectImpersonation, rejectDelegated, guard.RequireRecentAuth, h.ChangePasswordHandler)
+
+	r.PUT("/users/mfa", authMiddleware(verifier), rejectImpersonation, rejectDelegated, guard.RequireRecentAuth, h.SetMFAHandler)
+
+	// codes can be texted to a phone once it is verified
+	r.PUT("/users/phone", authMiddleware(verifier), rejectImpersonation, rejectDelegated, guard.RequireRecentAuth, h.SetPhoneHandler)
+	r.PATCH("/users/phone/verify", authMiddleware(verifier), rejectImpersonation, rejectDelegated, h.VerifyPhoneHandler)
+
+	devices := r.Group("/users/devices", authMiddleware(verifier), rejectImpersonation, rejectDelegated)
+
+	devices.GET("", h.ListTrustedDevicesHandler)
+	devices.DELETE("", h.RevokeTrustedDevicesHandler)
+	devices.DELETE("/:device_id", h.RevokeTrustedDeviceHandler)
+}
+
+func registerRoleRoutes(r *gin.Engine, h *RoleHandler, verifier CredentialVerifier, guard *Guard) {
+	admin := r.Group("/admin", authMiddleware(verifier))
+
+	admin.GET("/roles", guard.RequirePermission("roles:read"), h.ListRolesHandler)
+	admin.GET("/users/:user_id/roles", guard.RequirePermission("roles:read"), h.GetUserRolesHandler)
+	admin.PUT("/users/:user_id/roles/:role", guard.RequirePermission("roles:write"), h.AssignRoleHandler)
+	admin.DELETE("/users/:user_id/roles/:role", guard.RequirePermission("roles:write"), h.RevokeRoleHandler)
+}
+
+func registerAdminRoutes(r *gin.Engine, h *AdminHandler, verifier CredentialVerifier, guard *Guard, authorizer *Authorizer) {
+	admin := r.Group("/admin", authMiddleware(verifier))
+
+	// reads go through the policies, e.g. to let support staff in
+	admin.GET("/users", authorizer.Authorize("users:read", userResource), h.ListUsersHandler)
+	admin.GET("/users/:user_id", authorizer.Authorize("users:read", userResource), h.GetUserHandler)
+	admin.PATCH("/users/:user_id/activate", guard.RequirePermission("users:write"), h.ActivateUserHandler)
+	admin.PATCH("/users/:user_id/suspend", guard.RequirePermission("users:write"), h.SuspendUserHandler)
+	admin.PATCH("/users/:user_id/unsuspend", guard.RequirePermission("users:write"), h.UnsuspendUserHandler)
+	admin.POST("/users/:user_id/password-reset", guard.RequirePermission("users:write"), h.TriggerPasswordResetHandler)
+
+	// sources refused after credential stuffing
+	admin.GET("/login-blocks", guard.RequirePermission("security:read"), h.ListLoginBlocksHandler)
+	admin.DELETE("/login-blocks", guard.RequirePermission("security:write"), h.UnblockLoginHandler)
+}
+
+func registerOrgRoutes(r *gin.Engine, h *OrgHandler, verifier CredentialVerifier, guard *Guard) {
+	// memberships are managed with the user's own tokens only: api keys and
+	// clients would act beyond their scopes
+	orgs := r.Group("/orgs", authMiddleware(verifier), rejectDelegated)
+
+	orgs.POST("", h.CreateOrganizationHandler)
+	orgs.GET("", h.ListOrganizationsHandler)
+	// a switched token is a full token: impersonators would shed the act claim
+	orgs.POST("/switch", rejectImpersonation, h.SwitchOrganizationHandler)
+	orgs.POST("/invitations/accept", rejectImpersonation, h.AcceptInvitationHandler)
+	orgs.GET("/:org_id/members", h.ListMembersHandler)
+	orgs.POST("/:org_id/invitations", h.InviteMemberHandler)
+
+	admin := r.Group("/admin", authMiddleware(verifier))
+
+	admin.POST("/orgs", guard.RequirePermission("orgs:write"), h.CreateTenantHandler)
+	admin.POST("/orgs/:org_id/invitations", guard.RequirePermission("orgs:write"), h.AdminInviteMemberHandler)
+}
+
+// registerAuthzRoutes serves other services: they forward the user's token and
+// ask what the user may do.
+func registerAuthzRoutes(r *gin.Engine, h *AuthzHandler, verifier CredentialVerifier) {
+	authz := r.Group("/authz", authMiddleware(verifier))
+
+	authz.POST("/check", h.CheckHandler)
+	authz.POST("/check/batch", h.CheckBatchHandler)
+}
+
+func registerOAuthRoutes(r *gin.Engine, h *OAuthHandler, verifier CredentialVerifier, guard *Guard) {
+	r.POST("/oauth/token", h.TokenHandler)
+
+	// the consent screen of the SPA calls these with the token of the user
+	authorize := r.Group("/oauth/authorize", authMiddleware(verifier), rejectImpersonation, rejectDelegated)
+
+	authorize.GET("", h.AuthorizeHandler)
+	authorize.POST("", h.ConsentHandler)
+
+	r.POST("/oauth/device_authorization", h.DeviceAuthorizationHandler)
+
+	// the verification page where users enter the code shown by a device
+	device := r.Group("/oauth/device", authMiddleware(verifier), rejectImpersonation, rejectDelegated)
+
+	device.GET("", h.DeviceVerificationHandler)
+	device.POST("", h.DeviceDecisionHandler)
+
+	admin := r.Group("/admin/oauth", authMiddleware(verifier))
+
+	admin.POST("/clients", guard.RequirePermission("clients:write"), h.CreateClientHandler)
+	admin.GET("/clients", guard.RequirePermission("clients:read"), h.ListClientsHandler)
+}
+
+func registerOIDCRoutes(r *gin.Engine, h *OIDCHandler, verifier CredentialVerifier) {
+	r.GET("/.well-known/openid-configuration", h.DiscoveryHandler)
+	r.GET("/.well-known/jwks.json", h.KeySetHandler)
+
+	r.GET("/userinfo", authMiddleware(verifier), h.UserInfoHandler)
+	r.POST("/userinfo", authMiddleware(verifier), h.UserInfoHandler)
+}
+
+// registerAPIKeyRoutes serves the keys of the authenticated user and, to
+// administrators, the keys of services. Keys are managed with the user's own
+// tokens only.
+func registerAPIKeyRoutes(r *gin.Engine, h *APIKeyHandler, verifier CredentialVerifier, guard *Guard) {
+	keys := r.Group("/users/api-keys", authMiddleware(verifier), rejectDelegated)
+
+	keys.POST("", rejectImpersonation, h.CreateUserAPIKeyHandler)
+	keys.GET("", h.ListUserAPIKeysHandler)
+	keys.DELETE("/:key_id", h.RevokeUserAPIKeyHandler)
+
+	admin := r.Group("/admin/api-keys", authMiddleware(verifier), rejectDelegated)
+
+	admin.POST("", rejectImpersonation, guard.RequirePermission("apikeys:write"), h.CreateServiceAPIKeyHandler)
+	admin.GET("", guard.RequirePermission("apikeys:read"), h.ListServiceAPIKeysHandler)
+	admin.DELETE("/:key_id", guard.RequirePermission("apikeys:write"), h.RevokeServiceAPIKeyHandler)
+}
+
+// registerSocialRoutes serves the login with external identity providers. The
+// page registered as redirect URL at a provider forwards its query to the
+// callback.
+func registerSocialRoutes(r *gin.Engine, h *SocialHandler, verifier CredentialVerifier, guard *Guard) {
+	r.GET("/auth/providers", h.ListProvidersHandler)
+	r.GET("/auth/:provider/login", h.StartLoginHandler)
+	r.GET("/auth/:provider/callback", h.CallbackHandler)
+	r.POST("/auth/link", h.ConfirmLinkHandler)
+
+	identities := r.Group("/users/identities", authMiddleware(verifier), rejectDelegated)
+
+	identities.GET("", h.ListIdentitiesHandler)
+	identities.DELETE("/:provider", rejectImpersonation, guard.RequireRecentAuth, h.UnlinkHandler)
+}
+
+// registerSAMLRoutes serves the service provider of each isolated
+// organization, named by its slug, and lets administrators connect the
+// identity provider of an organization.
+func registerSAMLRoutes(r *gin.Engine, h *SAMLHandler, verifier CredentialVerifier, guard *Guard) {
+	r.GET("/saml/:slug/metadata", h.MetadataHandler)
+	r.GET("/saml/:slug/login", h.LoginHandler)
+	r.POST("/saml/:slug/acs", h.ACSHandler)
+
+	admin := r.Group("/admin/orgs/:org_id/saml", authMiddleware(verifier))
+
+	admin.PUT("", guard.RequirePermission("orgs:write"), h.PutConnectionHandler)
+	admin.GET("", guard.RequirePermission("orgs:read"), h.GetConnectionHandler)
+	admin.DELETE("", guard.RequirePermission("orgs:write"), h.DeleteConnectionHandler)
 }
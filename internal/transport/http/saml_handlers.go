@@ -0,0 +1,248 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type SAMLHandler struct {
+	samlService SAMLService
+	logger      logger.Logger
+}
+
+type SAMLService interface {
+	Metadata(slug string) ([]byte, error)
+	StartLogin(slug string) (string, error)
+	ConsumeResponse(slug, samlResponse string) (string, string, error)
+	PutConnection(connection *models.SAMLConnection) error
+	GetConnection(orgID int64) (models.SAMLConnection, error)
+	DeleteConnection(orgID int64) error
+}
+
+func NewSAMLHandler(samlService SAMLService, logger logger.Logger) *SAMLHandler {
+	return &SAMLHandler{
+		samlService: samlService,
+		logger:      logger,
+	}
+}
+
+type tenantRequest struct {
+	Slug string `uri:"slug" binding:"required"`
+}
+
+type acsRequest struct {
+	SAMLResponse string `form:"SAMLResponse" binding:"required"`
+}
+
+type samlConnectionRequest struct {
+	IdPEntityID        string `json:"idp_entity_id"`
+	SSOURL             string `json:"sso_url"`
+	Certificate        string `json:"certificate"`
+	EmailAttribute     string `json:"email_attribute"`
+	FirstNameAttribute string `json:"first_name_attribute"`
+	LastNameAttribute  string `json:"last_name_attribute"`
+	AllowIdPInitiated  bool   `json:"allow_idp_initiated"`
+}
+
+// MetadataHandler serves the metadata the administrators of the tenant load
+// into their identity provider.
+func (h *SAMLHandler) MetadataHandler(ctx *gin.Context) {
+	const op = "MetadataHandler"
+
+	var req tenantRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	metadata, err := h.samlService.Metadata(req.Slug)
+	if err != nil {
+		h.logger.Error("%s: h.samlService.Metadata: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// LoginHandler returns the URL of the identity provider of the tenant the
+// browser is sent to.
+func (h *SAMLHandler) LoginHandler(ctx *gin.Context) {
+	const op = "LoginHandler"
+
+	var req tenantRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	redirectTo, err := h.samlService.StartLogin(req.Slug)
+	if err != nil {
+		h.logger.Error("%s: h.samlService.StartLogin: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"redirect_to": redirectTo})
+}
+
+// ACSHandler is the assertion consumer service: the browser posts the
+// response of the identity provider here.
+func (h *SAMLHandler) ACSHandler(ctx *gin.Context) {
+	const op = "ACSHandler"
+
+	var uri tenantRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	var req acsRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		h.logger.Error("%s: ShouldBind: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	accessToken, redirectTo, err := h.samlService.ConsumeResponse(uri.Slug, req.SAMLResponse)
+	if err != nil {
+		h.logger.Error("%s: h.samlService.ConsumeResponse: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	if redirectTo != "" {
+		ctx.Redirect(http.StatusSeeOther, redirectTo)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"access-token": accessToken})
+}
+
+func (h *SAMLHandler) PutConnectionHandler(ctx *gin.Context) {
+	const op = "PutConnectionHandler"
+
+	var uri orgRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	var req samlConnectionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	connection := &models.SAMLConnection{
+		OrgID:              uri.OrgID,
+		IdPEntityID:        req.IdPEntityID,
+		SSOURL:             req.SSOURL,
+		Certificate:        req.Certificate,
+		EmailAttribute:     req.EmailAttribute,
+		FirstNameAttribute: req.FirstNameAttribute,
+		LastNameAttribute:  req.LastNameAttribute,
+		AllowIdPInitiated:  req.AllowIdPInitiated,
+	}
+
+	err := h.samlService.PutConnection(connection)
+	if err != nil {
+		h.logger.Error("%s: h.samlService.PutConnection: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"saml_connection": connection})
+}
+
+func (h *SAMLHandler) GetConnectionHandler(ctx *gin.Context) {
+	const op = "GetConnectionHandler"
+
+	var uri orgRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	connection, err := h.samlService.GetConnection(uri.OrgID)
+	if err != nil {
+		h.logger.Error("%s: h.samlService.GetConnection: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"saml_connection": connection})
+}
+
+func (h *SAMLHandler) DeleteConnectionHandler(ctx *gin.Context) {
+	const op = "DeleteConnectionHandler"
+
+	var uri orgRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.samlService.DeleteConnection(uri.OrgID)
+	if err != nil {
+		h.logger.Error("%s: h.samlService.DeleteConnection: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "saml connection deleted"})
+}
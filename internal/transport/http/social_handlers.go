@@ -0,0 +1,206 @@
+package http
+
+import (
+	"errors"
+	"fullstack-simple-app/internal/errcode"
+	"fullstack-simple-app/internal/models"
+	"fullstack-simple-app/pkg/app_errors"
+	"fullstack-simple-app/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type SocialHandler struct {
+	socialService SocialService
+	logger        logger.Logger
+}
+
+type SocialService interface {
+	Providers() []string
+	StartLogin(provider string) (string, error)
+	CompleteLogin(provider, code, state string) (models.SignIn, error)
+	ConfirmLink(linkToken, password string, client models.ClientInfo) (models.SignIn, error)
+	ListIdentities(userID int64) ([]models.Identity, error)
+	Unlink(userID int64, provider string) error
+}
+
+func NewSocialHandler(socialService SocialService, logger logger.Logger) *SocialHandler {
+	return &SocialHandler{
+		socialService: socialService,
+		logger:        logger,
+	}
+}
+
+type providerRequest struct {
+	Provider string `uri:"provider" binding:"required"`
+}
+
+type socialCallbackRequest struct {
+	Code  string `form:"code"`
+	State string `form:"state" binding:"required"`
+	// Error is set instead of the code when the user cancels at the provider
+	Error string `form:"error"`
+}
+
+type confirmLinkRequest struct {
+	LinkToken string `json:"link_token" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+}
+
+func (h *SocialHandler) ListProvidersHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"providers": h.socialService.Providers()})
+}
+
+// StartLoginHandler returns the URL of the provider the browser is sent to.
+func (h *SocialHandler) StartLoginHandler(ctx *gin.Context) {
+	const op = "StartLoginHandler"
+
+	var req providerRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	authURL, err := h.socialService.StartLogin(req.Provider)
+	if err != nil {
+		h.logger.Error("%s: h.socialService.StartLogin: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"redirect_to": authURL})
+}
+
+// CallbackHandler takes the code and the state the provider sent back, as
+// forwarded by the page registered as the redirect URL.
+func (h *SocialHandler) CallbackHandler(ctx *gin.Context) {
+	const op = "CallbackHandler"
+
+	var uri providerRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	var req socialCallbackRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	if req.Error != "" || req.Code == "" {
+		h.logger.Error("%s: provider returned %q", op, req.Error)
+		respondWithError(ctx, statusFromCode(errcode.ErrSocialLoginFailed), errcode.ErrSocialLoginFailed, "", nil)
+		return
+	}
+
+	result, err := h.socialService.CompleteLogin(uri.Provider, req.Code, req.State)
+	if err != nil {
+		h.logger.Error("%s: h.socialService.CompleteLogin: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	if respondWithNextStep(ctx, result) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"access-token": result.AccessToken})
+}
+
+// ConfirmLinkHandler links the identity to the existing account with the
+// same email once the user enters its password.
+func (h *SocialHandler) ConfirmLinkHandler(ctx *gin.Context) {
+	const op = "ConfirmLinkHandler"
+
+	var req confirmLinkRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	result, err := h.socialService.ConfirmLink(req.LinkToken, req.Password, clientInfo(ctx))
+	if err != nil {
+		h.logger.Error("%s: h.socialService.ConfirmLink: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	if respondWithNextStep(ctx, result) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"access-token": result.AccessToken})
+}
+
+func (h *SocialHandler) ListIdentitiesHandler(ctx *gin.Context) {
+	const op = "ListIdentitiesHandler"
+
+	identities, err := h.socialService.ListIdentities(authPayload(ctx).UserID)
+	if err != nil {
+		h.logger.Error("%s: h.socialService.ListIdentities: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+func (h *SocialHandler) UnlinkHandler(ctx *gin.Context) {
+	const op = "UnlinkHandler"
+
+	var req providerRequest
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		h.logger.Error("%s: ShouldBindUri: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	err := h.socialService.Unlink(authPayload(ctx).UserID, req.Provider)
+	if err != nil {
+		h.logger.Error("%s: h.socialService.Unlink: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "identity unlinked"})
+}
@@ -16,11 +16,23 @@ type UserHandler struct {
 }
 
 type UserService interface {
-	RegisterUser(user *models.User, password string) error
-	VerifyUser(email string, otp string) (models.User, string, error)
-	ResendCode(email string) error
-	UserSignIn(email string, password string) (string, error)
-	GetUser(email string) (models.User, error)
+	RegisterUser(org string, user *models.User, password string) error
+	VerifyUser(org string, email string, otp string) (models.User, string, error)
+	ResendCode(org string, email string, channel string) error
+	UserSignIn(org string, email string, password string, client models.ClientInfo, deviceToken string) (models.SignIn, error)
+	CompleteMFA(mfaToken string, code string, remember bool, client models.ClientInfo) (models.SignIn, models.RememberedDevice, error)
+	Reauthenticate(userID int64, orgID int64, password string, amr []string, ip string) (string, error)
+	GetUser(org string, email string) (models.User, error)
+	ChangePassword(userID int64, currentPassword string, newPassword string) error
+	RequestPasswordReset(org string, email string) error
+	ResetPassword(org string, email string, otp string, newPassword string) error
+	SetMFA(userID int64, enabled bool, method string) (models.User, error)
+	SetPhone(userID int64, phone string) (models.User, error)
+	VerifyPhone(userID int64, otp string) (models.User, error)
+	ListTrustedDevices(userID int64) ([]models.TrustedDevice, error)
+	RevokeTrustedDevice(userID int64, deviceID int64) error
+	RevokeTrustedDevices(userID int64) error
+	ReportLogin(token string) error
 }
 
 func NewUserHandler(userService UserService, logger logger.Logger) *UserHandler {
@@ -35,6 +47,9 @@ type registerUserRequest struct {
 	LastName  string `json:"last_name" binding:"required"`
 	Email     string `json:"email" binding:"required"`
 	Password  string `json:"password" biding:"required"`
+	Org       string `json:"org"`
+	// Phone, when given, gets the activation code instead of the email.
+	Phone string `json:"phone"`
 }
 
 func (h *UserHandler) RegisterUserHandler(ctx *gin.Context) {
@@ -51,9 +66,10 @@ func (h *UserHandler) RegisterUserHandler(ctx *gin.Context) {
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
 		Email:     req.Email,
+		Phone:     req.Phone,
 	}
 
-	err := h.userService.RegisterUser(user, req.Password)
+	err := h.userService.RegisterUser(req.Org, user, req.Password)
 	if err != nil {
 		h.logger.Error("%s: h.userService.RegisterUser: %v", op, err)
 
@@ -73,6 +89,7 @@ func (h *UserHandler) RegisterUserHandler(ctx *gin.Context) {
 type verifyUserRequest struct {
 	Code  string `json:"code" binding:"required"`
 	Email string `json:"email" binding:"required"`
+	Org   string `json:"org"`
 }
 
 const loginHTMLPath = "../html/login.html"
@@ -87,7 +104,7 @@ func (h *UserHandler) VerifyUserHandler(ctx *gin.Context) {
 		return
 	}
 
-	user, token, err := h.userService.VerifyUser(req.Email, req.Code)
+	user, token, err := h.userService.VerifyUser(req.Org, req.Email, req.Code)
 	if err != nil {
 		h.logger.Error("%s: h.userService.VerifyUser: %v", op, err)
 
@@ -110,6 +127,9 @@ func (h *UserHandler) VerifyUserHandler(ctx *gin.Context) {
 
 type resendCodeRequest struct {
 	Email string `json:"email" binding:"required"`
+	Org   string `json:"org"`
+	// Channel is "email", the default, or "sms".
+	Channel string `json:"channel"`
 }
 
 func (h *UserHandler) ResendCodeHandler(ctx *gin.Context) {
@@ -122,7 +142,7 @@ func (h *UserHandler) ResendCodeHandler(ctx *gin.Context) {
 		return
 	}
 
-	err := h.userService.ResendCode(req.Email)
+	err := h.userService.ResendCode(req.Org, req.Email, req.Channel)
 	if err != nil {
 		h.logger.Error("%s: h.userService.ResendCode: %v", op, err)
 
@@ -142,6 +162,7 @@ func (h *UserHandler) ResendCodeHandler(ctx *gin.Context) {
 type loginRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required,min=6"`
+	Org      string `json:"org"`
 }
 
 func (h *UserHandler) LoginHandler(ctx *gin.Context) {
@@ -154,7 +175,10 @@ func (h *UserHandler) LoginHandler(ctx *gin.Context) {
 		return
 	}
 
-	accessToken, err := h.userService.UserSignIn(req.Email, req.Password)
+	// a missing cookie just means the device isn't trusted
+	deviceToken, _ := ctx.Cookie(trustedDeviceCookie)
+
+	result, err := h.userService.UserSignIn(req.Org, req.Email, req.Password, loginClientInfo(ctx), deviceToken)
 	if err != nil {
 		h.logger.Error("%s: h.userService.UserSignIn: %v", op, err)
 
@@ -168,6 +192,76 @@ func (h *UserHandler) LoginHandler(ctx *gin.Context) {
 		return
 	}
 
+	if respondWithNextStep(ctx, result) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"access-token": result.AccessToken})
+}
+
+// respondWithNextStep answers a sign in that isn't complete yet with the
+// limited token for the step the user has to take, and reports whether it
+// did.
+func respondWithNextStep(ctx *gin.Context, result models.SignIn) bool {
+	switch {
+	case result.MFAToken != "":
+		ctx.JSON(statusFromCode(errcode.ErrMFARequired), gin.H{
+			"error":     errcode.ErrMFARequired,
+			"message":   errcode.GetErrorMessage(errcode.ErrMFARequired),
+			"mfa-token": result.MFAToken,
+		})
+		return true
+	case result.PasswordChangeToken != "":
+		ctx.JSON(statusFromCode(errcode.ErrPasswordChangeRequired), gin.H{
+			"error":                 errcode.ErrPasswordChangeRequired,
+			"message":               errcode.GetErrorMessage(errcode.ErrPasswordChangeRequired),
+			"password-change-token": result.PasswordChangeToken,
+		})
+		return true
+	case result.LinkToken != "":
+		ctx.JSON(statusFromCode(errcode.ErrAccountLinkRequired), gin.H{
+			"error":      errcode.ErrAccountLinkRequired,
+			"message":    errcode.GetErrorMessage(errcode.ErrAccountLinkRequired),
+			"link-token": result.LinkToken,
+		})
+		return true
+	}
+
+	return false
+}
+
+type reauthenticateRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// ReauthenticateHandler confirms the identity of the signed in user before a
+// sensitive operation and returns an upgraded token for the session.
+func (h *UserHandler) ReauthenticateHandler(ctx *gin.Context) {
+	const op = "ReauthenticateHandler"
+
+	var req reauthenticateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		h.logger.Error("%s: ShouldBindJSON: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	payload := authPayload(ctx)
+
+	accessToken, err := h.userService.Reauthenticate(payload.UserID, payload.OrgID, req.Password, payload.AMR, ctx.ClientIP())
+	if err != nil {
+		h.logger.Error("%s: h.userService.Reauthenticate: %v", op, err)
+
+		var appErr *app_errors.AppError
+		if errors.As(err, &appErr) {
+			statusCode := statusFromCode(appErr.Code)
+			respondWithError(ctx, statusCode, appErr.Code, "", appErr)
+		} else {
+			respondWithError(ctx, http.StatusInternalServerError, errcode.ErrInternal, "", err)
+		}
+		return
+	}
+
 	ctx.JSON(http.StatusCreated, gin.H{"access-token": accessToken})
 }
 
@@ -175,6 +269,10 @@ type getUserRequest struct {
 	Email string `uri:"email" binding:"required"`
 }
 
+type getUserQuery struct {
+	Org string `form:"org"`
+}
+
 func (h *UserHandler) GetUserHandler(ctx *gin.Context) {
 	const op = "GetUserHandler"
 
@@ -185,7 +283,14 @@ func (h *UserHandler) GetUserHandler(ctx *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.GetUser(req.Email)
+	var query getUserQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		h.logger.Error("%s: ShouldBindQuery: %v", op, err)
+		respondWithError(ctx, http.StatusBadRequest, errcode.ErrInvalidRequest, "", nil)
+		return
+	}
+
+	user, err := h.userService.GetUser(query.Org, req.Email)
 	if err != nil {
 		h.logger.Error("%s: h.userService.GetUser: %v", op, err)
 
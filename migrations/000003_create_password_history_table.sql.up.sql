@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS password_history (
+    id              bigint          PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    password_hash   bytea           NOT NULL,
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS password_history_user_id_created_at_idx ON password_history (user_id, created_at DESC);
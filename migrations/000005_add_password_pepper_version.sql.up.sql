@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_pepper_version integer NOT NULL DEFAULT 0;
+ALTER TABLE password_history ADD COLUMN IF NOT EXISTS password_pepper_version integer NOT NULL DEFAULT 0;
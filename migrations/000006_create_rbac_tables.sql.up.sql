@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS roles (
+    role_id         integer         PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name            varchar(50)     UNIQUE NOT NULL,
+    description     text            NOT NULL DEFAULT '',
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE TABLE IF NOT EXISTS permissions (
+    permission_id   integer         PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name            varchar(100)    UNIQUE NOT NULL,
+    description     text            NOT NULL DEFAULT ''
+    );
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id         integer         NOT NULL REFERENCES roles (role_id) ON DELETE CASCADE,
+    permission_id   integer         NOT NULL REFERENCES permissions (permission_id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+    );
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    role_id         integer         NOT NULL REFERENCES roles (role_id) ON DELETE CASCADE,
+    created_at      timestamptz     NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+    );
+
+INSERT INTO roles (name, description) VALUES
+    ('user', 'Regular user'),
+    ('admin', 'Administrator with full access')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'View any user'),
+    ('users:write', 'Manage any user'),
+    ('roles:read', 'View roles and permissions'),
+    ('roles:write', 'Assign and revoke roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.user_id, r.role_id FROM users u CROSS JOIN roles r
+WHERE r.name = 'user'
+ON CONFLICT DO NOTHING;
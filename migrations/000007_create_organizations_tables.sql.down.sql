@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE name IN ('orgs:read', 'orgs:write');
+
+DELETE FROM users WHERE tenant_org_id IS NOT NULL;
+DROP INDEX IF EXISTS users_tenant_email_key;
+DROP INDEX IF EXISTS users_email_key;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_org_id;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    org_id          integer         PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name            varchar(100)    NOT NULL,
+    slug            citext          UNIQUE NOT NULL,
+    isolated        boolean         NOT NULL DEFAULT false,
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE TABLE IF NOT EXISTS org_memberships (
+    org_id          integer         NOT NULL REFERENCES organizations (org_id) ON DELETE CASCADE,
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    role            varchar(50)     NOT NULL DEFAULT 'member',
+    created_at      timestamptz     NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+    );
+
+CREATE INDEX IF NOT EXISTS org_memberships_user_id_idx ON org_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    invitation_id   bigint          PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    org_id          integer         NOT NULL REFERENCES organizations (org_id) ON DELETE CASCADE,
+    email           citext          NOT NULL,
+    role            varchar(50)     NOT NULL DEFAULT 'member',
+    token_hash      bytea           UNIQUE NOT NULL,
+    invited_by      integer         REFERENCES users (user_id) ON DELETE SET NULL,
+    expires_at      timestamptz     NOT NULL,
+    accepted_at     timestamptz,
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+-- Users of an isolated organization (tenant) live in their own namespace:
+-- the same email may exist once globally and once in every isolated tenant.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_org_id integer REFERENCES organizations (org_id) ON DELETE CASCADE;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE tenant_org_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_key ON users (tenant_org_id, email) WHERE tenant_org_id IS NOT NULL;
+
+INSERT INTO permissions (name, description) VALUES
+    ('orgs:read', 'View any organization'),
+    ('orgs:write', 'Create isolated organizations (tenants)')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.name IN ('orgs:read', 'orgs:write')
+ON CONFLICT DO NOTHING;
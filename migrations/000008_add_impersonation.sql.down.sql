@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'users:impersonate';
+DELETE FROM roles WHERE name = 'support';
+
+DROP TABLE IF EXISTS audit_events;
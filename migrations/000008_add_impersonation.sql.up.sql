@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    event_id        bigint          PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    event_type      varchar(100)    NOT NULL,
+    actor_user_id   integer         REFERENCES users (user_id) ON DELETE SET NULL,
+    subject_user_id integer         REFERENCES users (user_id) ON DELETE SET NULL,
+    ip              varchar(45)     NOT NULL DEFAULT '',
+    details         jsonb           NOT NULL DEFAULT '{}',
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS audit_events_subject_user_id_idx ON audit_events (subject_user_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_user_id_idx ON audit_events (actor_user_id, created_at);
+
+INSERT INTO roles (name, description) VALUES
+    ('support', 'Support engineer')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:impersonate', 'Act as another user')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r CROSS JOIN permissions p
+WHERE r.name IN ('admin', 'support') AND p.name = 'users:impersonate'
+ON CONFLICT DO NOTHING;
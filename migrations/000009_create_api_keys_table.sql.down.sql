@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name IN ('apikeys:read', 'apikeys:write');
+
+DROP TABLE IF EXISTS api_keys;
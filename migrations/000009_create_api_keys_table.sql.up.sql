@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id          bigint          PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name            varchar(100)    NOT NULL,
+    prefix          varchar(20)     NOT NULL,
+    key_hash        bytea           UNIQUE NOT NULL,
+    user_id         integer         REFERENCES users (user_id) ON DELETE CASCADE,
+    service         varchar(100),
+    scopes          text[]          NOT NULL DEFAULT '{}',
+    expires_at      timestamptz,
+    last_used_at    timestamptz,
+    revoked_at      timestamptz,
+    created_by      integer         REFERENCES users (user_id) ON DELETE SET NULL,
+    created_at      timestamptz     NOT NULL DEFAULT NOW(),
+    -- a key belongs either to a user or to a service
+    CHECK ((user_id IS NULL) <> (service IS NULL))
+    );
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('apikeys:read', 'View service API keys'),
+    ('apikeys:write', 'Create and revoke service API keys')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.name IN ('apikeys:read', 'apikeys:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name IN ('clients:read', 'clients:write');
+
+DROP TABLE IF EXISTS oauth_refresh_tokens;
+DROP TABLE IF EXISTS oauth_clients;
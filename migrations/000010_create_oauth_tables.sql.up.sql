@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    client_id       varchar(32)     PRIMARY KEY,
+    name            varchar(100)    NOT NULL,
+    -- NULL for public clients, which can't keep a secret
+    secret_hash     bytea,
+    redirect_uris   text[]          NOT NULL DEFAULT '{}',
+    scopes          text[]          NOT NULL DEFAULT '{}',
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE TABLE IF NOT EXISTS oauth_refresh_tokens (
+    token_hash      bytea           PRIMARY KEY,
+    client_id       varchar(32)     NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    org_id          integer         REFERENCES organizations (org_id) ON DELETE CASCADE,
+    scopes          text[]          NOT NULL DEFAULT '{}',
+    expires_at      timestamptz     NOT NULL,
+    revoked_at      timestamptz,
+    created_at      timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS oauth_refresh_tokens_user_id_idx ON oauth_refresh_tokens (user_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('clients:read', 'View OAuth clients'),
+    ('clients:write', 'Register OAuth clients')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.name IN ('clients:read', 'clients:write')
+ON CONFLICT DO NOTHING;
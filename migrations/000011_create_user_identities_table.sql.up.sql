@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider        varchar(50)     NOT NULL,
+    -- the ID of the user at the provider, stable unlike the email
+    subject         varchar(255)    NOT NULL,
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    email           citext,
+    created_at      timestamptz     NOT NULL DEFAULT NOW(),
+    last_login_at   timestamptz,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (user_id, provider)
+    );
@@ -0,0 +1,15 @@
+-- the SAML identity provider of an isolated organization (tenant)
+CREATE TABLE IF NOT EXISTS saml_connections (
+    org_id                  integer         PRIMARY KEY REFERENCES organizations (org_id) ON DELETE CASCADE,
+    idp_entity_id           text            NOT NULL,
+    sso_url                 text            NOT NULL,
+    -- PEM encoded signing certificate of the identity provider
+    certificate             text            NOT NULL,
+    -- attributes holding the user fields; an empty email attribute uses the NameID
+    email_attribute         varchar(255)    NOT NULL DEFAULT '',
+    first_name_attribute    varchar(255)    NOT NULL DEFAULT '',
+    last_name_attribute     varchar(255)    NOT NULL DEFAULT '',
+    allow_idp_initiated     boolean         NOT NULL DEFAULT false,
+    created_at              timestamptz     NOT NULL DEFAULT NOW(),
+    updated_at              timestamptz     NOT NULL DEFAULT NOW()
+    );
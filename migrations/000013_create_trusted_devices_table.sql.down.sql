@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS trusted_devices;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_enabled;
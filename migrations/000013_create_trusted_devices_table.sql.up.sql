@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_enabled boolean NOT NULL DEFAULT false;
+
+-- browsers that may skip the second factor until expires_at
+CREATE TABLE IF NOT EXISTS trusted_devices (
+    device_id       bigserial       PRIMARY KEY,
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    -- SHA-256 of the secret kept in the remember-me cookie
+    token_hash      bytea           NOT NULL UNIQUE,
+    user_agent      text            NOT NULL DEFAULT '',
+    ip              varchar(45)     NOT NULL DEFAULT '',
+    created_at      timestamptz     NOT NULL DEFAULT NOW(),
+    last_used_at    timestamptz     NOT NULL DEFAULT NOW(),
+    expires_at      timestamptz     NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS trusted_devices_user_id_idx ON trusted_devices (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_method;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
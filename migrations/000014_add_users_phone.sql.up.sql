@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone varchar(16);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_verified boolean NOT NULL DEFAULT false;
+-- how the second factor is delivered: 'email' or 'sms' (to the verified phone)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_method varchar(10) NOT NULL DEFAULT 'email';
@@ -0,0 +1,13 @@
+-- devices the user signed in from, to tell them about logins from new ones
+CREATE TABLE IF NOT EXISTS login_devices (
+    device_id       bigserial       PRIMARY KEY,
+    user_id         integer         NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    -- SHA-256 of the device cookie
+    cookie_hash     bytea,
+    user_agent      text            NOT NULL DEFAULT '',
+    ip_prefix       varchar(50)     NOT NULL DEFAULT '',
+    created_at      timestamptz     NOT NULL DEFAULT NOW(),
+    last_seen_at    timestamptz     NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS login_devices_user_id_idx ON login_devices (user_id);
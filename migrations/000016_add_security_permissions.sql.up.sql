@@ -0,0 +1,9 @@
+INSERT INTO permissions (name, description) VALUES
+    ('security:read', 'View the sources of failed logins that are blocked'),
+    ('security:write', 'Unblock the sources of failed logins')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.role_id, p.permission_id FROM roles r CROSS JOIN permissions p
+WHERE r.name = 'admin' AND p.name IN ('security:read', 'security:write')
+ON CONFLICT DO NOTHING;
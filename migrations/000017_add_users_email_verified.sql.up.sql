@@ -0,0 +1,3 @@
+-- activation may go by a texted code, which proves the phone but not the email
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT false;
+UPDATE users SET email_verified = activated;
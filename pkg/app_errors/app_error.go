@@ -1,8 +1,11 @@
 package app_errors
 
+import "fmt"
+
 type AppError struct {
-	Code string
-	Err  error
+	Code   string
+	Err    error
+	Fields map[string]string
 }
 
 func (e *AppError) Error() string {
@@ -18,6 +21,12 @@ func NewAppError(code string, err error) *AppError {
 	return &AppError{Code: code, Err: err}
 }
 
+// NewValidationError builds an AppError that carries the per-field errors
+// collected by a validator, so they can be returned to the client as is.
+func NewValidationError(code string, fields map[string]string) *AppError {
+	return &AppError{Code: code, Err: fmt.Errorf("%v", fields), Fields: fields}
+}
+
 // Optional: a quick check function
 func IsAppError(err error) bool {
 	_, ok := err.(*AppError)
@@ -0,0 +1,104 @@
+package challenge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrCaptchaFailed = errors.New("captcha was not solved")
+
+// Verifier checks the response token of a CAPTCHA solved in the browser.
+type Verifier interface {
+	Verify(response string, remoteIP string) error
+}
+
+// SiteVerifyConfig describes the siteverify API shared by hCaptcha,
+// Cloudflare Turnstile and reCAPTCHA, e.g.
+// https://challenges.cloudflare.com/turnstile/v0/siteverify.
+type SiteVerifyConfig struct {
+	URL     string
+	Secret  string
+	Timeout time.Duration
+}
+
+type SiteVerify struct {
+	config SiteVerifyConfig
+	client *http.Client
+}
+
+func NewSiteVerify(cfg SiteVerifyConfig) (*SiteVerify, error) {
+	if cfg.URL == "" || cfg.Secret == "" {
+		return nil, fmt.Errorf("%w: siteverify url and secret must be provided", ErrInvalidConfig)
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	return &SiteVerify{config: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+type siteVerifyResult struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify asks the provider whether the response is a solved CAPTCHA.
+func (s *SiteVerify) Verify(response string, remoteIP string) error {
+	if response == "" {
+		return ErrCaptchaFailed
+	}
+
+	form := url.Values{
+		"secret":   {s.config.Secret},
+		"response": {response},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	resp, err := s.client.PostForm(s.config.URL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("siteverify answered %s", resp.Status)
+	}
+
+	var result siteVerifyResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrCaptchaFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
+
+// Stub accepts the one response it was given, standing in for a provider
+// in development and tests.
+type Stub struct {
+	response string
+}
+
+func NewStub(response string) *Stub {
+	return &Stub{response: response}
+}
+
+func (s *Stub) Verify(response string, remoteIP string) error {
+	if s.response == "" || response != s.response {
+		return ErrCaptchaFailed
+	}
+
+	return nil
+}
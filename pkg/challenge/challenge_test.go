@@ -0,0 +1,129 @@
+package challenge
+
+import (
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProofOfWork(t *testing.T) {
+	p, err := NewProofOfWork([]byte(util.RandomString(32)), 8, time.Minute)
+	require.NoError(t, err)
+
+	c, err := p.Issue()
+	require.NoError(t, err)
+	require.Equal(t, 8, c.Difficulty)
+	require.WithinDuration(t, time.Now().Add(time.Minute), c.ExpiresAt, 2*time.Second)
+
+	solution := Solve(c)
+
+	verified, err := p.Verify(c.Token, solution)
+	require.NoError(t, err)
+	require.Equal(t, c, verified)
+
+	// some of the other solutions may solve it as well, but not all of them
+	var wrong int
+	for n := 0; n < 16; n++ {
+		if _, err = p.Verify(c.Token, util.RandomString(8)); err != nil {
+			require.ErrorIs(t, err, ErrWrongSolution)
+			wrong++
+		}
+	}
+	require.NotZero(t, wrong)
+
+	_, err = p.Verify(c.Token, "")
+	require.ErrorIs(t, err, ErrWrongSolution)
+}
+
+func TestProofOfWorkForged(t *testing.T) {
+	p, err := NewProofOfWork([]byte(util.RandomString(32)), 4, time.Minute)
+	require.NoError(t, err)
+
+	other, err := NewProofOfWork([]byte(util.RandomString(32)), 1, time.Minute)
+	require.NoError(t, err)
+
+	// a challenge of another key, e.g. one with a lower difficulty
+	c, err := other.Issue()
+	require.NoError(t, err)
+
+	_, err = p.Verify(c.Token, Solve(c))
+	require.ErrorIs(t, err, ErrInvalidChallenge)
+
+	_, err = p.Verify(util.RandomString(20), "0")
+	require.ErrorIs(t, err, ErrInvalidChallenge)
+}
+
+func TestProofOfWorkExpired(t *testing.T) {
+	p, err := NewProofOfWork([]byte(util.RandomString(32)), 1, time.Second)
+	require.NoError(t, err)
+
+	c, err := p.Issue()
+	require.NoError(t, err)
+
+	p.ttl = -time.Hour
+	expired, err := p.Issue()
+	require.NoError(t, err)
+
+	_, err = p.Verify(expired.Token, Solve(expired))
+	require.ErrorIs(t, err, ErrExpiredChallenge)
+
+	_, err = p.Verify(c.Token, Solve(c))
+	require.NoError(t, err)
+}
+
+func TestNewProofOfWork(t *testing.T) {
+	_, err := NewProofOfWork([]byte("short"), 10, time.Minute)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = NewProofOfWork([]byte(util.RandomString(32)), 0, time.Minute)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = NewProofOfWork([]byte(util.RandomString(32)), 33, time.Minute)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = NewProofOfWork([]byte(util.RandomString(32)), 10, 0)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestSiteVerify(t *testing.T) {
+	secret := util.RandomString(16)
+	response := util.RandomString(32)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, secret, r.PostForm.Get("secret"))
+		require.Equal(t, "203.0.113.7", r.PostForm.Get("remoteip"))
+
+		if r.PostForm.Get("response") == response {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	s, err := NewSiteVerify(SiteVerifyConfig{URL: server.URL, Secret: secret})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Verify(response, "203.0.113.7"))
+
+	err = s.Verify(util.RandomString(32), "203.0.113.7")
+	require.ErrorIs(t, err, ErrCaptchaFailed)
+	require.Contains(t, err.Error(), "invalid-input-response")
+
+	_, err = NewSiteVerify(SiteVerifyConfig{URL: server.URL})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestStub(t *testing.T) {
+	response := util.RandomString(8)
+
+	s := NewStub(response)
+	require.NoError(t, s.Verify(response, ""))
+	require.ErrorIs(t, s.Verify(util.RandomString(8), ""), ErrCaptchaFailed)
+
+	require.ErrorIs(t, NewStub("").Verify("", ""), ErrCaptchaFailed)
+}
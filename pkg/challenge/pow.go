@@ -0,0 +1,148 @@
+// Package challenge keeps bots off the endpoints anyone may call: a
+// proof-of-work puzzle the server issues and checks without keeping state,
+// and the CAPTCHAs of hosted providers.
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidConfig    = errors.New("invalid challenge config")
+	ErrInvalidChallenge = errors.New("challenge is malformed or was not issued here")
+	ErrExpiredChallenge = errors.New("challenge has expired")
+	ErrWrongSolution    = errors.New("solution doesn't solve the challenge")
+)
+
+// maxSolutionLen bounds the solutions hashed: a counter takes 20 digits.
+const maxSolutionLen = 64
+
+// Challenge is a proof-of-work puzzle: find a solution such that the SHA-256
+// of Token, ":" and the solution starts with Difficulty zero bits.
+type Challenge struct {
+	Token      string    `json:"token"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ProofOfWork issues and checks the puzzles. The token carries the expiry
+// and the difficulty signed with the key, so any instance sharing the key
+// checks it without storage. Solving takes about 2^difficulty hashes.
+type ProofOfWork struct {
+	key        []byte
+	difficulty int
+	ttl        time.Duration
+}
+
+func NewProofOfWork(key []byte, difficulty int, ttl time.Duration) (*ProofOfWork, error) {
+	if len(key) < 16 {
+		return nil, fmt.Errorf("%w: key must have at least 16 bytes", ErrInvalidConfig)
+	}
+
+	if difficulty < 1 || difficulty > 32 {
+		return nil, fmt.Errorf("%w: difficulty must be from 1 to 32 bits", ErrInvalidConfig)
+	}
+
+	if ttl <= 0 {
+		return nil, fmt.Errorf("%w: ttl must be positive", ErrInvalidConfig)
+	}
+
+	return &ProofOfWork{key: key, difficulty: difficulty, ttl: ttl}, nil
+}
+
+// Issue returns a new puzzle of the configured difficulty.
+func (p *ProofOfWork) Issue() (Challenge, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return Challenge{}, err
+	}
+
+	expiresAt := time.Now().Add(p.ttl).Truncate(time.Second)
+
+	payload := fmt.Sprintf("%d.%d.%s", expiresAt.Unix(), p.difficulty, base64.RawURLEncoding.EncodeToString(nonce))
+
+	return Challenge{
+		Token:      payload + "." + p.sign(payload),
+		Difficulty: p.difficulty,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// Verify checks the solution of the puzzle and returns the puzzle. A puzzle
+// keeps the difficulty it was issued with.
+func (p *ProofOfWork) Verify(token string, solution string) (Challenge, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return Challenge{}, ErrInvalidChallenge
+	}
+
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(payload))) {
+		return Challenge{}, ErrInvalidChallenge
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Challenge{}, ErrInvalidChallenge
+	}
+
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Challenge{}, ErrInvalidChallenge
+	}
+
+	challenge := Challenge{Token: token, Difficulty: difficulty, ExpiresAt: time.Unix(expires, 0)}
+
+	if time.Now().After(challenge.ExpiresAt) {
+		return Challenge{}, ErrExpiredChallenge
+	}
+
+	if solution == "" || len(solution) > maxSolutionLen || !Solves(token, solution, difficulty) {
+		return Challenge{}, ErrWrongSolution
+	}
+
+	return challenge, nil
+}
+
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Solves reports whether the solution has the hash the puzzle asks for.
+func Solves(token string, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(token + ":" + solution))
+
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+
+	return zeros >= difficulty
+}
+
+// Solve finds the solution of the puzzle by counting up, as clients do.
+func Solve(challenge Challenge) string {
+	for n := uint64(0); ; n++ {
+		solution := strconv.FormatUint(n, 10)
+		if Solves(challenge.Token, solution, challenge.Difficulty) {
+			return solution
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package directory authenticates users against an LDAP directory: the entry
+// of the user is searched with a service account, then the password is
+// checked by binding as that entry.
+package directory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidConfig = errors.New("directory: invalid config")
+	// ErrUserNotFound is returned when no entry, or more than one, matches
+	// the username.
+	ErrUserNotFound       = errors.New("directory: user not found")
+	ErrInvalidCredentials = errors.New("directory: invalid credentials")
+)
+
+type Config struct {
+	// URL is ldap://host:389 or ldaps://host:636.
+	URL string
+	// StartTLS upgrades an ldap:// connection before anything is sent.
+	StartTLS bool
+	// CACertFile holds the PEM certificates the server is verified with, in
+	// addition to the system ones.
+	CACertFile         string
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the service account users are searched
+	// with; an empty BindDN searches anonymously.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of a user, %s is replaced with the escaped
+	// username, e.g. "(&(objectClass=person)(mail=%s))".
+	UserFilter         string
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	// GroupAttribute lists the groups on the entry of the user (memberOf).
+	GroupAttribute string
+	// GroupBaseDN and GroupFilter search the groups instead, for directories
+	// without memberOf; %s is replaced with the escaped DN of the user, e.g.
+	// "(&(objectClass=groupOfNames)(member=%s))".
+	GroupBaseDN string
+	GroupFilter string
+	Timeout     time.Duration
+}
+
+// Entry is the user as found in the directory. Groups are DNs.
+type Entry struct {
+	DN        string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string
+}
+
+// conn is the part of *ldap.Conn the directory uses.
+type conn interface {
+	StartTLS(config *tls.Config) error
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+type Directory struct {
+	cfg       Config
+	tlsConfig *tls.Config
+	dial      func() (conn, error)
+}
+
+func New(cfg Config) (*Directory, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be ldap:// or ldaps://", ErrInvalidConfig)
+	}
+
+	if u.Scheme == "ldaps" && cfg.StartTLS {
+		return nil, fmt.Errorf("%w: start_tls is for ldap:// urls", ErrInvalidConfig)
+	}
+
+	if cfg.BaseDN == "" || !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, fmt.Errorf("%w: base_dn and a user_filter with %%s are required", ErrInvalidConfig)
+	}
+
+	if cfg.GroupBaseDN != "" && !strings.Contains(cfg.GroupFilter, "%s") {
+		return nil, fmt.Errorf("%w: group_filter with %%s is required with group_base_dn", ErrInvalidConfig)
+	}
+
+	setDefault(&cfg.EmailAttribute, "mail")
+	setDefault(&cfg.FirstNameAttribute, "givenName")
+	setDefault(&cfg.LastNameAttribute, "sn")
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if cfg.CACertFile != "" {
+		data, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrInvalidConfig, cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	d := &Directory{cfg: cfg, tlsConfig: tlsConfig}
+	d.dial = func() (conn, error) {
+		c, err := ldap.DialURL(cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+		c.SetTimeout(cfg.Timeout)
+		return c, nil
+	}
+
+	return d, nil
+}
+
+// Authenticate finds the entry of the user and checks the password by
+// binding as it.
+func (d *Directory) Authenticate(username, password string) (Entry, error) {
+	// an empty password is an unauthenticated bind, which servers accept
+	if username == "" || password == "" {
+		return Entry{}, ErrInvalidCredentials
+	}
+
+	c, err := d.dial()
+	if err != nil {
+		return Entry{}, fmt.Errorf("directory: dial: %w", err)
+	}
+	defer c.Close()
+
+	if d.cfg.StartTLS {
+		if err = c.StartTLS(d.tlsConfig); err != nil {
+			return Entry{}, fmt.Errorf("directory: start tls: %w", err)
+		}
+	}
+
+	if err = d.bindService(c); err != nil {
+		return Entry{}, err
+	}
+
+	entry, err := d.findUser(c, username)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	err = c.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Entry{}, ErrInvalidCredentials
+		}
+		return Entry{}, fmt.Errorf("directory: bind user: %w", err)
+	}
+
+	if d.cfg.GroupBaseDN != "" {
+		// the groups may not be readable by the user
+		if err = d.bindService(c); err != nil {
+			return Entry{}, err
+		}
+
+		groups, err := d.findGroups(c, entry.DN)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Groups = append(entry.Groups, groups...)
+	}
+
+	return entry, nil
+}
+
+func (d *Directory) bindService(c conn) error {
+	var err error
+	if d.cfg.BindDN == "" {
+		err = c.Bind("", "")
+	} else {
+		err = c.Bind(d.cfg.BindDN, d.cfg.BindPassword)
+	}
+	if err != nil {
+		return fmt.Errorf("directory: bind service account: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Directory) findUser(c conn, username string) (Entry, error) {
+	attributes := []string{d.cfg.EmailAttribute, d.cfg.FirstNameAttribute, d.cfg.LastNameAttribute}
+	if d.cfg.GroupAttribute != "" {
+		attributes = append(attributes, d.cfg.GroupAttribute)
+	}
+
+	result, err := c.Search(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(d.cfg.UserFilter, ldap.EscapeFilter(username)),
+		attributes, nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return Entry{}, ErrUserNotFound
+		}
+		return Entry{}, fmt.Errorf("directory: search user: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return Entry{}, ErrUserNotFound
+	}
+
+	e := result.Entries[0]
+
+	entry := Entry{
+		DN:        e.DN,
+		Email:     e.GetAttributeValue(d.cfg.EmailAttribute),
+		FirstName: e.GetAttributeValue(d.cfg.FirstNameAttribute),
+		LastName:  e.GetAttributeValue(d.cfg.LastNameAttribute),
+	}
+	if d.cfg.GroupAttribute != "" {
+		entry.Groups = e.GetAttributeValues(d.cfg.GroupAttribute)
+	}
+
+	return entry, nil
+}
+
+func (d *Directory) findGroups(c conn, userDN string) ([]string, error) {
+	result, err := c.Search(ldap.NewSearchRequest(
+		d.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(d.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(d.cfg.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("directory: search groups: %w", err)
+	}
+
+	groups := make([]string, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		groups = append(groups, e.DN)
+	}
+
+	return groups, nil
+}
+
+// SameDN reports whether two DNs name the same entry, ignoring case and
+// spacing.
+func SameDN(a, b string) bool {
+	dnA, errA := ldap.ParseDN(a)
+	dnB, errB := ldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+
+	return dnA.EqualFold(dnB)
+}
+
+func setDefault(value *string, def string) {
+	if *value == "" {
+		*value = def
+	}
+}
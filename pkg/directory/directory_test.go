@@ -0,0 +1,175 @@
+package directory
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// fakeConn is a directory with one user in it.
+type fakeConn struct {
+	serviceDN, servicePassword string
+	user                       *ldap.Entry
+	password                   string
+	groups                     []string
+
+	boundAs  string
+	startTLS bool
+	filters  []string
+}
+
+func (c *fakeConn) StartTLS(*tls.Config) error {
+	c.startTLS = true
+	return nil
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	switch {
+	case username == c.serviceDN && password == c.servicePassword,
+		username == c.user.DN && password == c.password:
+		c.boundAs = username
+		return nil
+	default:
+		c.boundAs = ""
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+	}
+}
+
+func (c *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.boundAs != c.serviceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("not bound"))
+	}
+
+	c.filters = append(c.filters, req.Filter)
+
+	if strings.HasPrefix(req.BaseDN, "ou=groups") {
+		var entries []*ldap.Entry
+		if req.Filter == fmt.Sprintf("(member=%s)", ldap.EscapeFilter(c.user.DN)) {
+			for _, group := range c.groups {
+				entries = append(entries, ldap.NewEntry(group, nil))
+			}
+		}
+		return &ldap.SearchResult{Entries: entries}, nil
+	}
+
+	if req.Filter == fmt.Sprintf("(mail=%s)", ldap.EscapeFilter(c.user.GetAttributeValue("mail"))) {
+		return &ldap.SearchResult{Entries: []*ldap.Entry{c.user}}, nil
+	}
+
+	return &ldap.SearchResult{}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func newFakeDirectory(t *testing.T, cfg Config) (*Directory, *fakeConn) {
+	email := util.RandomEmail()
+
+	c := &fakeConn{
+		serviceDN:       "cn=service,dc=example,dc=com",
+		servicePassword: util.RandomString(12),
+		password:        util.RandomString(12),
+		user: ldap.NewEntry("uid="+util.RandomOwner()+",ou=people,dc=example,dc=com", map[string][]string{
+			"mail":      {email},
+			"givenName": {"Ada"},
+			"sn":        {"Lovelace"},
+			"memberOf":  {"cn=admins,ou=groups,dc=example,dc=com"},
+		}),
+		groups: []string{"cn=staff,ou=groups,dc=example,dc=com"},
+	}
+
+	cfg.URL = "ldap://ldap.example:389"
+	cfg.BindDN = c.serviceDN
+	cfg.BindPassword = c.servicePassword
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	cfg.UserFilter = "(mail=%s)"
+
+	d, err := New(cfg)
+	require.NoError(t, err)
+
+	d.dial = func() (conn, error) { return c, nil }
+
+	return d, c
+}
+
+func TestAuthenticate(t *testing.T) {
+	d, c := newFakeDirectory(t, Config{GroupAttribute: "memberOf"})
+
+	entry, err := d.Authenticate(c.user.GetAttributeValue("mail"), c.password)
+	require.NoError(t, err)
+	require.Equal(t, c.user.DN, entry.DN)
+	require.Equal(t, c.user.GetAttributeValue("mail"), entry.Email)
+	require.Equal(t, "Ada", entry.FirstName)
+	require.Equal(t, "Lovelace", entry.LastName)
+	require.Equal(t, []string{"cn=admins,ou=groups,dc=example,dc=com"}, entry.Groups)
+	require.False(t, c.startTLS)
+
+	_, err = d.Authenticate(c.user.GetAttributeValue("mail"), util.RandomString(12))
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = d.Authenticate(util.RandomEmail(), c.password)
+	require.ErrorIs(t, err, ErrUserNotFound)
+
+	// an empty password would be an anonymous bind
+	_, err = d.Authenticate(c.user.GetAttributeValue("mail"), "")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestAuthenticateEscapesFilter(t *testing.T) {
+	d, c := newFakeDirectory(t, Config{})
+
+	_, err := d.Authenticate("*)(uid=*", c.password)
+	require.ErrorIs(t, err, ErrUserNotFound)
+	require.Equal(t, []string{`(mail=\2a\29\28uid=\2a)`}, c.filters)
+}
+
+func TestAuthenticateGroupSearch(t *testing.T) {
+	d, c := newFakeDirectory(t, Config{
+		StartTLS:    true,
+		GroupBaseDN: "ou=groups,dc=example,dc=com",
+		GroupFilter: "(member=%s)",
+	})
+
+	entry, err := d.Authenticate(c.user.GetAttributeValue("mail"), c.password)
+	require.NoError(t, err)
+	require.Equal(t, c.groups, entry.Groups)
+	require.True(t, c.startTLS)
+}
+
+func TestNew(t *testing.T) {
+	valid := Config{URL: "ldaps://ldap.example", BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"}
+
+	_, err := New(valid)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{"scheme", func(cfg *Config) { cfg.URL = "https://ldap.example" }},
+		{"start tls on ldaps", func(cfg *Config) { cfg.StartTLS = true }},
+		{"no base dn", func(cfg *Config) { cfg.BaseDN = "" }},
+		{"filter without placeholder", func(cfg *Config) { cfg.UserFilter = "(uid=admin)" }},
+		{"group search without filter", func(cfg *Config) { cfg.GroupBaseDN = "ou=groups,dc=example,dc=com" }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := valid
+			tc.modify(&cfg)
+
+			_, err := New(cfg)
+			require.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
+
+func TestSameDN(t *testing.T) {
+	require.True(t, SameDN("CN=Admins,OU=Groups,DC=example,DC=com", "cn=admins, ou=groups, dc=example, dc=com"))
+	require.False(t, SameDN("cn=admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"))
+}
@@ -0,0 +1,83 @@
+// Package fingerprint describes the client of a login coarsely enough to
+// recognise the same device on its next login.
+package fingerprint
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// IPPrefix returns the network of the address: the /24 of IPv4 and the /48
+// of IPv6 addresses, so that addresses handed out by the same provider in
+// the same area match. Invalid addresses are returned unchanged.
+func IPPrefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+
+	addr = addr.Unmap()
+
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+
+	return prefix.String()
+}
+
+// browsers are checked in order: most user agents name several engines.
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+var systems = []struct{ token, name string }{
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// Browser names the browser and the operating system of the user agent for
+// people, e.g. "Chrome on Windows".
+func Browser(userAgent string) string {
+	browser := match(userAgent, browsers)
+	system := match(userAgent, systems)
+
+	switch {
+	case browser == "" && system == "":
+		return "Unknown browser"
+	case browser == "":
+		return "Unknown browser on " + system
+	case system == "":
+		return browser
+	default:
+		return browser + " on " + system
+	}
+}
+
+func match(userAgent string, known []struct{ token, name string }) string {
+	for _, k := range known {
+		if strings.Contains(userAgent, k.token) {
+			return k.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package fingerprint
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestIPPrefix(t *testing.T) {
+	testCases := []struct {
+		ip, prefix string
+	}{
+		{"192.168.10.77", "192.168.10.0/24"},
+		{"::ffff:192.168.10.77", "192.168.10.0/24"},
+		{"2001:db8:abcd:12::1", "2001:db8:abcd::/48"},
+		{"not an ip", "not an ip"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.prefix, IPPrefix(tc.ip), tc.ip)
+	}
+}
+
+func TestBrowser(t *testing.T) {
+	testCases := []struct {
+		userAgent, browser string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.5.0", "curl"},
+		{"", "Unknown browser"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.browser, Browser(tc.userAgent), tc.userAgent)
+	}
+}
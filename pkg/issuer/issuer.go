@@ -0,0 +1,326 @@
+// Package issuer verifies the RS256 JWTs of trusted external issuers, with
+// keys fetched from their JWKS URL or given up front.
+package issuer
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/pkg/jwks"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is tolerated on the time claims.
+	clockSkew = time.Minute
+	// minFetchInterval throttles the fetches of tokens with unknown key IDs,
+	// which anyone can make up.
+	minFetchInterval = 30 * time.Second
+)
+
+var (
+	ErrInvalidConfig = errors.New("issuer: invalid config")
+	ErrInvalidToken  = errors.New("issuer: token is invalid")
+	ErrExpiredToken  = errors.New("issuer: token has expired")
+)
+
+type Config struct {
+	// Issuer is the iss claim of the tokens.
+	Issuer string
+	// Audience must be one of the aud claim.
+	Audience string
+	JWKSURL  string
+	// Keys are PEM encoded RSA public keys, for issuers without a JWKS URL.
+	// A single key verifies tokens with any key ID; with several, tokens
+	// must carry the RFC 7638 thumbprint of their key as kid.
+	Keys []string
+	// RefreshInterval is how often the JWKS is fetched in Watch.
+	RefreshInterval time.Duration
+}
+
+// Claims of a verified token.
+type Claims map[string]interface{}
+
+// String returns the claim as a string; numbers are formatted without
+// exponent.
+func (c Claims) String(name string) string {
+	switch value := c[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64:
+		return fmt.Sprintf("%.0f", value)
+	default:
+		return ""
+	}
+}
+
+// Strings returns a claim holding a string or a list of them.
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// Time returns a NumericDate claim such as exp.
+func (c Claims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(f), 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+type Issuer struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      jwks.Set
+	fetchedAt time.Time
+}
+
+func New(cfg Config, client *http.Client) (*Issuer, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, fmt.Errorf("%w: issuer and audience are required", ErrInvalidConfig)
+	}
+
+	if (cfg.JWKSURL == "") == (len(cfg.Keys) == 0) {
+		return nil, fmt.Errorf("%w: %s needs either a jwks url or keys", ErrInvalidConfig, cfg.Issuer)
+	}
+
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = time.Hour
+	}
+
+	i := &Issuer{cfg: cfg, client: client}
+
+	for _, data := range cfg.Keys {
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, cfg.Issuer, err)
+		}
+		i.keys.Keys = append(i.keys.Keys, jwks.NewRSAKey(key))
+	}
+
+	if len(i.keys.Keys) == 1 {
+		i.keys.Keys[0].Kid = ""
+	}
+
+	return i, nil
+}
+
+func (i *Issuer) Name() string {
+	return i.cfg.Issuer
+}
+
+// Verify checks the signature of the token, then the issuer, the audience
+// and the time claims. The expiry is required.
+func (i *Issuer) Verify(ctx context.Context, token string) (Claims, error) {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := i.findKey(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		return key.RSAPublicKey()
+	}
+
+	parser := &jwt.Parser{
+		ValidMethods:         []string{jwt.SigningMethodRS256.Alg()},
+		UseJSONNumber:        true,
+		SkipClaimsValidation: true,
+	}
+
+	claims := jwt.MapClaims{}
+
+	_, err := parser.ParseWithClaims(token, claims, keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	c := Claims(claims)
+
+	if c.String("iss") != i.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	audience := false
+	for _, aud := range c.Strings("aud") {
+		audience = audience || aud == i.cfg.Audience
+	}
+	if !audience {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	now := time.Now()
+
+	exp, ok := c.Time("exp")
+	if !ok {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.Add(-clockSkew).After(exp) {
+		return nil, ErrExpiredToken
+	}
+
+	if nbf, ok := c.Time("nbf"); ok && now.Add(clockSkew).Before(nbf) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	if iat, ok := c.Time("iat"); ok && now.Add(clockSkew).Before(iat) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+
+	return c, nil
+}
+
+// Refresh fetches the keys from the JWKS URL. The keys in use are kept when
+// the fetch fails.
+func (i *Issuer) Refresh(ctx context.Context) error {
+	if i.cfg.JWKSURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, i.cfg.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	i.mu.Lock()
+	i.fetchedAt = time.Now()
+	i.mu.Unlock()
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("issuer: fetch %s: %w", i.cfg.JWKSURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("issuer: fetch %s: status %d", i.cfg.JWKSURL, resp.StatusCode)
+	}
+
+	var keys jwks.Set
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("issuer: decode %s: %w", i.cfg.JWKSURL, err)
+	}
+
+	i.mu.Lock()
+	i.keys = keys
+	i.mu.Unlock()
+
+	return nil
+}
+
+// Watch refreshes the keys every RefreshInterval until the context is done.
+// Refresh errors are passed to onError.
+func (i *Issuer) Watch(ctx context.Context, onError func(error)) {
+	if i.cfg.JWKSURL == "" {
+		return
+	}
+
+	ticker := time.NewTicker(i.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := i.Refresh(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// findKey returns the key with the ID from the cache. Unknown IDs, as after
+// a rotation, trigger a fetch, at most once per minFetchInterval.
+func (i *Issuer) findKey(ctx context.Context, kid string) (jwks.Key, error) {
+	i.mu.Lock()
+	key, err := i.keys.Find(kid)
+	fetch := err != nil && i.cfg.JWKSURL != "" && time.Since(i.fetchedAt) >= minFetchInterval
+	if fetch {
+		// concurrent requests wait for the next window instead of fetching too
+		i.fetchedAt = time.Now()
+	}
+	i.mu.Unlock()
+
+	if !fetch {
+		return key, err
+	}
+
+	if err = i.Refresh(ctx); err != nil {
+		return jwks.Key{}, err
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.keys.Find(kid)
+}
+
+// PeekIssuer returns the iss claim of the token without verifying it, to
+// pick the issuer that verifies it.
+func PeekIssuer(token string) (string, error) {
+	claims := jwt.MapClaims{}
+
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return Claims(claims).String("iss"), nil
+}
+
+func parsePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+
+	return key, nil
+}
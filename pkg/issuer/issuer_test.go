@@ -0,0 +1,192 @@
+package issuer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fullstack-simple-app/pkg/jwks"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// partner signs tokens and publishes its keys like an external issuer.
+type partner struct {
+	key     *rsa.PrivateKey
+	kid     string
+	issuer  string
+	server  *httptest.Server
+	fetches atomic.Int32
+}
+
+func newPartner(t *testing.T) *partner {
+	p := &partner{issuer: "https://partner.example/" + util.RandomString(6)}
+	p.rotate(t)
+
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.fetches.Add(1)
+		key := jwks.NewRSAKey(&p.key.PublicKey)
+		require.NoError(t, json.NewEncoder(w).Encode(jwks.Set{Keys: []jwks.Key{key}}))
+	}))
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *partner) rotate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p.key = key
+	p.kid = jwks.NewRSAKey(&key.PublicKey).Kid
+}
+
+func (p *partner) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+
+	signed, err := token.SignedString(p.key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func (p *partner) claims(audience string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   p.issuer,
+		"aud":   []string{"other", audience},
+		"sub":   util.RandomString(10),
+		"email": util.RandomEmail(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+	}
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	p := newPartner(t)
+	audience := util.RandomString(8)
+
+	i, err := New(Config{Issuer: p.issuer, Audience: audience, JWKSURL: p.server.URL}, p.server.Client())
+	require.NoError(t, err)
+
+	claims := p.claims(audience)
+
+	verified, err := i.Verify(context.Background(), p.sign(t, claims))
+	require.NoError(t, err)
+	require.Equal(t, claims["sub"], verified.String("sub"))
+	require.Equal(t, claims["email"], verified.String("email"))
+	require.Equal(t, int32(1), p.fetches.Load())
+
+	// cached
+	_, err = i.Verify(context.Background(), p.sign(t, claims))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), p.fetches.Load())
+
+	// after a rotation the keys are fetched again, though not more often
+	// than minFetchInterval
+	p.rotate(t)
+
+	_, err = i.Verify(context.Background(), p.sign(t, claims))
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.Equal(t, int32(1), p.fetches.Load())
+
+	i.fetchedAt = time.Now().Add(-minFetchInterval)
+
+	_, err = i.Verify(context.Background(), p.sign(t, claims))
+	require.NoError(t, err)
+	require.Equal(t, int32(2), p.fetches.Load())
+}
+
+func TestVerifyStaticKey(t *testing.T) {
+	p := newPartner(t)
+	audience := util.RandomString(8)
+
+	der, err := x509.MarshalPKIXPublicKey(&p.key.PublicKey)
+	require.NoError(t, err)
+
+	i, err := New(Config{
+		Issuer:   p.issuer,
+		Audience: audience,
+		Keys:     []string{string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+	}, p.server.Client())
+	require.NoError(t, err)
+
+	// a single key matches any key ID
+	p.kid = util.RandomString(6)
+
+	_, err = i.Verify(context.Background(), p.sign(t, p.claims(audience)))
+	require.NoError(t, err)
+	require.Zero(t, p.fetches.Load())
+}
+
+func TestVerifyRejects(t *testing.T) {
+	p := newPartner(t)
+	other := newPartner(t)
+	audience := util.RandomString(8)
+
+	i, err := New(Config{Issuer: p.issuer, Audience: audience, JWKSURL: p.server.URL}, p.server.Client())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		partner *partner
+		modify  func(claims jwt.MapClaims)
+		err     error
+	}{
+		{"other key", other, func(claims jwt.MapClaims) { claims["iss"] = p.issuer }, ErrInvalidToken},
+		{"other issuer", p, func(claims jwt.MapClaims) { claims["iss"] = other.issuer }, ErrInvalidToken},
+		{"other audience", p, func(claims jwt.MapClaims) { claims["aud"] = "other" }, ErrInvalidToken},
+		{"no expiry", p, func(claims jwt.MapClaims) { delete(claims, "exp") }, ErrInvalidToken},
+		{"expired", p, func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, ErrExpiredToken},
+		{"not valid yet", p, func(claims jwt.MapClaims) { claims["nbf"] = time.Now().Add(time.Hour).Unix() }, ErrInvalidToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := tc.partner.claims(audience)
+			tc.modify(claims)
+
+			_, err := i.Verify(context.Background(), tc.partner.sign(t, claims))
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	// HS256 signed with the public key must not pass
+	claims := p.claims(audience)
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := hmac.SignedString(x509.MarshalPKCS1PublicKey(&p.key.PublicKey))
+	require.NoError(t, err)
+
+	_, err = i.Verify(context.Background(), signed)
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestPeekIssuer(t *testing.T) {
+	p := newPartner(t)
+
+	iss, err := PeekIssuer(p.sign(t, p.claims("aud")))
+	require.NoError(t, err)
+	require.Equal(t, p.issuer, iss)
+
+	_, err = PeekIssuer("v2.local.not-a-jwt")
+	require.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Issuer: "https://partner.example", Audience: "aud"}, http.DefaultClient)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = New(Config{Issuer: "https://partner.example", JWKSURL: "https://partner.example/jwks"}, http.DefaultClient)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = New(Config{Issuer: "https://partner.example", Audience: "aud", Keys: []string{"not a key"}}, http.DefaultClient)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,99 @@
+// Package jwks represents public keys as JSON Web Keys (RFC 7517) so that
+// others can verify the tokens we sign, and we theirs.
+package jwks
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	KeyTypeRSA = "RSA"
+	UseSig     = "sig"
+	AlgRS256   = "RS256"
+)
+
+var (
+	ErrKeyNotFound        = errors.New("key not found")
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewRSAKey describes the public key for RS256 signatures. Its ID is the
+// RFC 7638 thumbprint.
+func NewRSAKey(pub *rsa.PublicKey) Key {
+	key := Key{
+		Kty: KeyTypeRSA,
+		Use: UseSig,
+		Alg: AlgRS256,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+	key.Kid = key.Thumbprint()
+
+	return key
+}
+
+// Thumbprint is the SHA-256 thumbprint of RFC 7638, base64url encoded.
+func (k Key) Thumbprint() string {
+	// the required members in lexicographic order, without whitespace
+	canonical := fmt.Sprintf(`{"e":"%s","kty":"%s","n":"%s"}`, k.E, k.Kty, k.N)
+	sum := sha256.Sum256([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (k Key) RSAPublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != KeyTypeRSA {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid key parameters")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// Find returns the key with the ID. A set of a single key matches any ID, as
+// some issuers don't set one.
+func (s Set) Find(kid string) (Key, error) {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key, nil
+		}
+	}
+
+	if len(s.Keys) == 1 && (kid == "" || s.Keys[0].Kid == "") {
+		return s.Keys[0], nil
+	}
+
+	return Key{}, ErrKeyNotFound
+}
@@ -0,0 +1,61 @@
+package jwks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRSAKeyRoundTrip(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key := NewRSAKey(&private.PublicKey)
+	require.Equal(t, KeyTypeRSA, key.Kty)
+	require.Equal(t, AlgRS256, key.Alg)
+	require.NotEmpty(t, key.Kid)
+	require.Equal(t, key.Kid, key.Thumbprint())
+
+	data, err := json.Marshal(Set{Keys: []Key{key}})
+	require.NoError(t, err)
+
+	var set Set
+	require.NoError(t, json.Unmarshal(data, &set))
+
+	found, err := set.Find(key.Kid)
+	require.NoError(t, err)
+
+	pub, err := found.RSAPublicKey()
+	require.NoError(t, err)
+	require.True(t, private.PublicKey.Equal(pub))
+}
+
+func TestSetFind(t *testing.T) {
+	first := Key{Kty: KeyTypeRSA, Kid: util.RandomString(8)}
+	second := Key{Kty: KeyTypeRSA, Kid: util.RandomString(8)}
+
+	set := Set{Keys: []Key{first, second}}
+
+	found, err := set.Find(second.Kid)
+	require.NoError(t, err)
+	require.Equal(t, second, found)
+
+	_, err = set.Find(util.RandomString(9))
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	_, err = set.Find("")
+	require.ErrorIs(t, err, ErrKeyNotFound)
+
+	// a lone key without an ID matches anything
+	single := Set{Keys: []Key{{Kty: KeyTypeRSA}}}
+	_, err = single.Find(util.RandomString(8))
+	require.NoError(t, err)
+}
+
+func TestUnsupportedKeyType(t *testing.T) {
+	_, err := Key{Kty: "EC"}.RSAPublicKey()
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
@@ -0,0 +1,92 @@
+// Package oauth holds the protocol details of OAuth 2.0 that don't depend on
+// storage: PKCE (RFC 7636), the scope parameter and grant type names.
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	CodeChallengeMethodS256 = "S256"
+
+	// GrantTypeDeviceCode is the grant type of RFC 8628.
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+var (
+	ErrInvalidCodeVerifier = errors.New("code verifier is invalid")
+
+	// codeVerifierRX is the syntax of RFC 7636 (4.1).
+	codeVerifierRX = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	// codeChallengeRX matches a base64url encoded SHA-256 hash.
+	codeChallengeRX = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// S256Challenge returns the S256 code challenge of the verifier.
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidCodeChallenge reports whether the challenge can be an S256 challenge.
+func ValidCodeChallenge(challenge string) bool {
+	return codeChallengeRX.MatchString(challenge)
+}
+
+// VerifyS256 checks the code verifier against the S256 challenge sent with
+// the authorization request.
+func VerifyS256(verifier, challenge string) error {
+	if !codeVerifierRX.MatchString(verifier) {
+		return ErrInvalidCodeVerifier
+	}
+
+	if subtle.ConstantTimeCompare([]byte(S256Challenge(verifier)), []byte(challenge)) != 1 {
+		return ErrInvalidCodeVerifier
+	}
+
+	return nil
+}
+
+// ParseScope splits the space-delimited scope parameter. Repeated scopes are
+// dropped.
+func ParseScope(scope string) []string {
+	var scopes []string
+
+	for _, s := range strings.Fields(scope) {
+		if !contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// Subset reports whether every scope is one of the allowed ones.
+func Subset(scopes, allowed []string) bool {
+	for _, s := range scopes {
+		if !contains(allowed, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,34 @@
+package oauth
+
+import (
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestVerifyS256(t *testing.T) {
+	// the example of RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	require.Equal(t, challenge, S256Challenge(verifier))
+	require.True(t, ValidCodeChallenge(challenge))
+	require.NoError(t, VerifyS256(verifier, challenge))
+
+	require.ErrorIs(t, VerifyS256(util.RandomString(43), challenge), ErrInvalidCodeVerifier)
+	require.ErrorIs(t, VerifyS256("short", S256Challenge("short")), ErrInvalidCodeVerifier)
+}
+
+func TestParseScope(t *testing.T) {
+	require.Nil(t, ParseScope("  "))
+	require.Equal(t, []string{"users:read", "orgs:read"}, ParseScope(" users:read orgs:read users:read"))
+	require.Equal(t, "users:read orgs:read", FormatScope([]string{"users:read", "orgs:read"}))
+}
+
+func TestSubset(t *testing.T) {
+	allowed := []string{"users:read", "orgs:read"}
+
+	require.True(t, Subset(nil, allowed))
+	require.True(t, Subset([]string{"orgs:read"}, allowed))
+	require.False(t, Subset([]string{"orgs:read", "users:write"}, allowed))
+}
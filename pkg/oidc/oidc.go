@@ -0,0 +1,215 @@
+// Package oidc signs and verifies OpenID Connect ID tokens (RS256).
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/pkg/jwks"
+	"github.com/dgrijalva/jwt-go"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+
+	// clockSkew is tolerated on the time claims of tokens of other issuers.
+	clockSkew = time.Minute
+)
+
+var (
+	ErrInvalidIDToken = errors.New("id token is invalid")
+	ErrExpiredIDToken = errors.New("id token has expired")
+)
+
+// Audience is the aud claim, a single string or an array of them.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+
+	return nil
+}
+
+// UserInfo holds the claims about the user, as served by the userinfo
+// endpoint. The profile and email claims are set only when the matching
+// scopes were granted.
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+// Claims of an ID token.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Nonce     string   `json:"nonce,omitempty"`
+	UserInfo
+}
+
+// Valid checks the expiry, with some tolerance for the clocks of other
+// issuers.
+func (c Claims) Valid() error {
+	if time.Now().Add(-clockSkew).Unix() > c.ExpiresAt {
+		return ErrExpiredIDToken
+	}
+	return nil
+}
+
+// Signer signs ID tokens with an RSA key published as a JWK.
+type Signer struct {
+	key *rsa.PrivateKey
+	jwk jwks.Key
+}
+
+func NewSigner(key *rsa.PrivateKey) *Signer {
+	return &Signer{
+		key: key,
+		jwk: jwks.NewRSAKey(&key.PublicKey),
+	}
+}
+
+// LoadSigner reads a PEM encoded RSA private key (PKCS #1 or PKCS #8).
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigner(key), nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA key", path)
+	}
+
+	return NewSigner(key), nil
+}
+
+// GenerateSigner signs with a new random key, for development: its tokens
+// can't be verified after a restart.
+func GenerateSigner() (*Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(key), nil
+}
+
+func (s *Signer) Sign(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.jwk.Kid
+
+	return token.SignedString(s.key)
+}
+
+// KeySet is what the jwks_uri publishes.
+func (s *Signer) KeySet() jwks.Set {
+	return jwks.Set{Keys: []jwks.Key{s.jwk}}
+}
+
+// Verify checks the RS256 signature of the token with one of the keys, then
+// the issuer, the audience and the expiry.
+func Verify(token string, keys jwks.Set, issuer, audience string) (*Claims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, ErrInvalidIDToken
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := keys.Find(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		return key.RSAPublicKey()
+	}
+
+	parsed, err := jwt.ParseWithClaims(token, &Claims{}, keyFunc)
+	if err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && errors.Is(verr.Inner, ErrExpiredIDToken) {
+			return nil, ErrExpiredIDToken
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	claims, ok := parsed.Claims.(*Claims)
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != issuer || !slices.Contains(claims.Audience, audience) {
+		return nil, fmt.Errorf("%w: unexpected issuer or audience", ErrInvalidIDToken)
+	}
+
+	if claims.IssuedAt > time.Now().Add(clockSkew).Unix() {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+// ProviderMetadata is the discovery document of OpenID Connect Discovery 1.0,
+// served at /.well-known/openid-configuration.
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"encoding/json"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func randomClaims() Claims {
+	verified := true
+
+	return Claims{
+		Issuer:    "https://" + util.RandomString(8) + ".example",
+		Audience:  Audience{util.RandomString(10)},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		IssuedAt:  time.Now().Unix(),
+		Nonce:     util.RandomString(12),
+		UserInfo: UserInfo{
+			Subject:       util.RandomString(6),
+			Email:         util.RandomEmail(),
+			EmailVerified: &verified,
+			GivenName:     util.RandomOwner(),
+		},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := GenerateSigner()
+	require.NoError(t, err)
+
+	claims := randomClaims()
+
+	token, err := signer.Sign(claims)
+	require.NoError(t, err)
+
+	verified, err := Verify(token, signer.KeySet(), claims.Issuer, claims.Audience[0])
+	require.NoError(t, err)
+	require.Equal(t, claims, *verified)
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer, err := GenerateSigner()
+	require.NoError(t, err)
+
+	other, err := GenerateSigner()
+	require.NoError(t, err)
+
+	claims := randomClaims()
+
+	token, err := signer.Sign(claims)
+	require.NoError(t, err)
+
+	_, err = Verify(token, other.KeySet(), claims.Issuer, claims.Audience[0])
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	_, err = Verify(token, signer.KeySet(), claims.Issuer, util.RandomString(10))
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	_, err = Verify(token, signer.KeySet(), "https://"+util.RandomString(8)+".example", claims.Audience[0])
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+
+	token, err = signer.Sign(claims)
+	require.NoError(t, err)
+
+	_, err = Verify(token, signer.KeySet(), claims.Issuer, claims.Audience[0])
+	require.ErrorIs(t, err, ErrExpiredIDToken)
+}
+
+func TestAudience(t *testing.T) {
+	var claims Claims
+
+	require.NoError(t, json.Unmarshal([]byte(`{"aud":"one"}`), &claims))
+	require.Equal(t, Audience{"one"}, claims.Audience)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"aud":["one","two"]}`), &claims))
+	require.Equal(t, Audience{"one", "two"}, claims.Audience)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"sub":"42","aud":"one"}`), &claims))
+	require.Equal(t, "42", claims.Subject)
+
+	data, err := json.Marshal(Audience{"one"})
+	require.NoError(t, err)
+	require.JSONEq(t, `"one"`, string(data))
+}
@@ -0,0 +1,172 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	hashPrefixLen = 5
+	sha1HexLen    = 40
+)
+
+// HIBPFile checks passwords against a local copy of the Have I Been Pwned
+// SHA-1 password list, so that no network access is needed.
+//
+// The path may point either to a directory of range files, one per 5-character
+// hash prefix ("21BD1" or "21BD1.txt") holding "SUFFIX:COUNT" lines, as served
+// by the range API; or to a single file of "HASH:COUNT" lines ordered by hash,
+// as produced by the official downloader, which is binary searched.
+type HIBPFile struct {
+	path  string
+	isDir bool
+}
+
+func NewHIBPFile(path string) (*HIBPFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("hibp file: %w", err)
+	}
+
+	return &HIBPFile{path: path, isDir: info.IsDir()}, nil
+}
+
+// Count returns how many times the password appears in the breach corpus,
+// or 0 if it was never seen.
+func (h *HIBPFile) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if h.isDir {
+		return h.countInRange(hash)
+	}
+	return h.countInOrderedFile(hash)
+}
+
+func (h *HIBPFile) countInRange(hash string) (int, error) {
+	prefix, suffix := hash[:hashPrefixLen], hash[hashPrefixLen:]
+
+	f, err := os.Open(filepath.Join(h.path, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(h.path, prefix+".txt"))
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineHash, count, ok := parseLine(scanner.Text())
+		if ok && strings.EqualFold(lineHash, suffix) {
+			return count, nil
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+func (h *HIBPFile) countInOrderedFile(hash string) (int, error) {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Binary search over byte offsets: lo always points at the start of a line
+	// whose hash is <= target (or at 0), hi is past the last candidate.
+	lo, hi := int64(0), info.Size()
+	for hi-lo > 4*(sha1HexLen+16) {
+		mid := lo + (hi-lo)/2
+
+		line, start, err := lineAfter(f, mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		lineHash, _, _ := parseLine(line)
+		if strings.ToUpper(lineHash) <= hash {
+			lo = start
+		} else {
+			hi = mid
+		}
+	}
+
+	// scan the remaining window linearly
+	if _, err = f.Seek(lo, io.SeekStart); err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(io.LimitReader(f, hi-lo+sha1HexLen+32))
+	for scanner.Scan() {
+		lineHash, count, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch upper := strings.ToUpper(lineHash); {
+		case upper == hash:
+			return count, nil
+		case upper > hash:
+			return 0, nil
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+// lineAfter returns the first complete line starting after offset and the
+// offset it starts at.
+func lineAfter(f *os.File, offset int64) (string, int64, error) {
+	buf := make([]byte, 2*(sha1HexLen+32))
+
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, err
+	}
+	buf = buf[:n]
+
+	nl := bytes.IndexByte(buf, '\n')
+	if nl < 0 {
+		return "", offset + int64(n), nil
+	}
+	rest := buf[nl+1:]
+	if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+		rest = rest[:end]
+	}
+
+	return strings.TrimSpace(string(rest)), offset + int64(nl) + 1, nil
+}
+
+func parseLine(line string) (string, int, bool) {
+	hash, countStr, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return "", 0, false
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return hash, count, true
+}
@@ -0,0 +1,174 @@
+package password
+
+import (
+	"fmt"
+	"fullstack-simple-app/pkg/validator"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rule names are used as suffixes of the validator keys ("password.<rule>"),
+// so clients can tell exactly which requirement was not met.
+const (
+	RuleMinLength   = "min_length"
+	RuleUpper       = "upper"
+	RuleLower       = "lower"
+	RuleDigit       = "digit"
+	RuleSymbol      = "symbol"
+	RuleCharClasses = "char_classes"
+	RuleUserInfo    = "user_info"
+	RuleStrength    = "strength"
+	RuleBreached    = "breached"
+)
+
+// BreachChecker reports how many times a password was seen in known breaches.
+type BreachChecker interface {
+	Count(password string) (int, error)
+}
+
+type Policy struct {
+	MinLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	MinCharClasses int
+	ForbidUserInfo bool
+	MinStrength    int
+	MaxBreachCount int
+
+	breaches BreachChecker
+}
+
+type Option func(*Policy)
+
+func WithBreachChecker(checker BreachChecker) Option {
+	return func(p *Policy) {
+		p.breaches = checker
+	}
+}
+
+func NewPolicy(opts ...Option) *Policy {
+	p := &Policy{}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Validate checks the password against every configured rule and records one
+// error per violated rule in v. userInputs are values the password must not be
+// built from, such as the user's name or email.
+func (p *Policy) Validate(v *validator.Validator, password string, userInputs ...string) error {
+	v.Check(utf8.RuneCountInString(password) >= p.MinLength, key(RuleMinLength),
+		fmt.Sprintf("must be at least %d characters long", p.MinLength))
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper {
+		v.Check(upper, key(RuleUpper), "must contain an uppercase letter")
+	}
+	if p.RequireLower {
+		v.Check(lower, key(RuleLower), "must contain a lowercase letter")
+	}
+	if p.RequireDigit {
+		v.Check(digit, key(RuleDigit), "must contain a digit")
+	}
+	if p.RequireSymbol {
+		v.Check(symbol, key(RuleSymbol), "must contain a symbol")
+	}
+
+	if p.MinCharClasses > 0 {
+		classes := 0
+		for _, ok := range []bool{upper, lower, digit, symbol} {
+			if ok {
+				classes++
+			}
+		}
+		v.Check(classes >= p.MinCharClasses, key(RuleCharClasses),
+			fmt.Sprintf("must contain at least %d of: uppercase, lowercase, digits, symbols", p.MinCharClasses))
+	}
+
+	if p.ForbidUserInfo {
+		v.Check(!containsUserInfo(password, userInputs), key(RuleUserInfo), "must not contain your name or email")
+	}
+
+	if p.MinStrength > 0 {
+		v.Check(Strength(password, userInputs...) >= p.MinStrength, key(RuleStrength), "is too easy to guess")
+	}
+
+	if p.breaches != nil {
+		count, err := p.breaches.Count(password)
+		if err != nil {
+			return fmt.Errorf("breach check: %w", err)
+		}
+		v.Check(count <= p.MaxBreachCount, key(RuleBreached), "has appeared in a data breach, please choose another one")
+	}
+
+	return nil
+}
+
+func key(rule string) string {
+	return "password." + rule
+}
+
+// containsUserInfo reports whether the password contains any user input of at
+// least three characters. Inputs are also split into words, so that
+// "john.doe@example.com" rejects "john" and "example" as well.
+func containsUserInfo(password string, userInputs []string) bool {
+	lowered := strings.ToLower(password)
+
+	for _, token := range userTokens(userInputs) {
+		if strings.Contains(lowered, token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func userTokens(userInputs []string) []string {
+	var tokens []string
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			continue
+		}
+
+		source := input
+		if local, domain, ok := strings.Cut(input, "@"); ok {
+			// the top-level domain is too short and too common to be meaningful
+			if i := strings.LastIndex(domain, "."); i >= 0 {
+				domain = domain[:i]
+			}
+			source = local + " " + domain
+		}
+
+		parts := strings.FieldsFunc(source, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, part := range append(parts, input) {
+			if utf8.RuneCountInString(part) >= 3 {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,129 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"fullstack-simple-app/pkg/validator"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRules(t *testing.T) {
+	policy := NewPolicy()
+	policy.MinLength = 10
+	policy.RequireUpper = true
+	policy.RequireDigit = true
+	policy.RequireSymbol = true
+	policy.ForbidUserInfo = true
+
+	v := validator.New()
+	err := policy.Validate(v, "kurushpass", "Kurush", "Qosimi", "kurush@example.com")
+	require.NoError(t, err)
+	require.False(t, v.Valid())
+	require.Contains(t, v.Errors, "password."+RuleUpper)
+	require.Contains(t, v.Errors, "password."+RuleDigit)
+	require.Contains(t, v.Errors, "password."+RuleSymbol)
+	require.Contains(t, v.Errors, "password."+RuleUserInfo)
+	require.NotContains(t, v.Errors, "password."+RuleMinLength)
+
+	v = validator.New()
+	err = policy.Validate(v, "Tr0ub4dor&3x", "Kurush", "Qosimi", "kurush@example.com")
+	require.NoError(t, err)
+	require.True(t, v.Valid(), v.Errors)
+}
+
+func TestPolicyMinLengthCountsRunes(t *testing.T) {
+	policy := NewPolicy()
+	policy.MinLength = 8
+
+	v := validator.New()
+	require.NoError(t, policy.Validate(v, "пароль1"))
+	require.Contains(t, v.Errors, "password."+RuleMinLength)
+}
+
+func TestStrength(t *testing.T) {
+	require.Equal(t, 0, Strength("password"))
+	require.Equal(t, 0, Strength("qwerty123"))
+	require.LessOrEqual(t, Strength("P@ssw0rd"), 1)
+	require.LessOrEqual(t, Strength("abcdefgh2024"), 1)
+	require.LessOrEqual(t, Strength("kurushqosimi", "kurush", "qosimi"), 1)
+	require.GreaterOrEqual(t, Strength("correct horse battery staple"), 3)
+	require.GreaterOrEqual(t, Strength("x7$Kp!q9Lm#2vR"), 4)
+}
+
+func TestHIBPOrderedFile(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein"}
+
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(fmt.Sprintf("filler-%d", i)), i+1))
+	}
+	for i, pw := range breached {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(pw), 1000+i))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+
+	checker, err := NewHIBPFile(path)
+	require.NoError(t, err)
+
+	for i, pw := range breached {
+		count, err := checker.Count(pw)
+		require.NoError(t, err)
+		require.Equal(t, 1000+i, count, pw)
+	}
+
+	count, err := checker.Count("filler-250")
+	require.NoError(t, err)
+	require.Equal(t, 251, count)
+
+	count, err = checker.Count("a perfectly unique passphrase")
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestHIBPRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("password")
+
+	content := fmt.Sprintf("0018A45C4D1DEF81644B54AB7F969B88D65:1\n%s:9545824\n", hash[5:])
+	require.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0o600))
+
+	checker, err := NewHIBPFile(dir)
+	require.NoError(t, err)
+
+	count, err := checker.Count("password")
+	require.NoError(t, err)
+	require.Equal(t, 9545824, count)
+
+	count, err = checker.Count("not in any range file")
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestPolicyBreached(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("Summer2019!")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]), []byte(hash[5:]+":42\n"), 0o600))
+
+	checker, err := NewHIBPFile(dir)
+	require.NoError(t, err)
+
+	policy := NewPolicy(WithBreachChecker(checker))
+
+	v := validator.New()
+	require.NoError(t, policy.Validate(v, "Summer2019!"))
+	require.Contains(t, v.Errors, "password."+RuleBreached)
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,331 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Strength estimates how hard a password is to guess and returns a score from
+// 0 (too guessable) to 4 (very unguessable), following the approach of zxcvbn:
+// the password is split into the cheapest sequence of known patterns
+// (dictionary words, keyboard walks, sequences, repeats, years) and brute-forced
+// gaps, and the resulting number of guesses is bucketed into a score.
+func Strength(password string, userInputs ...string) int {
+	guesses := estimateGuesses([]rune(password), userDictionary(userInputs))
+
+	switch {
+	case guesses < 1e3+5:
+		return 0
+	case guesses < 1e6+5:
+		return 1
+	case guesses < 1e8+5:
+		return 2
+	case guesses < 1e10+5:
+		return 3
+	default:
+		return 4
+	}
+}
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonRanks maps a common password to its popularity rank (1 is the most used).
+var commonRanks = loadRanks(commonPasswordsFile)
+
+var keyboardRows = []string{
+	"1234567890-=",
+	"qwertyuiop[]",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"qazwsxedcrfvtgbyhnujmikolp",
+}
+
+const (
+	bruteforceCardinality = 10
+	minSubmatchGuesses    = 10
+	minYearSpace          = 20
+	referenceYear         = 2025
+)
+
+type match struct {
+	i, j    int
+	guesses float64
+}
+
+func loadRanks(list string) map[string]int {
+	ranks := make(map[string]int)
+
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for rank := 1; scanner.Scan(); {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" {
+			continue
+		}
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = rank
+			rank++
+		}
+	}
+
+	return ranks
+}
+
+func userDictionary(userInputs []string) map[string]int {
+	ranks := make(map[string]int)
+
+	for i, token := range userTokens(userInputs) {
+		if _, ok := ranks[token]; !ok {
+			ranks[token] = i + 1
+		}
+	}
+
+	return ranks
+}
+
+// estimateGuesses finds the decomposition of the password into matches that
+// minimises the total number of guesses.
+func estimateGuesses(password []rune, userRanks map[string]int) float64 {
+	n := len(password)
+	if n == 0 {
+		return 1
+	}
+
+	matches := findMatches(password, userRanks)
+
+	// best[k] is the minimal guess count for password[:k] with count[k] matches.
+	best := make([]float64, n+1)
+	count := make([]int, n+1)
+	best[0] = 1
+
+	for k := 1; k <= n; k++ {
+		best[k] = math.Inf(1)
+
+		// brute-force the single character at k-1
+		if g := best[k-1] * bruteforceCardinality; g < best[k] {
+			best[k], count[k] = g, count[k-1]
+		}
+
+		for _, m := range matches {
+			if m.j != k-1 {
+				continue
+			}
+			g := best[m.i] * m.guesses * float64(count[m.i]+1)
+			if g < best[k] {
+				best[k], count[k] = g, count[m.i]+1
+			}
+		}
+	}
+
+	return best[n]
+}
+
+func findMatches(password []rune, userRanks map[string]int) []match {
+	var matches []match
+
+	matches = append(matches, dictionaryMatches(password, commonRanks)...)
+	matches = append(matches, dictionaryMatches(password, userRanks)...)
+	matches = append(matches, keyboardMatches(password)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, repeatMatches(password)...)
+	matches = append(matches, yearMatches(password)...)
+
+	return matches
+}
+
+func dictionaryMatches(password []rune, ranks map[string]int) []match {
+	var matches []match
+
+	if len(ranks) == 0 {
+		return nil
+	}
+
+	for i := range password {
+		for j := i + 2; j < len(password); j++ {
+			token := password[i : j+1]
+			word := strings.ToLower(string(token))
+
+			if rank, ok := ranks[word]; ok {
+				matches = append(matches, match{i, j, dictionaryGuesses(rank, token, 1)})
+			}
+			if rank, ok := ranks[reverse(word)]; ok {
+				matches = append(matches, match{i, j, dictionaryGuesses(rank, token, 2)})
+			}
+			if unleeted := unleet(word); unleeted != word {
+				if rank, ok := ranks[unleeted]; ok {
+					matches = append(matches, match{i, j, dictionaryGuesses(rank, token, 2)})
+				}
+			}
+		}
+	}
+
+	return matches
+}
+
+func dictionaryGuesses(rank int, token []rune, variations float64) float64 {
+	return math.Max(float64(rank), minSubmatchGuesses) * uppercaseVariations(token) * variations
+}
+
+// uppercaseVariations counts the ways the capitalisation of the word could
+// have been chosen; the common "Capitalised" and "ALL CAPS" forms cost only 2.
+func uppercaseVariations(token []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && unicode.IsUpper(token[0])) {
+		return 2
+	}
+
+	variations := 0.0
+	for k := 1; k <= min(upper, lower); k++ {
+		variations += binomial(upper+lower, k)
+	}
+
+	return variations
+}
+
+func keyboardMatches(password []rune) []match {
+	var matches []match
+
+	lowered := []rune(strings.ToLower(string(password)))
+	for i := 0; i < len(lowered); {
+		j := i
+		for j+1 < len(lowered) && adjacentOnKeyboard(lowered[j], lowered[j+1]) {
+			j++
+		}
+		if j-i+1 >= 3 {
+			matches = append(matches, match{i, j, float64(len(keyboardRows)) * 12 * float64(j-i+1) * 2})
+			i = j + 1
+			continue
+		}
+		i++
+	}
+
+	return matches
+}
+
+func adjacentOnKeyboard(a, b rune) bool {
+	for _, row := range keyboardRows {
+		ia, ib := strings.IndexRune(row, a), strings.IndexRune(row, b)
+		if ia >= 0 && ib >= 0 && (ia-ib == 1 || ib-ia == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+func sequenceMatches(password []rune) []match {
+	var matches []match
+
+	for i := 0; i+2 < len(password); {
+		delta := password[i+1] - password[i]
+		if delta != 1 && delta != -1 {
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j+1 < len(password) && password[j+1]-password[j] == delta {
+			j++
+		}
+
+		if j-i+1 >= 3 {
+			base := 26.0
+			switch first := password[i]; {
+			case first == 'a' || first == 'z' || first == '0' || first == '1' || first == '9':
+				base = 4
+			case unicode.IsDigit(first):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, match{i, j, base * float64(j-i+1)})
+		}
+		i = j
+	}
+
+	return matches
+}
+
+func repeatMatches(password []rune) []match {
+	var matches []match
+
+	for i := 0; i < len(password); {
+		j := i
+		for j+1 < len(password) && password[j+1] == password[i] {
+			j++
+		}
+		if j-i+1 >= 3 {
+			matches = append(matches, match{i, j, cardinality(password[i]) * float64(j-i+1)})
+		}
+		i = j + 1
+	}
+
+	return matches
+}
+
+func yearMatches(password []rune) []match {
+	var matches []match
+
+	for i := 0; i+4 <= len(password); i++ {
+		year, err := strconv.Atoi(string(password[i : i+4]))
+		if err != nil || year < 1900 || year > 2099 {
+			continue
+		}
+		space := math.Max(math.Abs(float64(year-referenceYear)), minYearSpace)
+		matches = append(matches, match{i, i + 3, space})
+	}
+
+	return matches
+}
+
+func cardinality(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return 10
+	case unicode.IsLower(r) || unicode.IsUpper(r):
+		return 26
+	default:
+		return 33
+	}
+}
+
+var leetTable = strings.NewReplacer(
+	"4", "a", "@", "a", "3", "e", "1", "i", "!", "i",
+	"0", "o", "$", "s", "5", "s", "7", "t", "+", "t",
+)
+
+func unleet(word string) string {
+	return leetTable.Replace(word)
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for d := 1; d <= k; d++ {
+		result = result * float64(n-k+d) / float64(d)
+	}
+	return result
+}
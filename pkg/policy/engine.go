@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// Engine evaluates the rules of a policy file and reloads them when the file
+// changes.
+type Engine struct {
+	path string
+
+	mu      sync.RWMutex
+	set     *Set
+	modTime time.Time
+}
+
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{path: path}
+
+	_, err := e.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *Engine) Evaluate(req Request) Decision {
+	e.mu.RLock()
+	set := e.set
+	e.mu.RUnlock()
+
+	return set.Evaluate(req)
+}
+
+// Reload reads the policy file if it was modified since the last load and
+// reports whether the rules were replaced. When the file is invalid the
+// previous rules stay in effect.
+func (e *Engine) Reload() (bool, error) {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.RLock()
+	unchanged := e.set != nil && info.ModTime().Equal(e.modTime)
+	e.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	set, err := Load(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.Lock()
+	e.set = set
+	e.modTime = info.ModTime()
+	e.mu.Unlock()
+
+	return true, nil
+}
+
+// Watch checks the policy file for changes every interval until the context
+// is done. Reload errors are passed to onError.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := e.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package policy evaluates attribute-based access rules.
+//
+// Rules are loaded from YAML:
+//
+//	timezone: Asia/Dushanbe
+//	policies:
+//	  - name: support-reads-own-org
+//	    effect: allow
+//	    actions: ['users:read']
+//	    resources: ['user']
+//	    conditions:
+//	      - {attr: subject.roles, op: contains, value: support}
+//	      - {attr: subject.org_id, op: eq, ref: resource.org_id}
+//	      - {attr: env.hour, op: between, value: [9, 18]}
+//
+// A rule applies when the action and the resource type match and all of its
+// conditions hold. A deny rule overrides any allow rule.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Operators of a Condition.
+const (
+	OpEq        = "eq"
+	OpNe        = "ne"
+	OpIn        = "in"
+	OpNotIn     = "not_in"
+	OpContains  = "contains"
+	OpGt        = "gt"
+	OpGte       = "gte"
+	OpLt        = "lt"
+	OpLte       = "lte"
+	OpBetween   = "between"
+	OpExists    = "exists"
+	OpNotExists = "not_exists"
+)
+
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// Condition compares the attribute Attr with Value, or with the attribute Ref
+// when it is set. A missing attribute fails every operator but not_exists.
+type Condition struct {
+	Attr  string      `yaml:"attr"`
+	Op    string      `yaml:"op"`
+	Value interface{} `yaml:"value"`
+	Ref   string      `yaml:"ref"`
+}
+
+// Rule matches actions and resource types exactly, by prefix ("users:*") or
+// all of them ("*").
+type Rule struct {
+	Name       string      `yaml:"name"`
+	Effect     string      `yaml:"effect"`
+	Actions    []string    `yaml:"actions"`
+	Resources  []string    `yaml:"resources"`
+	Conditions []Condition `yaml:"conditions"`
+}
+
+type Set struct {
+	// Timezone of the env.* attributes, UTC by default.
+	Timezone string `yaml:"timezone"`
+	Rules    []Rule `yaml:"policies"`
+
+	location *time.Location
+}
+
+// Attributes are keyed by their full name: "subject.roles", "resource.org_id".
+type Attributes map[string]interface{}
+
+type Request struct {
+	Action     string
+	Resource   string
+	Attributes Attributes
+	// Now sets the env.* attributes: env.hour, env.weekday ("mon"), env.time
+	// ("15:04") and env.date ("2006-01-02").
+	Now time.Time
+}
+
+type Decision struct {
+	// Effect is EffectAllow, EffectDeny or empty when no rule applies.
+	Effect string
+	Rule   string
+}
+
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return set, nil
+}
+
+func Parse(data []byte) (*Set, error) {
+	var set Set
+
+	err := yaml.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	set.location = time.UTC
+	if set.Timezone != "" {
+		set.location, err = time.LoadLocation(set.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: timezone: %v", ErrInvalidPolicy, err)
+		}
+	}
+
+	names := make(map[string]bool, len(set.Rules))
+
+	for i, rule := range set.Rules {
+		err = validateRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("%w: policy %d: %v", ErrInvalidPolicy, i, err)
+		}
+
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: policy %d: duplicate name %q", ErrInvalidPolicy, i, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return &set, nil
+}
+
+func validateRule(rule Rule) error {
+	switch {
+	case rule.Name == "":
+		return errors.New("name must be provided")
+	case rule.Effect != EffectAllow && rule.Effect != EffectDeny:
+		return fmt.Errorf("%s: effect must be allow or deny", rule.Name)
+	case len(rule.Actions) == 0:
+		return fmt.Errorf("%s: actions must be provided", rule.Name)
+	case len(rule.Resources) == 0:
+		return fmt.Errorf("%s: resources must be provided", rule.Name)
+	}
+
+	for _, cond := range rule.Conditions {
+		if cond.Attr == "" {
+			return fmt.Errorf("%s: condition attr must be provided", rule.Name)
+		}
+
+		switch cond.Op {
+		case OpEq, OpNe, OpContains, OpGt, OpGte, OpLt, OpLte, OpExists, OpNotExists:
+		case OpIn, OpNotIn:
+			if _, ok := toList(cond.Value); !ok && cond.Ref == "" {
+				return fmt.Errorf("%s: %s: %s needs a list value", rule.Name, cond.Attr, cond.Op)
+			}
+		case OpBetween:
+			if list, ok := toList(cond.Value); !ok || len(list) != 2 {
+				return fmt.Errorf("%s: %s: between needs a [from, to] value", rule.Name, cond.Attr)
+			}
+		default:
+			return fmt.Errorf("%s: %s: unknown operator %q", rule.Name, cond.Attr, cond.Op)
+		}
+	}
+
+	return nil
+}
+
+// Evaluate returns the first deny rule that applies, otherwise the first allow
+// rule that applies.
+func (s *Set) Evaluate(req Request) Decision {
+	attrs := s.withEnv(req)
+
+	var allow Decision
+
+	for _, rule := range s.Rules {
+		if !rule.applies(req, attrs) {
+			continue
+		}
+
+		if rule.Effect == EffectDeny {
+			return Decision{Effect: EffectDeny, Rule: rule.Name}
+		}
+
+		if allow.Effect == "" {
+			allow = Decision{Effect: EffectAllow, Rule: rule.Name}
+		}
+	}
+
+	return allow
+}
+
+func (s *Set) withEnv(req Request) Attributes {
+	attrs := make(Attributes, len(req.Attributes)+4)
+	for k, v := range req.Attributes {
+		attrs[k] = v
+	}
+
+	now := req.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	location := s.location
+	if location == nil {
+		location = time.UTC
+	}
+	now = now.In(location)
+
+	attrs["env.hour"] = now.Hour()
+	attrs["env.weekday"] = strings.ToLower(now.Weekday().String()[:3])
+	attrs["env.time"] = now.Format("15:04")
+	attrs["env.date"] = now.Format("2006-01-02")
+
+	return attrs
+}
+
+func (r Rule) applies(req Request, attrs Attributes) bool {
+	if !matchAny(r.Actions, req.Action) || !matchAny(r.Resources, req.Resource) {
+		return false
+	}
+
+	for _, cond := range r.Conditions {
+		if !cond.holds(attrs) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == value {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c Condition) holds(attrs Attributes) bool {
+	actual, ok := attrs[c.Attr]
+
+	switch c.Op {
+	case OpExists:
+		return ok
+	case OpNotExists:
+		return !ok
+	}
+
+	if !ok {
+		return false
+	}
+
+	expected := c.Value
+	if c.Ref != "" {
+		expected, ok = attrs[c.Ref]
+		if !ok {
+			return false
+		}
+	}
+
+	switch c.Op {
+	case OpEq:
+		return equal(actual, expected)
+	case OpNe:
+		return !equal(actual, expected)
+	case OpIn:
+		return contains(expected, actual)
+	case OpNotIn:
+		return !contains(expected, actual)
+	case OpContains:
+		return contains(actual, expected)
+	case OpGt, OpGte, OpLt, OpLte:
+		cmp, ok := compare(actual, expected)
+		if !ok {
+			return false
+		}
+		switch c.Op {
+		case OpGt:
+			return cmp > 0
+		case OpGte:
+			return cmp >= 0
+		case OpLt:
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
+	case OpBetween:
+		// from inclusive, to exclusive: [9, 18] is 9:00 to 17:59 for env.hour
+		bounds, _ := toList(expected)
+		if len(bounds) != 2 {
+			return false
+		}
+		from, ok1 := compare(actual, bounds[0])
+		to, ok2 := compare(actual, bounds[1])
+		return ok1 && ok2 && from >= 0 && to < 0
+	}
+
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			return fa == fb
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b interface{}) (int, bool) {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	sa, ok1 := a.(string)
+	sb, ok2 := b.(string)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+
+	return strings.Compare(sa, sb), true
+}
+
+func contains(list interface{}, value interface{}) bool {
+	items, ok := toList(list)
+	if !ok {
+		return false
+	}
+
+	for _, item := range items {
+		if equal(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toList(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+
+	return items, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPolicies = `
+timezone: UTC
+policies:
+  - name: deny-not-activated
+    effect: deny
+    actions: ['*']
+    resources: ['*']
+    conditions:
+      - {attr: subject.activated, op: eq, value: false}
+  - name: support-reads-own-org
+    effect: allow
+    actions: ['users:read']
+    resources: ['user']
+    conditions:
+      - {attr: subject.roles, op: contains, value: support}
+      - {attr: subject.org_id, op: eq, ref: resource.org_id}
+      - {attr: env.weekday, op: in, value: [mon, tue, wed, thu, fri]}
+      - {attr: env.hour, op: between, value: [9, 18]}
+`
+
+// a Wednesday
+var businessHours = time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)
+
+func supportRequest(now time.Time) Request {
+	return Request{
+		Action:   "users:read",
+		Resource: "user",
+		Attributes: Attributes{
+			"subject.activated": true,
+			"subject.roles":     []string{"support"},
+			"subject.org_id":    int64(7),
+			"resource.org_id":   7,
+		},
+		Now: now,
+	}
+}
+
+func TestEvaluateAllow(t *testing.T) {
+	set, err := Parse([]byte(testPolicies))
+	require.NoError(t, err)
+
+	decision := set.Evaluate(supportRequest(businessHours))
+	require.Equal(t, EffectAllow, decision.Effect)
+	require.Equal(t, "support-reads-own-org", decision.Rule)
+}
+
+func TestEvaluateConditionsFail(t *testing.T) {
+	set, err := Parse([]byte(testPolicies))
+	require.NoError(t, err)
+
+	evening := businessHours.Add(8 * time.Hour)
+	require.Empty(t, set.Evaluate(supportRequest(evening)).Effect)
+
+	saturday := businessHours.AddDate(0, 0, 3)
+	require.Empty(t, set.Evaluate(supportRequest(saturday)).Effect)
+
+	otherOrg := supportRequest(businessHours)
+	otherOrg.Attributes["resource.org_id"] = 8
+	require.Empty(t, set.Evaluate(otherOrg).Effect)
+
+	otherAction := supportRequest(businessHours)
+	otherAction.Action = "users:write"
+	require.Empty(t, set.Evaluate(otherAction).Effect)
+}
+
+func TestEvaluateDenyOverrides(t *testing.T) {
+	set, err := Parse([]byte(testPolicies))
+	require.NoError(t, err)
+
+	req := supportRequest(businessHours)
+	req.Attributes["subject.activated"] = false
+
+	decision := set.Evaluate(req)
+	require.Equal(t, EffectDeny, decision.Effect)
+	require.Equal(t, "deny-not-activated", decision.Rule)
+}
+
+func TestParseInvalid(t *testing.T) {
+	testCases := []string{
+		"policies: [{name: a, effect: maybe, actions: ['*'], resources: ['*']}]",
+		"policies: [{name: a, effect: allow, resources: ['*']}]",
+		"policies: [{name: a, effect: allow, actions: ['*'], resources: ['*'], conditions: [{attr: x, op: like}]}]",
+		"policies: [{name: a, effect: allow, actions: ['*'], resources: ['*'], conditions: [{attr: x, op: between, value: 1}]}]",
+		"policies: [{name: a, effect: allow, actions: ['*'], resources: ['*']}, {name: a, effect: deny, actions: ['*'], resources: ['*']}]",
+		"timezone: Nowhere/Special\npolicies: []",
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse([]byte(tc))
+		require.ErrorIs(t, err, ErrInvalidPolicy, tc)
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testPolicies), 0o600))
+
+	engine, err := NewEngine(path)
+	require.NoError(t, err)
+	require.Equal(t, EffectAllow, engine.Evaluate(supportRequest(businessHours)).Effect)
+
+	reloaded, err := engine.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	require.NoError(t, os.WriteFile(path, []byte("policies: []"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	reloaded, err = engine.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Empty(t, engine.Evaluate(supportRequest(businessHours)).Effect)
+
+	// a broken file keeps the previous rules
+	require.NoError(t, os.WriteFile(path, []byte("policies: [{name: a}]"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+
+	_, err = engine.Reload()
+	require.Error(t, err)
+	require.Empty(t, engine.Evaluate(supportRequest(businessHours)).Effect)
+}
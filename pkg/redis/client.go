@@ -24,14 +24,70 @@ func (r *RedisClient) Set(ctx context.Context, key string, value string, ttl tim
 	return r.rdb.Set(ctx, key, value, ttl).Err()
 }
 
+// SetNX sets the key only if it doesn't exist yet and reports whether it did
+// so.
+func (r *RedisClient) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return r.rdb.SetNX(ctx, key, value, ttl).Result()
+}
+
 func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
 	return r.rdb.Get(ctx, key).Result()
 }
 
+// GetDel returns the value of the key and deletes it at once, so that only
+// one of concurrent callers gets it.
+func (r *RedisClient) GetDel(ctx context.Context, key string) (string, error) {
+	return r.rdb.GetDel(ctx, key).Result()
+}
+
 func (r *RedisClient) Del(ctx context.Context, key string) error {
 	return r.rdb.Del(ctx, key).Err()
 }
 
+// Incr adds one to the counter and returns its value. A new counter lives for
+// the ttl, so the counter counts within a window starting at its first hit.
+func (r *RedisClient) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	pipe := r.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, ttl)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+// CountDistinct adds the member to the set kept as a HyperLogLog and returns
+// the approximate number of distinct members. A new set lives for the ttl.
+func (r *RedisClient) CountDistinct(ctx context.Context, key string, member string, ttl time.Duration) (int64, error) {
+	pipe := r.rdb.TxPipeline()
+	pipe.PFAdd(ctx, key, member)
+	pipe.ExpireNX(ctx, key, ttl)
+	count := pipe.PFCount(ctx, key)
+
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return count.Val(), nil
+}
+
+// Keys returns the keys matching the pattern. It scans the keyspace in
+// batches instead of blocking the server with KEYS.
+func (r *RedisClient) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+
+	iter := r.rdb.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	return keys, iter.Err()
+}
+
 func (r *RedisClient) Close() error {
 	return r.rdb.Close()
 }
@@ -0,0 +1,450 @@
+// Package saml is the service provider side of SAML 2.0 Web Browser SSO: it
+// describes the service provider in its metadata, sends authentication
+// requests with the HTTP-Redirect binding and validates the signed responses
+// the identity provider posts back with the HTTP-POST binding.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	NamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	NamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	NamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+
+	NameIDFormatEmail = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+	StatusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+
+	subjectConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// clockSkew is tolerated on the time conditions of the identity provider.
+	clockSkew = 3 * time.Minute
+)
+
+var (
+	ErrInvalidResponse  = errors.New("invalid saml response")
+	ErrInvalidSignature = errors.New("saml response is not signed by the identity provider")
+	ErrExpired          = errors.New("saml assertion has expired")
+)
+
+// ServiceProvider is us, as known to one identity provider.
+type ServiceProvider struct {
+	EntityID string
+	// ACSURL is the assertion consumer service the responses are posted to.
+	ACSURL string
+}
+
+// IdentityProvider is what the service provider knows of the identity
+// provider of a tenant.
+type IdentityProvider struct {
+	EntityID string
+	// SSOURL receives the authentication requests (HTTP-Redirect binding).
+	SSOURL       string
+	Certificates []*x509.Certificate
+}
+
+// Assertion is the validated statement of the identity provider about the
+// user.
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	// InResponseTo is the ID of the request of the service provider, empty
+	// when the login was initiated at the identity provider.
+	InResponseTo string
+	SessionIndex string
+	// ExpiresAt is when the assertion can't be used anymore, so its ID
+	// needn't be remembered against replays.
+	ExpiresAt  time.Time
+	Attributes map[string][]string
+}
+
+// Attribute returns the first value of the attribute.
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ParseCertificate reads the certificate of an identity provider, PEM encoded
+// or the bare base64 of its metadata.
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, fmt.Errorf("certificate is neither PEM nor base64: %w", err)
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// Metadata is the EntityDescriptor the identity provider is configured with.
+func (sp ServiceProvider) Metadata() ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+
+	descriptor := doc.CreateElement("md:EntityDescriptor")
+	descriptor.CreateAttr("xmlns:md", NamespaceMetadata)
+	descriptor.CreateAttr("entityID", sp.EntityID)
+
+	spDescriptor := descriptor.CreateElement("md:SPSSODescriptor")
+	spDescriptor.CreateAttr("AuthnRequestsSigned", "false")
+	spDescriptor.CreateAttr("WantAssertionsSigned", "true")
+	spDescriptor.CreateAttr("protocolSupportEnumeration", NamespaceProtocol)
+
+	spDescriptor.CreateElement("md:NameIDFormat").SetText(NameIDFormatEmail)
+
+	acs := spDescriptor.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", BindingHTTPPost)
+	acs.CreateAttr("Location", sp.ACSURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	doc.Indent(2)
+
+	return doc.WriteToBytes()
+}
+
+// AuthnRequestURL returns the ID of a new authentication request and the URL
+// of the identity provider carrying it (HTTP-Redirect binding).
+func (sp ServiceProvider) AuthnRequestURL(idp IdentityProvider, relayState string, now time.Time) (string, string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", "", err
+	}
+
+	doc := etree.NewDocument()
+
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	req.CreateAttr("xmlns:saml", NamespaceAssertion)
+	req.CreateAttr("ID", id)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	req.CreateAttr("Destination", idp.SSOURL)
+	req.CreateAttr("AssertionConsumerServiceURL", sp.ACSURL)
+	req.CreateAttr("ProtocolBinding", BindingHTTPPost)
+
+	req.CreateElement("saml:Issuer").SetText(sp.EntityID)
+
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", NameIDFormatEmail)
+	policy.CreateAttr("AllowCreate", "true")
+
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return "", "", err
+	}
+
+	// the binding deflates the request without the zlib header
+	var deflated bytes.Buffer
+
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = w.Write(data); err != nil {
+		return "", "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", "", err
+	}
+
+	u, err := url.Parse(idp.SSOURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	query := u.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	u.RawQuery = query.Encode()
+
+	return id, u.String(), nil
+}
+
+// ParseResponse validates the base64 encoded SAMLResponse the identity
+// provider posted and returns its assertion. Either the response or the
+// assertion must be signed with a certificate of the identity provider; only
+// signed content is read. Checking InResponseTo against the requests sent and
+// the assertion ID against replays is left to the caller.
+func (sp ServiceProvider) ParseResponse(samlResponse string, idp IdentityProvider, now time.Time) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	doc := etree.NewDocument()
+	if err = doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	response := doc.Root()
+	if response == nil || response.Tag != "Response" || response.NamespaceURI() != NamespaceProtocol {
+		return nil, fmt.Errorf("%w: not a samlp:Response", ErrInvalidResponse)
+	}
+
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: idp.Certificates})
+	validator.Clock = dsig.NewFakeClockAt(now)
+
+	responseSigned := hasSignature(response)
+	if responseSigned {
+		response, err = validator.Validate(response)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	}
+
+	var resp xmlResponse
+	if err = unmarshalElement(response, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Status.StatusCode.Value != StatusSuccess {
+		return nil, fmt.Errorf("%w: status %s", ErrInvalidResponse, resp.Status.StatusCode.Value)
+	}
+
+	if resp.Destination != "" && resp.Destination != sp.ACSURL {
+		return nil, fmt.Errorf("%w: destination %q", ErrInvalidResponse, resp.Destination)
+	}
+
+	assertions := childElements(response, NamespaceAssertion, "Assertion")
+	if len(assertions) != 1 {
+		// encrypted assertions are not supported
+		return nil, fmt.Errorf("%w: want exactly one plain assertion, got %d", ErrInvalidResponse, len(assertions))
+	}
+
+	assertionEl := assertions[0]
+
+	if hasSignature(assertionEl) {
+		assertionEl, err = validator.Validate(withNamespaces(assertionEl))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	} else if !responseSigned {
+		return nil, ErrInvalidSignature
+	}
+
+	var a xmlAssertion
+	if err = unmarshalElement(assertionEl, &a); err != nil {
+		return nil, err
+	}
+
+	return sp.validateAssertion(a, resp, idp, now)
+}
+
+func (sp ServiceProvider) validateAssertion(a xmlAssertion, resp xmlResponse, idp IdentityProvider, now time.Time) (*Assertion, error) {
+	if a.Issuer != idp.EntityID || (resp.Issuer != "" && resp.Issuer != idp.EntityID) {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidResponse, a.Issuer)
+	}
+
+	if a.Subject.NameID.Value == "" {
+		return nil, fmt.Errorf("%w: no name id", ErrInvalidResponse)
+	}
+
+	if a.Conditions.NotBefore != nil && now.Add(clockSkew).Before(*a.Conditions.NotBefore) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidResponse)
+	}
+
+	expiresAt := now.Add(clockSkew)
+	if a.Conditions.NotOnOrAfter != nil {
+		expiresAt = *a.Conditions.NotOnOrAfter
+	}
+
+	audiences := 0
+	for _, restriction := range a.Conditions.AudienceRestrictions {
+		if !slices.Contains(restriction.Audiences, sp.EntityID) {
+			return nil, fmt.Errorf("%w: audience %v", ErrInvalidResponse, restriction.Audiences)
+		}
+		audiences++
+	}
+	if audiences == 0 {
+		return nil, fmt.Errorf("%w: no audience restriction", ErrInvalidResponse)
+	}
+
+	// a bearer confirmation addressed to us, which carries the signed
+	// InResponseTo
+	var confirmation *xmlSubjectConfirmation
+	for i, c := range a.Subject.SubjectConfirmations {
+		if c.Method == subjectConfirmationBearer && c.Data.Recipient == sp.ACSURL {
+			confirmation = &a.Subject.SubjectConfirmations[i]
+			break
+		}
+	}
+	if confirmation == nil {
+		return nil, fmt.Errorf("%w: no bearer subject confirmation for %s", ErrInvalidResponse, sp.ACSURL)
+	}
+
+	if confirmation.Data.NotOnOrAfter == nil {
+		return nil, fmt.Errorf("%w: subject confirmation without expiry", ErrInvalidResponse)
+	}
+	if confirmation.Data.NotOnOrAfter.Before(expiresAt) {
+		expiresAt = *confirmation.Data.NotOnOrAfter
+	}
+
+	if !now.Add(-clockSkew).Before(expiresAt) {
+		return nil, ErrExpired
+	}
+
+	inResponseTo := confirmation.Data.InResponseTo
+	if resp.InResponseTo != inResponseTo {
+		return nil, fmt.Errorf("%w: InResponseTo mismatch", ErrInvalidResponse)
+	}
+
+	attributes := make(map[string][]string)
+	for _, attr := range a.AttributeStatement.Attributes {
+		attributes[attr.Name] = append(attributes[attr.Name], attr.Values...)
+		if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+			attributes[attr.FriendlyName] = append(attributes[attr.FriendlyName], attr.Values...)
+		}
+	}
+
+	return &Assertion{
+		ID:           a.ID,
+		Issuer:       a.Issuer,
+		NameID:       strings.TrimSpace(a.Subject.NameID.Value),
+		NameIDFormat: a.Subject.NameID.Format,
+		InResponseTo: inResponseTo,
+		SessionIndex: a.AuthnStatement.SessionIndex,
+		ExpiresAt:    expiresAt.Add(clockSkew),
+		Attributes:   attributes,
+	}, nil
+}
+
+type xmlResponse struct {
+	Destination  string `xml:"Destination,attr"`
+	InResponseTo string `xml:"InResponseTo,attr"`
+	Issuer       string `xml:"Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+	} `xml:"Status"`
+}
+
+type xmlSubjectConfirmation struct {
+	Method string `xml:"Method,attr"`
+	Data   struct {
+		InResponseTo string     `xml:"InResponseTo,attr"`
+		Recipient    string     `xml:"Recipient,attr"`
+		NotOnOrAfter *time.Time `xml:"NotOnOrAfter,attr"`
+	} `xml:"SubjectConfirmationData"`
+}
+
+type xmlAssertion struct {
+	ID      string `xml:"ID,attr"`
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"NameID"`
+		SubjectConfirmations []xmlSubjectConfirmation `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore            *time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         *time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"Audience"`
+		} `xml:"AudienceRestriction"`
+	} `xml:"Conditions"`
+	AuthnStatement struct {
+		SessionIndex string `xml:"SessionIndex,attr"`
+	} `xml:"AuthnStatement"`
+	AttributeStatement struct {
+		Attributes []struct {
+			Name         string   `xml:"Name,attr"`
+			FriendlyName string   `xml:"FriendlyName,attr"`
+			Values       []string `xml:"AttributeValue"`
+		} `xml:"Attribute"`
+	} `xml:"AttributeStatement"`
+}
+
+func hasSignature(el *etree.Element) bool {
+	return len(childElements(el, dsig.Namespace, dsig.SignatureTag)) > 0
+}
+
+// childElements returns the direct children with the namespace and tag.
+func childElements(el *etree.Element, namespace, tag string) []*etree.Element {
+	var children []*etree.Element
+
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == namespace {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// withNamespaces returns a copy of the element carrying the namespace
+// declarations it inherits, so that it can be validated on its own.
+func withNamespaces(el *etree.Element) *etree.Element {
+	detached := el.Copy()
+
+	for parent := el.Parent(); parent != nil; parent = parent.Parent() {
+		for _, attr := range parent.Attr {
+			if attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns") {
+				if detached.SelectAttr(attr.FullKey()) == nil {
+					detached.CreateAttr(attr.FullKey(), attr.Value)
+				}
+			}
+		}
+	}
+
+	return detached
+}
+
+// unmarshalElement decodes the element by the local names of its content.
+func unmarshalElement(el *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+
+	if err = xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	return nil
+}
+
+// newID returns an xsd:ID, which can't start with a digit.
+func newID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,309 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/beevik/etree"
+	"github.com/kurushqosimi/backendBank/util"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdP signs responses like an identity provider, with a locally
+// generated key pair.
+type testIdP struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+	idp  IdentityProvider
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(util.RandomInt(1, 1000000)),
+		Subject:      pkix.Name{CommonName: "idp.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testIdP{
+		key:  key,
+		cert: cert,
+		idp: IdentityProvider{
+			EntityID:     "https://idp.example/" + util.RandomString(6),
+			SSOURL:       "https://idp.example/sso",
+			Certificates: []*x509.Certificate{cert},
+		},
+	}
+}
+
+func testSP() ServiceProvider {
+	return ServiceProvider{
+		EntityID: "https://sp.example/saml/" + util.RandomString(6) + "/metadata",
+		ACSURL:   "https://sp.example/saml/acs",
+	}
+}
+
+type responseOptions struct {
+	inResponseTo   string
+	audience       string
+	recipient      string
+	notOnOrAfter   time.Time
+	signResponse   bool
+	signAssertion  bool
+	tamperedEmail  string
+	extraAssertion bool
+}
+
+// response builds a SAMLResponse the way identity providers do: namespaces
+// declared on the Response, the assertion signed on its own.
+func (i *testIdP) response(t *testing.T, sp ServiceProvider, email string, opts responseOptions) string {
+	now := time.Now().UTC()
+
+	doc := etree.NewDocument()
+
+	resp := doc.CreateElement("samlp:Response")
+	resp.CreateAttr("xmlns:samlp", NamespaceProtocol)
+	resp.CreateAttr("xmlns:saml", NamespaceAssertion)
+	resp.CreateAttr("ID", "_"+util.RandomString(20))
+	resp.CreateAttr("Version", "2.0")
+	resp.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	resp.CreateAttr("Destination", sp.ACSURL)
+	if opts.inResponseTo != "" {
+		resp.CreateAttr("InResponseTo", opts.inResponseTo)
+	}
+
+	resp.CreateElement("saml:Issuer").SetText(i.idp.EntityID)
+	resp.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", StatusSuccess)
+
+	assertion := resp.CreateElement("saml:Assertion")
+	assertion.CreateAttr("ID", "_"+util.RandomString(20))
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	assertion.CreateElement("saml:Issuer").SetText(i.idp.EntityID)
+
+	subject := assertion.CreateElement("saml:Subject")
+	nameID := subject.CreateElement("saml:NameID")
+	nameID.CreateAttr("Format", NameIDFormatEmail)
+	nameID.SetText(email)
+
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", subjectConfirmationBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("Recipient", opts.recipient)
+	data.CreateAttr("NotOnOrAfter", opts.notOnOrAfter.UTC().Format(time.RFC3339))
+	if opts.inResponseTo != "" {
+		data.CreateAttr("InResponseTo", opts.inResponseTo)
+	}
+
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", now.Add(-time.Minute).Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", opts.notOnOrAfter.UTC().Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(opts.audience)
+
+	assertion.CreateElement("saml:AuthnStatement").CreateAttr("SessionIndex", "_session")
+
+	statement := assertion.CreateElement("saml:AttributeStatement")
+	for name, value := range map[string]string{"email": email, "first_name": "Ada", "last_name": "Lovelace"} {
+		attr := statement.CreateElement("saml:Attribute")
+		attr.CreateAttr("Name", name)
+		attr.CreateElement("saml:AttributeValue").SetText(value)
+	}
+
+	ctx, err := dsig.NewSigningContext(i.key, [][]byte{i.cert.Raw})
+	require.NoError(t, err)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	if opts.signAssertion {
+		signed, err := ctx.SignEnveloped(withNamespaces(assertion))
+		require.NoError(t, err)
+
+		resp.RemoveChild(assertion)
+		resp.AddChild(signed)
+		assertion = signed
+	}
+
+	if opts.extraAssertion {
+		extra := assertion.Copy()
+		extra.RemoveChild(extra.SelectElement("Signature"))
+		resp.AddChild(extra)
+	}
+
+	if opts.signResponse {
+		signed, err := ctx.SignEnveloped(resp)
+		require.NoError(t, err)
+		doc.SetRoot(signed)
+	}
+
+	if opts.tamperedEmail != "" {
+		for _, el := range doc.FindElements("//NameID") {
+			el.SetText(opts.tamperedEmail)
+		}
+	}
+
+	out, err := doc.WriteToBytes()
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func validOptions(sp ServiceProvider) responseOptions {
+	return responseOptions{
+		audience:      sp.EntityID,
+		recipient:     sp.ACSURL,
+		notOnOrAfter:  time.Now().Add(5 * time.Minute),
+		signAssertion: true,
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := testSP()
+	email := util.RandomEmail()
+
+	opts := validOptions(sp)
+	opts.inResponseTo = "_" + util.RandomString(20)
+
+	assertion, err := sp.ParseResponse(idp.response(t, sp, email, opts), idp.idp, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, email, assertion.NameID)
+	require.Equal(t, NameIDFormatEmail, assertion.NameIDFormat)
+	require.Equal(t, opts.inResponseTo, assertion.InResponseTo)
+	require.Equal(t, idp.idp.EntityID, assertion.Issuer)
+	require.Equal(t, "_session", assertion.SessionIndex)
+	require.Equal(t, email, assertion.Attribute("email"))
+	require.Equal(t, "Ada", assertion.Attribute("first_name"))
+	require.Equal(t, "Lovelace", assertion.Attribute("last_name"))
+	require.True(t, assertion.ExpiresAt.After(time.Now()))
+
+	// only the response signed, IdP-initiated
+	opts = validOptions(sp)
+	opts.signAssertion = false
+	opts.signResponse = true
+
+	assertion, err = sp.ParseResponse(idp.response(t, sp, email, opts), idp.idp, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, email, assertion.NameID)
+	require.Empty(t, assertion.InResponseTo)
+
+	// both signed
+	opts.signAssertion = true
+
+	_, err = sp.ParseResponse(idp.response(t, sp, email, opts), idp.idp, time.Now())
+	require.NoError(t, err)
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	idp := newTestIdP(t)
+	other := newTestIdP(t)
+	sp := testSP()
+	email := util.RandomEmail()
+
+	testCases := []struct {
+		name   string
+		idp    *testIdP
+		modify func(opts *responseOptions)
+		err    error
+	}{
+		{"unsigned", idp, func(opts *responseOptions) { opts.signAssertion = false }, ErrInvalidSignature},
+		{"signed by another idp", other, func(opts *responseOptions) {}, ErrInvalidSignature},
+		{"tampered", idp, func(opts *responseOptions) { opts.tamperedEmail = util.RandomEmail() }, ErrInvalidSignature},
+		{"tampered signed response", idp, func(opts *responseOptions) {
+			opts.signAssertion = false
+			opts.signResponse = true
+			opts.tamperedEmail = util.RandomEmail()
+		}, ErrInvalidSignature},
+		{"injected assertion", idp, func(opts *responseOptions) { opts.extraAssertion = true }, ErrInvalidResponse},
+		{"other audience", idp, func(opts *responseOptions) { opts.audience = "https://other.example" }, ErrInvalidResponse},
+		{"other recipient", idp, func(opts *responseOptions) { opts.recipient = "https://other.example/acs" }, ErrInvalidResponse},
+		{"expired", idp, func(opts *responseOptions) { opts.notOnOrAfter = time.Now().Add(-time.Hour) }, ErrExpired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := validOptions(sp)
+			tc.modify(&opts)
+
+			_, err := sp.ParseResponse(tc.idp.response(t, sp, email, opts), idp.idp, time.Now())
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestAuthnRequestURL(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := testSP()
+
+	id, rawURL, err := sp.AuthnRequestURL(idp.idp, "relay", time.Now())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(id, "_"))
+
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	require.Equal(t, "relay", u.Query().Get("RelayState"))
+
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	require.NoError(t, err)
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromBytes(data))
+	require.Equal(t, id, doc.Root().SelectAttrValue("ID", ""))
+	require.Equal(t, sp.ACSURL, doc.Root().SelectAttrValue("AssertionConsumerServiceURL", ""))
+	require.Equal(t, sp.EntityID, doc.Root().SelectElement("Issuer").Text())
+}
+
+func TestMetadata(t *testing.T) {
+	sp := testSP()
+
+	data, err := sp.Metadata()
+	require.NoError(t, err)
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromBytes(data))
+	require.Equal(t, sp.EntityID, doc.Root().SelectAttrValue("entityID", ""))
+
+	acs := doc.FindElement("//AssertionConsumerService")
+	require.NotNil(t, acs)
+	require.Equal(t, sp.ACSURL, acs.SelectAttrValue("Location", ""))
+	require.Equal(t, BindingHTTPPost, acs.SelectAttrValue("Binding", ""))
+}
+
+func TestParseCertificate(t *testing.T) {
+	idp := newTestIdP(t)
+
+	pemData := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: idp.cert.Raw}))
+
+	cert, err := ParseCertificate(pemData)
+	require.NoError(t, err)
+	require.Equal(t, idp.cert.Raw, cert.Raw)
+
+	cert, err = ParseCertificate(base64.StdEncoding.EncodeToString(idp.cert.Raw))
+	require.NoError(t, err)
+	require.Equal(t, idp.cert.Raw, cert.Raw)
+
+	_, err = ParseCertificate("not a certificate")
+	require.Error(t, err)
+}
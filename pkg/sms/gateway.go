@@ -0,0 +1,92 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// GatewayConfig describes an HTTP SMS gateway taking
+// {"from": ..., "to": ..., "text": ...} as JSON, the request format most
+// providers accept or can be proxied to.
+type GatewayConfig struct {
+	URL string
+	// Token is sent as a bearer token, when set.
+	Token string
+	// From is the sender ID or number shown to the recipient.
+	From    string
+	Timeout time.Duration
+}
+
+type Gateway struct {
+	config GatewayConfig
+	client *http.Client
+}
+
+func NewGateway(cfg GatewayConfig) (*Gateway, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%w: gateway url must be provided", ErrInvalidConfig)
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	return &Gateway{config: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+type gatewayMessage struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+	Text string `json:"text"`
+}
+
+// Send posts the message to the gateway, retrying like the mailer does.
+// Rejected messages (4xx) are not retried.
+func (g *Gateway) Send(recipient, text string) error {
+	body, err := json.Marshal(gatewayMessage{From: g.config.From, To: recipient, Text: text})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i <= 3; i++ {
+		var retry bool
+
+		retry, err = g.post(body)
+		if err == nil || !retry {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
+
+func (g *Gateway) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, g.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if g.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.config.Token)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("sms gateway answered %s: %s", resp.Status, bytes.TrimSpace(detail))
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
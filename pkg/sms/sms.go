@@ -0,0 +1,37 @@
+// Package sms sends text messages rendered from the embedded templates,
+// either through an HTTP gateway or, during development, to a local sink.
+package sms
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+var ErrInvalidConfig = errors.New("invalid sms config")
+
+// Sender delivers a text message to a phone number in E.164 format.
+type Sender interface {
+	Send(recipient, text string) error
+}
+
+// Render executes the "body" template of the file.
+func Render(templateFile string, data interface{}) (string, error) {
+	tmpl, err := template.New("sms").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", err
+	}
+
+	body := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(body, "body", data)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(body.String()), nil
+}
@@ -0,0 +1,85 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	code := util.RandomString(6)
+
+	text, err := Render("mfa_code.tmpl", map[string]interface{}{"code": code, "expiresIn": 5})
+	require.NoError(t, err)
+	require.Contains(t, text, code)
+	require.NotContains(t, text, "\n")
+
+	_, err = Render("missing.tmpl", nil)
+	require.Error(t, err)
+}
+
+func TestGatewaySend(t *testing.T) {
+	token := util.RandomString(16)
+	recipient := "+992901234567"
+	text := util.RandomString(20)
+
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails on the gateway side
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		require.Equal(t, "Bearer "+token, r.Header.Get("Authorization"))
+
+		var msg gatewayMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		require.Equal(t, gatewayMessage{From: "Camelot", To: recipient, Text: text}, msg)
+	}))
+	defer server.Close()
+
+	g, err := NewGateway(GatewayConfig{URL: server.URL, Token: token, From: "Camelot"})
+	require.NoError(t, err)
+
+	require.NoError(t, g.Send(recipient, text))
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestGatewaySendRejected(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "invalid number", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	g, err := NewGateway(GatewayConfig{URL: server.URL})
+	require.NoError(t, err)
+
+	err = g.Send("+1", util.RandomString(10))
+	require.ErrorContains(t, err, "invalid number")
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestNewGateway(t *testing.T) {
+	_, err := NewGateway(GatewayConfig{})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	text := util.RandomString(20)
+
+	require.NoError(t, NewSink(&buf).Send("+992901234567", text))
+	require.True(t, strings.HasSuffix(buf.String(), "sms to +992901234567: \""+text+"\"\n"))
+}
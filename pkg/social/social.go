@@ -0,0 +1,406 @@
+// Package social signs users in with their account at another identity
+// provider: an OpenID Connect provider, whose ID tokens are verified, or a
+// plain OAuth 2.0 one (GitHub) that only serves a userinfo endpoint.
+package social
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fullstack-simple-app/pkg/jwks"
+	"fullstack-simple-app/pkg/oauth"
+	"fullstack-simple-app/pkg/oidc"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxResponseSize limits what is read from the provider.
+const maxResponseSize = 1 << 20
+
+var (
+	ErrInvalidConfig   = errors.New("invalid provider config")
+	ErrExchangeFailed  = errors.New("code exchange failed")
+	ErrInvalidResponse = errors.New("invalid provider response")
+)
+
+// ClaimMapping names the userinfo claims of a provider that doesn't use the
+// standard ones. Empty fields keep the standard name.
+type ClaimMapping struct {
+	Subject       string
+	Email         string
+	EmailVerified string
+	GivenName     string
+	FamilyName    string
+	Name          string
+}
+
+type Config struct {
+	Name string
+	// Issuer of an OpenID Connect provider. Its endpoints are discovered
+	// unless set below.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is registered at the provider, which sends the user back
+	// there with the code.
+	RedirectURL string
+	Scopes      []string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	UserInfoEndpoint      string
+	JWKSURI               string
+
+	Claims ClaimMapping
+	// TrustEmail treats the emails of the provider as verified, for
+	// providers that only share verified addresses without saying so.
+	TrustEmail bool
+}
+
+// Identity is the user as the provider knows them.
+type Identity struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Name          string `json:"name"`
+}
+
+// AuthRequest holds the secrets of one login, kept until the provider sends
+// the user back: the state against CSRF, the nonce against replayed ID tokens
+// and the PKCE code verifier.
+type AuthRequest struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+func NewAuthRequest() (AuthRequest, error) {
+	var values [3]string
+
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return AuthRequest{}, err
+		}
+		values[i] = strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	}
+
+	return AuthRequest{State: values[0], Nonce: values[1], CodeVerifier: values[2]}, nil
+}
+
+// Provider is the client of one identity provider.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keys       jwks.Set
+}
+
+// NewProvider checks the config. Nothing is fetched before the first login, so
+// that a provider that is down doesn't keep the service from starting.
+func NewProvider(cfg Config, client *http.Client) (*Provider, error) {
+	switch {
+	case cfg.Name == "" || cfg.ClientID == "" || cfg.RedirectURL == "":
+		return nil, fmt.Errorf("%w: name, client id and redirect url are required", ErrInvalidConfig)
+	case cfg.Issuer == "" && (cfg.AuthorizationEndpoint == "" || cfg.TokenEndpoint == "" || cfg.UserInfoEndpoint == ""):
+		return nil, fmt.Errorf("%w: %s: either the issuer or the authorization, token and userinfo endpoints are required", ErrInvalidConfig, cfg.Name)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Provider{config: cfg, client: client}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL is where the user is sent to sign in at the provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, req AuthRequest) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(p.config.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", oauth.FormatScope(p.config.Scopes))
+	query.Set("state", req.State)
+	query.Set("code_challenge", oauth.S256Challenge(req.CodeVerifier))
+	query.Set("code_challenge_method", oauth.CodeChallengeMethodS256)
+	if p.config.Issuer != "" {
+		query.Set("nonce", req.Nonce)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange redeems the code the provider sent back for the identity of the
+// user: from the ID token when the provider issues one, from the userinfo
+// endpoint otherwise. The caller checks the state.
+func (p *Provider) Exchange(ctx context.Context, code string, req AuthRequest) (Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {req.CodeVerifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub answers with a form unless asked for JSON
+	httpReq.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	status, err := p.do(httpReq, &token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// some providers report errors with a 200
+	if status != http.StatusOK || token.Error != "" {
+		return Identity{}, fmt.Errorf("%w: %d %s %s", ErrExchangeFailed, status, token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken != "" {
+		return p.verifyIDToken(ctx, token.IDToken, req.Nonce)
+	}
+
+	if token.AccessToken == "" {
+		return Identity{}, fmt.Errorf("%w: no access token", ErrInvalidResponse)
+	}
+
+	return p.userInfo(ctx, token.AccessToken)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, idToken, nonce string) (Identity, error) {
+	keys, fetched, err := p.keySet(ctx, false)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	claims, err := oidc.Verify(idToken, keys, p.config.Issuer, p.config.ClientID)
+	if err != nil && !fetched {
+		// the provider may have rotated its keys since they were fetched
+		keys, _, err = p.keySet(ctx, true)
+		if err != nil {
+			return Identity{}, err
+		}
+		claims, err = oidc.Verify(idToken, keys, p.config.Issuer, p.config.ClientID)
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if claims.Nonce != nonce {
+		return Identity{}, fmt.Errorf("%w: nonce mismatch", oidc.ErrInvalidIDToken)
+	}
+
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: no subject", oidc.ErrInvalidIDToken)
+	}
+
+	return Identity{
+		Provider:      p.config.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: p.config.TrustEmail || (claims.EmailVerified != nil && *claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *Provider) userInfo(ctx context.Context, accessToken string) (Identity, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.UserInfoEndpoint, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	httpReq.Header.Set("Accept", "application/json")
+
+	var claims map[string]interface{}
+
+	status, err := p.do(httpReq, &claims)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if status != http.StatusOK {
+		return Identity{}, fmt.Errorf("%w: userinfo endpoint returned %d", ErrInvalidResponse, status)
+	}
+
+	m := p.config.Claims
+
+	identity := Identity{
+		Provider:      p.config.Name,
+		Subject:       claim(claims, m.Subject, "sub"),
+		Email:         claim(claims, m.Email, "email"),
+		EmailVerified: p.config.TrustEmail || claim(claims, m.EmailVerified, "email_verified") == "true",
+		GivenName:     claim(claims, m.GivenName, "given_name"),
+		FamilyName:    claim(claims, m.FamilyName, "family_name"),
+		Name:          claim(claims, m.Name, "name"),
+	}
+
+	if identity.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: no subject", ErrInvalidResponse)
+	}
+
+	return identity, nil
+}
+
+// discover fills the endpoints the config leaves empty from the discovery
+// document of the issuer. A failed discovery is retried on the next call.
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered || p.config.Issuer == "" {
+		return nil
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+
+	var metadata oidc.ProviderMetadata
+
+	status, err := p.do(httpReq, &metadata)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("%w: discovery returned %d", ErrInvalidResponse, status)
+	}
+
+	if metadata.Issuer != p.config.Issuer {
+		return fmt.Errorf("%w: discovery is for issuer %q", ErrInvalidResponse, metadata.Issuer)
+	}
+
+	setDefault(&p.config.AuthorizationEndpoint, metadata.AuthorizationEndpoint)
+	setDefault(&p.config.TokenEndpoint, metadata.TokenEndpoint)
+	setDefault(&p.config.UserInfoEndpoint, metadata.UserInfoEndpoint)
+	setDefault(&p.config.JWKSURI, metadata.JWKSURI)
+
+	p.discovered = true
+
+	return nil
+}
+
+// keySet returns the keys of the provider, fetching them when there are
+// none yet or refresh is set. It reports whether they were just fetched.
+func (p *Provider) keySet(ctx context.Context, refresh bool) (jwks.Set, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.keys.Keys) > 0 && !refresh {
+		return p.keys, false, nil
+	}
+
+	if p.config.JWKSURI == "" {
+		return jwks.Set{}, false, fmt.Errorf("%w: %s: no jwks uri", ErrInvalidConfig, p.config.Name)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.JWKSURI, nil)
+	if err != nil {
+		return jwks.Set{}, false, err
+	}
+
+	var keys jwks.Set
+
+	status, err := p.do(httpReq, &keys)
+	if err != nil {
+		return jwks.Set{}, false, err
+	}
+
+	if status != http.StatusOK || len(keys.Keys) == 0 {
+		return jwks.Set{}, false, fmt.Errorf("%w: jwks uri returned %d", ErrInvalidResponse, status)
+	}
+
+	p.keys = keys
+
+	return keys, true, nil
+}
+
+// do sends the request and decodes the JSON response into v, whatever the
+// status, as errors have a body too.
+func (p *Provider) do(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize))
+	dec.UseNumber()
+
+	if err = dec.Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// claim returns the claim as a string: numeric IDs and booleans are common.
+func claim(claims map[string]interface{}, name, standard string) string {
+	if name == "" {
+		name = standard
+	}
+
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
@@ -0,0 +1,291 @@
+package social
+
+import (
+	"context"
+	"encoding/json"
+	"fullstack-simple-app/pkg/oauth"
+	"fullstack-simple-app/pkg/oidc"
+	"github.com/kurushqosimi/backendBank/util"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeProvider stands in for an identity provider: it hands out a single code
+// for the challenge it was sent, and the ID token or the userinfo of user.
+type fakeProvider struct {
+	t         *testing.T
+	server    *httptest.Server
+	signer    *oidc.Signer
+	clientID  string
+	code      string
+	challenge string
+	nonce     string
+	user      map[string]interface{}
+	// idTokens is off for plain OAuth 2.0 providers
+	idTokens bool
+}
+
+func newFakeProvider(t *testing.T, idTokens bool) *fakeProvider {
+	signer, err := oidc.GenerateSigner()
+	require.NoError(t, err)
+
+	f := &fakeProvider{
+		t:        t,
+		signer:   signer,
+		clientID: util.RandomString(12),
+		code:     util.RandomString(20),
+		idTokens: idTokens,
+		user: map[string]interface{}{
+			"sub":            util.RandomString(10),
+			"email":          util.RandomEmail(),
+			"email_verified": true,
+			"given_name":     util.RandomOwner(),
+			"family_name":    util.RandomOwner(),
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/jwks", f.keys)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/userinfo", f.userInfo)
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func (f *fakeProvider) config() Config {
+	cfg := Config{
+		Name:        "fake",
+		ClientID:    f.clientID,
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{oidc.ScopeOpenID, oidc.ScopeEmail, oidc.ScopeProfile},
+	}
+
+	if f.idTokens {
+		cfg.Issuer = f.server.URL
+	} else {
+		cfg.AuthorizationEndpoint = f.server.URL + "/authorize"
+		cfg.TokenEndpoint = f.server.URL + "/token"
+		cfg.UserInfoEndpoint = f.server.URL + "/userinfo"
+	}
+
+	return cfg
+}
+
+func (f *fakeProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.ProviderMetadata{
+		Issuer:                f.server.URL,
+		AuthorizationEndpoint: f.server.URL + "/authorize",
+		TokenEndpoint:         f.server.URL + "/token",
+		UserInfoEndpoint:      f.server.URL + "/userinfo",
+		JWKSURI:               f.server.URL + "/jwks",
+	})
+}
+
+func (f *fakeProvider) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, f.signer.KeySet())
+}
+
+func (f *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	require.NoError(f.t, r.ParseForm())
+
+	if r.PostForm.Get("code") != f.code || r.PostForm.Get("client_id") != f.clientID ||
+		oauth.VerifyS256(r.PostForm.Get("code_verifier"), f.challenge) != nil {
+		// the way GitHub reports errors
+		writeJSON(w, http.StatusOK, map[string]string{"error": "bad_verification_code"})
+		return
+	}
+
+	resp := map[string]string{"access_token": "access-" + f.code, "token_type": "Bearer"}
+
+	if f.idTokens {
+		verified, _ := f.user["email_verified"].(bool)
+
+		idToken, err := f.signer.Sign(oidc.Claims{
+			Issuer:    f.server.URL,
+			Audience:  oidc.Audience{f.clientID},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Nonce:     f.nonce,
+			UserInfo: oidc.UserInfo{
+				Subject:       f.user["sub"].(string),
+				Email:         f.user["email"].(string),
+				EmailVerified: &verified,
+				GivenName:     f.user["given_name"].(string),
+				FamilyName:    f.user["family_name"].(string),
+			},
+		})
+		require.NoError(f.t, err)
+
+		resp["id_token"] = idToken
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (f *fakeProvider) userInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer access-"+f.code {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, f.user)
+}
+
+// authorize plays the part of the user signing in at the provider.
+func (f *fakeProvider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+
+	query := u.Query()
+	require.Equal(t, f.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(t, f.clientID, query.Get("client_id"))
+	require.Equal(t, oauth.CodeChallengeMethodS256, query.Get("code_challenge_method"))
+
+	f.challenge = query.Get("code_challenge")
+	f.nonce = query.Get("nonce")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func login(t *testing.T, f *fakeProvider, p *Provider) (Identity, error) {
+	req, err := NewAuthRequest()
+	require.NoError(t, err)
+
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	require.NoError(t, err)
+
+	f.authorize(t, authURL)
+	require.Equal(t, req.State, mustQuery(t, authURL).Get("state"))
+
+	return p.Exchange(context.Background(), f.code, req)
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u.Query()
+}
+
+func TestExchangeIDToken(t *testing.T) {
+	f := newFakeProvider(t, true)
+
+	p, err := NewProvider(f.config(), f.server.Client())
+	require.NoError(t, err)
+
+	identity, err := login(t, f, p)
+	require.NoError(t, err)
+	require.Equal(t, Identity{
+		Provider:      "fake",
+		Subject:       f.user["sub"].(string),
+		Email:         f.user["email"].(string),
+		EmailVerified: true,
+		GivenName:     f.user["given_name"].(string),
+		FamilyName:    f.user["family_name"].(string),
+	}, identity)
+
+	// the provider rotates its keys
+	f.signer, err = oidc.GenerateSigner()
+	require.NoError(t, err)
+
+	_, err = login(t, f, p)
+	require.NoError(t, err)
+}
+
+func TestExchangeRejectsNonce(t *testing.T) {
+	f := newFakeProvider(t, true)
+
+	p, err := NewProvider(f.config(), f.server.Client())
+	require.NoError(t, err)
+
+	req, err := NewAuthRequest()
+	require.NoError(t, err)
+
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	require.NoError(t, err)
+
+	f.authorize(t, authURL)
+	f.nonce = util.RandomString(20)
+
+	_, err = p.Exchange(context.Background(), f.code, req)
+	require.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
+
+func TestExchangeUserInfo(t *testing.T) {
+	f := newFakeProvider(t, false)
+
+	// GitHub style: a numeric id and no email_verified claim
+	id := util.RandomInt(1, 1000000)
+	f.user = map[string]interface{}{"id": id, "login": util.RandomOwner(), "email": util.RandomEmail(), "name": util.RandomOwner()}
+
+	cfg := f.config()
+	cfg.Claims = ClaimMapping{Subject: "id"}
+
+	p, err := NewProvider(cfg, f.server.Client())
+	require.NoError(t, err)
+
+	identity, err := login(t, f, p)
+	require.NoError(t, err)
+	require.Equal(t, Identity{
+		Provider: "fake",
+		Subject:  strconv.FormatInt(id, 10),
+		Email:    f.user["email"].(string),
+		Name:     f.user["name"].(string),
+	}, identity)
+
+	cfg.TrustEmail = true
+
+	p, err = NewProvider(cfg, f.server.Client())
+	require.NoError(t, err)
+
+	identity, err = login(t, f, p)
+	require.NoError(t, err)
+	require.True(t, identity.EmailVerified)
+}
+
+func TestExchangeFails(t *testing.T) {
+	f := newFakeProvider(t, false)
+
+	p, err := NewProvider(f.config(), f.server.Client())
+	require.NoError(t, err)
+
+	req, err := NewAuthRequest()
+	require.NoError(t, err)
+
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	require.NoError(t, err)
+
+	f.authorize(t, authURL)
+
+	_, err = p.Exchange(context.Background(), util.RandomString(20), req)
+	require.ErrorIs(t, err, ErrExchangeFailed)
+
+	other, err := NewAuthRequest()
+	require.NoError(t, err)
+
+	_, err = p.Exchange(context.Background(), f.code, other)
+	require.ErrorIs(t, err, ErrExchangeFailed)
+}
+
+func TestNewProvider(t *testing.T) {
+	_, err := NewProvider(Config{Name: "fake", ClientID: "id", RedirectURL: "http://localhost/callback"}, nil)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = NewProvider(Config{Name: "fake", Issuer: "https://accounts.example", RedirectURL: "http://localhost/callback"}, nil)
+	require.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = NewProvider(Config{Name: "fake", Issuer: "https://accounts.example", ClientID: "id", RedirectURL: "http://localhost/callback"}, nil)
+	require.NoError(t, err)
+}
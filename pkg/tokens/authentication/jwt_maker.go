@@ -24,8 +24,8 @@ func NewJWTMaker(secretKey string) (*JWTMaker, error) {
 	return &JWTMaker{secretKey: secretKey}, nil
 }
 
-func (maker JWTMaker) CreateToken(username string, duration time.Duration) (string, error) {
-	payload, err := NewPayload(username, duration)
+func (maker JWTMaker) CreateToken(username string, duration time.Duration, opts ...PayloadOption) (string, error) {
+	payload, err := NewPayload(username, duration, opts...)
 	if err != nil {
 		return "", err
 	}
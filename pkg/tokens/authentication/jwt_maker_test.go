@@ -91,3 +91,25 @@ func TestInvalidPayloadType(t *testing.T) {
 	require.EqualError(t, err, ErrInvalidToken.Error())
 	require.Nil(t, nilPayload)
 }
+
+func TestJWTMakerClientToken(t *testing.T) {
+	maker, err := NewJWTMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	clientID := util.RandomString(16)
+	scopes := []string{"users:read", "orgs:read"}
+
+	token, err := maker.CreateToken(clientID, time.Minute, WithClient(clientID), WithScopes(scopes...))
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.Equal(t, clientID, payload.Username)
+	require.Equal(t, clientID, payload.ClientID)
+	require.Equal(t, scopes, payload.Scopes)
+	require.Zero(t, payload.UserID)
+	require.True(t, payload.IsClient())
+	require.True(t, payload.Delegated())
+	require.True(t, payload.AllowsScope("orgs:read"))
+	require.False(t, payload.AllowsScope("users:write"))
+}
@@ -29,8 +29,8 @@ func NewPasetoMaker(symmetricKey string) (*PasetoMaker, error) {
 	return maker, nil
 }
 
-func (maker *PasetoMaker) CreateToken(username string, duration time.Duration) (string, error) {
-	payload, err := NewPayload(username, duration)
+func (maker *PasetoMaker) CreateToken(username string, duration time.Duration, opts ...PayloadOption) (string, error) {
+	payload, err := NewPayload(username, duration, opts...)
 	if err != nil {
 		return "", err
 	}
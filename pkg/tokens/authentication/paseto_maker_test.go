@@ -66,3 +66,92 @@ func TestInvalidKeySizePasetoMaker(t *testing.T) {
 	require.Error(t, err, ErrInvalidSecretKeySizePaseto.Error())
 	require.Empty(t, maker)
 }
+
+func TestPasetoMakerWithScope(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	token, err := maker.CreateToken(util.RandomOwner(), time.Minute, WithScope(ScopePasswordChange))
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.Equal(t, ScopePasswordChange, payload.Scope)
+}
+
+func TestPasetoMakerWithOrg(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	userID := util.RandomInt(1, 1000)
+	orgID := util.RandomInt(1, 1000)
+
+	token, err := maker.CreateToken(util.RandomOwner(), time.Minute, WithUserID(userID), WithOrg(orgID))
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.Equal(t, userID, payload.UserID)
+	require.Equal(t, orgID, payload.OrgID)
+}
+
+func TestPasetoMakerWithActor(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	actorID := util.RandomInt(1, 1000)
+	actor := util.RandomOwner()
+
+	token, err := maker.CreateToken(util.RandomOwner(), time.Minute, WithActor(actorID, actor))
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.True(t, payload.Impersonated())
+	require.Equal(t, actor, payload.Act.Subject)
+	require.Equal(t, actorID, payload.Act.UserID)
+}
+
+func TestPayloadAllowsScope(t *testing.T) {
+	user, err := NewPayload(util.RandomOwner(), time.Minute, WithUserID(util.RandomInt(1, 1000)))
+	require.NoError(t, err)
+	require.True(t, user.AllowsScope("users:read"))
+
+	scoped, err := NewPayload(util.RandomOwner(), time.Minute, WithUserID(util.RandomInt(1, 1000)), WithScopes("users:read"))
+	require.NoError(t, err)
+	require.True(t, scoped.AllowsScope("users:read"))
+	require.False(t, scoped.AllowsScope("users:write"))
+
+	client, err := NewPayload(util.RandomOwner(), time.Minute, WithClient(util.RandomString(8)))
+	require.NoError(t, err)
+	require.True(t, client.IsClient())
+	require.False(t, client.AllowsScope("users:read"))
+
+	client.Scopes = []string{"users:read"}
+	require.True(t, client.AllowsScope("users:read"))
+}
+
+func TestPasetoMakerWithAuthentication(t *testing.T) {
+	maker, err := NewPasetoMaker(util.RandomString(32))
+	require.NoError(t, err)
+
+	authTime := time.Now().Add(-5 * time.Minute)
+
+	token, err := maker.CreateToken(util.RandomOwner(), time.Minute, WithAuthentication(authTime, AMRPassword, AMROTP))
+	require.NoError(t, err)
+
+	payload, err := maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.WithinDuration(t, authTime, payload.AuthTime, time.Second)
+	require.Equal(t, []string{AMRPassword, AMROTP}, payload.AMR)
+	require.True(t, payload.AuthenticatedWithin(10*time.Minute))
+	require.False(t, payload.AuthenticatedWithin(time.Minute))
+
+	// tokens without an authentication time need a fresh one
+	token, err = maker.CreateToken(util.RandomOwner(), time.Minute)
+	require.NoError(t, err)
+
+	payload, err = maker.VerifyToken(token)
+	require.NoError(t, err)
+	require.False(t, payload.AuthenticatedWithin(time.Hour))
+}
@@ -6,14 +6,127 @@ import (
 	"time"
 )
 
+// ScopePasswordChange marks a limited token that may only be used to change
+// an expired password. Tokens without a scope grant full access.
+const ScopePasswordChange = "password_change"
+
+// ScopeTrustedDevice marks the token of a remember-me cookie, which only
+// vouches that the browser completed the second factor.
+const ScopeTrustedDevice = "trusted_device"
+
+// Authentication methods of the amr claim (RFC 8176).
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRWebAuthn = "webauthn"
+)
+
+// Actor is the RFC 8693 "act" claim: the user acting on behalf of the token
+// subject.
+type Actor struct {
+	Subject string `json:"sub"`
+	UserID  int64  `json:"user_id,omitempty"`
+}
+
 type Payload struct {
-	ID        uuid.UUID `json:"id"`
-	Username  string    `json:"username"`
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+	UserID   int64     `json:"user_id,omitempty"`
+	OrgID    int64     `json:"org_id,omitempty"`
+	Scope    string    `json:"scope,omitempty"`
+	Roles    []string  `json:"roles,omitempty"`
+	Act      *Actor    `json:"act,omitempty"`
+	// Scopes limit what the token may be used for to the listed
+	// permissions. A client (ClientID without UserID) has no roles and may
+	// do exactly what its scopes list.
+	Scopes    []string  `json:"scopes,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	APIKeyID  int64     `json:"api_key_id,omitempty"`
 	IssuedAt  time.Time `json:"issued_at"`
 	ExpiredAt time.Time `json:"expired_at"`
+	// Issuer is the trusted external issuer of the token the payload was
+	// mapped from.
+	Issuer string `json:"issuer,omitempty"`
+	// AuthTime is when the user last proved their identity, AMR how. Tokens
+	// issued later for the same session, e.g. on switching organizations,
+	// keep them.
+	AuthTime time.Time `json:"auth_time,omitempty"`
+	AMR      []string  `json:"amr,omitempty"`
+}
+
+type PayloadOption func(*Payload)
+
+func WithScope(scope string) PayloadOption {
+	return func(p *Payload) {
+		p.Scope = scope
+	}
 }
 
-func NewPayload(username string, duration time.Duration) (*Payload, error) {
+func WithRoles(roles ...string) PayloadOption {
+	return func(p *Payload) {
+		p.Roles = roles
+	}
+}
+
+func WithUserID(userID int64) PayloadOption {
+	return func(p *Payload) {
+		p.UserID = userID
+	}
+}
+
+// WithOrg sets the organization the token acts in. Zero means none.
+func WithOrg(orgID int64) PayloadOption {
+	return func(p *Payload) {
+		p.OrgID = orgID
+	}
+}
+
+// WithActor marks an impersonation token issued to the actor.
+func WithActor(userID int64, username string) PayloadOption {
+	return func(p *Payload) {
+		p.Act = &Actor{Subject: username, UserID: userID}
+	}
+}
+
+// WithScopes limits the token to the listed permissions.
+func WithScopes(scopes ...string) PayloadOption {
+	return func(p *Payload) {
+		p.Scopes = scopes
+	}
+}
+
+// WithClient marks a token issued to a client or service rather than to a
+// user.
+func WithClient(clientID string) PayloadOption {
+	return func(p *Payload) {
+		p.ClientID = clientID
+	}
+}
+
+// WithAPIKey records the API key the request was authenticated with.
+func WithAPIKey(keyID int64) PayloadOption {
+	return func(p *Payload) {
+		p.APIKeyID = keyID
+	}
+}
+
+// WithIssuer records the external issuer the request was authenticated by.
+func WithIssuer(issuer string) PayloadOption {
+	return func(p *Payload) {
+		p.Issuer = issuer
+	}
+}
+
+// WithAuthentication records when and with which methods the user
+// authenticated.
+func WithAuthentication(authTime time.Time, methods ...string) PayloadOption {
+	return func(p *Payload) {
+		p.AuthTime = authTime
+		p.AMR = methods
+	}
+}
+
+func NewPayload(username string, duration time.Duration, opts ...PayloadOption) (*Payload, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
@@ -26,6 +139,10 @@ func NewPayload(username string, duration time.Duration) (*Payload, error) {
 		ExpiredAt: time.Now().Add(duration),
 	}
 
+	for _, opt := range opts {
+		opt(payload)
+	}
+
 	return payload, nil
 }
 
@@ -34,6 +151,46 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+// Impersonated reports whether the token was issued to someone acting as the
+// subject.
+func (payload *Payload) Impersonated() bool {
+	return payload.Act != nil
+}
+
+// Delegated reports whether the token was issued to a client, comes from an
+// API key or was issued by an external issuer, rather than to the user
+// signing in.
+func (payload *Payload) Delegated() bool {
+	return payload.ClientID != "" || payload.APIKeyID != 0 || payload.Issuer != ""
+}
+
+// IsClient reports whether the subject is a client or service, not a user.
+func (payload *Payload) IsClient() bool {
+	return payload.ClientID != "" && payload.UserID == 0
+}
+
+// AllowsScope reports whether the scopes of the token let it use the
+// permission. Tokens of users without scopes aren't limited.
+func (payload *Payload) AllowsScope(permission string) bool {
+	if len(payload.Scopes) == 0 {
+		return !payload.IsClient()
+	}
+
+	for _, scope := range payload.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AuthenticatedWithin reports whether the user proved their identity within
+// the last maxAge.
+func (payload *Payload) AuthenticatedWithin(maxAge time.Duration) bool {
+	return !payload.AuthTime.IsZero() && time.Since(payload.AuthTime) <= maxAge
+}
+
 func (payload *Payload) Valid() error {
 	if time.Now().After(payload.ExpiredAt) {
 		return ErrExpiredToken
@@ -11,6 +11,7 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeInvitation     = "invitation"
 )
 
 type Token struct {
@@ -21,7 +22,9 @@ type Token struct {
 	Scope     string    `json:"-"`
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+// GenerateToken creates a random token for the user. Only its SHA-256 hash
+// should be stored, the plaintext is handed to the user.
+func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
@@ -37,12 +40,17 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = Hash(token.Plaintext)
 
 	return token, nil
 }
 
+// Hash returns the SHA-256 hash of a token plaintext, for lookups.
+func Hash(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
 func ValidationTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")